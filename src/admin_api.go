@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobwas/ws"
+)
+
+// Admin API: live connection management for operators
+//
+// Served on its own listener (WS_ADMIN_ADDR, e.g. 127.0.0.1:3005) so it is never
+// exposed through the public load balancer, and protected by a bearer token
+// (WS_ADMIN_TOKEN), mTLS (WS_ADMIN_CLIENT_CA_FILE), or both.
+//
+// Endpoints:
+//
+//	GET  /admin/clients[?user=<id>]          Connected clients (id, address, user, subscriptions, queue, seq, strikes)
+//	GET  /admin/subscribers?channel=<name>   Clients receiving a channel (direct or via pattern)
+//	POST /admin/disconnect                   {"client_id":42} or {"user_id":"user-7"}, optional "reason"
+//	POST /admin/banlist/reload               Re-read WS_BAN_LIST_FILE, close newly banned clients (see ban_list.go)
+//
+// Example:
+//
+//	curl -H "Authorization: Bearer $WS_ADMIN_TOKEN" http://127.0.0.1:3005/admin/subscribers?channel=BTC.trade
+//	curl -H "Authorization: Bearer $WS_ADMIN_TOKEN" -d '{"user_id":"user-7","reason":"account suspended"}' \
+//	     http://127.0.0.1:3005/admin/disconnect
+//
+// Disconnected clients get close code 4410 with the reason and can't resume
+// their session. Every disconnect (and every rejected admin request) is audited.
+
+// CloseCodeAdminDisconnect is the WebSocket close code for connections closed
+// by an operator through the admin API (mirrors HTTP 410 Gone)
+const CloseCodeAdminDisconnect ws.StatusCode = 4410
+
+// maxCloseReasonBytes is the longest close reason that fits a control frame
+// (125 byte payload - 2 byte status code)
+const maxCloseReasonBytes = 123
+
+// AdminClientInfo is one connection as reported by GET /admin/clients
+type AdminClientInfo struct {
+	ID                  int64     `json:"id"`
+	RemoteAddr          string    `json:"remote_addr"`
+	UserID              string    `json:"user_id,omitempty"`
+	Roles               []string  `json:"roles,omitempty"`
+	SessionID           string    `json:"session_id,omitempty"`
+	ConnectedAt         time.Time `json:"connected_at"`
+	Codec               string    `json:"codec"`
+	Compressed          bool      `json:"compressed"`
+	Subscriptions       []string  `json:"subscriptions"`
+	SendQueueDepth      int       `json:"send_queue_depth"`
+	SendQueueCapacity   int       `json:"send_queue_capacity"`
+	Seq                 int64     `json:"seq"`
+	SlowClientStrikes   int32     `json:"slow_client_strikes"`
+	RateLimitViolations int       `json:"rate_limit_violations"`
+}
+
+// adminDisconnectRequest is the body of POST /admin/disconnect
+type adminDisconnectRequest struct {
+	ClientID int64  `json:"client_id"`
+	UserID   string `json:"user_id"`
+	Reason   string `json:"reason"`
+}
+
+// startAdminAPI serves the admin API on WS_ADMIN_ADDR (no-op when unset)
+func (s *Server) startAdminAPI() error {
+	if s.config.AdminAddr == "" {
+		return nil
+	}
+
+	tlsConfig, err := adminTLSConfig(s.config.AdminTLSCertFile, s.config.AdminTLSKeyFile, s.config.AdminClientCAFile)
+	if err != nil {
+		return fmt.Errorf("invalid admin TLS config: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", s.config.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin address: %w", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/clients", s.adminOnly(http.MethodGet, s.handleAdminClients))
+	mux.HandleFunc("/admin/subscribers", s.adminOnly(http.MethodGet, s.handleAdminSubscribers))
+	mux.HandleFunc("/admin/disconnect", s.adminOnly(http.MethodPost, s.handleAdminDisconnect))
+	mux.HandleFunc("/admin/banlist/reload", s.adminOnly(http.MethodPost, s.handleAdminBanListReload))
+
+	s.adminServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second, // Client lists can be large
+		MaxHeaderBytes:    1 << 16,
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Printf("Admin API error: %v", err)
+		}
+	}()
+
+	s.structLogger.Info().
+		Str("addr", s.config.AdminAddr).
+		Bool("tls", tlsConfig != nil).
+		Bool("mtls", s.config.AdminClientCAFile != "").
+		Bool("token", s.config.AdminToken != "").
+		Msg("Admin API listening")
+	return nil
+}
+
+// adminTLSConfig builds the admin listener's TLS config
+// Returns nil without a certificate (plain HTTP - bind to localhost)
+// With a client CA every connection must present a certificate signed by it
+func adminTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in client CA file %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// adminOnly wraps an admin handler with method and token checks
+// (mTLS is enforced by the listener before the request is read)
+func (s *Server) adminOnly(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+				s.auditLogger.Warning("AdminAuthFailed", "Admin API request with missing or invalid token", map[string]any{
+					"remoteAddr": r.RemoteAddr,
+					"path":       r.URL.Path,
+				})
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ws-admin"`)
+				writeAdminError(w, http.StatusUnauthorized, "invalid or missing admin token")
+				return
+			}
+		}
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// adminPrincipal names who made an admin request (for audit logs)
+// Client certificate CN with mTLS, "token" otherwise
+func adminPrincipal(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "token"
+}
+
+// handleAdminClients lists connected clients, optionally filtered by ?user=
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	userFilter := r.URL.Query().Get("user")
+
+	clients := make([]AdminClientInfo, 0)
+	s.clients.Range(func(key, _ any) bool {
+		c := key.(*Client)
+		if userFilter != "" && sessionUserID(c.Identity()) != userFilter {
+			return true
+		}
+		clients = append(clients, s.adminClientInfo(c))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"count":   len(clients),
+		"clients": clients,
+	})
+}
+
+// handleAdminSubscribers lists the clients receiving ?channel=
+// Includes clients subscribed through a pattern ("BTC.*" receives "BTC.trade")
+func (s *Server) handleAdminSubscribers(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Query().Get("channel")
+	if err := ValidateChannelPattern(channel); err != nil || IsChannelPattern(channel) {
+		writeAdminError(w, http.StatusBadRequest, "channel must be a literal channel such as BTC.trade")
+		return
+	}
+
+	subscribers := s.subscriptionIndex.Get(channel)
+	clients := make([]AdminClientInfo, 0, len(subscribers))
+	for _, c := range subscribers {
+		clients = append(clients, s.adminClientInfo(c))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"channel": channel,
+		"count":   len(clients),
+		"clients": clients,
+	})
+}
+
+// handleAdminDisconnect force-disconnects a client (client_id) or every
+// connection of a user (user_id)
+func (s *Server) handleAdminDisconnect(w http.ResponseWriter, r *http.Request) {
+	var req adminDisconnectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if (req.ClientID == 0) == (req.UserID == "") {
+		writeAdminError(w, http.StatusBadRequest, "exactly one of client_id or user_id is required")
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "Disconnected by administrator"
+	}
+
+	var targets []*Client
+	s.clients.Range(func(key, _ any) bool {
+		c := key.(*Client)
+		if (req.ClientID != 0 && c.id == req.ClientID) ||
+			(req.UserID != "" && sessionUserID(c.Identity()) == req.UserID) {
+			targets = append(targets, c)
+		}
+		return true
+	})
+
+	disconnected := make([]int64, 0, len(targets))
+	for _, c := range targets {
+		disconnected = append(disconnected, c.id)
+		s.closeClient(c, CloseCodeAdminDisconnect, truncateCloseReason(req.Reason))
+	}
+	IncrementAdminDisconnects(len(disconnected))
+
+	s.auditLogger.Warning("AdminDisconnect", "Connections closed through the admin API", map[string]any{
+		"admin":        adminPrincipal(r),
+		"adminAddr":    r.RemoteAddr,
+		"clientID":     req.ClientID,
+		"userID":       req.UserID,
+		"reason":       req.Reason,
+		"disconnected": disconnected,
+	})
+
+	status := http.StatusOK
+	if len(disconnected) == 0 {
+		status = http.StatusNotFound
+	}
+	writeAdminJSON(w, status, map[string]any{
+		"disconnected": disconnected,
+		"count":        len(disconnected),
+	})
+}
+
+// handleAdminBanListReload reloads the ban list (same as SIGHUP)
+// A file that fails to load leaves the previous list active (HTTP 500)
+func (s *Server) handleAdminBanListReload(w http.ResponseWriter, r *http.Request) {
+	disconnected, err := s.ReloadBanList("admin:" + adminPrincipal(r))
+	if errors.Is(err, ErrBanListDisabled) {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"entries":      s.banList.Load().Size(),
+		"disconnected": disconnected,
+		"count":        len(disconnected),
+	})
+}
+
+// adminClientInfo snapshots a client for the admin API
+// Reads only fields that are safe outside the client's pumps
+func (s *Server) adminClientInfo(c *Client) AdminClientInfo {
+	identity := c.Identity()
+	info := AdminClientInfo{
+		ID:                  c.id,
+		RemoteAddr:          c.clientIP,
+		UserID:              sessionUserID(identity),
+		SessionID:           c.sessionID,
+		ConnectedAt:         c.connectedAt,
+		Compressed:          c.deflate != nil,
+		Subscriptions:       c.subscriptions.List(),
+		SendQueueDepth:      len(c.send),
+		SendQueueCapacity:   cap(c.send),
+		SlowClientStrikes:   atomic.LoadInt32(&c.sendAttempts),
+		RateLimitViolations: s.rateLimiter.Violations(identity, c.clientIP),
+	}
+	if identity != nil {
+		info.Roles = identity.Roles
+	}
+	if c.codec != nil {
+		info.Codec = c.codec.Subprotocol()
+	}
+	if c.seqGen != nil {
+		info.Seq = atomic.LoadInt64(&c.seqGen.counter)
+	}
+	sort.Strings(info.Subscriptions)
+	return info
+}
+
+// truncateCloseReason shortens a close reason to fit a close frame
+// Cuts on a rune boundary so the reason stays valid UTF-8 (RFC 6455 §5.5.1)
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReasonBytes {
+		return reason
+	}
+	reason = reason[:maxCloseReasonBytes]
+	for !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+	return reason
+}
+
+// writeAdminJSON writes an admin API response
+func writeAdminJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeAdminError writes an admin API error response
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]any{"error": message})
+}
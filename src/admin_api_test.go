@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobwas/ws"
+)
+
+// adminRequest runs one request through the admin API's handler chain
+func adminRequest(t *testing.T, s *Server, handler http.HandlerFunc, method, target, body string) (int, map[string]any) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+s.config.AdminToken)
+	w := httptest.NewRecorder()
+	s.adminOnly(method, handler)(w, r)
+
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode admin response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+// adminClientIDs extracts the client IDs from a clients/subscribers response
+func adminClientIDs(resp map[string]any) []int64 {
+	var ids []int64
+	for _, client := range resp["clients"].([]any) {
+		ids = append(ids, int64(client.(map[string]any)["id"].(float64)))
+	}
+	return ids
+}
+
+func newAdminTestServer(t *testing.T) *Server {
+	return newTestServer(t, func(config *ServerConfig) { config.AdminToken = "admin-secret" })
+}
+
+// connectAdminTestClient registers a client as connected, subscribed to channels
+func connectAdminTestClient(s *Server, userID string, channels ...string) *Client {
+	c := newTestClient(s, 1)
+	if userID != "" {
+		c.identity = &ClientIdentity{UserID: userID}
+	}
+	c.subscriptions.AddMultiple(channels)
+	s.subscriptionIndex.AddMultiple(channels, c)
+	s.clients.Store(c, true)
+	return c
+}
+
+func TestAdminOnly(t *testing.T) {
+	s := newAdminTestServer(t)
+	handler := func(w http.ResponseWriter, _ *http.Request) { writeAdminJSON(w, http.StatusOK, map[string]any{}) }
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "valid token", method: http.MethodGet, authorization: "Bearer admin-secret", wantStatus: http.StatusOK},
+		{name: "missing token", method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, authorization: "Bearer admin-secreT", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", method: http.MethodGet, authorization: "admin-secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodPost, authorization: "Bearer admin-secret", wantStatus: http.StatusMethodNotAllowed},
+		{name: "wrong method before auth", method: http.MethodPost, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/admin/clients", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			s.adminOnly(http.MethodGet, handler)(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	s := newAdminTestServer(t)
+	first := connectAdminTestClient(s, "user-7", "ETH.trade", "BTC.trade")
+	connectAdminTestClient(s, "user-8")
+	third := connectAdminTestClient(s, "user-7")
+	first.send <- []byte("queued")
+
+	status, resp := adminRequest(t, s, s.handleAdminClients, http.MethodGet, "/admin/clients?user=user-7", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	ids := adminClientIDs(resp)
+	if len(ids) != 2 || ids[0] != first.id || ids[1] != third.id {
+		t.Fatalf("clients = %v, want [%d %d] in ID order", ids, first.id, third.id)
+	}
+
+	info := resp["clients"].([]any)[0].(map[string]any)
+	if subs := info["subscriptions"].([]any); len(subs) != 2 || subs[0] != "BTC.trade" || subs[1] != "ETH.trade" {
+		t.Errorf("subscriptions = %v, want sorted [BTC.trade ETH.trade]", subs)
+	}
+	if info["user_id"] != "user-7" || info["send_queue_depth"] != 1.0 || info["send_queue_capacity"] != 1.0 {
+		t.Errorf("client info = %v, want user-7 with 1/1 queued", info)
+	}
+
+	if _, resp := adminRequest(t, s, s.handleAdminClients, http.MethodGet, "/admin/clients", ""); resp["count"] != 3.0 {
+		t.Errorf("unfiltered count = %v, want 3", resp["count"])
+	}
+}
+
+func TestAdminSubscribers(t *testing.T) {
+	s := newAdminTestServer(t)
+	direct := connectAdminTestClient(s, "user-1", "BTC.trade")
+	pattern := connectAdminTestClient(s, "user-2", "BTC.*")
+	connectAdminTestClient(s, "user-3", "ETH.trade")
+
+	status, resp := adminRequest(t, s, s.handleAdminSubscribers, http.MethodGet, "/admin/subscribers?channel=BTC.trade", "")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if ids := adminClientIDs(resp); len(ids) != 2 || ids[0] != direct.id || ids[1] != pattern.id {
+		t.Errorf("subscribers = %v, want the direct and the pattern subscriber [%d %d]", ids, direct.id, pattern.id)
+	}
+
+	for _, channel := range []string{"", "BTC.*", "BTC..trade"} {
+		if status, _ := adminRequest(t, s, s.handleAdminSubscribers, http.MethodGet, "/admin/subscribers?channel="+channel, ""); status != http.StatusBadRequest {
+			t.Errorf("channel %q: status = %d, want 400", channel, status)
+		}
+	}
+}
+
+func TestAdminDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantClosed []int // Indexes of the clients below
+	}{
+		{name: "by client", body: `{"client_id":%d}`, wantStatus: http.StatusOK, wantClosed: []int{0}},
+		{name: "every connection of a user", body: `{"user_id":"user-7"}`, wantStatus: http.StatusOK, wantClosed: []int{0, 2}},
+		{name: "unknown user", body: `{"user_id":"user-9"}`, wantStatus: http.StatusNotFound},
+		{name: "client and user", body: `{"client_id":%d,"user_id":"user-7"}`, wantStatus: http.StatusBadRequest},
+		{name: "neither", body: `{"reason":"x"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid JSON", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdminTestServer(t)
+			clients := []*Client{
+				connectAdminTestClient(s, "user-7"),
+				connectAdminTestClient(s, "user-8"),
+				connectAdminTestClient(s, "user-7"),
+			}
+			body := tt.body
+			if strings.Contains(body, "%d") {
+				body = fmt.Sprintf(body, clients[0].id)
+			}
+
+			status, resp := adminRequest(t, s, s.handleAdminDisconnect, http.MethodPost, "/admin/disconnect", body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%v)", status, tt.wantStatus, resp)
+			}
+
+			closed := make(map[int]bool)
+			for _, i := range tt.wantClosed {
+				closed[i] = true
+			}
+			for i, c := range clients {
+				if got := atomic.LoadInt32(&c.disconnecting) == 1; got != closed[i] {
+					t.Errorf("client %d disconnecting = %v, want %v", i, got, closed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAdminDisconnectSendsCloseFrame(t *testing.T) {
+	s := newAdminTestServer(t)
+	c := connectAdminTestClient(s, "user-7")
+	serverConn, peer := net.Pipe()
+	defer peer.Close()
+	c.conn = serverConn
+
+	reason := strings.Repeat("é", 100) // 200 bytes: must be cut on a rune boundary
+	body, _ := json.Marshal(adminDisconnectRequest{UserID: "user-7", Reason: reason})
+	if status, resp := adminRequest(t, s, s.handleAdminDisconnect, http.MethodPost, "/admin/disconnect", string(body)); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%v)", status, resp)
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	frame, err := ws.ReadFrame(peer)
+	if err != nil {
+		t.Fatalf("read close frame: %v", err)
+	}
+	code, got := ws.ParseCloseFrameData(frame.Payload)
+	if code != CloseCodeAdminDisconnect {
+		t.Errorf("close code = %d, want %d", code, CloseCodeAdminDisconnect)
+	}
+	if len(got) > maxCloseReasonBytes || !utf8.ValidString(got) || !strings.HasPrefix(reason, got) {
+		t.Errorf("close reason = %q (%d bytes), want a valid UTF-8 prefix of at most %d bytes", got, len(got), maxCloseReasonBytes)
+	}
+}
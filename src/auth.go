@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT authentication on the WebSocket handshake
+//
+// Why: anonymous connections can't receive user-specific data (balances), and
+// a trading platform needs to know who is connected.
+//
+// Token sources (first match wins):
+//
+//	1. Authorization: Bearer <jwt>              (server-to-server, native apps)
+//	2. ws://host/ws?token=<jwt>                  (browsers - WebSocket API can't set headers)
+//	3. First message {"type":"auth","data":{"token":"<jwt>"}}
+//	                                             (keeps the token out of URLs and proxy logs)
+//
+// Header/query tokens are validated BEFORE the upgrade: a bad token gets an
+// HTTP 401 and never costs a WebSocket. First-message auth (required mode,
+// no token in the handshake) must arrive within WS_AUTH_TIMEOUT; anything else
+// first closes the connection with code 4401.
+//
+// Keys:
+// - HS256: shared secret (WS_JWT_HS256_SECRET)
+// - RS256: public keys from a local JWKS file (WS_JWT_JWKS_FILE), selected by "kid"
+//
+// Claims attached to the Client:
+//
+//	{"sub": "user-42", "roles": ["trader"], "exp": 1718003600}
+
+// AuthMode controls whether connections must authenticate
+type AuthMode string
+
+const (
+	AuthModeOff      AuthMode = "off"      // No authentication (tokens ignored)
+	AuthModeOptional AuthMode = "optional" // Anonymous allowed; presented tokens must be valid
+	AuthModeRequired AuthMode = "required" // Every connection must authenticate
+)
+
+// CloseCodeUnauthorized is the WebSocket close code for failed or missing
+// authentication (4000-4999 are application codes; 4401 mirrors HTTP 401)
+const CloseCodeUnauthorized ws.StatusCode = 4401
+
+var (
+	// ErrAuthTokenMissing is returned when required auth has no token
+	ErrAuthTokenMissing = errors.New("authentication token required")
+
+	// ErrAuthTokenInvalid is returned for tokens that fail signature or claim validation
+	ErrAuthTokenInvalid = errors.New("invalid authentication token")
+
+	// ErrAuthTokenExpired is returned for tokens past their exp claim
+	ErrAuthTokenExpired = errors.New("authentication token expired")
+
+	// ErrAuthTimeout is recorded when first-message auth doesn't arrive within WS_AUTH_TIMEOUT
+	ErrAuthTimeout = errors.New("authentication timeout")
+)
+
+// AuthConfig configures JWT validation
+type AuthConfig struct {
+	Mode        AuthMode
+	HS256Secret string        // Shared secret for HS256 tokens (empty = HS256 not accepted)
+	JWKSFile    string        // Local JWKS file with RS256 public keys (empty = RS256 not accepted)
+	Issuer      string        // Required "iss" (empty = not checked)
+	Audience    string        // Required "aud" (empty = not checked)
+	Leeway      time.Duration // Clock skew tolerance for exp/nbf
+	Timeout     time.Duration // Deadline for first-message auth
+}
+
+// ClientIdentity is the authenticated principal attached to a Client
+// nil on the Client = anonymous connection
+type ClientIdentity struct {
+	UserID    string    // "sub" claim
+	Roles     []string  // "roles" claim
+	ExpiresAt time.Time // "exp" claim
+}
+
+// HasRole reports whether the identity carries a role
+func (id *ClientIdentity) HasRole(role string) bool {
+	if id == nil {
+		return false
+	}
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authClaims is the JWT payload we understand
+type authClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Authenticator validates JWTs against the configured keys
+// Immutable after construction - safe for concurrent use
+type Authenticator struct {
+	config   AuthConfig
+	hsSecret []byte
+	rsaKeys  map[string]*rsa.PublicKey // kid → key
+	parser   *jwt.Parser
+}
+
+// NewAuthenticator loads keys and builds the token parser
+// Fails fast on an unreadable JWKS or a mode with no keys configured
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{config: config}
+	if config.Mode == AuthModeOff {
+		return a, nil
+	}
+
+	var methods []string
+	if config.HS256Secret != "" {
+		a.hsSecret = []byte(config.HS256Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKSFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("auth mode %q needs WS_JWT_HS256_SECRET or WS_JWT_JWKS_FILE", config.Mode)
+	}
+
+	// Pin the accepted algorithms - never trust the token's own "alg"
+	// (prevents alg=none and RS256→HS256 key confusion)
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(config.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+
+	return a, nil
+}
+
+// Enabled reports whether tokens are validated at all
+func (a *Authenticator) Enabled() bool {
+	return a.config.Mode != AuthModeOff
+}
+
+// Required reports whether anonymous connections are refused
+func (a *Authenticator) Required() bool {
+	return a.config.Mode == AuthModeRequired
+}
+
+// Validate checks a token and returns the identity it carries
+func (a *Authenticator) Validate(tokenString string) (*ClientIdentity, error) {
+	if tokenString == "" {
+		return nil, ErrAuthTokenMissing
+	}
+
+	var claims authClaims
+	_, err := a.parser.ParseWithClaims(tokenString, &claims, a.keyFunc)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrAuthTokenExpired
+		}
+		return nil, fmt.Errorf("%w: %v", ErrAuthTokenInvalid, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrAuthTokenInvalid)
+	}
+
+	return &ClientIdentity{
+		UserID:    claims.Subject,
+		Roles:     claims.Roles,
+		ExpiresAt: claims.ExpiresAt.Time, // Non-nil: WithExpirationRequired
+	}, nil
+}
+
+// keyFunc picks the verification key for a token's algorithm (and kid)
+func (a *Authenticator) keyFunc(token *jwt.Token) (any, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return a.hsSecret, nil
+
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		// No kid: acceptable only when the JWKS holds exactly one key
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, key := range a.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// tokenFromRequest extracts a handshake token (Authorization header, then ?token=)
+// Returns the token and where it came from (for audit), or "" if none
+func tokenFromRequest(r *http.Request) (string, string) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), "header"
+		}
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, "query"
+	}
+	return "", ""
+}
+
+// jwksDocument is the subset of RFC 7517 we read
+type jwksDocument struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// loadJWKSFile reads RSA signing keys from a local JWKS file
+// Non-RSA and encryption ("use": "enc") keys are skipped
+func loadJWKSFile(path string) (map[string]*rsa.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var doc jwksDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range doc.Keys {
+		if jwk.Kty != "RSA" || jwk.Use == "enc" || (jwk.Alg != "" && jwk.Alg != "RS256") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: invalid modulus: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("JWKS key %q: invalid exponent", jwk.Kid)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s contains no RS256 signing keys", path)
+	}
+	return keys, nil
+}
+
+// authenticateHandshake validates a header/query token before the upgrade
+// Writes the HTTP error itself and returns ok=false on rejection
+//
+// Returns (nil, true) for an anonymous or first-message-auth connection
+func (s *Server) authenticateHandshake(w http.ResponseWriter, r *http.Request, clientIP string) (*ClientIdentity, bool) {
+	if !s.auth.Enabled() {
+		return nil, true
+	}
+
+	token, source := tokenFromRequest(r)
+	if token == "" {
+		return nil, true // Anonymous (optional mode) or first-message auth (required mode)
+	}
+
+	identity, err := s.auth.Validate(token)
+	if err != nil {
+		s.rejectAuth(clientIP, source, err)
+
+		// RFC 6750: 401 + WWW-Authenticate tells the client to get a new token
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	s.acceptAuth(identity, clientIP, source)
+	return identity, true
+}
+
+// awaitFirstMessageAuth closes a connection that hasn't authenticated within WS_AUTH_TIMEOUT
+func (s *Server) awaitFirstMessageAuth(c *Client, remoteAddr string) {
+	atomic.StoreInt32(&c.authPending, 1)
+
+	stop := c.stop
+	timer := time.NewTimer(s.auth.config.Timeout)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			if atomic.LoadInt32(&c.authPending) == 1 {
+				s.rejectAuth(remoteAddr, "message", ErrAuthTimeout)
+				s.closeClient(c, CloseCodeUnauthorized, "Authentication timeout")
+			}
+		case <-stop:
+		}
+	}()
+}
+
+// handleAuthMessage processes {"type":"auth","data":{"token":"..."}}
+// Authenticates a connection awaiting first-message auth, or upgrades an
+// anonymous connection in optional mode. A bad token closes the connection
+// (same outcome as an HTTP 401 on the handshake)
+func (s *Server) handleAuthMessage(c *Client, data json.RawMessage) {
+	if !s.auth.Enabled() || c.Identity() != nil {
+		s.sendControlMessage(c, map[string]any{
+			"type":    "error",
+			"code":    "AUTH_NOT_ACCEPTED",
+			"message": "Authentication is disabled or the connection is already authenticated",
+		})
+		return
+	}
+
+	var authReq struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(data, &authReq) // Malformed → empty token → rejected below
+
+	remoteAddr := c.clientIP
+
+	identity, err := s.auth.Validate(authReq.Token)
+	if err != nil {
+		s.rejectAuth(remoteAddr, "message", err)
+		s.closeClient(c, CloseCodeUnauthorized, "Invalid authentication token")
+		return
+	}
+
+	c.setIdentity(identity)
+	atomic.StoreInt32(&c.authPending, 0)
+	s.acceptAuth(identity, remoteAddr, "message")
+
+	// Valid token, banned user (close 4403)
+	if s.closeIfBannedUser(c) {
+		return
+	}
+
+	s.sendControlMessage(c, map[string]any{
+		"type":       "auth_ack",
+		"user_id":    identity.UserID,
+		"roles":      identity.Roles,
+		"expires_at": identity.ExpiresAt.UnixMilli(),
+	})
+
+	// Resumed before auth: the session's owner is checked now
+	if c.pendingResume != nil && !s.finishPendingResume(c) {
+		return
+	}
+
+	// Anonymous → authenticated (optional mode): existing subscriptions are
+	// re-checked with the new claims, and the token's exp arms the watchdog
+	s.reauthorizeSubscriptions(c)
+	s.scheduleExpiry(c)
+}
+
+// acceptAuth records a successful authentication
+func (s *Server) acceptAuth(identity *ClientIdentity, remoteAddr, source string) {
+	IncrementAuthAttempts("success")
+	s.structLogger.Debug().
+		Str("user_id", identity.UserID).
+		Strs("roles", identity.Roles).
+		Time("expires_at", identity.ExpiresAt).
+		Str("source", source).
+		Str("remote_addr", remoteAddr).
+		Msg("Client authenticated")
+}
+
+// rejectAuth records a failed authentication (audit + metrics)
+func (s *Server) rejectAuth(remoteAddr, source string, err error) {
+	reason := authFailureReason(err)
+	IncrementAuthAttempts(reason)
+
+	s.auditLogger.Warning("AuthenticationFailed", "WebSocket authentication rejected", map[string]any{
+		"remoteAddr": remoteAddr,
+		"source":     source,
+		"reason":     reason,
+		"error":      err.Error(),
+	})
+}
+
+// authFailureReason maps an authentication error to its metric/audit label
+// Anything that isn't a known auth error is a token the server couldn't accept ("invalid")
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrAuthTokenExpired):
+		return "expired"
+	case errors.Is(err, ErrAuthTokenMissing):
+		return "missing"
+	case errors.Is(err, ErrAuthTimeout):
+		return "timeout"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testHS256Secret = "test-secret-at-least-32-bytes-long!!"
+
+// writeTestJWKS writes the public halves of keys (kid → key) as a JWKS file
+func writeTestJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+
+	var doc jwksDocument
+	for kid, key := range keys {
+		doc.Keys = append(doc.Keys, struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		}{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+	return path
+}
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+// signTestToken signs claims with method and key; kid "" leaves the header out
+func signTestToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func TestAuthenticatorValidate(t *testing.T) {
+	key1, key2, other := generateTestKey(t), generateTestKey(t), generateTestKey(t)
+
+	auth, err := NewAuthenticator(AuthConfig{
+		Mode:        AuthModeRequired,
+		HS256Secret: testHS256Secret,
+		JWKSFile:    writeTestJWKS(t, map[string]*rsa.PrivateKey{"k1": key1, "k2": key2}),
+		Issuer:      "https://auth.example.com",
+		Audience:    "ws",
+		Leeway:      5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	now := time.Now()
+	exp := now.Add(time.Hour).Truncate(time.Second)
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":   "user-42",
+			"roles": []string{"trader"},
+			"iss":   "https://auth.example.com",
+			"aud":   "ws",
+			"exp":   exp.Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+	hs256 := func(overrides jwt.MapClaims) string {
+		return signTestToken(t, jwt.SigningMethodHS256, []byte(testHS256Secret), "", claims(overrides))
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *ClientIdentity
+		wantErr error
+	}{
+		{
+			name:  "HS256",
+			token: hs256(nil),
+			want:  &ClientIdentity{UserID: "user-42", Roles: []string{"trader"}, ExpiresAt: exp},
+		},
+		{
+			name:  "no roles",
+			token: hs256(jwt.MapClaims{"roles": nil}),
+			want:  &ClientIdentity{UserID: "user-42", ExpiresAt: exp},
+		},
+		{
+			name:  "RS256 by kid",
+			token: signTestToken(t, jwt.SigningMethodRS256, key2, "k2", claims(nil)),
+			want:  &ClientIdentity{UserID: "user-42", Roles: []string{"trader"}, ExpiresAt: exp},
+		},
+		{
+			name:  "expired within leeway",
+			token: hs256(jwt.MapClaims{"exp": now.Add(-2 * time.Second).Unix()}),
+			want:  &ClientIdentity{UserID: "user-42", Roles: []string{"trader"}, ExpiresAt: now.Add(-2 * time.Second).Truncate(time.Second)},
+		},
+		{name: "empty token", token: "", wantErr: ErrAuthTokenMissing},
+		{name: "garbage", token: "not.a.jwt", wantErr: ErrAuthTokenInvalid},
+		{name: "expired", token: hs256(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), wantErr: ErrAuthTokenExpired},
+		{name: "no exp", token: hs256(jwt.MapClaims{"exp": nil}), wantErr: ErrAuthTokenInvalid},
+		{name: "no sub", token: hs256(jwt.MapClaims{"sub": nil}), wantErr: ErrAuthTokenInvalid},
+		{name: "not yet valid", token: hs256(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}), wantErr: ErrAuthTokenInvalid},
+		{name: "wrong issuer", token: hs256(jwt.MapClaims{"iss": "https://evil.example.com"}), wantErr: ErrAuthTokenInvalid},
+		{name: "wrong audience", token: hs256(jwt.MapClaims{"aud": "admin"}), wantErr: ErrAuthTokenInvalid},
+		{
+			name:    "HS256 wrong secret",
+			token:   signTestToken(t, jwt.SigningMethodHS256, []byte("another-secret-at-least-32-bytes!!"), "", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "RS256 unknown kid",
+			token:   signTestToken(t, jwt.SigningMethodRS256, key1, "k3", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "RS256 kid of another key",
+			token:   signTestToken(t, jwt.SigningMethodRS256, key1, "k2", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "RS256 without kid and several keys",
+			token:   signTestToken(t, jwt.SigningMethodRS256, key1, "", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "RS256 untrusted key",
+			token:   signTestToken(t, jwt.SigningMethodRS256, other, "k1", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "alg none",
+			token:   signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			name:    "algorithm not pinned",
+			token:   signTestToken(t, jwt.SigningMethodHS512, []byte(testHS256Secret), "", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+		{
+			// RS256 → HS256 key confusion: HMAC keyed with the public key
+			name:    "HS256 signed with RSA public key",
+			token:   signTestToken(t, jwt.SigningMethodHS256, key1.N.Bytes(), "k1", claims(nil)),
+			wantErr: ErrAuthTokenInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auth.Validate(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate(): %v", err)
+			}
+			if !got.ExpiresAt.Equal(tt.want.ExpiresAt) {
+				t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, tt.want.ExpiresAt)
+			}
+			got.ExpiresAt = tt.want.ExpiresAt
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorKeyFunc(t *testing.T) {
+	key1, key2 := generateTestKey(t), generateTestKey(t)
+
+	single, err := NewAuthenticator(AuthConfig{
+		Mode:     AuthModeOptional,
+		JWKSFile: writeTestJWKS(t, map[string]*rsa.PrivateKey{"k1": key1}),
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	several, err := NewAuthenticator(AuthConfig{
+		Mode:        AuthModeOptional,
+		HS256Secret: testHS256Secret,
+		JWKSFile:    writeTestJWKS(t, map[string]*rsa.PrivateKey{"k1": key1, "k2": key2}),
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	token := func(method jwt.SigningMethod, kid any) *jwt.Token {
+		tok := jwt.New(method)
+		if kid != nil {
+			tok.Header["kid"] = kid
+		}
+		return tok
+	}
+
+	tests := []struct {
+		name  string
+		auth  *Authenticator
+		token *jwt.Token
+		want  any // nil = error expected
+	}{
+		{name: "HS256 secret", auth: several, token: token(jwt.SigningMethodHS256, nil), want: []byte(testHS256Secret)},
+		{name: "RS256 kid k1", auth: several, token: token(jwt.SigningMethodRS256, "k1"), want: &key1.PublicKey},
+		{name: "RS256 kid k2", auth: several, token: token(jwt.SigningMethodRS256, "k2"), want: &key2.PublicKey},
+		{name: "RS256 unknown kid", auth: several, token: token(jwt.SigningMethodRS256, "k3")},
+		{name: "RS256 non-string kid", auth: several, token: token(jwt.SigningMethodRS256, 1)},
+		{name: "RS256 no kid, several keys", auth: several, token: token(jwt.SigningMethodRS256, nil)},
+		{name: "RS256 no kid, single key", auth: single, token: token(jwt.SigningMethodRS256, nil), want: &key1.PublicKey},
+		{name: "RS256 unknown kid, single key", auth: single, token: token(jwt.SigningMethodRS256, "k9")},
+		{name: "ES256", auth: several, token: token(jwt.SigningMethodES256, nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.auth.keyFunc(tt.token)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("keyFunc() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("keyFunc(): %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keyFunc() returned the wrong key")
+			}
+		})
+	}
+}
+
+func TestAuthFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: ErrAuthTokenMissing, want: "missing"},
+		{err: ErrAuthTokenExpired, want: "expired"},
+		{err: fmt.Errorf("%w: bad signature", ErrAuthTokenInvalid), want: "invalid"},
+		{err: ErrAuthTimeout, want: "timeout"},
+		{err: errors.New("unexpected"), want: "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := authFailureReason(tt.err); got != tt.want {
+				t.Errorf("authFailureReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
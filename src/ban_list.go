@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gobwas/ws"
+)
+
+// Ban list: block abusive IPs, users and bots without a redeploy
+//
+// Loaded from WS_BAN_LIST_FILE and reloaded on SIGHUP or
+// POST /admin/banlist/reload. Format:
+//
+//	{
+//	  "ips":         ["203.0.113.7", "198.51.100.0/24", "2001:db8::/32"],
+//	  "users":       ["user-666"],
+//	  "user_agents": ["python-requests/*", "*scrapy*"]
+//	}
+//
+// Enforcement:
+//   - IP and User-Agent: checked in handleWebSocket before anything else
+//     (HTTP 403, no JWT validation or upgrade)
+//   - User: checked once the identity is known - after handshake auth (403)
+//     or first-message auth (close 4403). Reauth can't change the user.
+//   - Reload: connected clients matching the new list are closed with 4403
+//
+// User-agent patterns are case-insensitive with "*" matching any run of
+// characters; a pattern without "*" must match the whole User-Agent.
+// A reload that fails to parse keeps the previous list (a typo must not
+// unban everyone). Every rejection, disconnect and reload is audited.
+
+// CloseCodeBanned is the WebSocket close code for banned clients (mirrors HTTP 403)
+const CloseCodeBanned ws.StatusCode = 4403
+
+// ErrBanListDisabled is returned by ReloadBanList without WS_BAN_LIST_FILE
+var ErrBanListDisabled = errors.New("no ban list configured (WS_BAN_LIST_FILE)")
+
+// Ban rule kinds (metrics label, audit "rule")
+const (
+	banRuleIP        = "ip"
+	banRuleUser      = "user"
+	banRuleUserAgent = "user_agent"
+)
+
+// BanListFile is the JSON structure of WS_BAN_LIST_FILE
+type BanListFile struct {
+	IPs        []string `json:"ips"`
+	Users      []string `json:"users"`
+	UserAgents []string `json:"user_agents"`
+}
+
+// BanList is a parsed ban list
+// Immutable after construction - replaced as a whole on reload
+type BanList struct {
+	networks   []*net.IPNet
+	users      map[string]bool
+	userAgents []string // Lowercased glob patterns
+}
+
+// BanMatch describes which entry a client matched
+type BanMatch struct {
+	Rule  string // ip, user, user_agent
+	Entry string // The matching ban list entry ("198.51.100.0/24")
+}
+
+// LoadBanList reads and parses a ban list file
+func LoadBanList(path string) (*BanList, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ban list: %w", err)
+	}
+
+	var file BanListFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse ban list %s: %w", path, err)
+	}
+	return NewBanList(file)
+}
+
+// NewBanList validates a ban list and builds its lookup structures
+func NewBanList(file BanListFile) (*BanList, error) {
+	list := &BanList{users: make(map[string]bool, len(file.Users))}
+
+	for _, entry := range file.IPs {
+		network, err := parseIPNetwork(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("ban list: invalid ip %q: %w", entry, err)
+		}
+		list.networks = append(list.networks, network)
+	}
+	for _, user := range file.Users {
+		if user = strings.TrimSpace(user); user != "" {
+			list.users[user] = true
+		}
+	}
+	for _, pattern := range file.UserAgents {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if strings.Trim(pattern, "*") == "" {
+			return nil, fmt.Errorf("ban list: user agent pattern %q would ban every client", pattern)
+		}
+		list.userAgents = append(list.userAgents, pattern)
+	}
+	return list, nil
+}
+
+// Size returns the number of entries per rule kind
+func (b *BanList) Size() map[string]int {
+	if b == nil {
+		return map[string]int{banRuleIP: 0, banRuleUser: 0, banRuleUserAgent: 0}
+	}
+	return map[string]int{
+		banRuleIP:        len(b.networks),
+		banRuleUser:      len(b.users),
+		banRuleUserAgent: len(b.userAgents),
+	}
+}
+
+// MatchConnection checks a client address and User-Agent
+func (b *BanList) MatchConnection(clientIP, userAgent string) (BanMatch, bool) {
+	if b == nil {
+		return BanMatch{}, false
+	}
+
+	if ip := net.ParseIP(clientIP); ip != nil {
+		for _, network := range b.networks {
+			if network.Contains(ip) {
+				return BanMatch{Rule: banRuleIP, Entry: network.String()}, true
+			}
+		}
+	}
+
+	if userAgent != "" {
+		userAgent = strings.ToLower(userAgent)
+		for _, pattern := range b.userAgents {
+			if matchGlob(pattern, userAgent) {
+				return BanMatch{Rule: banRuleUserAgent, Entry: pattern}, true
+			}
+		}
+	}
+	return BanMatch{}, false
+}
+
+// MatchUser checks an authenticated identity (anonymous never matches)
+func (b *BanList) MatchUser(identity *ClientIdentity) (BanMatch, bool) {
+	if b == nil || identity == nil || !b.users[identity.UserID] {
+		return BanMatch{}, false
+	}
+	return BanMatch{Rule: banRuleUser, Entry: identity.UserID}, true
+}
+
+// matchGlob matches s against a pattern where "*" matches any run of characters
+//
+//	matchGlob("python-requests/*", "python-requests/2.31") → true
+//	matchGlob("*scrapy*", "mozilla/5.0 (compatible; scrapy/2.11)") → true
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	// First part anchors the start, last part the end, the rest in order between
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// checkBannedConnection rejects banned IPs / User-Agents before the upgrade
+// Returns false after writing an HTTP 403
+func (s *Server) checkBannedConnection(w http.ResponseWriter, r *http.Request, clientIP string) bool {
+	match, banned := s.banList.Load().MatchConnection(clientIP, r.UserAgent())
+	if !banned {
+		return true
+	}
+
+	s.rejectBanned(match, clientIP, r.UserAgent(), "")
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// checkBannedUser rejects a banned user after handshake auth
+// Returns false after writing an HTTP 403
+func (s *Server) checkBannedUser(w http.ResponseWriter, r *http.Request, clientIP string, identity *ClientIdentity) bool {
+	match, banned := s.banList.Load().MatchUser(identity)
+	if !banned {
+		return true
+	}
+
+	s.rejectBanned(match, clientIP, r.UserAgent(), identity.UserID)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// closeIfBannedUser closes an upgraded connection whose first-message auth
+// identity is banned. Returns true if the client was closed.
+func (s *Server) closeIfBannedUser(c *Client) bool {
+	identity := c.Identity()
+	match, banned := s.banList.Load().MatchUser(identity)
+	if !banned {
+		return false
+	}
+
+	s.rejectBanned(match, c.clientIP, c.userAgent, identity.UserID)
+	s.closeClient(c, CloseCodeBanned, "Banned")
+	return true
+}
+
+// rejectBanned records a connection refused by the ban list
+func (s *Server) rejectBanned(match BanMatch, clientIP, userAgent, userID string) {
+	IncrementBanRejections(match.Rule)
+	s.auditLogger.Warning("ConnectionBanned", "Connection rejected by ban list", map[string]any{
+		"rule":       match.Rule,
+		"entry":      match.Entry,
+		"remoteAddr": clientIP,
+		"userAgent":  userAgent,
+		"userID":     userID,
+	})
+}
+
+// ReloadBanList re-reads WS_BAN_LIST_FILE, swaps it in and disconnects
+// connected clients that match the new list
+// On error the previous list stays active. Returns the disconnected client IDs.
+//
+// Called on SIGHUP and from POST /admin/banlist/reload
+func (s *Server) ReloadBanList(trigger string) ([]int64, error) {
+	if s.config.BanListFile == "" {
+		return nil, ErrBanListDisabled
+	}
+
+	list, err := LoadBanList(s.config.BanListFile)
+	if err != nil {
+		IncrementBanListReloads("error")
+		s.auditLogger.Warning("BanListReloadFailed", "Ban list reload failed, keeping previous list", map[string]any{
+			"file":    s.config.BanListFile,
+			"trigger": trigger,
+			"error":   err.Error(),
+		})
+		return nil, err
+	}
+	s.banList.Store(list)
+	IncrementBanListReloads("success")
+
+	// Disconnect clients the new list bans
+	type bannedClient struct {
+		client *Client
+		match  BanMatch
+	}
+	var targets []bannedClient
+	s.clients.Range(func(key, _ any) bool {
+		c := key.(*Client)
+		match, banned := list.MatchConnection(c.clientIP, c.userAgent)
+		if !banned {
+			match, banned = list.MatchUser(c.Identity())
+		}
+		if banned {
+			targets = append(targets, bannedClient{client: c, match: match})
+		}
+		return true
+	})
+
+	disconnected := make([]int64, 0, len(targets))
+	for _, t := range targets {
+		c := t.client
+		disconnected = append(disconnected, c.id)
+		IncrementBanDisconnects(t.match.Rule)
+		s.auditLogger.Warning("BannedClientDisconnected", "Connected client matched the reloaded ban list", map[string]any{
+			"clientID":   c.id,
+			"rule":       t.match.Rule,
+			"entry":      t.match.Entry,
+			"remoteAddr": c.clientIP,
+			"userAgent":  c.userAgent,
+			"userID":     sessionUserID(c.Identity()),
+		})
+		s.closeClient(c, CloseCodeBanned, "Banned")
+	}
+
+	s.auditLogger.Info("BanListReloaded", "Ban list reloaded", map[string]any{
+		"file":         s.config.BanListFile,
+		"trigger":      trigger,
+		"entries":      list.Size(),
+		"disconnected": len(disconnected),
+	})
+	return disconnected, nil
+}
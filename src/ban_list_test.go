@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		// No wildcard: exact match
+		{pattern: "curl/8.4.0", s: "curl/8.4.0", want: true},
+		{pattern: "curl/8.4.0", s: "curl/8.4.01", want: false},
+		{pattern: "", s: "", want: true},
+		{pattern: "", s: "x", want: false},
+
+		// Prefix / suffix / contains
+		{pattern: "python-requests/*", s: "python-requests/2.31", want: true},
+		{pattern: "python-requests/*", s: "python-requests/", want: true},
+		{pattern: "python-requests/*", s: "my-python-requests/2.31", want: false},
+		{pattern: "*bot", s: "googlebot", want: true},
+		{pattern: "*bot", s: "googlebot/2.1", want: false},
+		{pattern: "*scrapy*", s: "mozilla/5.0 (compatible; scrapy/2.11)", want: true},
+		{pattern: "*scrapy*", s: "scrapy", want: true},
+		{pattern: "*scrapy*", s: "scrap", want: false},
+		{pattern: "*", s: "", want: true},
+		{pattern: "*", s: "anything", want: true},
+		{pattern: "**", s: "anything", want: true},
+
+		// Several wildcards: parts must appear in order without overlapping
+		{pattern: "a*b*c", s: "abc", want: true},
+		{pattern: "a*b*c", s: "axxbyyc", want: true},
+		{pattern: "a*b*c", s: "acb", want: false},
+		{pattern: "*b*bc", s: "xbc", want: false},
+		{pattern: "*b*bc", s: "xbbc", want: true},
+		{pattern: "ab*ba", s: "aba", want: false},
+		{pattern: "ab*ba", s: "abba", want: true},
+		{pattern: "a*a", s: "a", want: false},
+		{pattern: "a*a", s: "aa", want: true},
+
+		// Case-sensitive (the ban list lowercases both sides)
+		{pattern: "curl/*", s: "Curl/8.4.0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"|"+tt.s, func(t *testing.T) {
+			if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+				t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Channel-level authorization for subscribe requests
+//
+// Problem: authentication tells us WHO is connected, but without authorization
+// any client can still subscribe to any channel - including another user's
+// balances.
+//
+// Solution: the subscribe handler asks a ChannelAuthorizer about every
+// requested channel/pattern. Denied entries are left out of the subscription
+// and reported in the ack:
+//
+//	Client → {"type":"subscribe","data":{"channels":["BTC.trade","user-7.balances"]}}
+//	Server → {"type":"subscription_ack","subscribed":["BTC.trade"],
+//	          "denied":[{"channel":"user-7.balances","reason":"forbidden"}],...}
+//
+// Default (WS_AUTHZ_POLICY_FILE empty): every channel is allowed, as before.
+
+var (
+	// ErrChannelForbidden is returned when the policy denies the channel
+	ErrChannelForbidden = errors.New("forbidden")
+
+	// ErrChannelAuthRequired is returned when an anonymous client requests a
+	// channel the policy only grants to authenticated clients
+	ErrChannelAuthRequired = errors.New("authentication_required")
+)
+
+// ChannelAuthorizer decides whether a client may subscribe to a channel
+//
+// channel may be a pattern ("BTC.*"): implementations must only allow it if
+// every channel it can match is allowed. identity is nil for anonymous clients.
+// Called from readPumps - implementations must be safe for concurrent use.
+type ChannelAuthorizer interface {
+	Authorize(identity *ClientIdentity, channel string) error
+}
+
+// ChannelDenial is one rejected entry in subscription_ack "denied"
+type ChannelDenial struct {
+	Channel string `json:"channel"`
+	Reason  string `json:"reason"` // "forbidden", "authentication_required"
+}
+
+// allowAllAuthorizer is used when no policy file is configured
+type allowAllAuthorizer struct{}
+
+func (allowAllAuthorizer) Authorize(*ClientIdentity, string) error {
+	return nil
+}
+
+// policyUserPlaceholder in a rule pattern is replaced by the client's user ID
+// ("{user}.balances" → "user-42.balances"), so one rule covers every user's own channels
+const policyUserPlaceholder = "{user}"
+
+// Policy effects
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+)
+
+// ChannelPolicy is the policy file format (JSON)
+//
+// Rules are evaluated in file order, first match wins; "default" applies when
+// no rule matches (default: deny).
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"channels": [">"], "roles": ["admin"]},
+//	    {"channels": ["{user}.balances"], "authenticated": true},
+//	    {"channels": ["*.balances"], "effect": "deny"},
+//	    {"channels": ["*.favorites"], "roles": ["trader"]},
+//	    {"channels": ["*.trade", "*.liquidity", "*.metadata", "*.social",
+//	                  "*.creation", "*.analytics"]}
+//	  ]
+//	}
+type ChannelPolicy struct {
+	Default string              `json:"default"`
+	Rules   []ChannelPolicyRule `json:"rules"`
+}
+
+// ChannelPolicyRule grants (or denies) channels to a set of clients
+//
+// Who the rule applies to (all given conditions must hold; none = everyone,
+// including anonymous clients):
+//   - authenticated: client presented a valid token
+//   - roles: client has at least one of these roles
+//   - users: client's user ID is one of these
+//
+// A rule using {user} only applies to authenticated clients.
+type ChannelPolicyRule struct {
+	Channels      []string `json:"channels"`
+	Effect        string   `json:"effect"` // "allow" (default) or "deny"
+	Authenticated bool     `json:"authenticated"`
+	Roles         []string `json:"roles"`
+	Users         []string `json:"users"`
+}
+
+// PolicyAuthorizer is the rule-based ChannelAuthorizer loaded from a policy file
+// Immutable after construction - safe for concurrent use
+type PolicyAuthorizer struct {
+	rules        []ChannelPolicyRule
+	defaultAllow bool
+}
+
+// LoadPolicyAuthorizer reads and validates a policy file
+func LoadPolicyAuthorizer(path string) (*PolicyAuthorizer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var policy ChannelPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	return NewPolicyAuthorizer(policy)
+}
+
+// NewPolicyAuthorizer validates a policy and builds an authorizer from it
+func NewPolicyAuthorizer(policy ChannelPolicy) (*PolicyAuthorizer, error) {
+	switch policy.Default {
+	case "", policyDeny, policyAllow:
+	default:
+		return nil, fmt.Errorf("invalid policy default %q (expected allow or deny)", policy.Default)
+	}
+
+	for i, rule := range policy.Rules {
+		switch rule.Effect {
+		case "", policyAllow, policyDeny:
+		default:
+			return nil, fmt.Errorf("policy rule %d: invalid effect %q (expected allow or deny)", i, rule.Effect)
+		}
+		if len(rule.Channels) == 0 {
+			return nil, fmt.Errorf("policy rule %d: no channels", i)
+		}
+		for _, pattern := range rule.Channels {
+			// Validate with a sample user ID substituted for the placeholder
+			if err := ValidateChannelPattern(strings.ReplaceAll(pattern, policyUserPlaceholder, "user")); err != nil {
+				return nil, fmt.Errorf("policy rule %d: %w", i, err)
+			}
+		}
+	}
+
+	return &PolicyAuthorizer{
+		rules:        policy.Rules,
+		defaultAllow: policy.Default == policyAllow,
+	}, nil
+}
+
+// Authorize evaluates the rules against a requested channel or pattern
+//
+// A rule decides the request when one of its patterns covers it entirely
+// ("*.trade" covers "BTC.trade"). A deny rule that merely overlaps the request
+// also denies it: "BTC.*" can't be granted while "*.balances" is denied,
+// it would deliver BTC.balances. Order rules from most to least specific.
+func (p *PolicyAuthorizer) Authorize(identity *ClientIdentity, channel string) error {
+	needsAuth := false
+
+	for _, rule := range p.rules {
+		ruleApplies := rule.appliesTo(identity)
+
+		for _, pattern := range rule.Channels {
+			applies := ruleApplies
+			if strings.Contains(pattern, policyUserPlaceholder) {
+				// Anonymous clients (and user IDs that aren't a single channel
+				// token, e.g. "*") never match {user} - only learn whether a
+				// token could have granted it
+				userToken := singleTokenWildcard
+				if identity != nil && isChannelToken(identity.UserID) {
+					userToken = identity.UserID
+				} else {
+					applies = false
+				}
+				pattern = strings.ReplaceAll(pattern, policyUserPlaceholder, userToken)
+			}
+
+			if rule.Effect == policyDeny {
+				if applies && ChannelPatternsOverlap(pattern, channel) {
+					if needsAuth {
+						return ErrChannelAuthRequired
+					}
+					return ErrChannelForbidden
+				}
+				continue
+			}
+
+			if !ChannelPatternCovers(pattern, channel) {
+				continue
+			}
+			if applies {
+				return nil
+			}
+			if identity == nil {
+				needsAuth = true // Would be granted with a token
+			}
+		}
+	}
+
+	if p.defaultAllow {
+		return nil
+	}
+	if needsAuth {
+		return ErrChannelAuthRequired
+	}
+	return ErrChannelForbidden
+}
+
+// appliesTo reports whether the rule's conditions hold for a client
+func (r *ChannelPolicyRule) appliesTo(identity *ClientIdentity) bool {
+	if identity == nil {
+		return !r.Authenticated && len(r.Roles) == 0 && len(r.Users) == 0
+	}
+
+	if len(r.Roles) > 0 {
+		hasRole := false
+		for _, role := range r.Roles {
+			if identity.HasRole(role) {
+				hasRole = true
+				break
+			}
+		}
+		if !hasRole {
+			return false
+		}
+	}
+
+	if len(r.Users) > 0 {
+		for _, user := range r.Users {
+			if identity.UserID == user {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// isChannelToken reports whether s is a single literal channel token
+func isChannelToken(s string) bool {
+	return s != "" && !strings.ContainsAny(s, tokenSeparator+singleTokenWildcard+fullWildcard+" \t\r\n")
+}
+
+// NewChannelAuthorizer returns the policy authorizer for a policy file,
+// or one that allows everything when no file is configured
+func NewChannelAuthorizer(policyFile string) (ChannelAuthorizer, error) {
+	if policyFile == "" {
+		return allowAllAuthorizer{}, nil
+	}
+	return LoadPolicyAuthorizer(policyFile)
+}
+
+// authorizeChannels splits requested channels into allowed and denied
+func (s *Server) authorizeChannels(c *Client, channels []string) ([]string, []ChannelDenial) {
+	allowed := make([]string, 0, len(channels))
+	var denied []ChannelDenial
+
+	for _, channel := range channels {
+		if err := s.channelAuthorizer.Authorize(c.identity, channel); err != nil {
+			denied = append(denied, ChannelDenial{Channel: channel, Reason: err.Error()})
+			IncrementSubscriptionDenials(err.Error())
+			continue
+		}
+		allowed = append(allowed, channel)
+	}
+
+	if len(denied) > 0 {
+		s.auditLogger.Info("SubscriptionDenied", "Subscribe request partially denied by channel policy", map[string]any{
+			"clientID": c.id,
+			"userID":   sessionUserID(c.identity),
+			"denied":   denied,
+		})
+	}
+	return allowed, denied
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyAuthorizerAuthorize(t *testing.T) {
+	policy, err := NewPolicyAuthorizer(ChannelPolicy{
+		Default: policyDeny,
+		Rules: []ChannelPolicyRule{
+			{Channels: []string{">"}, Roles: []string{"admin"}},
+			{Channels: []string{"{user}.balances"}, Authenticated: true},
+			{Channels: []string{"*.balances"}, Effect: policyDeny},
+			{Channels: []string{"*.favorites"}, Roles: []string{"trader"}},
+			{Channels: []string{"VIP.*"}, Users: []string{"user-9"}},
+			{Channels: []string{"*.trade", "*.social"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer: %v", err)
+	}
+
+	admin := &ClientIdentity{UserID: "root", Roles: []string{"admin"}}
+	trader := &ClientIdentity{UserID: "user-1", Roles: []string{"trader"}}
+	viewer := &ClientIdentity{UserID: "user-2"}
+	vip := &ClientIdentity{UserID: "user-9"}
+	wildcardUser := &ClientIdentity{UserID: "*"}
+
+	tests := []struct {
+		name     string
+		identity *ClientIdentity
+		channel  string
+		want     error
+	}{
+		{name: "public channel", channel: "BTC.trade"},
+		{name: "public pattern", channel: "*.social"},
+		{name: "admin gets everything", identity: admin, channel: ">"},
+		{name: "admin pattern overlapping a deny", identity: admin, channel: "BTC.*"},
+		{name: "own balances", identity: trader, channel: "user-1.balances"},
+		{name: "another user's balances", identity: trader, channel: "user-2.balances", want: ErrChannelForbidden},
+		{name: "anonymous balances", channel: "user-1.balances", want: ErrChannelAuthRequired},
+		{name: "pattern reaching into balances", identity: trader, channel: "BTC.*", want: ErrChannelForbidden},
+		{name: "anonymous pattern reaching into balances", channel: "BTC.*", want: ErrChannelAuthRequired},
+		{name: "own pattern still overlaps the deny", identity: trader, channel: "user-1.>", want: ErrChannelForbidden},
+		{name: "balances of every user", identity: trader, channel: "*.balances", want: ErrChannelForbidden},
+		{name: "wildcard user ID never matches {user}", identity: wildcardUser, channel: "x.balances", want: ErrChannelForbidden},
+		{name: "role grant", identity: trader, channel: "BTC.favorites"},
+		{name: "missing role", identity: viewer, channel: "BTC.favorites", want: ErrChannelForbidden},
+		{name: "anonymous, a role would grant it", channel: "BTC.favorites", want: ErrChannelAuthRequired},
+		{name: "user grant", identity: vip, channel: "VIP.trade"},
+		{name: "user grant pattern overlapping the deny", identity: vip, channel: "VIP.*", want: ErrChannelForbidden},
+		{name: "other user", identity: viewer, channel: "VIP.analytics", want: ErrChannelForbidden},
+		{name: "pattern wider than the grant", identity: trader, channel: "*.*", want: ErrChannelForbidden},
+		{name: "default deny", identity: viewer, channel: "BTC.metadata", want: ErrChannelForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Authorize(tt.identity, tt.channel)
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("Authorize(%v, %q) = %v, want %v", tt.identity, tt.channel, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyAuthorizerDefaultAllow(t *testing.T) {
+	policy, err := NewPolicyAuthorizer(ChannelPolicy{
+		Default: policyAllow,
+		Rules:   []ChannelPolicyRule{{Channels: []string{"*.balances"}, Effect: policyDeny}},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer: %v", err)
+	}
+
+	tests := []struct {
+		channel string
+		want    error
+	}{
+		{channel: "BTC.trade"},
+		{channel: "BTC.balances", want: ErrChannelForbidden},
+		{channel: ">", want: ErrChannelForbidden},
+		{channel: "BTC.trade.v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if err := policy.Authorize(nil, tt.channel); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Errorf("Authorize(%q) = %v, want %v", tt.channel, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicyAuthorizerValidates(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ChannelPolicy
+	}{
+		{name: "unknown default", policy: ChannelPolicy{Default: "maybe"}},
+		{name: "unknown effect", policy: ChannelPolicy{Rules: []ChannelPolicyRule{{Channels: []string{"*.trade"}, Effect: "grant"}}}},
+		{name: "no channels", policy: ChannelPolicy{Rules: []ChannelPolicyRule{{Roles: []string{"admin"}}}}},
+		{name: "invalid pattern", policy: ChannelPolicy{Rules: []ChannelPolicyRule{{Channels: []string{"BTC..trade"}}}}},
+		{name: "placeholder inside a token", policy: ChannelPolicy{Rules: []ChannelPolicyRule{{Channels: []string{"{user}*.balances"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicyAuthorizer(tt.policy); err == nil {
+				t.Error("NewPolicyAuthorizer() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestAuthorizeChannelsSplitsDenied(t *testing.T) {
+	s := newTestServer(t, nil)
+	policy, err := NewPolicyAuthorizer(ChannelPolicy{Rules: []ChannelPolicyRule{
+		{Channels: []string{"{user}.balances"}, Authenticated: true},
+		{Channels: []string{"*.trade"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer: %v", err)
+	}
+	s.channelAuthorizer = policy
+	c := newTestClient(s, 1)
+
+	allowed, denied := s.authorizeChannels(c, []string{"BTC.trade", "user-1.balances", "BTC.social"})
+	if want := []string{"BTC.trade"}; !reflect.DeepEqual(allowed, want) {
+		t.Errorf("allowed = %q, want %q", allowed, want)
+	}
+	wantDenied := []ChannelDenial{
+		{Channel: "user-1.balances", Reason: "authentication_required"},
+		{Channel: "BTC.social", Reason: "forbidden"},
+	}
+	if !reflect.DeepEqual(denied, wantDenied) {
+		t.Errorf("denied = %+v, want %+v", denied, wantDenied)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Channel name validation and subscription quotas
+//
+// Problem: every subscribed string lands in the client's SubscriptionSet and
+// the global SubscriptionIndex. Without checks one subscribe with thousands of
+// made-up names is an unbounded memory vector - and none of them can ever
+// receive a message (subject mappings only produce "{SYMBOL}.{EVENT_TYPE}",
+// see stream_sources.go).
+//
+// Checks on subscribe, in order:
+//  1. Channels per request ≤ WS_MAX_CHANNELS_PER_REQUEST - else the whole
+//     request is rejected (TOO_MANY_CHANNELS), nothing is subscribed
+//  2. Grammar: "{SYMBOL}.{EVENT_TYPE}", either token may be "*", or "{SYMBOL}.>"
+//  3. EVENT_TYPE is in the registry (WS_CHANNEL_EVENT_TYPES)
+//  4. Channel policy (see channel_authorizer.go)
+//  5. Subscriptions per client ≤ WS_MAX_SUBSCRIPTIONS_PER_CLIENT - channels
+//     past the quota are rejected, the ones before it are subscribed
+//
+// Rejected channels are reported only in a structured error after the ack:
+//
+//	Client → {"type":"subscribe","data":{"channels":["BTC.trade","BTC.tarde","BTC trade"]}}
+//	Server → {"type":"subscription_ack","subscribed":["BTC.trade"],...}
+//	Server → {"type":"error","code":"SUBSCRIPTION_REJECTED","rejected":[
+//	            {"channel":"BTC.tarde","reason":"unknown_event_type"},
+//	            {"channel":"BTC trade","reason":"invalid_channel"}],...}
+
+var (
+	// ErrInvalidChannel is returned for channels that don't follow the
+	// "{SYMBOL}.{EVENT_TYPE}" grammar
+	ErrInvalidChannel = errors.New("invalid_channel")
+
+	// ErrUnknownEventType is returned for well-formed channels whose event type
+	// isn't in the registry
+	ErrUnknownEventType = errors.New("unknown_event_type")
+
+	// ErrSubscriptionLimit is returned for channels past the per-client quota
+	ErrSubscriptionLimit = errors.New("subscription_limit")
+)
+
+// Subscription rejection reasons not tied to a single channel (metrics label)
+const subscriptionRejectTooManyChannels = "too_many_channels"
+
+// maxSymbolLength bounds the SYMBOL token (token symbols, and user IDs in
+// per-user channels such as "user-42.balances")
+const maxSymbolLength = 128
+
+// ChannelValidator checks subscribe requests against the channel grammar and
+// the event-type registry
+// Immutable after construction - safe for concurrent use
+type ChannelValidator struct {
+	eventTypes map[string]bool
+}
+
+// NewChannelValidator creates a validator for a WS_CHANNEL_EVENT_TYPES
+// specification (comma-separated event types)
+func NewChannelValidator(eventTypesSpec string) (*ChannelValidator, error) {
+	eventTypes, err := ParseEventTypes(eventTypesSpec)
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelValidator{eventTypes: eventTypes}, nil
+}
+
+// ParseEventTypes parses a WS_CHANNEL_EVENT_TYPES specification
+// Format: "trade,liquidity,..." (at least one, each a valid symbol-style token)
+func ParseEventTypes(spec string) (map[string]bool, error) {
+	eventTypes := make(map[string]bool)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !isSymbolToken(entry) {
+			return nil, fmt.Errorf("invalid event type %q", entry)
+		}
+		eventTypes[entry] = true
+	}
+
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("no event types configured")
+	}
+	return eventTypes, nil
+}
+
+// Validate checks one channel or pattern
+// Returns ErrInvalidChannel or ErrUnknownEventType (wrapped with details)
+//
+// Accepted:
+//
+//	BTC.trade   *.trade   BTC.*   *.*   BTC.>   user-42.balances
+//
+// Rejected:
+//
+//	BTC, >        (no event type)             invalid_channel
+//	BTC.trade.x   (too many tokens)           invalid_channel
+//	BT$.trade     (bad symbol characters)     invalid_channel
+//	BTC.tarde     (not in the registry)       unknown_event_type
+func (v *ChannelValidator) Validate(channel string) error {
+	// NATS subject rules first (empty tokens, partial wildcards, whitespace)
+	if err := ValidateChannelPattern(channel); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidChannel, err)
+	}
+
+	symbol, eventType, found := strings.Cut(channel, tokenSeparator)
+	if !found || strings.Contains(eventType, tokenSeparator) {
+		return fmt.Errorf("%w: %q is not {SYMBOL}.{EVENT_TYPE}", ErrInvalidChannel, channel)
+	}
+	if symbol != singleTokenWildcard && !isSymbolToken(symbol) {
+		return fmt.Errorf("%w: invalid symbol %q", ErrInvalidChannel, symbol)
+	}
+
+	if eventType != singleTokenWildcard && eventType != fullWildcard && !v.eventTypes[eventType] {
+		return fmt.Errorf("%w: %q", ErrUnknownEventType, eventType)
+	}
+	return nil
+}
+
+// isSymbolToken reports whether a token is a valid SYMBOL / EVENT_TYPE
+// Letters, digits and -_:@| (user IDs such as "auth0|42"), up to 128 characters
+func isSymbolToken(token string) bool {
+	if token == "" || len(token) > maxSymbolLength {
+		return false
+	}
+	for _, r := range token {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_:@|", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateChannels splits a subscribe request into valid channels and rejections
+func (s *Server) validateChannels(channels []string) ([]string, []ChannelDenial) {
+	valid := make([]string, 0, len(channels))
+	var rejected []ChannelDenial
+
+	for _, channel := range channels {
+		if err := s.channelValidator.Validate(channel); err != nil {
+			reason := ErrInvalidChannel.Error()
+			if errors.Is(err, ErrUnknownEventType) {
+				reason = ErrUnknownEventType.Error()
+			}
+			IncrementSubscriptionRejections(reason)
+			rejected = append(rejected, ChannelDenial{Channel: channel, Reason: reason})
+			continue
+		}
+		valid = append(valid, channel)
+	}
+	return valid, rejected
+}
+
+// applySubscriptionQuota keeps the channels that fit within the client's
+// WS_MAX_SUBSCRIPTIONS_PER_CLIENT quota, in request order
+// Channels the client already has don't count against the quota
+func (s *Server) applySubscriptionQuota(c *Client, channels []string) ([]string, []ChannelDenial) {
+	limit := s.config.MaxSubscriptionsPerClient
+	if limit <= 0 {
+		return channels, nil
+	}
+
+	available := limit - c.subscriptions.Count()
+	accepted := make([]string, 0, len(channels))
+	seen := make(map[string]bool, len(channels))
+	var rejected []ChannelDenial
+
+	for _, channel := range channels {
+		if c.subscriptions.Has(channel) || seen[channel] {
+			accepted = append(accepted, channel) // Re-subscribe / duplicate: no new entry
+			continue
+		}
+		if available <= 0 {
+			IncrementSubscriptionRejections(ErrSubscriptionLimit.Error())
+			rejected = append(rejected, ChannelDenial{Channel: channel, Reason: ErrSubscriptionLimit.Error()})
+			continue
+		}
+		seen[channel] = true
+		available--
+		accepted = append(accepted, channel)
+	}
+	return accepted, rejected
+}
+
+// checkChannelsPerRequest rejects subscribe/unsubscribe requests listing more
+// than WS_MAX_CHANNELS_PER_REQUEST channels
+// Returns false after sending the TOO_MANY_CHANNELS error
+func (s *Server) checkChannelsPerRequest(c *Client, requestType string, channels []string) bool {
+	limit := s.config.MaxChannelsPerRequest
+	if limit <= 0 || len(channels) <= limit {
+		return true
+	}
+
+	IncrementSubscriptionRejections(subscriptionRejectTooManyChannels)
+	s.logger.Printf("⚠️  Client %d sent %s with %d channels (max %d)", c.id, requestType, len(channels), limit)
+
+	// Client buffer full - skip error (the request is dropped either way)
+	s.sendControlMessage(c, map[string]any{
+		"type":                     "error",
+		"code":                     "TOO_MANY_CHANNELS",
+		"message":                  fmt.Sprintf("%s lists %d channels, max %d per request - nothing was changed", requestType, len(channels), limit),
+		"request":                  requestType,
+		"requested":                len(channels),
+		"max_channels_per_request": limit,
+	})
+	return false
+}
+
+// sendSubscriptionRejected reports channels rejected by validation or quota
+func (s *Server) sendSubscriptionRejected(c *Client, rejected []ChannelDenial) {
+	if len(rejected) == 0 {
+		return
+	}
+
+	// Client buffer full - skip error (the ack's "subscribed" is authoritative)
+	s.sendControlMessage(c, map[string]any{
+		"type":              "error",
+		"code":              "SUBSCRIPTION_REJECTED",
+		"message":           fmt.Sprintf("%d channel(s) were not subscribed", len(rejected)),
+		"rejected":          rejected,
+		"count":             c.subscriptions.Count(),
+		"max_subscriptions": s.config.MaxSubscriptionsPerClient,
+	})
+}
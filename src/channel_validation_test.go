@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChannelValidatorValidate(t *testing.T) {
+	v, err := NewChannelValidator("trade,liquidity,balances")
+	if err != nil {
+		t.Fatalf("NewChannelValidator: %v", err)
+	}
+
+	tests := []struct {
+		channel string
+		wantErr error // nil = valid
+	}{
+		// Accepted
+		{channel: "BTC.trade"},
+		{channel: "*.trade"},
+		{channel: "BTC.*"},
+		{channel: "*.*"},
+		{channel: "BTC.>"},
+		{channel: "*.>"},
+		{channel: "user-42.balances"},
+		{channel: "auth0|42.balances"},
+		{channel: "a_b:c@d.trade"},
+		{channel: strings.Repeat("X", maxSymbolLength) + ".trade"},
+
+		// Grammar
+		{channel: "", wantErr: ErrInvalidChannel},
+		{channel: "BTC", wantErr: ErrInvalidChannel},
+		{channel: ">", wantErr: ErrInvalidChannel},
+		{channel: "*", wantErr: ErrInvalidChannel},
+		{channel: "BTC.trade.x", wantErr: ErrInvalidChannel},
+		{channel: "BTC.>.x", wantErr: ErrInvalidChannel},
+		{channel: "BTC..trade", wantErr: ErrInvalidChannel},
+		{channel: ".trade", wantErr: ErrInvalidChannel},
+		{channel: "BTC.", wantErr: ErrInvalidChannel},
+		{channel: "BTC trade", wantErr: ErrInvalidChannel},
+		{channel: "BTC.tr*de", wantErr: ErrInvalidChannel},
+		{channel: "B*C.trade", wantErr: ErrInvalidChannel},
+		{channel: "BT$.trade", wantErr: ErrInvalidChannel},
+		{channel: ">.trade", wantErr: ErrInvalidChannel},
+		{channel: strings.Repeat("X", maxSymbolLength+1) + ".trade", wantErr: ErrInvalidChannel},
+
+		// Registry
+		{channel: "BTC.tarde", wantErr: ErrUnknownEventType},
+		{channel: "*.social", wantErr: ErrUnknownEventType},
+		{channel: "BTC.Trade", wantErr: ErrUnknownEventType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			err := v.Validate(tt.channel)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Validate(%q) = %v, want nil", tt.channel, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.channel, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{spec: "trade", want: []string{"trade"}},
+		{spec: " trade , liquidity ,", want: []string{"trade", "liquidity"}},
+		{spec: "", wantErr: true},
+		{spec: " , ", wantErr: true},
+		{spec: "trade,bad type", wantErr: true},
+		{spec: "trade,*", wantErr: true},
+		{spec: "trade,a.b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseEventTypes(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseEventTypes(%q) = %v, want error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEventTypes(%q): %v", tt.spec, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("ParseEventTypes(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+			for _, eventType := range tt.want {
+				if !got[eventType] {
+					t.Errorf("ParseEventTypes(%q) is missing %q", tt.spec, eventType)
+				}
+			}
+		})
+	}
+}
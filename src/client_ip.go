@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// Client IP resolution behind proxies
+//
+// Behind a load balancer r.RemoteAddr is the balancer, so every per-IP limit
+// and audit log would lump all clients together. Two ways to learn the real
+// client address, both only believed from trusted proxies (WS_TRUSTED_PROXIES):
+//
+// 1. PROXY protocol v1/v2 (TCP load balancers, WS_PROXY_PROTOCOL)
+//    The balancer prefixes the TCP stream with the client address; the
+//    listener strips it and conn.RemoteAddr() / r.RemoteAddr become the client.
+//
+// 2. Forwarding headers (HTTP proxies) - Forwarded (RFC 7239) if present,
+//    else X-Forwarded-For. Each proxy appends the address it saw:
+//
+//	X-Forwarded-For: <spoofed by client>, 203.0.113.7, 10.0.0.5
+//	Forwarded: for=203.0.113.7, for="[2001:db8::17]:4711"
+//	                                      ^ real client  ^ inner proxy
+//
+//    Read right-to-left skipping trusted hops: the first untrusted address is
+//    the client. Entries further left were written by the client itself.
+//
+// Both compose: LB (PROXY protocol) → ingress proxy (X-Forwarded-For) → server.
+// The result is stored on Client.clientIP and used for every log and limit.
+
+// TrustedProxies is a set of CIDRs whose forwarding headers are believed
+// Immutable after construction - safe for concurrent use
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// ParseTrustedProxies parses WS_TRUSTED_PROXIES (comma-separated CIDRs or IPs)
+func ParseTrustedProxies(spec string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		network, err := parseIPNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		tp.networks = append(tp.networks, network)
+	}
+	return tp, nil
+}
+
+// parseIPNetwork parses a CIDR or a bare IP (→ single-host network)
+// Shared by WS_TRUSTED_PROXIES and the ban list
+func parseIPNetwork(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("not an IP or CIDR")
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(entry)
+	return network, err
+}
+
+// Contains reports whether ip belongs to a trusted proxy
+func (tp *TrustedProxies) Contains(ip net.IP) bool {
+	if tp == nil || ip == nil {
+		return false
+	}
+	for _, network := range tp.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the real client IP of a request
+func (tp *TrustedProxies) ClientIP(r *http.Request) string {
+	peer := hostOnly(r.RemoteAddr)
+	if !tp.Contains(net.ParseIP(peer)) {
+		return peer // Direct connection (or untrusted proxy): headers ignored
+	}
+
+	// Walk the forwarding chain right-to-left (multiple headers = one list)
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // Garbage: stop at the last address we could verify
+		}
+		if !tp.Contains(ip) {
+			return ip.String()
+		}
+		peer = ip.String() // Trusted hop - keep walking
+	}
+	return peer
+}
+
+// forwardedFor extracts the for= addresses of Forwarded headers (RFC 7239)
+//
+//	Forwarded: for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"
+//	→ ["192.0.2.60", "2001:db8:cafe::17"]
+//
+// Obfuscated ("_hidden") and "unknown" nodes are kept as-is: they don't parse
+// as IPs, which stops the right-to-left walk there
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+
+				value = strings.Trim(value, `"`)
+				if strings.HasPrefix(value, "[") {
+					// IPv6: "[2001:db8::17]" or "[2001:db8::17]:4711"
+					if end := strings.Index(value, "]"); end > 0 {
+						value = value[1:end]
+					}
+				} else {
+					value = hostOnly(value)
+				}
+				hops = append(hops, value)
+			}
+		}
+	}
+	return hops
+}
+
+// Proxy protocol modes (WS_PROXY_PROTOCOL)
+const (
+	ProxyProtocolOff      = "off"      // Plain TCP listener
+	ProxyProtocolOptional = "optional" // Trusted proxies may send a PROXY header
+	ProxyProtocolRequired = "required" // Trusted proxies must send a PROXY header
+)
+
+// wrapProxyProtocol makes the listener accept PROXY protocol v1/v2 headers
+//
+// Only connections from trusted proxies may set the client address; a PROXY
+// header from anyone else is read and ignored (otherwise a client connecting
+// directly could claim any IP). headerTimeout bounds the wait for the header
+// (same slowloris concern as the HTTP handshake).
+func wrapProxyProtocol(listener net.Listener, mode string, trusted *TrustedProxies, headerTimeout time.Duration) net.Listener {
+	if mode == ProxyProtocolOff || mode == "" {
+		return listener
+	}
+
+	trustedPolicy := proxyproto.USE
+	if mode == ProxyProtocolRequired {
+		trustedPolicy = proxyproto.REQUIRE
+	}
+
+	return &proxyproto.Listener{
+		Listener:          listener,
+		ReadHeaderTimeout: headerTimeout,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if tcpAddr, ok := upstream.(*net.TCPAddr); ok && trusted.Contains(tcpAddr.IP) {
+				return trustedPolicy, nil
+			}
+			return proxyproto.IGNORE, nil
+		},
+	}
+}
+
+// hostOnly strips the port from a host:port address
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
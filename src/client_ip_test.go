@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1, fd00::/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		xff        []string
+		want       string
+	}{
+		{
+			name:       "direct connection",
+			remoteAddr: "203.0.113.7:51234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer headers ignored",
+			remoteAddr: "203.0.113.7:51234",
+			xff:        []string{"198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "single trusted proxy",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed leftmost entry skipped",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"1.2.3.4, 203.0.113.7, 10.0.0.6"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed entry in separate header",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"1.2.3.4", "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"192.168.1.1, 10.0.0.6"},
+			want:       "192.168.1.1",
+		},
+		{
+			name:       "garbage hop stops the walk",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"203.0.113.7, not-an-ip, 10.0.0.6"},
+			want:       "10.0.0.6",
+		},
+		{
+			name:       "garbage rightmost hop keeps the peer",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{"203.0.113.7, unknown"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "empty header keeps the peer",
+			remoteAddr: "10.0.0.5:443",
+			xff:        []string{""},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "forwarded IPv4",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"for=192.0.2.60;proto=https"},
+			want:       "192.0.2.60",
+		},
+		{
+			name:       "forwarded IPv6 in brackets with port",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{`for="[2001:db8:cafe::17]:4711"`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded IPv6 in brackets without port",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{`for="[2001:db8:cafe::17]"`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded trusted IPv6 hop skipped",
+			remoteAddr: "[fd00::1]:443",
+			forwarded:  []string{`for=198.51.100.9, for="[fd00::2]:8080"`},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "forwarded obfuscated node stops the walk",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"for=198.51.100.9, for=_hidden"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "forwarded wins over X-Forwarded-For",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"for=192.0.2.60"},
+			xff:        []string{"198.51.100.1"},
+			want:       "192.0.2.60",
+		},
+		{
+			name:       "X-Forwarded-For used without for= in Forwarded",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"proto=https;by=10.0.0.5"},
+			xff:        []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+			for _, value := range tt.forwarded {
+				r.Header.Add("Forwarded", value)
+			}
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := trusted.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []string
+	}{
+		{
+			name:    "multiple elements and parameters",
+			headers: []string{`for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"`},
+			want:    []string{"192.0.2.60", "2001:db8:cafe::17"},
+		},
+		{
+			name:    "IPv4 with port",
+			headers: []string{`for="192.0.2.60:8080"`},
+			want:    []string{"192.0.2.60"},
+		},
+		{
+			name:    "case-insensitive key",
+			headers: []string{"For=192.0.2.60"},
+			want:    []string{"192.0.2.60"},
+		},
+		{
+			name:    "several headers form one list",
+			headers: []string{"for=192.0.2.1", "for=192.0.2.2"},
+			want:    []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:    "obfuscated and unknown kept as-is",
+			headers: []string{"for=_hidden, for=unknown"},
+			want:    []string{"_hidden", "unknown"},
+		},
+		{
+			name:    "no for parameter",
+			headers: []string{"proto=https;by=203.0.113.43"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedFor(tt.headers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forwardedFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPNetwork(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{entry: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{entry: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{entry: "192.168.1.1", want: "192.168.1.1/32"},
+		{entry: "2001:db8::1", want: "2001:db8::1/128"},
+		{entry: "2001:db8::/32", want: "2001:db8::/32"},
+		{entry: "::ffff:192.0.2.1", want: "192.0.2.1/32"},
+		{entry: "not-an-ip", wantErr: true},
+		{entry: "10.0.0.0/33", wantErr: true},
+		{entry: "[2001:db8::1]", wantErr: true},
+		{entry: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			network, err := parseIPNetwork(tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseIPNetwork(%q) = %v, want error", tt.entry, network)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIPNetwork(%q): %v", tt.entry, err)
+			}
+			if got := network.String(); got != tt.want {
+				t.Errorf("parseIPNetwork(%q) = %s, want %s", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
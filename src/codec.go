@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/ws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols negotiated via Sec-WebSocket-Protocol
+//
+// Client usage (browser):
+//
+//	new WebSocket(url, ["odin.msgpack.v1", "odin.json.v1"])  // prefers msgpack
+//	new WebSocket(url)                                       // no header → JSON (default)
+//
+// Server picks the FIRST client-offered protocol it supports (RFC 6455 §4.2.2)
+const (
+	SubprotocolJSON    = "odin.json.v1"
+	SubprotocolMsgpack = "odin.msgpack.v1"
+)
+
+// WireCodec encodes server → client frames and decodes client → server requests
+// Each connection gets one codec at upgrade time (see negotiateCodec)
+//
+// Why an interface:
+//   - JSON (text frames) stays the default for browsers and debugging tools
+//   - MessagePack (binary frames) cuts payload size ~30-50% for mobile clients on
+//     high-frequency trade channels (no field-name quoting, compact numbers)
+//
+// Implementations must be stateless and safe for concurrent use
+type WireCodec interface {
+	// Subprotocol returns the Sec-WebSocket-Protocol token ("odin.json.v1")
+	Subprotocol() string
+
+	// OpCode returns the WebSocket frame type used for outgoing messages
+	OpCode() ws.OpCode
+
+	// Marshal encodes a control message (acks, errors, pong)
+	Marshal(v any) ([]byte, error)
+
+	// DecodeRequest converts an incoming client frame to JSON
+	// Client requests are small and infrequent, so transcoding to JSON keeps
+	// a single request parser (handleClientMessage) for every encoding
+	DecodeRequest(data []byte) ([]byte, error)
+
+	// PrepareEnvelope serializes a broadcast envelope once for all subscribers
+	// using this codec (see PreparedMessage)
+	PrepareEnvelope(data []byte, header EnvelopeHeader) (*PreparedMessage, error)
+}
+
+var (
+	jsonWireCodec    WireCodec = jsonCodec{}
+	msgpackWireCodec WireCodec = msgpackCodec{}
+
+	// supportedCodecs maps subprotocol token → codec
+	supportedCodecs = map[string]WireCodec{
+		SubprotocolJSON:    jsonWireCodec,
+		SubprotocolMsgpack: msgpackWireCodec,
+	}
+)
+
+// isSupportedSubprotocol is the ws.HTTPUpgrader.Protocol callback
+func isSupportedSubprotocol(protocol string) bool {
+	_, ok := supportedCodecs[strings.TrimSpace(protocol)]
+	return ok
+}
+
+// negotiateCodec returns the codec for the subprotocol selected during upgrade
+// Empty protocol (client sent no Sec-WebSocket-Protocol) → JSON
+func negotiateCodec(protocol string) WireCodec {
+	if codec, ok := supportedCodecs[protocol]; ok {
+		return codec
+	}
+	return jsonWireCodec
+}
+
+// jsonCodec - text frames, byte-compatible with the original wire format
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+
+func (jsonCodec) OpCode() ws.OpCode { return ws.OpText }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) DecodeRequest(data []byte) ([]byte, error) { return data, nil }
+
+func (jsonCodec) PrepareEnvelope(data []byte, header EnvelopeHeader) (*PreparedMessage, error) {
+	return PrepareMessage(data, header)
+}
+
+// msgpackCodec - binary frames, MessagePack encoding
+//
+// Envelope layout (same field names as JSON):
+//
+//	map{"seq": int64, "ts": int, "type": str, ["priority": int], ["snapshot": bool], ["stream_seq": uint],
+//	    ["channel": str], ["channel_seq": uint], ["prev_channel_seq": uint], ["prev_channel_seq_unknown": bool],
+//	    "data": <payload as native msgpack>}
+//
+// Optional fields are omitted when zero/empty (matches JSON omitempty)
+//
+// "data" is the NATS JSON payload converted to native msgpack values (maps,
+// arrays, ints, floats) - NOT a JSON string - so clients decode it in one pass
+//
+// "seq" is always encoded as a fixed-width int64 (0xd3 + 8 bytes) so it can be
+// spliced into the shared frame without re-encoding (see PreparedMessage.Frame)
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) OpCode() ws.OpCode { return ws.OpBinary }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) DecodeRequest(data []byte) ([]byte, error) {
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid msgpack request: %w", err)
+	}
+	return json.Marshal(v)
+}
+
+func (msgpackCodec) PrepareEnvelope(data []byte, header EnvelopeHeader) (*PreparedMessage, error) {
+	payload, err := jsonToMsgpackValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := 4 // seq, ts, type, data + the optional fields present
+	for _, present := range []bool{
+		header.Priority != 0, header.Snapshot, header.StreamSeq != 0, header.Channel != "",
+		header.ChannelSeq != 0, header.PrevChannelSeq != 0, header.PrevUnknown,
+	} {
+		if present {
+			fields++
+		}
+	}
+
+	// Prefix: map header + "seq" key + int64 marker (value spliced per client)
+	var prefix bytes.Buffer
+	enc := msgpack.NewEncoder(&prefix)
+	if err := enc.EncodeMapLen(fields); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString("seq"); err != nil {
+		return nil, err
+	}
+	prefix.WriteByte(msgpackInt64Code)
+
+	// Tail: every field after seq (shared by all subscribers)
+	var tail bytes.Buffer
+	enc.Reset(&tail)
+	enc.UseCompactInts(true)
+	if err := enc.EncodeString("ts"); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeInt(header.Timestamp); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString("type"); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(header.Type); err != nil {
+		return nil, err
+	}
+	if header.Priority != 0 {
+		if err := enc.EncodeString("priority"); err != nil {
+			return nil, err
+		}
+		if err := enc.EncodeInt(int64(header.Priority)); err != nil {
+			return nil, err
+		}
+	}
+	if header.Snapshot {
+		if err := enc.EncodeString("snapshot"); err != nil {
+			return nil, err
+		}
+		if err := enc.EncodeBool(true); err != nil {
+			return nil, err
+		}
+	}
+	if err := encodeMsgpackUint(enc, "stream_seq", header.StreamSeq); err != nil {
+		return nil, err
+	}
+	if header.Channel != "" {
+		if err := enc.EncodeString("channel"); err != nil {
+			return nil, err
+		}
+		if err := enc.EncodeString(header.Channel); err != nil {
+			return nil, err
+		}
+	}
+	if err := encodeMsgpackUint(enc, "channel_seq", header.ChannelSeq); err != nil {
+		return nil, err
+	}
+	if err := encodeMsgpackUint(enc, "prev_channel_seq", header.PrevChannelSeq); err != nil {
+		return nil, err
+	}
+	if header.PrevUnknown {
+		if err := enc.EncodeString("prev_channel_seq_unknown"); err != nil {
+			return nil, err
+		}
+		if err := enc.EncodeBool(true); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.EncodeString("data"); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(payload); err != nil {
+		return nil, err
+	}
+
+	return &PreparedMessage{
+		EnvelopeHeader: header,
+		prefix:         prefix.Bytes(),
+		tail:           tail.Bytes(),
+		fixedSeqLen:    true,
+	}, nil
+}
+
+// encodeMsgpackUint writes an optional uint map entry (skipped when zero)
+func encodeMsgpackUint(enc *msgpack.Encoder, key string, value uint64) error {
+	if value == 0 {
+		return nil
+	}
+	if err := enc.EncodeString(key); err != nil {
+		return err
+	}
+	return enc.EncodeUint(value)
+}
+
+// msgpackInt64Code is the MessagePack type marker for a big-endian int64
+const msgpackInt64Code = 0xd3
+
+// appendFixedInt64 appends seq as 8 big-endian bytes (msgpack int64 body)
+func appendFixedInt64(dst []byte, seq int64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(seq))
+	return append(dst, b[:]...)
+}
+
+// jsonToMsgpackValue decodes a JSON payload into values msgpack can encode natively
+// Integers stay integers (json.Number → int64) instead of becoming float64
+func jsonToMsgpackValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+	return convertJSONNumbers(v), nil
+}
+
+func convertJSONNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	case map[string]any:
+		for k, item := range val {
+			val[k] = convertJSONNumbers(item)
+		}
+		return val
+	case []any:
+		for i, item := range val {
+			val[i] = convertJSONNumbers(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// preparedSet lazily prepares one PreparedMessage per codec for a single broadcast
+// Typical fan-out touches 1-2 codecs, so a slice scan beats a map
+// Not thread-safe: owned by the broadcast goroutine
+type preparedSet struct {
+	data    []byte
+	header  EnvelopeHeader
+	entries []preparedEntry
+}
+
+type preparedEntry struct {
+	codec WireCodec
+	msg   *PreparedMessage
+}
+
+func newPreparedSet(data []byte, header EnvelopeHeader) *preparedSet {
+	return &preparedSet{
+		data:    data,
+		header:  header,
+		entries: make([]preparedEntry, 0, len(supportedCodecs)),
+	}
+}
+
+// get returns the prepared envelope for codec, encoding it on first use
+func (ps *preparedSet) get(codec WireCodec) (*PreparedMessage, error) {
+	for _, entry := range ps.entries {
+		if entry.codec == codec {
+			return entry.msg, nil
+		}
+	}
+
+	msg, err := codec.PrepareEnvelope(ps.data, ps.header)
+	if err != nil {
+		return nil, err
+	}
+	ps.entries = append(ps.entries, preparedEntry{codec: codec, msg: msg})
+	return msg, nil
+}
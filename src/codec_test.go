@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiateCodec(t *testing.T) {
+	tests := []struct {
+		protocol      string
+		wantSupported bool
+		wantCodec     WireCodec
+		wantOpCode    ws.OpCode
+	}{
+		{protocol: SubprotocolMsgpack, wantSupported: true, wantCodec: msgpackWireCodec, wantOpCode: ws.OpBinary},
+		{protocol: SubprotocolJSON, wantSupported: true, wantCodec: jsonWireCodec, wantOpCode: ws.OpText},
+		{protocol: "", wantCodec: jsonWireCodec, wantOpCode: ws.OpText},
+		{protocol: "odin.msgpack.v2", wantCodec: jsonWireCodec, wantOpCode: ws.OpText},
+		{protocol: "graphql-ws", wantCodec: jsonWireCodec, wantOpCode: ws.OpText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			if got := isSupportedSubprotocol(tt.protocol); got != tt.wantSupported {
+				t.Errorf("isSupportedSubprotocol(%q) = %v, want %v", tt.protocol, got, tt.wantSupported)
+			}
+			codec := negotiateCodec(tt.protocol)
+			if codec != tt.wantCodec {
+				t.Errorf("negotiateCodec(%q) = %s, want %s", tt.protocol, codec.Subprotocol(), tt.wantCodec.Subprotocol())
+			}
+			if codec.OpCode() != tt.wantOpCode {
+				t.Errorf("OpCode() = %v, want %v", codec.OpCode(), tt.wantOpCode)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeRequest(t *testing.T) {
+	request := map[string]any{
+		"type": "subscribe",
+		"data": map[string]any{"channels": []string{"BTC.trade", "ETH.*"}},
+	}
+	raw, err := msgpack.Marshal(request)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+
+	decoded, err := msgpackCodec{}.DecodeRequest(raw)
+	if err != nil {
+		t.Fatalf("DecodeRequest: %v", err)
+	}
+	var got, want any
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("DecodeRequest returned invalid JSON %q: %v", decoded, err)
+	}
+	wantJSON, _ := json.Marshal(request)
+	_ = json.Unmarshal(wantJSON, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeRequest = %s, want %s", decoded, wantJSON)
+	}
+
+	if _, err := (msgpackCodec{}).DecodeRequest([]byte{0xc1}); err == nil {
+		t.Error("DecodeRequest accepted an invalid msgpack frame")
+	}
+}
+
+func TestJSONToMsgpackValue(t *testing.T) {
+	got, err := jsonToMsgpackValue([]byte(`{"volume": 1234567890123, "price": 45000.5, "tags": [1, 2.5, "x"], "big": 1e400}`))
+	if err != nil {
+		t.Fatalf("jsonToMsgpackValue: %v", err)
+	}
+	want := map[string]any{
+		"volume": int64(1234567890123),
+		"price":  45000.5,
+		"tags":   []any{int64(1), 2.5, "x"},
+		"big":    "1e400", // Out of float64 range - kept verbatim
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("jsonToMsgpackValue = %#v, want %#v", got, want)
+	}
+
+	if _, err := jsonToMsgpackValue([]byte(`{"price":`)); err == nil {
+		t.Error("jsonToMsgpackValue accepted truncated JSON")
+	}
+}
+
+func TestPreparedSetEncodesOncePerCodec(t *testing.T) {
+	ps := newPreparedSet([]byte(`{"price": 1}`), EnvelopeHeader{Type: "token:trade"})
+
+	first, err := ps.get(msgpackWireCodec)
+	if err != nil {
+		t.Fatalf("get(msgpack): %v", err)
+	}
+	if again, _ := ps.get(msgpackWireCodec); again != first {
+		t.Error("second get(msgpack) encoded the envelope again")
+	}
+	asJSON, err := ps.get(jsonWireCodec)
+	if err != nil {
+		t.Fatalf("get(json): %v", err)
+	}
+	if asJSON == first {
+		t.Error("get(json) returned the msgpack envelope")
+	}
+	if len(ps.entries) != 2 {
+		t.Errorf("entries = %d, want 2", len(ps.entries))
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// permessage-deflate (RFC 7692) support
+//
+// Why compress:
+// - Price/analytics payloads are highly repetitive JSON (same field names every frame)
+// - Typical ratio 0.2-0.4 on trade frames → 60-80% less egress for mobile clients
+//
+// Cost:
+// - CPU: one deflate pass per frame per client (frames are compressed in writePump)
+// - Memory: a flate.Writer is ~600KB, so compressors are POOLED unless server
+//   context takeover is enabled (then each connection owns one for its lifetime)
+//
+// Negotiation (browser → server):
+//
+//	Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits
+//
+// Server response (defaults: no context takeover either direction):
+//
+//	Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover
+
+// CompressionConfig controls permessage-deflate negotiation and framing
+type CompressionConfig struct {
+	Enabled bool // Offer permessage-deflate during upgrade (default: false)
+
+	// flate level: 1 (BestSpeed) - 9 (BestCompression)
+	// Level 1 gets ~90% of level 9's ratio on JSON at a fraction of the CPU
+	Level int
+
+	// Context takeover keeps the LZ77 window across messages (better ratio on
+	// repetitive streams) at the cost of per-connection memory:
+	// - Server: ~600KB flate.Writer per connection (not pooled)
+	// - Client: 32KB inflate dictionary per connection
+	ServerContextTakeover bool
+	ClientContextTakeover bool
+
+	// Frames smaller than this are sent uncompressed
+	// Small frames (acks, pongs) often GROW when deflated, and cost CPU for nothing
+	MinSize int
+}
+
+// deflateTail is the empty stored block every compressed message ends with
+// Senders strip it (RFC 7692 §7.2.1), receivers append it back before inflating
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateReadTail = deflateTail + a final empty stored block so the inflater
+// reports io.EOF at the end of each message instead of io.ErrUnexpectedEOF
+var deflateReadTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// ErrInflatedMessageTooLarge is returned when a client message decompresses past
+// WS_MAX_MESSAGE_SIZE (zip bomb protection: a few KB on the wire can inflate to GBs)
+var ErrInflatedMessageTooLarge = errors.New("inflated message exceeds maximum size")
+
+// Compressor pools (one per flate level, indexed by level)
+// Used when server context takeover is disabled: the writer is Reset per message,
+// so any idle connection's compressor can be reused by another
+var flateWriterPools [flate.BestCompression + 1]sync.Pool
+
+func init() {
+	for level := range flateWriterPools {
+		level := level
+		flateWriterPools[level].New = func() any {
+			// Error only on invalid level (0-9 are all valid)
+			fw, _ := flate.NewWriter(nil, level)
+			return fw
+		}
+	}
+}
+
+// deflateNegotiator is the ws.HTTPUpgrader.Negotiate callback for one handshake
+// Stateful (remembers the accepted offer), so create one per connection
+type deflateNegotiator struct {
+	config   CompressionConfig
+	accepted bool
+	params   wsflate.Parameters
+}
+
+// Negotiate accepts the first acceptable permessage-deflate offer
+// Unacceptable offers are declined (not errored) so the client can still connect uncompressed
+func (n *deflateNegotiator) Negotiate(opt httphead.Option) (httphead.Option, error) {
+	if n.accepted || !bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) {
+		return httphead.Option{}, nil
+	}
+
+	var offer wsflate.Parameters
+	if err := offer.Parse(opt); err != nil {
+		return httphead.Option{}, nil // Malformed offer - try the next one
+	}
+
+	// compress/flate always uses a 32KB window (15 bits)
+	// A client asking for a smaller server window can't be honored
+	if offer.ServerMaxWindowBits.Defined() && offer.ServerMaxWindowBits < 15 {
+		return httphead.Option{}, nil
+	}
+
+	n.params = wsflate.Parameters{
+		ServerNoContextTakeover: offer.ServerNoContextTakeover || !n.config.ServerContextTakeover,
+		ClientNoContextTakeover: offer.ClientNoContextTakeover || !n.config.ClientContextTakeover,
+		ServerMaxWindowBits:     offer.ServerMaxWindowBits, // Echo back (only 15 gets here)
+	}
+	n.accepted = true
+
+	return n.params.Option(), nil
+}
+
+// deflateSession holds the per-connection compression state
+// compress is only called from writePump, decompress only from readPump,
+// so each half is single-goroutine and needs no locking
+type deflateSession struct {
+	level   int
+	minSize int
+
+	// Outbound (server → client)
+	serverTakeover bool
+	writer         *flate.Writer // Owned for the connection lifetime when serverTakeover
+	writeBuf       bytes.Buffer
+
+	// Inbound (client → server)
+	clientTakeover bool
+	reader         io.ReadCloser
+	dict           []byte // Last 32KB of inflated client data (clientTakeover only)
+}
+
+func newDeflateSession(config CompressionConfig, params wsflate.Parameters) *deflateSession {
+	return &deflateSession{
+		level:          config.Level,
+		minSize:        config.MinSize,
+		serverTakeover: !params.ServerNoContextTakeover,
+		clientTakeover: !params.ClientNoContextTakeover,
+	}
+}
+
+// compress deflates p and strips the trailing empty block
+// The returned slice is only valid until the next compress call
+func (d *deflateSession) compress(p []byte) ([]byte, error) {
+	d.writeBuf.Reset()
+
+	fw := d.writer
+	if fw == nil {
+		if d.serverTakeover {
+			var err error
+			if fw, err = flate.NewWriter(&d.writeBuf, d.level); err != nil {
+				return nil, err
+			}
+			d.writer = fw
+		} else {
+			pool := &flateWriterPools[d.level]
+			fw = pool.Get().(*flate.Writer)
+			fw.Reset(&d.writeBuf)
+			defer pool.Put(fw)
+		}
+	}
+
+	if _, err := fw.Write(p); err != nil {
+		return nil, err
+	}
+	// Flush (not Close) ends the message on a byte boundary with the empty
+	// stored block - and keeps the window intact for context takeover
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	out := d.writeBuf.Bytes()
+	if !bytes.HasSuffix(out, deflateTail) {
+		return nil, fmt.Errorf("unexpected deflate flush trailer")
+	}
+	return out[:len(out)-len(deflateTail)], nil
+}
+
+// decompress inflates one client message
+func (d *deflateSession) decompress(p []byte, limit int64) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateReadTail))
+
+	var dict []byte
+	if d.clientTakeover {
+		dict = d.dict
+	}
+
+	if d.reader == nil {
+		d.reader = flate.NewReaderDict(src, dict)
+	} else if err := d.reader.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+
+	out, err := io.ReadAll(io.LimitReader(d.reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrInflatedMessageTooLarge
+	}
+
+	if d.clientTakeover {
+		d.dict = append(d.dict, out...)
+		if over := len(d.dict) - wsflate.MaxLZ77WindowSize; over > 0 {
+			d.dict = append(d.dict[:0], d.dict[over:]...)
+		}
+	}
+	return out, nil
+}
+
+// readMessage reads the next data message from the client, inflating it if
+// the sender set RSV1 (permessage-deflate)
+// Control frames (ping/close) are handled inline like wsutil.ReadClientData
+//
+// maxSize caps the message both on the wire (a single frame header announcing
+// more is rejected before its payload is read; fragments are summed) and after
+// inflating - wsutil.ErrFrameTooLarge / ErrMessageTooLarge / ErrInflatedMessageTooLarge
+//
+// Returns the (inflated) payload and the number of bytes received on the wire
+func (c *Client) readMessage(maxSize int64) ([]byte, ws.OpCode, int, error) {
+	state := ws.StateServerSide
+	var msgState wsflate.MessageState
+	var extensions []wsutil.RecvExtension
+	if c.deflate != nil {
+		state |= ws.StateExtended // RSV1 is legal once the extension is negotiated
+		extensions = []wsutil.RecvExtension{&msgState}
+	}
+
+	// Pong/close replies share the connection with writePump (see writeCloseFrame)
+	replyHandler := wsutil.ControlFrameHandler(c.conn, ws.StateServerSide)
+	controlHandler := func(hdr ws.Header, r io.Reader) error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		return replyHandler(hdr, r)
+	}
+	rd := wsutil.Reader{
+		Source:         c.conn,
+		State:          state,
+		CheckUTF8:      false, // Checked below, after inflating
+		Extensions:     extensions,
+		MaxFrameSize:   maxSize,
+		OnIntermediate: controlHandler,
+	}
+
+	for {
+		hdr, err := rd.NextFrame()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := controlHandler(hdr, &rd); err != nil {
+				return nil, 0, 0, err
+			}
+			continue
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(&rd, maxSize+1))
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if int64(len(payload)) > maxSize {
+			return nil, 0, 0, ErrMessageTooLarge
+		}
+		wireSize := len(payload)
+
+		if msgState.IsCompressed() {
+			start := time.Now()
+			payload, err = c.deflate.decompress(payload, maxSize)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			RecordCompression("inbound", len(payload), wireSize, time.Since(start))
+		}
+
+		if hdr.OpCode == ws.OpText && !utf8.Valid(payload) {
+			return nil, 0, 0, wsutil.ErrInvalidUTF8
+		}
+
+		return payload, hdr.OpCode, wireSize, nil
+	}
+}
+
+// writeMessage writes one data message, deflating it when compression was
+// negotiated and the payload is at least MinSize bytes
+//
+// Returns the number of payload bytes written to the wire
+func (c *Client) writeMessage(op ws.OpCode, payload []byte) (int, error) {
+	if c.deflate == nil {
+		return len(payload), wsutil.WriteServerMessage(c.conn, op, payload)
+	}
+
+	if len(payload) < c.deflate.minSize {
+		IncrementCompressionSkipped()
+		return len(payload), wsutil.WriteServerMessage(c.conn, op, payload)
+	}
+
+	start := time.Now()
+	compressed, err := c.deflate.compress(payload)
+	if err != nil {
+		return 0, err
+	}
+	RecordCompression("outbound", len(payload), len(compressed), time.Since(start))
+
+	frame := ws.NewFrame(op, true, compressed)
+	if frame.Header, err = wsflate.SetBit(frame.Header); err != nil {
+		return 0, err
+	}
+	return len(compressed), ws.WriteFrame(c.conn, frame)
+}
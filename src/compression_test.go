@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+func TestDeflateNegotiate(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       CompressionConfig
+		offer        wsflate.Parameters
+		wantAccepted bool
+		want         wsflate.Parameters
+	}{
+		{
+			name:         "no context takeover by default",
+			offer:        wsflate.Parameters{},
+			wantAccepted: true,
+			want:         wsflate.Parameters{ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+		},
+		{
+			name:         "takeover enabled",
+			config:       CompressionConfig{ServerContextTakeover: true, ClientContextTakeover: true},
+			offer:        wsflate.Parameters{},
+			wantAccepted: true,
+			want:         wsflate.Parameters{},
+		},
+		{
+			name:         "client refuses takeover",
+			config:       CompressionConfig{ServerContextTakeover: true, ClientContextTakeover: true},
+			offer:        wsflate.Parameters{ServerNoContextTakeover: true},
+			wantAccepted: true,
+			want:         wsflate.Parameters{ServerNoContextTakeover: true},
+		},
+		{
+			name:         "full server window echoed",
+			offer:        wsflate.Parameters{ServerMaxWindowBits: 15},
+			wantAccepted: true,
+			want:         wsflate.Parameters{ServerNoContextTakeover: true, ClientNoContextTakeover: true, ServerMaxWindowBits: 15},
+		},
+		{
+			name:  "smaller server window declined",
+			offer: wsflate.Parameters{ServerMaxWindowBits: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &deflateNegotiator{config: tt.config}
+			resp, err := n.Negotiate(tt.offer.Option())
+			if err != nil {
+				t.Fatalf("Negotiate: %v", err)
+			}
+			if n.accepted != tt.wantAccepted {
+				t.Fatalf("accepted = %v, want %v", n.accepted, tt.wantAccepted)
+			}
+			if !tt.wantAccepted {
+				if resp.Size() != 0 {
+					t.Errorf("declined offer answered with %s", resp.String())
+				}
+				return
+			}
+			if n.params != tt.want {
+				t.Errorf("params = %+v, want %+v", n.params, tt.want)
+			}
+			if resp.String() != tt.want.Option().String() {
+				t.Errorf("response = %s, want %s", resp.String(), tt.want.Option().String())
+			}
+		})
+	}
+}
+
+func TestDeflateNegotiateFirstOfferOnly(t *testing.T) {
+	n := &deflateNegotiator{}
+
+	other := httphead.Option{Name: []byte("x-webkit-deflate-frame")}
+	if resp, _ := n.Negotiate(other); resp.Size() != 0 || n.accepted {
+		t.Fatal("accepted an unrelated extension")
+	}
+	if resp, _ := n.Negotiate(wsflate.Parameters{}.Option()); resp.Size() == 0 {
+		t.Fatal("declined a plain permessage-deflate offer")
+	}
+	if resp, _ := n.Negotiate(wsflate.Parameters{ClientNoContextTakeover: true}.Option()); resp.Size() != 0 {
+		t.Error("answered a second offer after accepting one")
+	}
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	messages := [][]byte{
+		[]byte(`{"type":"token:trade","channel":"BTC.trade","data":{"price":45000.5,"volume":1234567}}`),
+		[]byte(`{"type":"token:trade","channel":"BTC.trade","data":{"price":45001.0,"volume":1234570}}`),
+		[]byte(strings.Repeat(`{"type":"token:trade"}`, 500)),
+	}
+
+	for _, takeover := range []bool{false, true} {
+		params := wsflate.Parameters{ServerNoContextTakeover: !takeover, ClientNoContextTakeover: !takeover}
+		// Level 9: lower levels encode frames this short without back-references
+		server := newDeflateSession(CompressionConfig{Level: 9}, params)
+		client := newDeflateSession(CompressionConfig{}, params)
+
+		var sizes []int
+		for i, msg := range messages {
+			compressed, err := server.compress(msg)
+			if err != nil {
+				t.Fatalf("takeover=%v: compress #%d: %v", takeover, i, err)
+			}
+			sizes = append(sizes, len(compressed))
+
+			// Inflating consumes a copy: compress reuses its buffer
+			got, err := client.decompress(append([]byte(nil), compressed...), 1<<20)
+			if err != nil {
+				t.Fatalf("takeover=%v: decompress #%d: %v", takeover, i, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Errorf("takeover=%v: message #%d = %q, want %q", takeover, i, got, msg)
+			}
+		}
+
+		// The second trade frame repeats the first: with the window kept it
+		// compresses to a fraction of it
+		if takeover && sizes[1] >= sizes[0]/2 {
+			t.Errorf("takeover: second frame %d bytes, first %d - window not kept", sizes[1], sizes[0])
+		}
+		if !takeover && sizes[1] < sizes[0]/2 {
+			t.Errorf("no takeover: second frame %d bytes, first %d - window leaked across messages", sizes[1], sizes[0])
+		}
+	}
+}
+
+func TestDeflateDecompressLimit(t *testing.T) {
+	session := newDeflateSession(CompressionConfig{Level: 9}, wsflate.Parameters{ServerNoContextTakeover: true, ClientNoContextTakeover: true})
+
+	bomb, err := session.compress(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	bomb = append([]byte(nil), bomb...)
+
+	if _, err := session.decompress(bomb, 64<<10); !errors.Is(err, ErrInflatedMessageTooLarge) {
+		t.Errorf("decompress past the limit: err = %v, want %v", err, ErrInflatedMessageTooLarge)
+	}
+	if out, err := session.decompress(bomb, 1<<20); err != nil || len(out) != 1<<20 {
+		t.Errorf("decompress at the limit = %d bytes, %v", len(out), err)
+	}
+}
+
+func TestWriteMessageCompressesFromMinSize(t *testing.T) {
+	params := wsflate.Parameters{ServerNoContextTakeover: true, ClientNoContextTakeover: true}
+	small := []byte(`{"type":"pong"}`)
+	large := []byte(strings.Repeat(`{"price":45000.5}`, 20))
+
+	tests := []struct {
+		name           string
+		payload        []byte
+		wantCompressed bool
+	}{
+		{name: "below min size", payload: small},
+		{name: "at min size", payload: large, wantCompressed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, peer := net.Pipe()
+			defer serverConn.Close()
+			defer peer.Close()
+
+			c := &Client{conn: serverConn, deflate: newDeflateSession(CompressionConfig{Level: 1, MinSize: len(large)}, params)}
+			errc := make(chan error, 1)
+			go func() {
+				_, err := c.writeMessage(ws.OpText, tt.payload)
+				errc <- err
+			}()
+
+			frame, err := ws.ReadFrame(peer)
+			if err != nil {
+				t.Fatalf("ReadFrame: %v", err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatalf("writeMessage: %v", err)
+			}
+
+			compressed, err := wsflate.IsCompressed(frame.Header)
+			if err != nil {
+				t.Fatalf("IsCompressed: %v", err)
+			}
+			if compressed != tt.wantCompressed {
+				t.Fatalf("RSV1 = %v, want %v", compressed, tt.wantCompressed)
+			}
+			payload := frame.Payload
+			if compressed {
+				inflater := newDeflateSession(CompressionConfig{}, params)
+				if payload, err = inflater.decompress(payload, 1<<20); err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("payload = %q, want %q", payload, tt.payload)
+			}
+		})
+	}
+}
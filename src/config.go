@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 )
 
@@ -48,6 +50,117 @@ type Config struct {
 	JSStreamName      string        `env:"JS_STREAM_NAME" envDefault:"ODIN_TOKENS"`
 	JSConsumerName    string        `env:"JS_CONSUMER_NAME" envDefault:"ws-server"`
 
+	// Stream sources: streams, subjects and subject → channel mappings (JSON)
+	// Empty = one token source from JS_STREAM_* (odin.token.> → {symbol}.{event})
+	JSSourcesFile string `env:"JS_SOURCES_FILE" envDefault:""`
+
+	// Interest-based consumption: consumer filters follow local subscriptions
+	JSInterestSubscriptions bool          `env:"JS_INTEREST_SUBSCRIPTIONS" envDefault:"false"`
+	JSInterestDebounce      time.Duration `env:"JS_INTEREST_DEBOUNCE" envDefault:"250ms"`
+	JSInterestLinger        time.Duration `env:"JS_INTEREST_LINGER" envDefault:"30s"`
+
+	// JetStream consumer mode (instance = full feed per instance, shared = split)
+	JSConsumerMode              string        `env:"JS_CONSUMER_MODE" envDefault:"instance"`
+	JSConsumerStart             string        `env:"JS_CONSUMER_START" envDefault:"new"`
+	JSInstanceID                string        `env:"JS_INSTANCE_ID" envDefault:""`
+	JSConsumerInactiveThreshold time.Duration `env:"JS_CONSUMER_INACTIVE_THRESHOLD" envDefault:"5m"`
+
+	// Pull consumption (batch = min(batch size, free worker queue, NATS rate tokens))
+	JSFetchBatchSize int           `env:"JS_FETCH_BATCH_SIZE" envDefault:"100"`
+	JSFetchMaxWait   time.Duration `env:"JS_FETCH_MAX_WAIT" envDefault:"500ms"`
+
+	// Redelivery and dead letters
+	JSConsumerMaxDeliver int           `env:"JS_CONSUMER_MAX_DELIVER" envDefault:"5"`
+	JSNakBackoffBase     time.Duration `env:"JS_NAK_BACKOFF_BASE" envDefault:"1s"`
+	JSNakBackoffMax      time.Duration `env:"JS_NAK_BACKOFF_MAX" envDefault:"30s"`
+	JSDeadLetterSubject  string        `env:"JS_DEAD_LETTER_SUBJECT" envDefault:""` // Empty = disabled (opt-in)
+	JSDeadLetterStream   string        `env:"JS_DEAD_LETTER_STREAM" envDefault:"ODIN_DEAD_LETTERS"`
+
+	// Message routing (channel → envelope type + priority)
+	// Format: "pattern=type/priority,..." - pattern is an event type ("social") or channel pattern ("BTC.*")
+	MessageRoutes        string `env:"WS_MESSAGE_ROUTES" envDefault:""`
+	MessageRouteFallback string `env:"WS_MESSAGE_ROUTE_FALLBACK" envDefault:"token:update/normal"`
+
+	// Deep replay (replay requests beyond the in-memory buffer served from JetStream)
+	DeepReplayEnabled       bool          `env:"WS_DEEP_REPLAY_ENABLED" envDefault:"false"`
+	DeepReplayMaxMessages   int           `env:"WS_DEEP_REPLAY_MAX_MESSAGES" envDefault:"1000"`
+	DeepReplayTimeout       time.Duration `env:"WS_DEEP_REPLAY_TIMEOUT" envDefault:"5s"`
+	DeepReplayMaxConcurrent int           `env:"WS_DEEP_REPLAY_MAX_CONCURRENT" envDefault:"4"`
+
+	// Last-value cache (snapshot-on-subscribe)
+	LVCEnabled          bool          `env:"WS_LVC_ENABLED" envDefault:"true"`
+	LVCMaxBytes         int64         `env:"WS_LVC_MAX_BYTES" envDefault:"16777216"` // 16MB
+	LVCDefaultRetention time.Duration `env:"WS_LVC_DEFAULT_RETENTION" envDefault:"10m"`
+	LVCRetention        string        `env:"WS_LVC_RETENTION" envDefault:"trade=1m,liquidity=1m,metadata=24h,analytics=1h"`
+
+	// Authentication (JWT on the WebSocket handshake)
+	AuthMode       string        `env:"WS_AUTH_MODE" envDefault:"off"` // off, optional, required
+	JWTHS256Secret string        `env:"WS_JWT_HS256_SECRET" envDefault:""`
+	JWTJWKSFile    string        `env:"WS_JWT_JWKS_FILE" envDefault:""`
+	JWTIssuer      string        `env:"WS_JWT_ISSUER" envDefault:""`
+	JWTAudience    string        `env:"WS_JWT_AUDIENCE" envDefault:""`
+	JWTLeeway      time.Duration `env:"WS_JWT_LEEWAY" envDefault:"30s"`
+	AuthTimeout    time.Duration `env:"WS_AUTH_TIMEOUT" envDefault:"5s"`
+
+	// Connection lifetime (token expiry watchdog, in-band reauth)
+	MaxConnectionLifetime time.Duration `env:"WS_MAX_CONNECTION_LIFETIME" envDefault:"0"` // 0 = unlimited
+	AuthExpiryWarning     time.Duration `env:"WS_AUTH_EXPIRY_WARNING" envDefault:"60s"`
+
+	// Handshake hardening and inbound message limits
+	AllowedOrigins   string        `env:"WS_ALLOWED_ORIGINS" envDefault:""`       // Empty = any origin
+	MaxMessageSize   int64         `env:"WS_MAX_MESSAGE_SIZE" envDefault:"65536"` // 64KB
+	HandshakeTimeout time.Duration `env:"WS_HANDSHAKE_TIMEOUT" envDefault:"10s"`
+
+	// Per-IP connection limits and global accept rate (0 = unlimited)
+	// Per-IP limits are off by default: users behind one NAT/CGNAT address and
+	// load tests from a single host would hit any fixed value
+	MaxConnectionsPerIP  int     `env:"WS_MAX_CONNECTIONS_PER_IP" envDefault:"0"`
+	ConnectionRatePerIP  float64 `env:"WS_CONNECTION_RATE_PER_IP" envDefault:"0"`
+	ConnectionBurstPerIP int     `env:"WS_CONNECTION_BURST_PER_IP" envDefault:"20"`
+	AcceptRate           float64 `env:"WS_ACCEPT_RATE" envDefault:"500"`
+	AcceptBurst          int     `env:"WS_ACCEPT_BURST" envDefault:"1000"`
+	TrustedProxies       string  `env:"WS_TRUSTED_PROXIES" envDefault:""`
+	ProxyProtocol        string  `env:"WS_PROXY_PROTOCOL" envDefault:"off"` // off, optional, required
+
+	// Channel authorization (subscribe policy)
+	AuthzPolicyFile string `env:"WS_AUTHZ_POLICY_FILE" envDefault:""`
+
+	// Subscription quotas and channel validation (0 = unlimited)
+	MaxSubscriptionsPerClient int    `env:"WS_MAX_SUBSCRIPTIONS_PER_CLIENT" envDefault:"500"`
+	MaxChannelsPerRequest     int    `env:"WS_MAX_CHANNELS_PER_REQUEST" envDefault:"100"`
+	ChannelEventTypes         string `env:"WS_CHANNEL_EVENT_TYPES" envDefault:"trade,liquidity,metadata,social,favorites,creation,analytics,balances"`
+
+	// Admin API (separate listener, empty address = disabled)
+	AdminAddr         string `env:"WS_ADMIN_ADDR" envDefault:""`
+	AdminToken        string `env:"WS_ADMIN_TOKEN" envDefault:""`
+	AdminTLSCertFile  string `env:"WS_ADMIN_TLS_CERT_FILE" envDefault:""`
+	AdminTLSKeyFile   string `env:"WS_ADMIN_TLS_KEY_FILE" envDefault:""`
+	AdminClientCAFile string `env:"WS_ADMIN_CLIENT_CA_FILE" envDefault:""`
+
+	// Ban list (reloaded on SIGHUP and POST /admin/banlist/reload)
+	BanListFile string `env:"WS_BAN_LIST_FILE" envDefault:""`
+
+	// Per-client message rate limits
+	RateLimitBurst                float64       `env:"WS_RATE_LIMIT_BURST" envDefault:"100"`
+	RateLimitRate                 float64       `env:"WS_RATE_LIMIT_RATE" envDefault:"10"`
+	RateLimitCosts                string        `env:"WS_RATE_LIMIT_COSTS" envDefault:"heartbeat=0.2,subscribe=2,replay=2"`
+	RateLimitReplayCostPerMessage float64       `env:"WS_RATE_LIMIT_REPLAY_COST_PER_MESSAGE" envDefault:"0.1"`
+	RateLimitRoles                string        `env:"WS_RATE_LIMIT_ROLES" envDefault:""` // "role=burst/rate,..."
+	RateLimitViolationWindow      time.Duration `env:"WS_RATE_LIMIT_VIOLATION_WINDOW" envDefault:"60s"`
+	RateLimitMuteAfter            int           `env:"WS_RATE_LIMIT_MUTE_AFTER" envDefault:"3"`
+	RateLimitMuteDuration         time.Duration `env:"WS_RATE_LIMIT_MUTE_DURATION" envDefault:"10s"`
+	RateLimitCloseAfter           int           `env:"WS_RATE_LIMIT_CLOSE_AFTER" envDefault:"10"`
+
+	// Resumable sessions (0 = disabled)
+	SessionGracePeriod time.Duration `env:"WS_SESSION_GRACE_PERIOD" envDefault:"30s"`
+
+	// permessage-deflate (RFC 7692)
+	CompressionEnabled               bool `env:"WS_COMPRESSION_ENABLED" envDefault:"false"`
+	CompressionLevel                 int  `env:"WS_COMPRESSION_LEVEL" envDefault:"1"` // 1 = BestSpeed, 9 = BestCompression
+	CompressionServerContextTakeover bool `env:"WS_COMPRESSION_SERVER_CONTEXT_TAKEOVER" envDefault:"false"`
+	CompressionClientContextTakeover bool `env:"WS_COMPRESSION_CLIENT_CONTEXT_TAKEOVER" envDefault:"false"`
+	CompressionMinSize               int  `env:"WS_COMPRESSION_MIN_SIZE" envDefault:"256"` // bytes
+
 	// Monitoring
 	MetricsInterval time.Duration `env:"METRICS_INTERVAL" envDefault:"15s"`
 
@@ -140,6 +253,177 @@ func (c *Config) Validate() error {
 	if c.CPUPauseThreshold < 0 || c.CPUPauseThreshold > 100 {
 		return fmt.Errorf("WS_CPU_PAUSE_THRESHOLD must be 0-100, got %.1f", c.CPUPauseThreshold)
 	}
+	if c.JSConsumerMode != ConsumerModeInstance && c.JSConsumerMode != ConsumerModeShared {
+		return fmt.Errorf("JS_CONSUMER_MODE must be instance or shared, got %q", c.JSConsumerMode)
+	}
+	if c.JSConsumerStart != ConsumerStartNew && c.JSConsumerStart != ConsumerStartResume {
+		return fmt.Errorf("JS_CONSUMER_START must be new or resume, got %q", c.JSConsumerStart)
+	}
+	if c.JSConsumerInactiveThreshold < time.Minute {
+		return fmt.Errorf("JS_CONSUMER_INACTIVE_THRESHOLD must be >= 1m, got %s", c.JSConsumerInactiveThreshold)
+	}
+	if c.JSFetchBatchSize < 1 {
+		return fmt.Errorf("JS_FETCH_BATCH_SIZE must be > 0, got %d", c.JSFetchBatchSize)
+	}
+	if c.JSFetchMaxWait < 10*time.Millisecond {
+		return fmt.Errorf("JS_FETCH_MAX_WAIT must be >= 10ms, got %s", c.JSFetchMaxWait)
+	}
+	if c.JSInterestSubscriptions {
+		if c.JSConsumerMode != ConsumerModeInstance || c.JSConsumerStart != ConsumerStartNew {
+			return fmt.Errorf("JS_INTEREST_SUBSCRIPTIONS requires JS_CONSUMER_MODE=instance and JS_CONSUMER_START=new")
+		}
+		if c.JSInterestDebounce < 10*time.Millisecond {
+			return fmt.Errorf("JS_INTEREST_DEBOUNCE must be >= 10ms, got %s", c.JSInterestDebounce)
+		}
+		if c.JSInterestLinger < 0 {
+			return fmt.Errorf("JS_INTEREST_LINGER must be >= 0, got %s", c.JSInterestLinger)
+		}
+	}
+	if c.JSConsumerMaxDeliver < 1 {
+		return fmt.Errorf("JS_CONSUMER_MAX_DELIVER must be > 0, got %d", c.JSConsumerMaxDeliver)
+	}
+	if c.JSNakBackoffBase <= 0 || c.JSNakBackoffMax < c.JSNakBackoffBase {
+		return fmt.Errorf("JS_NAK_BACKOFF_BASE must be > 0 and <= JS_NAK_BACKOFF_MAX, got %s/%s", c.JSNakBackoffBase, c.JSNakBackoffMax)
+	}
+	sourceSubjects := []string{defaultTokenSubject}
+	if c.JSSourcesFile != "" {
+		sources, err := LoadStreamSources(c.JSSourcesFile)
+		if err != nil {
+			return fmt.Errorf("JS_SOURCES_FILE: %w", err)
+		}
+		sourceSubjects = nil
+		for _, source := range sources {
+			sourceSubjects = append(sourceSubjects, source.Config.Subjects...)
+		}
+		// Deep replay reads the first (primary) source - interest retention would leave no history
+		if c.DeepReplayEnabled && sources[0].Config.Retention == nats.InterestPolicy {
+			return fmt.Errorf("WS_DEEP_REPLAY_ENABLED requires limits retention, but primary source %s uses interest retention", sources[0].Name)
+		}
+	}
+	if c.JSDeadLetterSubject != "" {
+		if err := ValidateChannelPattern(c.JSDeadLetterSubject); err != nil || strings.ContainsAny(c.JSDeadLetterSubject, "*>") {
+			return fmt.Errorf("JS_DEAD_LETTER_SUBJECT must be a literal subject, got %q", c.JSDeadLetterSubject)
+		}
+		for _, subject := range sourceSubjects {
+			if MatchChannelPattern(subject, c.JSDeadLetterSubject) {
+				return fmt.Errorf("JS_DEAD_LETTER_SUBJECT must not be under source subject %s (it would be consumed again)", subject)
+			}
+		}
+	}
+	if c.DeepReplayMaxMessages < 1 {
+		return fmt.Errorf("WS_DEEP_REPLAY_MAX_MESSAGES must be > 0, got %d", c.DeepReplayMaxMessages)
+	}
+	if c.DeepReplayTimeout <= 0 {
+		return fmt.Errorf("WS_DEEP_REPLAY_TIMEOUT must be > 0, got %s", c.DeepReplayTimeout)
+	}
+	if c.DeepReplayMaxConcurrent < 1 {
+		return fmt.Errorf("WS_DEEP_REPLAY_MAX_CONCURRENT must be > 0, got %d", c.DeepReplayMaxConcurrent)
+	}
+	if c.LVCMaxBytes < 1 {
+		return fmt.Errorf("WS_LVC_MAX_BYTES must be > 0, got %d", c.LVCMaxBytes)
+	}
+	if c.LVCDefaultRetention < 0 {
+		return fmt.Errorf("WS_LVC_DEFAULT_RETENTION must be >= 0, got %s", c.LVCDefaultRetention)
+	}
+	if c.JWTLeeway < 0 {
+		return fmt.Errorf("WS_JWT_LEEWAY must be >= 0, got %s", c.JWTLeeway)
+	}
+	if c.AuthTimeout <= 0 {
+		return fmt.Errorf("WS_AUTH_TIMEOUT must be > 0, got %s", c.AuthTimeout)
+	}
+	if c.MaxConnectionLifetime < 0 {
+		return fmt.Errorf("WS_MAX_CONNECTION_LIFETIME must be >= 0, got %s", c.MaxConnectionLifetime)
+	}
+	if c.AuthExpiryWarning < 0 {
+		return fmt.Errorf("WS_AUTH_EXPIRY_WARNING must be >= 0, got %s", c.AuthExpiryWarning)
+	}
+	if c.MaxMessageSize < 1024 {
+		return fmt.Errorf("WS_MAX_MESSAGE_SIZE must be >= 1024, got %d", c.MaxMessageSize)
+	}
+	if c.HandshakeTimeout <= 0 {
+		return fmt.Errorf("WS_HANDSHAKE_TIMEOUT must be > 0, got %s", c.HandshakeTimeout)
+	}
+	if _, err := ParseOriginAllowlist(c.AllowedOrigins); err != nil {
+		return fmt.Errorf("WS_ALLOWED_ORIGINS invalid: %w", err)
+	}
+	if c.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("WS_MAX_CONNECTIONS_PER_IP must be >= 0, got %d", c.MaxConnectionsPerIP)
+	}
+	if c.ConnectionRatePerIP < 0 || c.AcceptRate < 0 {
+		return fmt.Errorf("WS_CONNECTION_RATE_PER_IP and WS_ACCEPT_RATE must be >= 0")
+	}
+	if c.ConnectionRatePerIP > 0 && c.ConnectionBurstPerIP < 1 {
+		return fmt.Errorf("WS_CONNECTION_BURST_PER_IP must be > 0, got %d", c.ConnectionBurstPerIP)
+	}
+	if c.AcceptRate > 0 && c.AcceptBurst < 1 {
+		return fmt.Errorf("WS_ACCEPT_BURST must be > 0, got %d", c.AcceptBurst)
+	}
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		return fmt.Errorf("WS_TRUSTED_PROXIES invalid: %w", err)
+	}
+	switch c.ProxyProtocol {
+	case ProxyProtocolOff:
+	case ProxyProtocolOptional, ProxyProtocolRequired:
+		// PROXY headers are only accepted from trusted proxies - none trusted = feature inert
+		if strings.TrimSpace(c.TrustedProxies) == "" {
+			return fmt.Errorf("WS_PROXY_PROTOCOL=%s requires WS_TRUSTED_PROXIES", c.ProxyProtocol)
+		}
+	default:
+		return fmt.Errorf("WS_PROXY_PROTOCOL must be off, optional or required, got %q", c.ProxyProtocol)
+	}
+	if c.AdminAddr != "" {
+		// Never serve connection management unauthenticated
+		if c.AdminToken == "" && c.AdminClientCAFile == "" {
+			return fmt.Errorf("WS_ADMIN_ADDR requires WS_ADMIN_TOKEN or WS_ADMIN_CLIENT_CA_FILE")
+		}
+		if (c.AdminTLSCertFile == "") != (c.AdminTLSKeyFile == "") {
+			return fmt.Errorf("WS_ADMIN_TLS_CERT_FILE and WS_ADMIN_TLS_KEY_FILE must be set together")
+		}
+		if c.AdminClientCAFile != "" && c.AdminTLSCertFile == "" {
+			return fmt.Errorf("WS_ADMIN_CLIENT_CA_FILE requires WS_ADMIN_TLS_CERT_FILE (mTLS needs TLS)")
+		}
+	}
+	if c.BanListFile != "" {
+		if _, err := LoadBanList(c.BanListFile); err != nil {
+			return fmt.Errorf("WS_BAN_LIST_FILE invalid: %w", err)
+		}
+	}
+	if c.MaxSubscriptionsPerClient < 0 || c.MaxChannelsPerRequest < 0 {
+		return fmt.Errorf("WS_MAX_SUBSCRIPTIONS_PER_CLIENT and WS_MAX_CHANNELS_PER_REQUEST must be >= 0")
+	}
+	if _, err := ParseEventTypes(c.ChannelEventTypes); err != nil {
+		return fmt.Errorf("WS_CHANNEL_EVENT_TYPES invalid: %w", err)
+	}
+	if c.RateLimitBurst <= 0 || c.RateLimitRate <= 0 {
+		return fmt.Errorf("WS_RATE_LIMIT_BURST and WS_RATE_LIMIT_RATE must be > 0, got %.1f/%.1f", c.RateLimitBurst, c.RateLimitRate)
+	}
+	if _, err := ParseMessageCosts(c.RateLimitCosts); err != nil {
+		return fmt.Errorf("WS_RATE_LIMIT_COSTS invalid: %w", err)
+	}
+	if c.RateLimitReplayCostPerMessage < 0 {
+		return fmt.Errorf("WS_RATE_LIMIT_REPLAY_COST_PER_MESSAGE must be >= 0, got %.2f", c.RateLimitReplayCostPerMessage)
+	}
+	if _, err := ParseRoleRateLimits(c.RateLimitRoles); err != nil {
+		return fmt.Errorf("WS_RATE_LIMIT_ROLES invalid: %w", err)
+	}
+	if c.RateLimitViolationWindow <= 0 {
+		return fmt.Errorf("WS_RATE_LIMIT_VIOLATION_WINDOW must be > 0, got %s", c.RateLimitViolationWindow)
+	}
+	if c.RateLimitMuteAfter < 0 || c.RateLimitCloseAfter < 0 {
+		return fmt.Errorf("WS_RATE_LIMIT_MUTE_AFTER and WS_RATE_LIMIT_CLOSE_AFTER must be >= 0")
+	}
+	if c.RateLimitMuteAfter > 0 && c.RateLimitMuteDuration <= 0 {
+		return fmt.Errorf("WS_RATE_LIMIT_MUTE_DURATION must be > 0, got %s", c.RateLimitMuteDuration)
+	}
+	if c.SessionGracePeriod < 0 {
+		return fmt.Errorf("WS_SESSION_GRACE_PERIOD must be >= 0, got %s", c.SessionGracePeriod)
+	}
+	if c.CompressionLevel < 1 || c.CompressionLevel > 9 {
+		return fmt.Errorf("WS_COMPRESSION_LEVEL must be 1-9, got %d", c.CompressionLevel)
+	}
+	if c.CompressionMinSize < 0 {
+		return fmt.Errorf("WS_COMPRESSION_MIN_SIZE must be >= 0, got %d", c.CompressionMinSize)
+	}
 
 	// Logical checks
 	if c.CPUPauseThreshold < c.CPURejectThreshold {
@@ -153,11 +437,29 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("LOG_LEVEL must be one of: debug, info, warn, error (got: %s)", c.LogLevel)
 	}
 
+	validAuthModes := map[string]bool{"off": true, "optional": true, "required": true}
+	if !validAuthModes[c.AuthMode] {
+		return fmt.Errorf("WS_AUTH_MODE must be one of: off, optional, required (got: %s)", c.AuthMode)
+	}
+	if c.AuthMode != "off" && c.JWTHS256Secret == "" && c.JWTJWKSFile == "" {
+		return fmt.Errorf("WS_AUTH_MODE=%s requires WS_JWT_HS256_SECRET or WS_JWT_JWKS_FILE", c.AuthMode)
+	}
+
 	validLogFormats := map[string]bool{"json": true, "text": true, "pretty": true}
 	if !validLogFormats[c.LogFormat] {
 		return fmt.Errorf("LOG_FORMAT must be one of: json, text, pretty (got: %s)", c.LogFormat)
 	}
 
+	// Last-value cache retention syntax
+	if _, err := ParseLVCRetention(c.LVCRetention); err != nil {
+		return fmt.Errorf("WS_LVC_RETENTION invalid: %w", err)
+	}
+
+	// Routing table syntax
+	if _, err := NewMessageRouter(c.MessageRoutes, c.MessageRouteFallback); err != nil {
+		return fmt.Errorf("WS_MESSAGE_ROUTES/WS_MESSAGE_ROUTE_FALLBACK invalid: %w", err)
+	}
+
 	return nil
 }
 
@@ -184,10 +486,71 @@ func (c *Config) Print() {
 	fmt.Printf("CPU Pause:       %.1f%%\n", c.CPUPauseThreshold)
 	fmt.Println("\n=== JetStream ===")
 	fmt.Printf("Stream:          %s\n", c.JSStreamName)
+	fmt.Printf("Sources:         %s\n", sourcesStatus(c.JSSourcesFile))
+	fmt.Printf("Interest Filter: %s\n", interestStatus(c.JSInterestSubscriptions, c.JSInterestDebounce, c.JSInterestLinger))
 	fmt.Printf("Consumer:        %s\n", c.JSConsumerName)
+	fmt.Printf("Consumer Mode:   %s (start: %s, instance: %s)\n", c.JSConsumerMode, c.JSConsumerStart, instanceIDStatus(c.JSInstanceID))
+	fmt.Printf("Inactive After:  %s\n", c.JSConsumerInactiveThreshold)
+	fmt.Printf("Fetch:           batch %d, max wait %s\n", c.JSFetchBatchSize, c.JSFetchMaxWait)
+	fmt.Printf("Max Deliver:     %d (NAK backoff %s..%s)\n", c.JSConsumerMaxDeliver, c.JSNakBackoffBase, c.JSNakBackoffMax)
+	fmt.Printf("Dead Letters:    %s\n", deadLetterStatus(c.JSDeadLetterSubject, c.JSDeadLetterStream))
 	fmt.Printf("Max Age:         %s\n", c.JSStreamMaxAge)
 	fmt.Printf("Max Messages:    %d\n", c.JSStreamMaxMsgs)
 	fmt.Printf("Max Bytes:       %d MB\n", c.JSStreamMaxBytes/(1024*1024))
+	fmt.Println("\n=== Deep Replay ===")
+	fmt.Printf("Enabled:         %t\n", c.DeepReplayEnabled)
+	fmt.Printf("Max Messages:    %d\n", c.DeepReplayMaxMessages)
+	fmt.Printf("Timeout:         %s\n", c.DeepReplayTimeout)
+	fmt.Printf("Max Concurrent:  %d\n", c.DeepReplayMaxConcurrent)
+	fmt.Println("\n=== Message Routing ===")
+	fmt.Printf("Routes:          %s\n", c.MessageRoutes)
+	fmt.Printf("Fallback:        %s\n", c.MessageRouteFallback)
+	fmt.Println("\n=== Last-Value Cache ===")
+	fmt.Printf("Enabled:         %t\n", c.LVCEnabled)
+	fmt.Printf("Max Size:        %d MB\n", c.LVCMaxBytes/(1024*1024))
+	fmt.Printf("Retention:       %s (default: %s)\n", c.LVCRetention, c.LVCDefaultRetention)
+	fmt.Println("\n=== Authentication ===")
+	fmt.Printf("Mode:            %s\n", c.AuthMode)
+	fmt.Printf("HS256 Secret:    %s\n", secretStatus(c.JWTHS256Secret))
+	fmt.Printf("JWKS File:       %s\n", c.JWTJWKSFile)
+	fmt.Printf("Issuer:          %s\n", c.JWTIssuer)
+	fmt.Printf("Audience:        %s\n", c.JWTAudience)
+	fmt.Printf("Leeway:          %s\n", c.JWTLeeway)
+	fmt.Printf("Auth Timeout:    %s\n", c.AuthTimeout)
+	fmt.Printf("Channel Policy:  %s\n", c.AuthzPolicyFile)
+	fmt.Printf("Subscriptions:   %d per client, %d channels per request\n", c.MaxSubscriptionsPerClient, c.MaxChannelsPerRequest)
+	fmt.Printf("Event Types:     %s\n", c.ChannelEventTypes)
+	fmt.Printf("Max Lifetime:    %s (0 = unlimited)\n", c.MaxConnectionLifetime)
+	fmt.Printf("Expiry Warning:  %s\n", c.AuthExpiryWarning)
+	fmt.Println("\n=== Handshake Hardening ===")
+	fmt.Printf("Allowed Origins: %s\n", originsStatus(c.AllowedOrigins))
+	fmt.Printf("Max Message:     %d KB\n", c.MaxMessageSize/1024)
+	fmt.Printf("Handshake:       %s timeout\n", c.HandshakeTimeout)
+	fmt.Println("\n=== Connection Limits ===")
+	fmt.Printf("Per IP:          %d connections, %.1f/sec (burst %d)\n", c.MaxConnectionsPerIP, c.ConnectionRatePerIP, c.ConnectionBurstPerIP)
+	fmt.Printf("Accept Rate:     %.0f/sec (burst %d)\n", c.AcceptRate, c.AcceptBurst)
+	fmt.Printf("Trusted Proxies: %s\n", c.TrustedProxies)
+	fmt.Printf("PROXY Protocol:  %s\n", c.ProxyProtocol)
+	fmt.Println("\n=== Message Rate Limits ===")
+	fmt.Printf("Per Client:      %.0f burst, %.1f/sec\n", c.RateLimitBurst, c.RateLimitRate)
+	fmt.Printf("Costs:           %s (replay +%.2f/message)\n", c.RateLimitCosts, c.RateLimitReplayCostPerMessage)
+	fmt.Printf("Role Overrides:  %s\n", c.RateLimitRoles)
+	fmt.Printf("Penalties:       mute %s after %d, close after %d (per %s)\n",
+		c.RateLimitMuteDuration, c.RateLimitMuteAfter, c.RateLimitCloseAfter, c.RateLimitViolationWindow)
+	fmt.Println("\n=== Admin API ===")
+	fmt.Printf("Address:         %s\n", adminStatus(c.AdminAddr))
+	fmt.Printf("Token:           %s\n", secretStatus(c.AdminToken))
+	fmt.Printf("TLS / mTLS:      %t / %t\n", c.AdminTLSCertFile != "", c.AdminClientCAFile != "")
+	fmt.Println("\n=== Ban List ===")
+	fmt.Printf("File:            %s\n", banListStatus(c.BanListFile))
+	fmt.Println("\n=== Sessions ===")
+	fmt.Printf("Grace Period:    %s\n", c.SessionGracePeriod)
+	fmt.Println("\n=== Compression ===")
+	fmt.Printf("Enabled:         %t\n", c.CompressionEnabled)
+	fmt.Printf("Level:           %d\n", c.CompressionLevel)
+	fmt.Printf("Server Takeover: %t\n", c.CompressionServerContextTakeover)
+	fmt.Printf("Client Takeover: %t\n", c.CompressionClientContextTakeover)
+	fmt.Printf("Min Size:        %d bytes\n", c.CompressionMinSize)
 	fmt.Println("\n=== Logging ===")
 	fmt.Printf("Level:           %s\n", c.LogLevel)
 	fmt.Printf("Format:          %s\n", c.LogFormat)
@@ -211,13 +574,146 @@ func (c *Config) LogConfig(logger zerolog.Logger) {
 		Float64("cpu_reject_threshold", c.CPURejectThreshold).
 		Float64("cpu_pause_threshold", c.CPUPauseThreshold).
 		Str("js_stream_name", c.JSStreamName).
+		Str("js_sources_file", sourcesStatus(c.JSSourcesFile)).
+		Str("js_interest_subscriptions", interestStatus(c.JSInterestSubscriptions, c.JSInterestDebounce, c.JSInterestLinger)).
 		Str("js_consumer_name", c.JSConsumerName).
+		Str("js_consumer_mode", c.JSConsumerMode).
+		Str("js_consumer_start", c.JSConsumerStart).
+		Str("js_instance_id", instanceIDStatus(c.JSInstanceID)).
+		Dur("js_consumer_inactive_threshold", c.JSConsumerInactiveThreshold).
+		Int("js_fetch_batch_size", c.JSFetchBatchSize).
+		Dur("js_fetch_max_wait", c.JSFetchMaxWait).
+		Int("js_consumer_max_deliver", c.JSConsumerMaxDeliver).
+		Dur("js_nak_backoff_base", c.JSNakBackoffBase).
+		Dur("js_nak_backoff_max", c.JSNakBackoffMax).
+		Str("js_dead_letters", deadLetterStatus(c.JSDeadLetterSubject, c.JSDeadLetterStream)).
 		Dur("js_stream_max_age", c.JSStreamMaxAge).
 		Int64("js_stream_max_msgs", c.JSStreamMaxMsgs).
 		Int64("js_stream_max_bytes_mb", c.JSStreamMaxBytes/(1024*1024)).
 		Dur("js_consumer_ack_wait", c.JSConsumerAckWait).
+		Bool("deep_replay_enabled", c.DeepReplayEnabled).
+		Int("deep_replay_max_messages", c.DeepReplayMaxMessages).
+		Dur("deep_replay_timeout", c.DeepReplayTimeout).
+		Int("deep_replay_max_concurrent", c.DeepReplayMaxConcurrent).
+		Str("message_routes", c.MessageRoutes).
+		Str("message_route_fallback", c.MessageRouteFallback).
+		Bool("lvc_enabled", c.LVCEnabled).
+		Int64("lvc_max_bytes_mb", c.LVCMaxBytes/(1024*1024)).
+		Dur("lvc_default_retention", c.LVCDefaultRetention).
+		Str("lvc_retention", c.LVCRetention).
+		Str("auth_mode", c.AuthMode).
+		Str("jwt_hs256_secret", secretStatus(c.JWTHS256Secret)).
+		Str("jwt_jwks_file", c.JWTJWKSFile).
+		Str("jwt_issuer", c.JWTIssuer).
+		Str("jwt_audience", c.JWTAudience).
+		Dur("jwt_leeway", c.JWTLeeway).
+		Dur("auth_timeout", c.AuthTimeout).
+		Str("authz_policy_file", c.AuthzPolicyFile).
+		Int("max_subscriptions_per_client", c.MaxSubscriptionsPerClient).
+		Int("max_channels_per_request", c.MaxChannelsPerRequest).
+		Str("channel_event_types", c.ChannelEventTypes).
+		Dur("max_connection_lifetime", c.MaxConnectionLifetime).
+		Dur("auth_expiry_warning", c.AuthExpiryWarning).
+		Str("allowed_origins", originsStatus(c.AllowedOrigins)).
+		Int64("max_message_size", c.MaxMessageSize).
+		Dur("handshake_timeout", c.HandshakeTimeout).
+		Int("max_connections_per_ip", c.MaxConnectionsPerIP).
+		Float64("connection_rate_per_ip", c.ConnectionRatePerIP).
+		Int("connection_burst_per_ip", c.ConnectionBurstPerIP).
+		Float64("accept_rate", c.AcceptRate).
+		Int("accept_burst", c.AcceptBurst).
+		Str("trusted_proxies", c.TrustedProxies).
+		Str("proxy_protocol", c.ProxyProtocol).
+		Str("admin_addr", adminStatus(c.AdminAddr)).
+		Str("admin_token", secretStatus(c.AdminToken)).
+		Bool("admin_tls", c.AdminTLSCertFile != "").
+		Bool("admin_mtls", c.AdminClientCAFile != "").
+		Str("ban_list_file", banListStatus(c.BanListFile)).
+		Float64("rate_limit_burst", c.RateLimitBurst).
+		Float64("rate_limit_rate", c.RateLimitRate).
+		Str("rate_limit_costs", c.RateLimitCosts).
+		Float64("rate_limit_replay_cost_per_message", c.RateLimitReplayCostPerMessage).
+		Str("rate_limit_roles", c.RateLimitRoles).
+		Dur("rate_limit_violation_window", c.RateLimitViolationWindow).
+		Int("rate_limit_mute_after", c.RateLimitMuteAfter).
+		Dur("rate_limit_mute_duration", c.RateLimitMuteDuration).
+		Int("rate_limit_close_after", c.RateLimitCloseAfter).
+		Dur("session_grace_period", c.SessionGracePeriod).
+		Bool("compression_enabled", c.CompressionEnabled).
+		Int("compression_level", c.CompressionLevel).
+		Bool("compression_server_context_takeover", c.CompressionServerContextTakeover).
+		Bool("compression_client_context_takeover", c.CompressionClientContextTakeover).
+		Int("compression_min_size", c.CompressionMinSize).
 		Dur("metrics_interval", c.MetricsInterval).
 		Str("log_level", c.LogLevel).
 		Str("log_format", c.LogFormat).
 		Msg("Server configuration loaded")
 }
+
+// originsStatus shows the origin allowlist ("any" when empty)
+func originsStatus(origins string) string {
+	if origins == "" {
+		return "any"
+	}
+	return origins
+}
+
+// adminStatus describes WS_ADMIN_ADDR for logs
+func adminStatus(addr string) string {
+	if addr == "" {
+		return "disabled"
+	}
+	return addr
+}
+
+// deadLetterStatus describes JS_DEAD_LETTER_SUBJECT / JS_DEAD_LETTER_STREAM for logs
+func deadLetterStatus(subject, stream string) string {
+	switch {
+	case subject == "":
+		return "disabled"
+	case stream == "":
+		return subject + " (no stream)"
+	default:
+		return subject + " (stream " + stream + ")"
+	}
+}
+
+// instanceIDStatus describes JS_INSTANCE_ID for logs
+func instanceIDStatus(id string) string {
+	if id == "" {
+		return "hostname"
+	}
+	return id
+}
+
+// sourcesStatus describes JS_SOURCES_FILE for logs
+func sourcesStatus(path string) string {
+	if path == "" {
+		return "default (odin.token.>)"
+	}
+	return path
+}
+
+// interestStatus describes JS_INTEREST_* for logs
+func interestStatus(enabled bool, debounce, linger time.Duration) string {
+	if !enabled {
+		return "disabled (full feed)"
+	}
+	return fmt.Sprintf("enabled (debounce %s, linger %s)", debounce, linger)
+}
+
+// banListStatus describes WS_BAN_LIST_FILE for logs
+func banListStatus(path string) string {
+	if path == "" {
+		return "disabled"
+	}
+	return path
+}
+
+// secretStatus hides a secret in config output ("set" / "not set")
+func secretStatus(secret string) string {
+	if secret == "" {
+		return "not set"
+	}
+	return "set"
+}
@@ -41,6 +41,66 @@ type Client struct {
 	server    *Server     // Reference to parent server
 	send      chan []byte // Buffered channel for outgoing messages (512 slots, 108s @ 4.7 msg/sec)
 	closeOnce sync.Once   // Ensures connection is only closed once
+	writeMu   sync.Mutex  // Serializes frames on conn (writePump vs. server-initiated close)
+
+	// Wire encoding negotiated via Sec-WebSocket-Protocol (JSON text or msgpack binary)
+	// Set once at upgrade, read-only afterwards
+	codec WireCodec
+
+	// Resumable session (see SessionManager)
+	// sessionID survives reconnects; resumeSecret is rotated on every (re)connect
+	sessionID    string
+	resumeSecret string
+
+	// pendingResume: resumed before first-message auth, owner not yet checked
+	// (readPump only - see finishPendingResume)
+	pendingResume *pendingResume
+
+	// Per-connection pump lifecycle (recreated on every attach, including resume)
+	// stop: closed by readPump to stop writePump
+	// writerDone: closed by writePump on exit
+	stop       chan struct{}
+	writerDone chan struct{}
+
+	// Authenticated principal (nil = anonymous) - see Authenticator
+	// authPending: 1 while waiting for first-message auth (atomic)
+	// identityMu: readPump is the only writer once the client is registered and
+	// reads without it; other goroutines (admin API, ban list) use Identity()
+	identity    *ClientIdentity
+	identityMu  sync.RWMutex
+	authPending int32
+
+	// Real client IP (PROXY protocol / trusted forwarding headers applied, see client_ip.go)
+	// Used for logs, audit events and per-IP limits instead of conn.RemoteAddr()
+	clientIP string
+
+	// User-Agent from the upgrade request (ban list matching on reload)
+	userAgent string
+
+	// Expiry watchdog (see scheduleExpiry)
+	// connectedAt: start of the current connection (max lifetime is per connection, not per session)
+	// expiryCancel: closed to cancel the armed watchdog - owned by readPump
+	connectedAt  time.Time
+	expiryCancel chan struct{}
+
+	// 1 while a deep replay (JetStream) is running for this client - one at a time
+	// deepReplayWG: the running replay goroutine (waited for before pooling)
+	deepReplayActive int32
+	deepReplayWG     sync.WaitGroup
+
+	// snapshotWG: a running stream snapshot lookup (waited for before pooling)
+	snapshotWG sync.WaitGroup
+
+	// slowPath: HIGH/CRITICAL frames waiting for room in send, in seq order
+	// (recreated on every attach, see slowPathQueue)
+	// deliveryMu: read-held by the slow-path drainer while it waits for room
+	// in send; releaseClient takes it before pooling (see drainSlowPath)
+	slowPath   *slowPathQueue
+	deliveryMu sync.RWMutex
+
+	// permessage-deflate state (nil = compression not negotiated)
+	// Set once at upgrade, read-only afterwards
+	deflate *deflateSession
 
 	// Message reliability fields
 	// Sequence generator - creates monotonically increasing message IDs
@@ -73,9 +133,10 @@ type Client struct {
 	// - Coinbase: 2 strikes (more aggressive)
 	// - Binance: No automatic disconnect (relies on ping timeout)
 	// - FIX protocol: 5 second timeout (more lenient)
-	lastMessageSentAt time.Time // Timestamp of last successful send
-	sendAttempts      int32     // Consecutive failed send attempts (atomic for thread-safety)
-	slowClientWarned  int32     // Flag to avoid log spam (warn once) - atomic: 0 = not warned, 1 = warned
+	lastMessageSentAt int64 // Unix nanos of last successful send (atomic - broadcast workers and slow-path drainers write it)
+	sendAttempts      int32 // Consecutive failed send attempts (atomic for thread-safety)
+	slowClientWarned  int32 // Flag to avoid log spam (warn once) - atomic: 0 = not warned, 1 = warned
+	disconnecting     int32 // Flag set once slow-client disconnect starts - atomic: 0 = no, 1 = yes
 
 	// Subscription filtering fields
 	// Purpose: Only send messages to clients subscribed to specific channels
@@ -92,6 +153,26 @@ type Client struct {
 	subscriptions *SubscriptionSet // Thread-safe set of subscribed channels
 }
 
+// markSent records a frame queued in send: the client is keeping up again
+func (c *Client) markSent() {
+	atomic.StoreInt32(&c.sendAttempts, 0)
+	atomic.StoreInt64(&c.lastMessageSentAt, time.Now().UnixNano())
+}
+
+// setIdentity replaces the client's identity (first-message auth, reauth)
+func (c *Client) setIdentity(identity *ClientIdentity) {
+	c.identityMu.Lock()
+	c.identity = identity
+	c.identityMu.Unlock()
+}
+
+// Identity returns the client's identity from outside readPump
+func (c *Client) Identity() *ClientIdentity {
+	c.identityMu.RLock()
+	defer c.identityMu.RUnlock()
+	return c.identity
+}
+
 // ConnectionPool manages a pool of reusable client objects
 type ConnectionPool struct {
 	pool       sync.Pool
@@ -116,7 +197,7 @@ func NewConnectionPool(maxSize int, bufferPool *BufferPool) *ConnectionPool {
 				send: make(chan []byte, 512),
 			}
 
-			client.replayBuffer = NewReplayBuffer(100, bufferPool)
+			client.replayBuffer = NewReplayBuffer(replayBufferSize, bufferPool)
 			return client
 		},
 	}
@@ -149,7 +230,7 @@ func (p *ConnectionPool) Get() *Client {
 		// Memory calculation: 100 messages × ~500 bytes = ~50KB per client
 		// With 7,864 max clients: 50KB × 7,864 = 393MB (fits in 512MB container)
 		if client.replayBuffer == nil {
-			client.replayBuffer = NewReplayBuffer(100, p.bufferPool)
+			client.replayBuffer = NewReplayBuffer(replayBufferSize, p.bufferPool)
 		} else {
 			if p.bufferPool != nil {
 				client.replayBuffer.withPool(p.bufferPool)
@@ -157,10 +238,24 @@ func (p *ConnectionPool) Get() *Client {
 			client.replayBuffer.Clear()
 		}
 
+		// Default wire encoding (overridden by subprotocol negotiation)
+		client.codec = jsonWireCodec
+		client.deflate = nil // Set at upgrade if permessage-deflate is negotiated
+
+		// Fresh session (ID and secret are issued after upgrade)
+		client.sessionID = ""
+		client.resumeSecret = ""
+		client.pendingResume = nil
+
+		// Anonymous until authenticated
+		client.identity = nil
+		atomic.StoreInt32(&client.authPending, 0)
+
 		// Initialize slow client detection fields
-		client.lastMessageSentAt = time.Now()
+		atomic.StoreInt64(&client.lastMessageSentAt, time.Now().UnixNano())
 		atomic.StoreInt32(&client.sendAttempts, 0)
 		atomic.StoreInt32(&client.slowClientWarned, 0) // 0 = not warned
+		atomic.StoreInt32(&client.disconnecting, 0)
 
 		// Initialize subscription set
 		// Each new connection starts with no subscriptions
@@ -185,6 +280,20 @@ func (p *ConnectionPool) Put(c *Client) {
 	c.conn = nil
 	c.server = nil
 	c.id = 0
+	c.sessionID = ""
+	c.resumeSecret = ""
+	c.identity = nil
+	atomic.StoreInt32(&c.deepReplayActive, 0)
+
+	// Drop every frame still queued for the previous owner
+	// (Get only drains one - a background sender may have queued more)
+	for drained := false; !drained; {
+		select {
+		case <-c.send:
+		default:
+			drained = true
+		}
+	}
 
 	// Clear subscriptions before returning to pool
 	if c.subscriptions != nil {
@@ -267,6 +376,24 @@ func (s *SubscriptionSet) Has(channel string) bool {
 	return exists
 }
 
+// Matches checks if a literal channel is covered by any subscription
+// (exact channel or wildcard pattern)
+// Thread-safe: Uses read lock
+func (s *SubscriptionSet) Matches(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.channels[channel]; exists {
+		return true
+	}
+	for sub := range s.channels {
+		if IsChannelPattern(sub) && MatchChannelPattern(sub, channel) {
+			return true
+		}
+	}
+	return false
+}
+
 // Count returns the number of active subscriptions
 // Thread-safe: Uses read lock
 func (s *SubscriptionSet) Count() int {
@@ -275,6 +402,21 @@ func (s *SubscriptionSet) Count() int {
 	return len(s.channels)
 }
 
+// PatternCount returns the number of wildcard subscriptions ("BTC.*", "*.trade")
+// Thread-safe: Uses read lock
+func (s *SubscriptionSet) PatternCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for ch := range s.channels {
+		if IsChannelPattern(ch) {
+			count++
+		}
+	}
+	return count
+}
+
 // List returns a copy of all subscribed channels
 // Thread-safe: Uses read lock
 // Returns: New slice (safe to modify without affecting internal state)
@@ -306,6 +448,12 @@ func (s *SubscriptionSet) Clear() {
 // - With index: Directly access ~500 subscribers = 500 iterations
 // - CPU savings: 93% (14× reduction in iterations)
 //
+// Pattern subscriptions ("BTC.*", "*.trade", "BTC.>"):
+// - Literal channels stay in the exact-match map (O(1) lookup, unchanged hot path)
+// - Patterns go into a token trie (see subscription_trie.go)
+// - Get() merges both and dedupes clients holding several matching subscriptions
+// - Lookup cost for patterns: O(channel depth), independent of pattern count
+//
 // Memory cost: ~7MB for 5 channels × 7,000 clients (map overhead)
 // CPU savings: 93% fewer iterations per broadcast in production
 //
@@ -313,24 +461,50 @@ func (s *SubscriptionSet) Clear() {
 // - Add/Remove: Write lock (during subscribe/unsubscribe)
 // - Get: Read lock (during broadcast - hot path!)
 type SubscriptionIndex struct {
-	subscribers map[string][]*Client // channel → list of subscribed clients
+	subscribers map[string][]*Client // literal channel → list of subscribed clients
+	patterns    *subscriptionTrie    // wildcard pattern → subscribed clients
 	mu          sync.RWMutex
+
+	// Called (under the write lock) when a channel or pattern gains its first
+	// or loses its last subscriber - must not block or call back into the index
+	// Set once before use (see jetstream_interest.go)
+	onInterestChange func()
 }
 
 // NewSubscriptionIndex creates a new empty subscription index
 func NewSubscriptionIndex() *SubscriptionIndex {
 	return &SubscriptionIndex{
 		subscribers: make(map[string][]*Client),
+		patterns:    newSubscriptionTrie(),
 	}
 }
 
-// Add registers a client as a subscriber to a channel
-// Thread-safe: Uses write lock
-func (idx *SubscriptionIndex) Add(channel string, client *Client) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+// SetInterestListener registers fn to be called whenever the set of channels
+// and patterns with subscribers changes (not safe to call concurrently with use)
+func (idx *SubscriptionIndex) SetInterestListener(fn func()) {
+	idx.onInterestChange = fn
+}
+
+// interestChanged notifies the interest listener
+// Caller must hold write lock
+func (idx *SubscriptionIndex) interestChanged() {
+	if idx.onInterestChange != nil {
+		idx.onInterestChange()
+	}
+}
+
+// addLocked registers client under a literal channel or pattern
+// Caller must hold write lock
+func (idx *SubscriptionIndex) addLocked(channel string, client *Client) {
+	if IsChannelPattern(channel) {
+		before := idx.patterns.size
+		idx.patterns.insert(channel, client)
+		if idx.patterns.size != before {
+			idx.interestChanged() // First subscriber of this pattern
+		}
+		return
+	}
 
-	// Get existing subscribers or create new slice
 	subscribers := idx.subscribers[channel]
 
 	// Check if client already subscribed (avoid duplicates)
@@ -340,40 +514,21 @@ func (idx *SubscriptionIndex) Add(channel string, client *Client) {
 		}
 	}
 
-	// Add client to subscribers list
 	idx.subscribers[channel] = append(subscribers, client)
+	if len(subscribers) == 0 {
+		idx.interestChanged() // First subscriber of this channel
+	}
 }
 
-// AddMultiple registers a client as a subscriber to multiple channels
-// More efficient than calling Add() multiple times (single lock acquisition)
-// Thread-safe: Uses write lock
-func (idx *SubscriptionIndex) AddMultiple(channels []string, client *Client) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
-
-	for _, channel := range channels {
-		subscribers := idx.subscribers[channel]
-
-		// Check if client already subscribed
-		alreadySubscribed := false
-		for _, existing := range subscribers {
-			if existing == client {
-				alreadySubscribed = true
-				break
-			}
-		}
-
-		if !alreadySubscribed {
-			idx.subscribers[channel] = append(subscribers, client)
+// removeLocked unregisters client from a literal channel or pattern
+// Caller must hold write lock
+func (idx *SubscriptionIndex) removeLocked(channel string, client *Client) {
+	if IsChannelPattern(channel) {
+		if idx.patterns.remove(channel, client) {
+			idx.interestChanged() // Last subscriber of this pattern
 		}
+		return
 	}
-}
-
-// Remove unregisters a client from a channel
-// Thread-safe: Uses write lock
-func (idx *SubscriptionIndex) Remove(channel string, client *Client) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
 
 	subscribers, exists := idx.subscribers[channel]
 	if !exists {
@@ -390,46 +545,63 @@ func (idx *SubscriptionIndex) Remove(channel string, client *Client) {
 			// Clean up empty slices to free memory
 			if len(idx.subscribers[channel]) == 0 {
 				delete(idx.subscribers, channel)
+				idx.interestChanged() // Last subscriber of this channel
 			}
 			return
 		}
 	}
 }
 
-// RemoveMultiple unregisters a client from multiple channels
-// More efficient than calling Remove() multiple times (single lock acquisition)
+// Add registers a client as a subscriber to a channel or pattern
 // Thread-safe: Uses write lock
-func (idx *SubscriptionIndex) RemoveMultiple(channels []string, client *Client) {
+func (idx *SubscriptionIndex) Add(channel string, client *Client) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.addLocked(channel, client)
+}
+
+// AddMultiple registers a client as a subscriber to multiple channels or patterns
+// More efficient than calling Add() multiple times (single lock acquisition)
+// Thread-safe: Uses write lock
+func (idx *SubscriptionIndex) AddMultiple(channels []string, client *Client) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
 	for _, channel := range channels {
-		subscribers, exists := idx.subscribers[channel]
-		if !exists {
-			continue
-		}
+		idx.addLocked(channel, client)
+	}
+}
 
-		// Find and remove client
-		for i, existing := range subscribers {
-			if existing == client {
-				subscribers[i] = subscribers[len(subscribers)-1]
-				idx.subscribers[channel] = subscribers[:len(subscribers)-1]
+// Remove unregisters a client from a channel or pattern
+// Patterns are removed by exact pattern string (NATS semantics):
+// unsubscribing "BTC.*" does not touch a separate "BTC.trade" subscription
+// Thread-safe: Uses write lock
+func (idx *SubscriptionIndex) Remove(channel string, client *Client) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(channel, client)
+}
 
-				if len(idx.subscribers[channel]) == 0 {
-					delete(idx.subscribers, channel)
-				}
-				break
-			}
-		}
+// RemoveMultiple unregisters a client from multiple channels or patterns
+// More efficient than calling Remove() multiple times (single lock acquisition)
+// Thread-safe: Uses write lock
+func (idx *SubscriptionIndex) RemoveMultiple(channels []string, client *Client) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, channel := range channels {
+		idx.removeLocked(channel, client)
 	}
 }
 
-// RemoveClient removes a client from ALL channels (called on disconnect)
+// RemoveClient removes a client from ALL channels and patterns (called on disconnect)
 // Thread-safe: Uses write lock
 func (idx *SubscriptionIndex) RemoveClient(client *Client) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
+	emptied := false
+
 	// Iterate all channels and remove client
 	for channel, subscribers := range idx.subscribers {
 		for i, existing := range subscribers {
@@ -439,40 +611,100 @@ func (idx *SubscriptionIndex) RemoveClient(client *Client) {
 
 				if len(idx.subscribers[channel]) == 0 {
 					delete(idx.subscribers, channel)
+					emptied = true
 				}
 				break
 			}
 		}
 	}
+
+	// Walk pattern trie and prune emptied branches
+	if len(idx.patterns.removeClient(client)) > 0 {
+		emptied = true
+	}
+	if emptied {
+		idx.interestChanged()
+	}
 }
 
-// Get returns a copy of all clients subscribed to a channel
+// getLocked resolves all clients subscribed to a literal channel,
+// either directly or through a matching pattern
+// Caller must hold read lock
+func (idx *SubscriptionIndex) getLocked(channel string) []*Client {
+	subscribers := idx.subscribers[channel]
+
+	// Fast path: no pattern subscriptions anywhere (common case)
+	if idx.patterns.size == 0 {
+		if len(subscribers) == 0 {
+			return nil
+		}
+		// Return a copy to avoid race conditions during iteration
+		result := make([]*Client, len(subscribers))
+		copy(result, subscribers)
+		return result
+	}
+
+	result := make([]*Client, len(subscribers), len(subscribers)+8)
+	copy(result, subscribers)
+	result = idx.patterns.match(channel, result)
+	if len(result) == len(subscribers) {
+		if len(result) == 0 {
+			return nil
+		}
+		return result // No pattern matched - nothing to dedupe
+	}
+
+	// Dedupe: a client subscribed to "BTC.trade" and "BTC.*" gets ONE copy
+	seen := make(map[*Client]struct{}, len(result))
+	deduped := result[:0]
+	for _, client := range result {
+		if _, dup := seen[client]; dup {
+			continue
+		}
+		seen[client] = struct{}{}
+		deduped = append(deduped, client)
+	}
+	return deduped
+}
+
+// Get returns a copy of all clients subscribed to a channel (literal or via pattern)
 // Thread-safe: Uses read lock (optimized for hot path - broadcast!)
-// Returns: New slice (safe to iterate without holding lock)
+// Returns: New slice (safe to iterate without holding lock), each client once
 func (idx *SubscriptionIndex) Get(channel string) []*Client {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
+	return idx.getLocked(channel)
+}
+
+// Count returns the number of distinct subscribers for a channel (literal or via pattern)
+// Thread-safe: Uses read lock
+func (idx *SubscriptionIndex) Count(channel string) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
 
-	subscribers, exists := idx.subscribers[channel]
-	if !exists || len(subscribers) == 0 {
-		return nil
+	if idx.patterns.size == 0 {
+		return len(idx.subscribers[channel])
 	}
-
-	// Return a copy to avoid race conditions during iteration
-	result := make([]*Client, len(subscribers))
-	copy(result, subscribers)
-	return result
+	return len(idx.getLocked(channel))
 }
 
-// Count returns the number of subscribers for a channel
+// Channels returns every literal channel and pattern with at least one subscriber
 // Thread-safe: Uses read lock
-func (idx *SubscriptionIndex) Count(channel string) int {
+func (idx *SubscriptionIndex) Channels() []string {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	subscribers, exists := idx.subscribers[channel]
-	if !exists {
-		return 0
+	channels := make([]string, 0, len(idx.subscribers)+idx.patterns.size)
+	for channel := range idx.subscribers {
+		channels = append(channels, channel)
 	}
-	return len(subscribers)
+	return idx.patterns.list(channels)
+}
+
+// PatternCount returns the number of distinct wildcard patterns with subscribers
+// Thread-safe: Uses read lock
+func (idx *SubscriptionIndex) PatternCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.patterns.size
 }
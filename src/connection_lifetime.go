@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// Connection lifetime: token refresh, expiry watchdog, max connection lifetime
+//
+// Problem: trading sessions stay connected for hours, tokens live for minutes.
+// Without a check a connection keeps its claims long after the token expired
+// (or the user's roles were revoked).
+//
+// Solution:
+// 1. Watchdog: every connection has a deadline = min(token exp, connect time +
+//    WS_MAX_CONNECTION_LIFETIME). WS_AUTH_EXPIRY_WARNING before it the client
+//    gets a warning; at the deadline the connection is closed with 4408.
+// 2. In-band refresh: the client swaps in a fresh token without reconnecting:
+//
+//	Server → {"type":"session_expiring","reason":"token_expired","expires_at":1718003600000}
+//	Client → {"type":"reauth","data":{"token":"<new jwt>"}}
+//	Server → {"type":"reauth_ack","user_id":"user-42","roles":["trader"],"expires_at":1718007200000}
+//
+// 3. Subscriptions are re-authorized when the claims change (roles dropped
+//    in the new token) - revoked channels are unsubscribed and reported:
+//
+//	Server → {"type":"subscriptions_revoked","revoked":[{"channel":"BTC.favorites","reason":"forbidden"}]}
+//
+// The max lifetime can't be extended by reauth - it exists to rebalance
+// long-lived connections across instances and bound per-connection state.
+// Expired connections are not resumable (server-initiated close).
+
+// CloseCodeSessionExpired is the WebSocket close code for connections closed by
+// the watchdog (token expired or max connection lifetime reached; mirrors HTTP 408)
+const CloseCodeSessionExpired ws.StatusCode = 4408
+
+// Watchdog deadline reasons (session_expiring "reason", metrics label)
+const (
+	expiryReasonToken    = "token_expired"
+	expiryReasonLifetime = "max_lifetime"
+)
+
+// connectionDeadline returns when the connection must be closed and why
+// Zero time = no deadline (anonymous connection, no max lifetime)
+func (s *Server) connectionDeadline(c *Client) (time.Time, string) {
+	var deadline time.Time
+	reason := ""
+
+	if s.config.MaxConnectionLifetime > 0 {
+		deadline = c.connectedAt.Add(s.config.MaxConnectionLifetime)
+		reason = expiryReasonLifetime
+	}
+	if c.identity != nil && !c.identity.ExpiresAt.IsZero() {
+		if deadline.IsZero() || c.identity.ExpiresAt.Before(deadline) {
+			deadline = c.identity.ExpiresAt
+			reason = expiryReasonToken
+		}
+	}
+	return deadline, reason
+}
+
+// scheduleExpiry (re)arms the connection's watchdog
+// Called on connect and whenever the identity changes (auth, reauth)
+//
+// Not thread-safe: only called before the pumps start or from readPump
+func (s *Server) scheduleExpiry(c *Client) {
+	// Cancel the previous watchdog (old token's deadline)
+	if c.expiryCancel != nil {
+		close(c.expiryCancel)
+		c.expiryCancel = nil
+	}
+
+	deadline, reason := s.connectionDeadline(c)
+	if deadline.IsZero() {
+		return
+	}
+
+	cancel := make(chan struct{})
+	c.expiryCancel = cancel
+	stop := c.stop
+	userID := sessionUserID(c.identity) // c.identity belongs to readPump
+
+	warnTimer := time.NewTimer(time.Until(deadline.Add(-s.config.AuthExpiryWarning)))
+	expireTimer := time.NewTimer(time.Until(deadline))
+
+	go func() {
+		defer warnTimer.Stop()
+		defer expireTimer.Stop()
+
+		for {
+			select {
+			case <-warnTimer.C:
+				// Client buffer full - skip warning (the close still follows)
+				s.sendControlMessage(c, map[string]any{
+					"type":       "session_expiring",
+					"reason":     reason,
+					"expires_at": deadline.UnixMilli(),
+				})
+
+			case <-expireTimer.C:
+				select {
+				case <-cancel:
+					return // Reauth won the race
+				default:
+				}
+				s.expireConnection(c, userID, reason)
+				return
+
+			case <-cancel:
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// expireConnection closes a connection whose deadline passed
+func (s *Server) expireConnection(c *Client, userID, reason string) {
+	IncrementConnectionsExpired(reason)
+	s.structLogger.Info().
+		Int64("client_id", c.id).
+		Str("user_id", userID).
+		Str("reason", reason).
+		Msg("Connection expired")
+
+	closeReason := "Token expired"
+	if reason == expiryReasonLifetime {
+		closeReason = "Max connection lifetime reached"
+	}
+	s.closeClient(c, CloseCodeSessionExpired, closeReason)
+}
+
+// handleReauthMessage processes {"type":"reauth","data":{"token":"..."}}
+// Swaps the credentials of an authenticated connection for a fresh token
+//
+// A failed reauth keeps the current credentials (the old token is still good
+// until the watchdog fires), so the client can retry. The new token must be
+// for the same user - switching users needs a new connection.
+func (s *Server) handleReauthMessage(c *Client, data json.RawMessage) {
+	if !s.auth.Enabled() || c.identity == nil {
+		s.sendControlMessage(c, map[string]any{
+			"type":    "error",
+			"code":    "REAUTH_NOT_ACCEPTED",
+			"message": "Reauthentication requires an authenticated connection (use auth)",
+		})
+		return
+	}
+
+	var reauthReq struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(data, &reauthReq) // Malformed → empty token → rejected below
+
+	remoteAddr := c.clientIP
+
+	identity, err := s.auth.Validate(reauthReq.Token)
+	if err == nil && identity.UserID != c.identity.UserID {
+		err = errReauthUserMismatch
+	}
+	if err != nil {
+		result := "invalid"
+		switch {
+		case errors.Is(err, errReauthUserMismatch):
+			result = "user_mismatch"
+		case errors.Is(err, ErrAuthTokenExpired):
+			result = "expired"
+		}
+		IncrementReauthAttempts(result)
+		s.auditLogger.Warning("ReauthenticationFailed", "In-band token refresh rejected", map[string]any{
+			"clientID":   c.id,
+			"userID":     c.identity.UserID,
+			"remoteAddr": remoteAddr,
+			"reason":     result,
+			"error":      err.Error(),
+		})
+		s.sendControlMessage(c, map[string]any{
+			"type":    "error",
+			"code":    "REAUTH_FAILED",
+			"reason":  result,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	previous := c.identity
+	c.setIdentity(identity)
+	IncrementReauthAttempts("success")
+	s.acceptAuth(identity, remoteAddr, "reauth")
+
+	s.sendControlMessage(c, map[string]any{
+		"type":       "reauth_ack",
+		"user_id":    identity.UserID,
+		"roles":      identity.Roles,
+		"expires_at": identity.ExpiresAt.UnixMilli(),
+	})
+
+	if !slices.Equal(previous.Roles, identity.Roles) {
+		s.reauthorizeSubscriptions(c)
+	}
+	s.scheduleExpiry(c)
+}
+
+// errReauthUserMismatch is returned when a reauth token names a different user
+var errReauthUserMismatch = errors.New("token is for a different user")
+
+// reauthorizeSubscriptions re-checks every subscription against the channel
+// policy after the client's claims changed, and drops the ones no longer allowed
+func (s *Server) reauthorizeSubscriptions(c *Client) {
+	var revoked []ChannelDenial
+	var channels []string
+
+	for _, channel := range c.subscriptions.List() {
+		if err := s.channelAuthorizer.Authorize(c.identity, channel); err != nil {
+			revoked = append(revoked, ChannelDenial{Channel: channel, Reason: err.Error()})
+			channels = append(channels, channel)
+		}
+	}
+	if len(revoked) == 0 {
+		return
+	}
+
+	c.subscriptions.RemoveMultiple(channels)
+	s.subscriptionIndex.RemoveMultiple(channels, c)
+	IncrementSubscriptionsRevoked(len(revoked))
+
+	s.auditLogger.Info("SubscriptionsRevoked", "Subscriptions revoked after claims changed", map[string]any{
+		"clientID": c.id,
+		"userID":   sessionUserID(c.identity),
+		"revoked":  revoked,
+	})
+
+	// Client buffer full - skip notice (the subscriptions are gone either way)
+	s.sendControlMessage(c, map[string]any{
+		"type":     "subscriptions_revoked",
+		"revoked":  revoked,
+		"count":    c.subscriptions.Count(),
+		"patterns": c.subscriptions.PatternCount(),
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestConnectionDeadline(t *testing.T) {
+	connectedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name         string
+		lifetime     time.Duration
+		expiresAt    time.Time // Zero = anonymous
+		wantDeadline time.Time
+		wantReason   string
+	}{
+		{name: "anonymous, no max lifetime"},
+		{name: "max lifetime only", lifetime: time.Hour, wantDeadline: connectedAt.Add(time.Hour), wantReason: expiryReasonLifetime},
+		{name: "token only", expiresAt: connectedAt.Add(10 * time.Minute), wantDeadline: connectedAt.Add(10 * time.Minute), wantReason: expiryReasonToken},
+		{name: "token expires first", lifetime: time.Hour, expiresAt: connectedAt.Add(10 * time.Minute), wantDeadline: connectedAt.Add(10 * time.Minute), wantReason: expiryReasonToken},
+		{name: "lifetime ends first", lifetime: time.Hour, expiresAt: connectedAt.Add(2 * time.Hour), wantDeadline: connectedAt.Add(time.Hour), wantReason: expiryReasonLifetime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) { config.MaxConnectionLifetime = tt.lifetime })
+			c := newTestClient(s, 1)
+			c.connectedAt = connectedAt
+			if !tt.expiresAt.IsZero() {
+				c.identity = &ClientIdentity{UserID: "user-1", ExpiresAt: tt.expiresAt}
+			}
+
+			deadline, reason := s.connectionDeadline(c)
+			if !deadline.Equal(tt.wantDeadline) || reason != tt.wantReason {
+				t.Errorf("connectionDeadline() = %v %q, want %v %q", deadline, reason, tt.wantDeadline, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestScheduleExpiryWarnsThenCloses(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.AuthExpiryWarning = 100 * time.Millisecond })
+	c := newTestClient(s, 4)
+	c.identity = &ClientIdentity{UserID: "user-1", ExpiresAt: time.Now().Add(150 * time.Millisecond)}
+
+	s.scheduleExpiry(c)
+
+	warning := controlMessage(t, receiveFrames(t, c, 1, time.Second)[0])
+	if warning["type"] != "session_expiring" || warning["reason"] != expiryReasonToken {
+		t.Errorf("warning = %v, want session_expiring for the token", warning)
+	}
+	if atomic.LoadInt32(&c.disconnecting) != 0 {
+		t.Fatal("closed at the warning")
+	}
+	eventually(t, time.Second, func() bool { return atomic.LoadInt32(&c.disconnecting) == 1 }, "connection not closed at the deadline")
+}
+
+func TestScheduleExpiryRearmAndStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel func(s *Server, c *Client)
+	}{
+		{
+			name: "reauth moves the deadline",
+			cancel: func(s *Server, c *Client) {
+				c.identity = &ClientIdentity{UserID: "user-1", ExpiresAt: time.Now().Add(time.Hour)}
+				s.scheduleExpiry(c)
+			},
+		},
+		{
+			name:   "connection stopped",
+			cancel: func(_ *Server, c *Client) { close(c.stop) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			c := newTestClient(s, 4)
+			c.identity = &ClientIdentity{UserID: "user-1", ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+
+			s.scheduleExpiry(c)
+			tt.cancel(s, c)
+
+			time.Sleep(150 * time.Millisecond)
+			if atomic.LoadInt32(&c.disconnecting) != 0 {
+				t.Error("the cancelled watchdog still closed the connection")
+			}
+		})
+	}
+}
+
+func TestHandleReauthMessage(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) {
+		config.AuthMode = AuthModeOptional
+		config.JWTHS256Secret = testHS256Secret
+	})
+	policy, err := NewPolicyAuthorizer(ChannelPolicy{Rules: []ChannelPolicyRule{
+		{Channels: []string{"*.favorites"}, Roles: []string{"trader"}},
+		{Channels: []string{"*.trade"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer: %v", err)
+	}
+	s.channelAuthorizer = policy
+
+	token := func(sub string, roles []string, exp time.Time) json.RawMessage {
+		signed := signTestToken(t, jwt.SigningMethodHS256, []byte(testHS256Secret), "", jwt.MapClaims{
+			"sub":   sub,
+			"roles": roles,
+			"exp":   exp.Unix(),
+		})
+		raw, _ := json.Marshal(map[string]string{"token": signed})
+		return raw
+	}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		data      json.RawMessage
+		want      []map[string]any // type + the fields checked
+		wantRoles []string
+	}{
+		{
+			name:      "anonymous connection",
+			anonymous: true,
+			data:      token("user-1", nil, later),
+			want:      []map[string]any{{"type": "error", "code": "REAUTH_NOT_ACCEPTED"}},
+		},
+		{
+			name:      "other user",
+			data:      token("user-2", []string{"trader"}, later),
+			want:      []map[string]any{{"type": "error", "code": "REAUTH_FAILED", "reason": "user_mismatch"}},
+			wantRoles: []string{"trader"},
+		},
+		{
+			name:      "expired token",
+			data:      token("user-1", []string{"trader"}, time.Now().Add(-time.Hour)),
+			want:      []map[string]any{{"type": "error", "code": "REAUTH_FAILED", "reason": "expired"}},
+			wantRoles: []string{"trader"},
+		},
+		{
+			name:      "malformed request",
+			data:      json.RawMessage(`"not an object"`),
+			want:      []map[string]any{{"type": "error", "code": "REAUTH_FAILED", "reason": "invalid"}},
+			wantRoles: []string{"trader"},
+		},
+		{
+			name:      "same roles",
+			data:      token("user-1", []string{"trader"}, later),
+			want:      []map[string]any{{"type": "reauth_ack", "user_id": "user-1"}},
+			wantRoles: []string{"trader"},
+		},
+		{
+			name: "role dropped",
+			data: token("user-1", nil, later),
+			want: []map[string]any{
+				{"type": "reauth_ack", "user_id": "user-1"},
+				{"type": "subscriptions_revoked", "count": 1.0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(s, 8)
+			defer close(c.stop) // Ends the watchdog a successful reauth arms
+			if !tt.anonymous {
+				c.identity = &ClientIdentity{UserID: "user-1", Roles: []string{"trader"}, ExpiresAt: time.Now().Add(time.Minute)}
+				channels := []string{"BTC.trade", "BTC.favorites"}
+				c.subscriptions.AddMultiple(channels)
+				s.subscriptionIndex.AddMultiple(channels, c)
+				defer s.subscriptionIndex.RemoveClient(c)
+			}
+
+			s.handleReauthMessage(c, tt.data)
+
+			for i, frame := range receiveFrames(t, c, len(tt.want), time.Second) {
+				msg := controlMessage(t, frame)
+				for key, want := range tt.want[i] {
+					if !reflect.DeepEqual(msg[key], want) {
+						t.Errorf("message %d %s = %v, want %v (%s)", i, key, msg[key], want, frame)
+					}
+				}
+			}
+			if len(c.send) != 0 {
+				t.Errorf("%d unexpected messages queued", len(c.send))
+			}
+			if !tt.anonymous && !reflect.DeepEqual(c.Identity().Roles, tt.wantRoles) {
+				t.Errorf("roles = %v, want %v", c.Identity().Roles, tt.wantRoles)
+			}
+		})
+	}
+}
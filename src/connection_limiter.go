@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ConnectionLimiter enforces per-IP and global limits on new connections
+//
+// ResourceGuard protects the server as a whole (connections, CPU, memory);
+// it can't stop one host from taking every slot. Three limits, checked before
+// the (relatively expensive) JWT validation and upgrade:
+//
+//  1. Global accept rate (WS_ACCEPT_RATE/WS_ACCEPT_BURST)
+//     After a restart or LB failover every client reconnects at once. Admitting
+//     them at a steady rate keeps the handshake + replay spike below the CPU
+//     reject threshold; the rest retry after Retry-After.
+//  2. Per-IP new-connection rate (WS_CONNECTION_RATE_PER_IP/WS_CONNECTION_BURST_PER_IP)
+//     Token bucket per IP - a reconnect loop in a buggy client can't flood us
+//  3. Per-IP concurrent connections (WS_MAX_CONNECTIONS_PER_IP)
+//     Off by default: mobile carriers put thousands of users behind one CGNAT IP,
+//     so a value must be sized to the deployment
+//
+// Rejections: HTTP 429 (per IP) or 503 (global) with a Retry-After header.
+// A zero limit disables that check.
+type ConnectionLimiter struct {
+	maxPerIP   int
+	ratePerIP  float64
+	burstPerIP float64
+
+	acceptLimiter *rate.Limiter // nil = no global accept rate
+
+	mu    sync.Mutex
+	perIP map[string]*ipConnectionState
+}
+
+// ipConnectionState tracks one source IP
+type ipConnectionState struct {
+	connections int          // Currently open connections
+	bucket      *TokenBucket // New-connection rate (nil = unlimited)
+	lastSeen    time.Time    // Last connection attempt (for pruning)
+}
+
+// Connection limit rejection reasons (metrics label)
+const (
+	connLimitAcceptRate    = "accept_rate"
+	connLimitIPRate        = "ip_rate"
+	connLimitIPConnections = "ip_connections"
+)
+
+// NewConnectionLimiter creates a limiter
+//
+// Parameters:
+//
+//	maxPerIP    - Concurrent connections per IP (0 = unlimited)
+//	ratePerIP   - New connections per second per IP (0 = unlimited)
+//	burstPerIP  - Token bucket capacity per IP
+//	acceptRate  - New connections per second server-wide (0 = unlimited)
+//	acceptBurst - Global burst
+func NewConnectionLimiter(maxPerIP int, ratePerIP float64, burstPerIP int, acceptRate float64, acceptBurst int) *ConnectionLimiter {
+	cl := &ConnectionLimiter{
+		maxPerIP:   maxPerIP,
+		ratePerIP:  ratePerIP,
+		burstPerIP: float64(burstPerIP),
+		perIP:      make(map[string]*ipConnectionState),
+	}
+	if acceptRate > 0 {
+		cl.acceptLimiter = rate.NewLimiter(rate.Limit(acceptRate), acceptBurst)
+	}
+	return cl
+}
+
+// Acquire admits a new connection from ip
+// Returns ok=false with the rejection reason and how long the client should
+// wait. On success the caller must Release(ip) when the connection ends.
+func (cl *ConnectionLimiter) Acquire(ip string) (ok bool, reason string, retryAfter time.Duration) {
+	now := time.Now()
+
+	cl.mu.Lock()
+	state, exists := cl.perIP[ip]
+	if !exists {
+		state = &ipConnectionState{}
+		if cl.ratePerIP > 0 {
+			state.bucket = NewTokenBucket(cl.burstPerIP, cl.ratePerIP)
+		}
+		cl.perIP[ip] = state
+	}
+	state.lastSeen = now
+
+	// Per-IP checks first: an abusive IP must not consume global accept tokens
+	if cl.maxPerIP > 0 && state.connections >= cl.maxPerIP {
+		cl.mu.Unlock()
+		// A slot frees when one of the IP's connections closes - no way to know when
+		return false, connLimitIPConnections, 5 * time.Second
+	}
+	if state.bucket != nil && !state.bucket.TryConsume(1) {
+		wait := state.bucket.TimeUntil(1)
+		cl.mu.Unlock()
+		return false, connLimitIPRate, wait
+	}
+	state.connections++
+	cl.mu.Unlock()
+
+	if cl.acceptLimiter != nil {
+		reservation := cl.acceptLimiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			cl.Release(ip)
+			return false, connLimitAcceptRate, delay
+		}
+	}
+	return true, "", 0
+}
+
+// Release ends a connection admitted by Acquire
+func (cl *ConnectionLimiter) Release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if state, ok := cl.perIP[ip]; ok && state.connections > 0 {
+		state.connections--
+	}
+}
+
+// Prune forgets IPs with no open connections that haven't connected for idleAfter
+// (their token bucket has long refilled - recreating it is equivalent)
+func (cl *ConnectionLimiter) Prune(idleAfter time.Duration) {
+	cutoff := time.Now().Add(-idleAfter)
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for ip, state := range cl.perIP {
+		if state.connections == 0 && state.lastSeen.Before(cutoff) {
+			delete(cl.perIP, ip)
+		}
+	}
+	UpdateTrackedIPs(len(cl.perIP))
+}
+
+// StartPruning prunes idle IPs periodically until ctx is cancelled
+// Without it every IP that ever connected stays in memory
+func (cl *ConnectionLimiter) StartPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				cl.Prune(interval)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// admitConnection applies the ConnectionLimiter to an upgrade request
+// Returns false after writing a 429/503 with Retry-After
+func (s *Server) admitConnection(w http.ResponseWriter, clientIP string) bool {
+	ok, reason, retryAfter := s.connLimiter.Acquire(clientIP)
+	if ok {
+		return true
+	}
+
+	IncrementConnectionLimitRejections(reason)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+
+	if reason == connLimitAcceptRate {
+		// Reconnect storm: expected after restarts, not an abuse signal
+		s.structLogger.Debug().
+			Str("client_ip", clientIP).
+			Dur("retry_after", retryAfter).
+			Msg("Connection rejected: global accept rate")
+		http.Error(w, "Server busy, retry later", http.StatusServiceUnavailable)
+		return false
+	}
+
+	s.auditLogger.Warning("ConnectionLimitExceeded", "Connection rejected by per-IP limit", map[string]any{
+		"clientIP":   clientIP,
+		"reason":     reason,
+		"retryAfter": retryAfter.String(),
+	})
+	http.Error(w, "Too many connections", http.StatusTooManyRequests)
+	return false
+}
+
+// retryAfterSeconds formats a Retry-After value (whole seconds, at least 1)
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
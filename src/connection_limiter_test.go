@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimiterPerIPCap(t *testing.T) {
+	cl := NewConnectionLimiter(2, 0, 0, 0, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, reason, _ := cl.Acquire("203.0.113.7"); !ok {
+			t.Fatalf("Acquire #%d rejected (%s) under the cap", i+1, reason)
+		}
+	}
+	ok, reason, retryAfter := cl.Acquire("203.0.113.7")
+	if ok || reason != connLimitIPConnections || retryAfter <= 0 {
+		t.Errorf("Acquire over the cap = %v %q %s, want rejected %q with a Retry-After", ok, reason, retryAfter, connLimitIPConnections)
+	}
+	if ok, _, _ := cl.Acquire("198.51.100.1"); !ok {
+		t.Error("another IP rejected by the first IP's cap")
+	}
+
+	cl.Release("203.0.113.7")
+	if ok, reason, _ := cl.Acquire("203.0.113.7"); !ok {
+		t.Errorf("Acquire after Release rejected (%s)", reason)
+	}
+}
+
+func TestConnectionLimiterPerIPRate(t *testing.T) {
+	cl := NewConnectionLimiter(0, 1, 2, 0, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, reason, _ := cl.Acquire("203.0.113.7"); !ok {
+			t.Fatalf("Acquire #%d rejected (%s) within the burst", i+1, reason)
+		}
+		cl.Release("203.0.113.7") // Short-lived connections still spend tokens
+	}
+	ok, reason, retryAfter := cl.Acquire("203.0.113.7")
+	if ok || reason != connLimitIPRate {
+		t.Fatalf("Acquire after the burst = %v %q, want rejected %q", ok, reason, connLimitIPRate)
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retryAfter = %s, want the time to the next token (<= 1s at 1/s)", retryAfter)
+	}
+
+	// A rejected attempt must not hold a slot
+	cl.mu.Lock()
+	connections := cl.perIP["203.0.113.7"].connections
+	cl.mu.Unlock()
+	if connections != 0 {
+		t.Errorf("connections = %d after rejections, want 0", connections)
+	}
+}
+
+func TestConnectionLimiterAcceptRate(t *testing.T) {
+	cl := NewConnectionLimiter(1, 0, 0, 1, 1)
+
+	if ok, _, _ := cl.Acquire("203.0.113.7"); !ok {
+		t.Fatal("first connection rejected")
+	}
+	ok, reason, retryAfter := cl.Acquire("198.51.100.1")
+	if ok || reason != connLimitAcceptRate || retryAfter <= 0 {
+		t.Fatalf("Acquire over the accept rate = %v %q %s, want rejected %q", ok, reason, retryAfter, connLimitAcceptRate)
+	}
+
+	// The global rejection gives the per-IP slot back
+	cl.Release("203.0.113.7")
+	time.Sleep(retryAfter)
+	if ok, reason, _ := cl.Acquire("198.51.100.1"); !ok {
+		t.Errorf("Acquire after Retry-After rejected (%s)", reason)
+	}
+}
+
+func TestConnectionLimiterPrune(t *testing.T) {
+	cl := NewConnectionLimiter(1, 0, 0, 0, 0)
+	cl.Acquire("203.0.113.7")
+	cl.Acquire("198.51.100.1")
+	cl.Release("198.51.100.1")
+
+	cl.Prune(0)
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if _, ok := cl.perIP["198.51.100.1"]; ok {
+		t.Error("idle IP kept")
+	}
+	if _, ok := cl.perIP["203.0.113.7"]; !ok {
+		t.Error("IP with an open connection pruned")
+	}
+}
+
+func TestAdmitConnectionRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		limiter    *ConnectionLimiter
+		wantStatus int
+	}{
+		{name: "per-IP cap", limiter: NewConnectionLimiter(1, 0, 0, 0, 0), wantStatus: http.StatusTooManyRequests},
+		{name: "global accept rate", limiter: NewConnectionLimiter(0, 0, 0, 0.5, 1), wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			s.connLimiter = tt.limiter
+
+			if !s.admitConnection(httptest.NewRecorder(), "203.0.113.7") {
+				t.Fatal("first connection rejected")
+			}
+			w := httptest.NewRecorder()
+			if s.admitConnection(w, "203.0.113.7") {
+				t.Fatal("second connection admitted")
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got == "" || got == "0" {
+				t.Errorf("Retry-After = %q, want at least 1 second", got)
+			}
+		})
+	}
+}
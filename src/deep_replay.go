@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Deep replay - replay requests the in-memory ReplayBuffer can't satisfy
+//
+// Problem: each client's ReplayBuffer holds 100 frames (~10s of traffic).
+// After a longer outage GetRange/GetSince silently return only the tail.
+//
+// Solution: fetch the missing range from the primary source's stream
+// (ODIN_TOKENS by default, see stream_sources.go) with an ordered ephemeral
+// consumer filtered to the client's subscriptions, and send it before the
+// buffered tail.
+//
+// Protocol:
+//
+//	Client →  {"type":"replay","data":{"stream_seq":98231}}        (from stream sequence)
+//	Client →  {"type":"replay","data":{"start_time":1718000000000}} (from time, Unix ms)
+//	Client →  {"type":"replay","data":{"from":103,"to":149}}        (seq range older than the buffer)
+//	Server →  {"type":"replay_deep_start",...}
+//	Server →  historical envelopes: "seq":0, original "ts", "stream_seq"/"channel_seq" set
+//	Server →  buffered frames (original seqs, seq-range requests only)
+//	Server →  {"type":"replay_deep_end","delivered":412,"last_stream_seq":98643,"truncated":false,"exact":true}
+//
+// Historical envelopes have seq 0 (they were never assigned a client seq) -
+// clients dedupe them by stream_seq. "exact": false means the start point was
+// estimated (seq-range request), so the batch may include messages the client
+// already has.
+//
+// If part of the range is older than the stream keeps (its max age/msgs),
+// the client first gets:
+//
+//	{"type":"replay_aged_out","partial":true,"oldest_stream_seq":97000,"oldest_time":...,"max_age_ms":30000}
+//
+// and should resync the affected channels from a snapshot.
+//
+// Cost: one JetStream consumer per running replay, capped server-wide by
+// WS_DEEP_REPLAY_MAX_CONCURRENT; one per client at a time.
+
+// deepReplayFetchBatch is how many messages are pulled per Fetch round trip
+const deepReplayFetchBatch = 256
+
+// maxDeepReplayFilterSubjects caps the consumer's filter list
+// Clients with more subscriptions read the whole stream and filter locally
+const maxDeepReplayFilterSubjects = 64
+
+// deepReplayRequest describes the stream range to replay
+type deepReplayRequest struct {
+	startSeq  uint64    // First stream sequence (0 = use startTime)
+	startTime time.Time // First message time (zero = use startSeq)
+	endSeq    uint64    // Last stream sequence (inclusive, 0 = stream head when the replay starts)
+	tail      [][]byte  // Buffered frames sent after the historical messages
+	exact     bool      // false: start point is an estimate (client must dedupe by stream_seq)
+}
+
+// deepReplayAvailable reports whether replays can reach into JetStream
+func (s *Server) deepReplayAvailable() bool {
+	return s.streamJS != nil
+}
+
+// startDeepReplay runs a deep replay in the background
+// Rejects the request if the client already has one running or the
+// server-wide limit is reached (client should retry later)
+func (s *Server) startDeepReplay(c *Client, req deepReplayRequest) {
+	if !atomic.CompareAndSwapInt32(&c.deepReplayActive, 0, 1) {
+		RecordDeepReplay("busy", 0)
+		s.sendControlMessage(c, map[string]any{
+			"type":    "error",
+			"code":    "DEEP_REPLAY_BUSY",
+			"message": "A deep replay is already running for this connection",
+		})
+		return
+	}
+
+	select {
+	case s.deepReplaySem <- struct{}{}:
+	default:
+		atomic.StoreInt32(&c.deepReplayActive, 0)
+		RecordDeepReplay("busy", 0)
+		s.sendControlMessage(c, map[string]any{
+			"type":    "error",
+			"code":    "DEEP_REPLAY_BUSY",
+			"message": "Too many deep replays in progress, retry shortly",
+		})
+		return
+	}
+
+	// Connection-scoped stop channel: a disconnect aborts the replay
+	// (a resumed session gets its gap from the replay buffer instead)
+	stop := c.stop
+
+	// Tracked per client too: releaseClient waits for it before the Client
+	// goes back to the pool (its frames must never reach the next owner)
+	s.wg.Add(1)
+	c.deepReplayWG.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer c.deepReplayWG.Done()
+		defer func() {
+			<-s.deepReplaySem
+			atomic.StoreInt32(&c.deepReplayActive, 0)
+		}()
+
+		ctx, cancel := context.WithTimeout(s.ctx, s.config.DeepReplayTimeout)
+		defer cancel()
+
+		s.runDeepReplay(ctx, c, stop, req)
+	}()
+}
+
+// runDeepReplay fetches the range from the stream and queues it to the client
+func (s *Server) runDeepReplay(ctx context.Context, c *Client, stop <-chan struct{}, req deepReplayRequest) {
+	source := s.primarySource()
+	stream, err := s.streamJS.Stream(ctx, source.Config.Name)
+	if err != nil {
+		s.failDeepReplay(c, stop, req, err)
+		return
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		s.failDeepReplay(c, stop, req, err)
+		return
+	}
+	state := info.State
+
+	if req.endSeq == 0 {
+		req.endSeq = state.LastSeq
+	}
+
+	// Aged out: the stream no longer holds (part of) the requested range
+	// Fully aged out when even the end of the range is gone
+	agedOut := state.Msgs == 0 ||
+		(req.startSeq != 0 && req.startSeq < state.FirstSeq) ||
+		(!req.startTime.IsZero() && req.startTime.Before(state.FirstTime))
+	fullyAgedOut := state.Msgs == 0 || req.endSeq < state.FirstSeq
+
+	if agedOut {
+		msg := map[string]any{
+			"type":              "replay_aged_out",
+			"partial":           !fullyAgedOut,
+			"oldest_stream_seq": state.FirstSeq,
+			"max_age_ms":        source.Config.MaxAge.Milliseconds(),
+		}
+		if req.startSeq != 0 {
+			msg["requested_stream_seq"] = req.startSeq
+		}
+		if !req.startTime.IsZero() {
+			msg["requested_time"] = req.startTime.UnixMilli()
+		}
+		if state.Msgs > 0 {
+			msg["oldest_time"] = state.FirstTime.UnixMilli()
+		}
+		s.sendReplayControl(c, stop, msg)
+	}
+
+	if fullyAgedOut {
+		RecordDeepReplay("aged_out", 0)
+		s.finishDeepReplay(c, stop, req, 0, 0, false)
+		return
+	}
+
+	cfg := jetstream.OrderedConsumerConfig{
+		FilterSubjects:    source.filterSubjects(c.subscriptions.List(), maxDeepReplayFilterSubjects),
+		InactiveThreshold: s.config.DeepReplayTimeout,
+	}
+	switch {
+	case req.startSeq != 0 && req.startSeq >= state.FirstSeq:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = req.startSeq
+	case !req.startTime.IsZero() && !req.startTime.Before(state.FirstTime):
+		startTime := req.startTime
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &startTime
+	default:
+		cfg.DeliverPolicy = jetstream.DeliverAllPolicy // Aged out (partial) or estimated start
+	}
+
+	if len(cfg.FilterSubjects) == 0 {
+		// No subscriptions - nothing to replay
+		RecordDeepReplay("complete", 0)
+		s.finishDeepReplay(c, stop, req, 0, 0, false)
+		return
+	}
+
+	consumer, err := s.streamJS.OrderedConsumer(ctx, source.Config.Name, cfg)
+	if err != nil {
+		s.failDeepReplay(c, stop, req, err)
+		return
+	}
+
+	s.sendReplayControl(c, stop, map[string]any{
+		"type":          "replay_deep_start",
+		"to_stream_seq": req.endSeq,
+		"exact":         req.exact,
+	})
+
+	delivered := 0
+	var lastStreamSeq uint64
+	truncated := false
+
+	// Per-channel chain within the replayed range (first message per channel: unknown)
+	prevChannelSeq := make(map[string]uint64)
+
+fetchLoop:
+	for {
+		if ctx.Err() != nil {
+			truncated = true // WS_DEEP_REPLAY_TIMEOUT reached
+			break
+		}
+		if replayStopped(stop) {
+			truncated = true // Disconnected - releaseClient is waiting for us
+			break
+		}
+
+		batch, err := consumer.Fetch(deepReplayFetchBatch, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			s.failDeepReplay(c, stop, req, err)
+			return
+		}
+
+		received := 0
+		for msg := range batch.Messages() {
+			received++
+
+			meta, err := msg.Metadata()
+			if err != nil {
+				continue
+			}
+			if meta.Sequence.Stream > req.endSeq {
+				break fetchLoop // Caught up with where live delivery began
+			}
+
+			// Consumer filters may be broader than the subscriptions (overlapping
+			// patterns, too many channels), and subscriptions may change mid-replay
+			channel := source.ChannelFor(msg.Subject())
+			if channel != "" && c.subscriptions.Matches(channel) {
+				if delivered >= s.config.DeepReplayMaxMessages {
+					truncated = true
+					break fetchLoop
+				}
+
+				route := s.messageRouter.Resolve(channel)
+				prepared, err := c.codec.PrepareEnvelope(msg.Data(), EnvelopeHeader{
+					Timestamp:      meta.Timestamp.UnixMilli(),
+					Type:           route.Type,
+					Priority:       route.Priority,
+					StreamSeq:      meta.Sequence.Stream,
+					Channel:        channel,
+					ChannelSeq:     meta.Sequence.Stream,
+					PrevChannelSeq: prevChannelSeq[channel],
+				})
+				prevChannelSeq[channel] = meta.Sequence.Stream
+				if err != nil {
+					RecordSerializationError(ErrorSeverityWarning)
+					continue
+				}
+
+				// seq 0: historical message, never assigned a client sequence
+				if !s.queueReplayFrame(c, stop, prepared.Frame(0)) {
+					truncated = true
+					break fetchLoop
+				}
+				delivered++
+				lastStreamSeq = meta.Sequence.Stream
+			}
+
+			if meta.NumPending == 0 || meta.Sequence.Stream == req.endSeq {
+				break fetchLoop
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			s.failDeepReplay(c, stop, req, err)
+			return
+		}
+		if received == 0 {
+			break // Nothing left in range
+		}
+	}
+
+	result := "complete"
+	if truncated {
+		result = "truncated"
+	}
+	RecordDeepReplay(result, delivered)
+
+	s.structLogger.Debug().
+		Int64("client_id", c.id).
+		Int("delivered", delivered).
+		Uint64("last_stream_seq", lastStreamSeq).
+		Bool("truncated", truncated).
+		Msg("Deep replay completed")
+
+	s.finishDeepReplay(c, stop, req, delivered, lastStreamSeq, truncated)
+}
+
+// finishDeepReplay sends the buffered tail and the replay_deep_end marker
+func (s *Server) finishDeepReplay(c *Client, stop <-chan struct{}, req deepReplayRequest, delivered int, lastStreamSeq uint64, truncated bool) {
+	for _, frame := range req.tail {
+		if !s.queueReplayFrame(c, stop, frame) {
+			truncated = true
+			break
+		}
+	}
+
+	s.sendReplayControl(c, stop, map[string]any{
+		"type":            "replay_deep_end",
+		"delivered":       delivered,
+		"last_stream_seq": lastStreamSeq,
+		"truncated":       truncated,
+		"exact":           req.exact,
+	})
+}
+
+// failDeepReplay reports a JetStream error to the client
+// The buffered tail is still sent - it doesn't depend on the stream
+func (s *Server) failDeepReplay(c *Client, stop <-chan struct{}, req deepReplayRequest, err error) {
+	RecordDeepReplay("failed", 0)
+	RecordJetStreamError(ErrorSeverityWarning)
+	s.structLogger.Warn().
+		Err(err).
+		Int64("client_id", c.id).
+		Msg("Deep replay failed")
+
+	s.sendReplayControl(c, stop, map[string]any{
+		"type":    "error",
+		"code":    "DEEP_REPLAY_FAILED",
+		"message": "Replay from stream failed, resync from snapshot",
+	})
+	for _, frame := range req.tail {
+		if replayStopped(stop) {
+			return
+		}
+		select {
+		case c.send <- frame:
+		default:
+		}
+	}
+}
+
+// replayStopped reports whether the replay's connection is gone
+// Checked before every send: select picks randomly between a send with room
+// and a closed stop, so the select alone would keep queueing after a disconnect
+func replayStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendReplayControl sends a replay control message unless the connection is gone
+func (s *Server) sendReplayControl(c *Client, stop <-chan struct{}, msg map[string]any) bool {
+	if replayStopped(stop) {
+		return false
+	}
+	return s.sendControlMessage(c, msg)
+}
+
+// queueReplayFrame queues one frame with the same patience as buffered replay
+// (3s per frame); returns false if the client is too slow or disconnected
+func (s *Server) queueReplayFrame(c *Client, stop <-chan struct{}, frame []byte) bool {
+	if replayStopped(stop) {
+		return false
+	}
+
+	timer := time.NewTimer(3 * time.Second)
+	defer timer.Stop()
+
+	select {
+	case c.send <- frame:
+		return true
+	case <-stop:
+		return false
+	case <-timer.C:
+		return false
+	}
+}
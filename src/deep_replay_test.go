@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// controlMessage decodes a JSON control frame
+func controlMessage(t *testing.T, frame string) map[string]any {
+	t.Helper()
+	var msg map[string]any
+	if err := json.Unmarshal([]byte(frame), &msg); err != nil {
+		t.Fatalf("decode control frame %q: %v", frame, err)
+	}
+	return msg
+}
+
+func TestStartDeepReplayBusy(t *testing.T) {
+	tests := []struct {
+		name          string
+		alreadyActive bool
+		semFull       bool
+	}{
+		{name: "replay running for the connection", alreadyActive: true},
+		{name: "server-wide limit reached", semFull: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			s.deepReplaySem = make(chan struct{}, 1)
+			if tt.semFull {
+				s.deepReplaySem <- struct{}{}
+			}
+			c := newTestClient(s, 4)
+			if tt.alreadyActive {
+				c.deepReplayActive = 1
+			}
+
+			s.startDeepReplay(c, deepReplayRequest{startSeq: 1})
+
+			msg := controlMessage(t, receiveFrames(t, c, 1, time.Second)[0])
+			if msg["type"] != "error" || msg["code"] != "DEEP_REPLAY_BUSY" {
+				t.Errorf("reply = %v, want a DEEP_REPLAY_BUSY error", msg)
+			}
+			// A rejected request must not take over the running replay's flag
+			if got, want := atomic.LoadInt32(&c.deepReplayActive) == 1, tt.alreadyActive; got != want {
+				t.Errorf("deepReplayActive = %v, want %v", got, want)
+			}
+			wantSlots := 0
+			if tt.semFull {
+				wantSlots = 1
+			}
+			if got := len(s.deepReplaySem); got != wantSlots {
+				t.Errorf("semaphore holds %d slots after a rejected request, want %d", got, wantSlots)
+			}
+		})
+	}
+}
+
+func TestFinishDeepReplaySendsTailThenEnd(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 8)
+
+	req := deepReplayRequest{tail: [][]byte{[]byte(`"seq 149"`), []byte(`"seq 150"`)}, exact: false}
+	s.finishDeepReplay(c, c.stop, req, 412, 98643, false)
+
+	frames := receiveFrames(t, c, 3, time.Second)
+	if want := []string{`"seq 149"`, `"seq 150"`}; !reflect.DeepEqual(frames[:2], want) {
+		t.Errorf("tail = %q, want %q", frames[:2], want)
+	}
+	end := controlMessage(t, frames[2])
+	want := map[string]any{"type": "replay_deep_end", "delivered": 412.0, "last_stream_seq": 98643.0, "truncated": false, "exact": false}
+	if !reflect.DeepEqual(end, want) {
+		t.Errorf("end marker = %v, want %v", end, want)
+	}
+}
+
+func TestDeepReplayStopsAfterDisconnect(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 8)
+	close(c.stop)
+
+	if s.queueReplayFrame(c, c.stop, []byte("x")) {
+		t.Error("queueReplayFrame queued a frame after the connection stopped")
+	}
+	if s.sendReplayControl(c, c.stop, map[string]any{"type": "replay_deep_start"}) {
+		t.Error("sendReplayControl sent after the connection stopped")
+	}
+	s.failDeepReplay(c, c.stop, deepReplayRequest{tail: [][]byte{[]byte("tail")}}, nats.ErrTimeout)
+	s.finishDeepReplay(c, c.stop, deepReplayRequest{tail: [][]byte{[]byte("tail")}}, 0, 0, false)
+
+	if len(c.send) != 0 {
+		t.Errorf("%d frames queued for a stopped connection", len(c.send))
+	}
+}
+
+func TestQueueReplayFrameGivesUpOnFullBuffer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the 3s per-frame timeout")
+	}
+
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 1)
+	c.send <- []byte("full")
+
+	start := time.Now()
+	if s.queueReplayFrame(c, c.stop, []byte("x")) {
+		t.Fatal("queueReplayFrame queued into a full buffer")
+	}
+	if waited := time.Since(start); waited < 3*time.Second {
+		t.Errorf("gave up after %s, want the 3s per-frame patience", waited)
+	}
+}
+
+func TestCheckDeepReplayRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		primary   bool
+		retention nats.RetentionPolicy
+		wantErr   bool
+	}{
+		{name: "primary interest stream", enabled: true, primary: true, retention: nats.InterestPolicy, wantErr: true},
+		{name: "primary limits stream", enabled: true, primary: true, retention: nats.LimitsPolicy},
+		{name: "secondary interest stream", enabled: true, retention: nats.InterestPolicy},
+		{name: "deep replay disabled", primary: true, retention: nats.InterestPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) { config.DeepReplayEnabled = tt.enabled })
+			source := &StreamSource{Name: "other", Config: nats.StreamConfig{Name: "OTHER"}}
+			if tt.primary {
+				source = s.primarySource()
+			}
+
+			err := s.checkDeepReplayRetention(source, tt.retention)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDeepReplayRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// Handshake hardening and inbound message limits
+//
+// Abuse this defends against:
+// - Cross-site WebSocket hijacking: a malicious page opening ws://our-host
+//   from the victim's browser (cookies attached) → Origin allowlist
+// - Slowloris: connections that trickle the upgrade request byte by byte and
+//   hold a socket/goroutine forever → handshake timeout
+// - Oversized frames: a frame header announcing 2GB makes the reader allocate
+//   it; a small deflated frame can inflate to GBs → max message size
+// - Invalid UTF-8 in text frames (RFC 6455 §8.1 requires failing the connection)
+//
+// Each violation has its own outcome, counter label (ws_protocol_violations_total)
+// and audit event:
+//
+//	Violation           Outcome                          Reason label
+//	Origin not allowed  HTTP 403 before the upgrade      origin_not_allowed
+//	Handshake too slow  TCP connection dropped           handshake_timeout
+//	Message too large   Close 1009 (Message Too Big)     message_too_large
+//	Invalid UTF-8       Close 1007 (Invalid Payload)     invalid_utf8
+
+// ErrMessageTooLarge is returned when a (fragmented) client message exceeds WS_MAX_MESSAGE_SIZE
+var ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+// Protocol violation reasons (metrics label, audit "reason")
+const (
+	violationOriginNotAllowed = "origin_not_allowed"
+	violationHandshakeTimeout = "handshake_timeout"
+	violationMessageTooLarge  = "message_too_large"
+	violationInvalidUTF8      = "invalid_utf8"
+)
+
+// OriginAllowlist checks the Origin header of upgrade requests
+//
+// Entries are scheme://host[:port] and may start the host with "*." to allow
+// every subdomain ("https://*.example.com" allows https://app.example.com but
+// not https://example.com). Immutable after construction.
+//
+// Requests without an Origin header are allowed: browsers always send it,
+// so its absence means a non-browser client (server-to-server, native apps),
+// which a malicious page can't impersonate.
+type OriginAllowlist struct {
+	exact     map[string]bool  // "https://app.example.com"
+	wildcards []originWildcard // "https://*.example.com"
+}
+
+// originWildcard is a "scheme://*.domain" entry split for matching
+type originWildcard struct {
+	prefix string // "https://"
+	suffix string // ".example.com"
+}
+
+// ParseOriginAllowlist parses WS_ALLOWED_ORIGINS (comma-separated)
+// Returns nil for an empty spec (every origin allowed)
+func ParseOriginAllowlist(spec string) (*OriginAllowlist, error) {
+	list := &OriginAllowlist{exact: make(map[string]bool)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q: expected scheme://host[:port]", entry)
+		}
+
+		origin := u.Scheme + "://" + u.Host
+		if strings.HasPrefix(u.Host, "*.") {
+			list.wildcards = append(list.wildcards, originWildcard{prefix: u.Scheme + "://", suffix: u.Host[1:]})
+			continue
+		}
+		list.exact[origin] = true
+	}
+
+	if len(list.exact) == 0 && len(list.wildcards) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
+
+// Allowed reports whether an Origin header value is allowed
+func (l *OriginAllowlist) Allowed(origin string) bool {
+	if l == nil || origin == "" {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if l.exact[origin] {
+		return true
+	}
+	for _, wildcard := range l.wildcards {
+		if strings.HasPrefix(origin, wildcard.prefix) && strings.HasSuffix(origin, wildcard.suffix) &&
+			len(origin) > len(wildcard.prefix)+len(wildcard.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin rejects upgrade requests from origins not in WS_ALLOWED_ORIGINS
+// Returns false after writing an HTTP 403
+func (s *Server) checkOrigin(w http.ResponseWriter, r *http.Request, clientIP string) bool {
+	origin := r.Header.Get("Origin")
+	if s.allowedOrigins.Allowed(origin) {
+		return true
+	}
+
+	s.recordViolation(violationOriginNotAllowed, clientIP, 0, map[string]any{
+		"origin": origin,
+	})
+	http.Error(w, "Origin not allowed", http.StatusForbidden)
+	return false
+}
+
+// handshakeTracker detects connections that never complete their HTTP request
+// within WS_HANDSHAKE_TIMEOUT
+//
+// net/http enforces the timeout itself (ReadHeaderTimeout) but drops the
+// connection silently; the tracker follows connection states to count them:
+//
+//	StateNew/StateActive → request in progress (start time recorded)
+//	StateIdle/StateHijacked → request completed (upgraded or served)
+//	StateClosed while in progress for ≥ timeout → handshake timeout
+type handshakeTracker struct {
+	timeout   time.Duration
+	started   sync.Map // net.Conn → time.Time
+	onTimeout func(remoteAddr string, elapsed time.Duration)
+}
+
+// ConnState is installed as http.Server.ConnState
+func (t *handshakeTracker) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew, http.StateActive:
+		t.started.LoadOrStore(conn, time.Now())
+	case http.StateIdle, http.StateHijacked:
+		t.started.Delete(conn)
+	case http.StateClosed:
+		if started, ok := t.started.LoadAndDelete(conn); ok {
+			if elapsed := time.Since(started.(time.Time)); elapsed >= t.timeout {
+				t.onTimeout(conn.RemoteAddr().String(), elapsed)
+			}
+		}
+	}
+}
+
+// closeOnReadViolation closes the connection with a specific close code when
+// readMessage failed because of a size or UTF-8 violation
+// Other read errors (client went away, timeouts) are left to readPump
+func (s *Server) closeOnReadViolation(c *Client, err error) {
+	var (
+		reason string
+		code   ws.StatusCode
+		text   string
+	)
+	switch {
+	case errors.Is(err, wsutil.ErrFrameTooLarge), errors.Is(err, ErrMessageTooLarge), errors.Is(err, ErrInflatedMessageTooLarge):
+		reason, code, text = violationMessageTooLarge, ws.StatusMessageTooBig, "Message too large"
+	case errors.Is(err, wsutil.ErrInvalidUTF8):
+		reason, code, text = violationInvalidUTF8, ws.StatusInvalidFramePayloadData, "Invalid UTF-8 in text frame"
+	default:
+		return
+	}
+
+	s.recordViolation(reason, c.clientIP, c.id, map[string]any{
+		"error":          err.Error(),
+		"maxMessageSize": s.config.MaxMessageSize,
+	})
+
+	// Synchronous: readPump closes the connection as soon as this returns
+	if conn := s.beginClose(c); conn != nil {
+		c.writeCloseFrame(conn, code, text)
+	}
+}
+
+// recordViolation counts a protocol violation and writes its audit event
+func (s *Server) recordViolation(reason, remoteAddr string, clientID int64, metadata map[string]any) {
+	IncrementProtocolViolations(reason)
+
+	if metadata == nil {
+		metadata = make(map[string]any)
+	}
+	metadata["reason"] = reason
+	metadata["remoteAddr"] = remoteAddr
+	if clientID != 0 {
+		metadata["clientID"] = clientID
+	}
+	s.auditLogger.Warning("ProtocolViolation", "Connection violated handshake or message limits", metadata)
+}
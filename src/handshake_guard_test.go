@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+func TestOriginAllowlist(t *testing.T) {
+	list, err := ParseOriginAllowlist(" https://app.example.com, HTTPS://*.Example.org ,http://localhost:3000/")
+	if err != nil {
+		t.Fatalf("ParseOriginAllowlist: %v", err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "", want: true}, // Non-browser client
+		{origin: "https://app.example.com", want: true},
+		{origin: "HTTPS://APP.EXAMPLE.COM", want: true},
+		{origin: "http://app.example.com"},
+		{origin: "https://app.example.com:8443"},
+		{origin: "https://evil.app.example.com"},
+		{origin: "https://app.example.org", want: true},
+		{origin: "https://a.b.example.org", want: true},
+		{origin: "https://example.org"},
+		{origin: "https://.example.org"},
+		{origin: "https://evilexample.org"},
+		{origin: "http://app.example.org"},
+		{origin: "http://localhost:3000", want: true},
+		{origin: "http://localhost:3001"},
+		{origin: "null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := list.Allowed(tt.origin); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOriginAllowlist(t *testing.T) {
+	if list, err := ParseOriginAllowlist(" , "); err != nil || list != nil {
+		t.Errorf("ParseOriginAllowlist(empty) = %v, %v, want nil allowlist", list, err)
+	}
+	if !(*OriginAllowlist)(nil).Allowed("https://anything.example") {
+		t.Error("nil allowlist rejected an origin")
+	}
+
+	for _, spec := range []string{"app.example.com", "https://", "https://app.example.com/path", "://x"} {
+		if _, err := ParseOriginAllowlist(spec); err == nil {
+			t.Errorf("ParseOriginAllowlist(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestCheckOriginRejectsWith403(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.AllowedOrigins = "https://app.example.com" })
+
+	tests := []struct {
+		origin     string
+		wantAccept bool
+	}{
+		{origin: "https://app.example.com", wantAccept: true},
+		{origin: "https://evil.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+
+			if got := s.checkOrigin(w, r, "192.0.2.1"); got != tt.wantAccept {
+				t.Fatalf("checkOrigin() = %v, want %v", got, tt.wantAccept)
+			}
+			if !tt.wantAccept && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestHandshakeTrackerCountsTimeouts(t *testing.T) {
+	var timedOut []string
+	tracker := &handshakeTracker{
+		timeout:   20 * time.Millisecond,
+		onTimeout: func(remoteAddr string, _ time.Duration) { timedOut = append(timedOut, remoteAddr) },
+	}
+
+	slow, _ := net.Pipe()
+	upgraded, _ := net.Pipe()
+	fast, _ := net.Pipe()
+
+	tracker.ConnState(slow, http.StateNew)
+	tracker.ConnState(upgraded, http.StateNew)
+	tracker.ConnState(fast, http.StateNew)
+	tracker.ConnState(upgraded, http.StateActive)
+	tracker.ConnState(upgraded, http.StateHijacked)
+	tracker.ConnState(fast, http.StateClosed) // Closed right away: not a slow handshake
+
+	time.Sleep(30 * time.Millisecond)
+	tracker.ConnState(slow, http.StateClosed)
+	tracker.ConnState(upgraded, http.StateClosed)
+
+	if len(timedOut) != 1 {
+		t.Errorf("timeouts = %d, want 1 (only the connection that never finished its request)", len(timedOut))
+	}
+}
+
+func TestReadViolationsCloseWithCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		frames   []ws.Frame
+		wantCode ws.StatusCode
+	}{
+		{
+			name:     "frame too large",
+			frames:   []ws.Frame{ws.NewTextFrame(make([]byte, 65))},
+			wantCode: ws.StatusMessageTooBig,
+		},
+		{
+			name: "fragments too large together",
+			frames: []ws.Frame{
+				ws.NewFrame(ws.OpText, false, make([]byte, 40)),
+				ws.NewFrame(ws.OpContinuation, true, make([]byte, 40)),
+			},
+			wantCode: ws.StatusMessageTooBig,
+		},
+		{
+			name:     "invalid UTF-8",
+			frames:   []ws.Frame{ws.NewTextFrame([]byte{'{', 0xff, '}'})},
+			wantCode: ws.StatusInvalidFramePayloadData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) { config.MaxMessageSize = 64 })
+			serverConn, peer := net.Pipe()
+			defer peer.Close()
+
+			c := newTestClient(s, 1)
+			c.conn = serverConn
+
+			go func() {
+				for _, frame := range tt.frames {
+					if err := ws.WriteFrame(peer, ws.MaskFrame(frame)); err != nil {
+						return
+					}
+				}
+			}()
+
+			_, _, _, err := c.readMessage(s.config.MaxMessageSize)
+			if err == nil {
+				t.Fatal("readMessage accepted the message")
+			}
+			go s.closeOnReadViolation(c, err)
+
+			peer.SetReadDeadline(time.Now().Add(time.Second))
+			frame, err := ws.ReadFrame(peer)
+			if err != nil {
+				t.Fatalf("read close frame: %v", err)
+			}
+			code, _ := ws.ParseCloseFrameData(frame.Payload)
+			if frame.Header.OpCode != ws.OpClose || code != tt.wantCode {
+				t.Errorf("got %v frame with code %d, want close %d", frame.Header.OpCode, code, tt.wantCode)
+			}
+		})
+	}
+}
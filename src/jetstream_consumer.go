@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStream consumer modes: who receives which message
+//
+// Problem: with one shared durable ("ws-server") JetStream load-balances the
+// stream across every instance bound to it. Clients are spread over instances
+// too, so each client only saw the share of updates its instance happened to get.
+//
+// Modes (JS_CONSUMER_MODE):
+//   - instance (default): every instance has its own durable consumer,
+//     "{JS_CONSUMER_NAME}-{JS_INSTANCE_ID}", and receives the full feed
+//   - shared: the old behaviour - one durable, messages split across instances
+//     (only correct for a single instance)
+//
+// Startup (JS_CONSUMER_START, instance mode):
+//   - new (default): the instance's consumer is recreated at the tail of the
+//     stream - a restarted instance doesn't push a backlog of stale prices to
+//     clients that reconnected in the meantime
+//   - resume: keep the consumer and continue after the last ack (no gap for
+//     clients that resume their session on the same instance)
+//
+// Stale consumers: instances that go away (scale-in, pod rescheduled with a new
+// name) leave their consumer behind. With interest retention it pins every
+// message until it acks, so:
+//   - per-instance consumers get an InactiveThreshold - the NATS server deletes
+//     them after JS_CONSUMER_INACTIVE_THRESHOLD without pull requests
+//   - in "new" mode the instance deletes its own consumer on graceful shutdown
+//
+// Instances never delete each other's consumers: delivery activity can't tell
+// an idle instance (quiet stream, CPU brake) from a dead one. The server only
+// expires a consumer nobody pulls from, and an instance whose consumer was
+// deleted anyway (long CPU brake, operator) recreates it (see consumeJetStream).
+//
+// Each stream source (see stream_sources.go) has its own consumer - consumer
+// names are per stream, so all of them use the same name.
+
+// Consumer modes (JS_CONSUMER_MODE)
+const (
+	ConsumerModeInstance = "instance"
+	ConsumerModeShared   = "shared"
+)
+
+// Consumer start policies (JS_CONSUMER_START)
+const (
+	ConsumerStartNew    = "new"
+	ConsumerStartResume = "resume"
+)
+
+// ResolveInstanceID returns the configured instance ID or, if empty, the
+// hostname (the pod name on Kubernetes), reduced to characters valid in a
+// consumer name
+func ResolveInstanceID(configured string) (string, error) {
+	id := strings.TrimSpace(configured)
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine instance ID (set JS_INSTANCE_ID): %w", err)
+		}
+		id = hostname
+	}
+
+	// Consumer names can't contain '.', '*', '>', path separators or whitespace
+	id = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, id)
+	if strings.Trim(id, "-") == "" {
+		return "", fmt.Errorf("instance ID %q has no usable characters", configured)
+	}
+	return id, nil
+}
+
+// consumerName returns the durable name this instance consumes with
+func (s *Server) consumerName() string {
+	if s.config.JSConsumerMode == ConsumerModeShared {
+		return s.config.JSConsumerName
+	}
+	return s.config.JSConsumerName + "-" + s.instanceID
+}
+
+// consumerSubOpts returns the pull consumer options for the configured mode
+// (the durable name is passed to PullSubscribe)
+func (s *Server) consumerSubOpts() []nats.SubOpt {
+	opts := []nats.SubOpt{
+		nats.AckWait(s.config.JSConsumerAckWait),
+		nats.MaxDeliver(s.config.JSConsumerMaxDeliver), // Then dead-lettered (see jetstream_redelivery.go)
+	}
+	if s.config.JSConsumerMode == ConsumerModeShared {
+		return opts // No DeliverNew: existing "ws-server" consumers keep their deliver policy
+	}
+	return append(opts,
+		nats.DeliverNew(), // A brand-new instance starts at the tail, not 30s in the past
+		nats.InactiveThreshold(s.config.JSConsumerInactiveThreshold),
+	)
+}
+
+// prepareConsumer runs before subscribing to a stream: resets (start "new")
+// or reconciles this instance's own consumer
+func (s *Server) prepareConsumer(stream string) {
+	if s.config.JSConsumerMode == ConsumerModeShared || s.config.JSConsumerStart != ConsumerStartNew {
+		s.reconcileConsumer(stream)
+		return
+	}
+
+	err := s.natsJS.DeleteConsumer(stream, s.consumerName())
+	if err == nil {
+		s.logger.Printf("🧹 Deleted previous consumer %s on %s (JS_CONSUMER_START=new)", s.consumerName(), stream)
+	} else if !errors.Is(err, nats.ErrConsumerNotFound) {
+		RecordJetStreamError(ErrorSeverityWarning)
+		s.structLogger.Warn().
+			Err(err).
+			Str("consumer", s.consumerName()).
+			Msg("Failed to delete previous consumer - resuming it instead")
+		s.reconcileConsumer(stream)
+	}
+}
+
+// reconcileConsumer makes an existing consumer match the configuration before
+// the pull subscription binds to it (the client rejects mismatched settings):
+//   - push consumer from an older version → deleted, recreated as pull consumer
+//   - AckWait / MaxDeliver changed → updated in place
+func (s *Server) reconcileConsumer(stream string) {
+	info, err := s.natsJS.ConsumerInfo(stream, s.consumerName())
+	if err != nil {
+		return // Doesn't exist yet - PullSubscribe creates it
+	}
+
+	if info.Config.DeliverSubject != "" {
+		if err := s.natsJS.DeleteConsumer(stream, s.consumerName()); err != nil {
+			RecordJetStreamError(ErrorSeverityWarning)
+			s.structLogger.Warn().
+				Err(err).
+				Str("consumer", s.consumerName()).
+				Msg("Failed to delete push consumer - pull subscribe will fail")
+			return
+		}
+		s.auditLogger.Info("PushConsumerMigrated", "Deleted push consumer, recreating it as a pull consumer", map[string]any{
+			"stream":   stream,
+			"consumer": s.consumerName(),
+		})
+		return
+	}
+
+	cfg := info.Config
+	if cfg.AckWait == s.config.JSConsumerAckWait && cfg.MaxDeliver == s.config.JSConsumerMaxDeliver {
+		return
+	}
+	cfg.AckWait = s.config.JSConsumerAckWait
+	cfg.MaxDeliver = s.config.JSConsumerMaxDeliver
+	if _, err := s.natsJS.UpdateConsumer(stream, &cfg); err != nil {
+		RecordJetStreamError(ErrorSeverityWarning)
+		s.structLogger.Warn().
+			Err(err).
+			Str("consumer", s.consumerName()).
+			Msg("Failed to update consumer settings - pull subscribe will fail")
+		return
+	}
+	s.auditLogger.Info("ConsumerUpdated", "Updated consumer ack wait / max deliver", map[string]any{
+		"stream":     stream,
+		"consumer":   s.consumerName(),
+		"ackWait":    cfg.AckWait.String(),
+		"maxDeliver": cfg.MaxDeliver,
+	})
+}
+
+// releaseConsumer deletes this instance's consumers on graceful shutdown in
+// "new" mode - the next start recreates them anyway, and until then they would
+// only hold messages back (interest retention)
+func (s *Server) releaseConsumer() {
+	if s.natsJS == nil || s.config.JSConsumerMode == ConsumerModeShared || s.config.JSConsumerStart != ConsumerStartNew {
+		return
+	}
+	for _, source := range s.sources {
+		err := s.natsJS.DeleteConsumer(source.Config.Name, s.consumerName())
+		if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+			s.structLogger.Warn().
+				Err(err).
+				Str("stream", source.Config.Name).
+				Str("consumer", s.consumerName()).
+				Msg("Failed to delete consumer on shutdown - it expires after the inactive threshold")
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestResolveInstanceID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	wantHostname, err := ResolveInstanceID(hostname)
+	if err != nil {
+		t.Fatalf("ResolveInstanceID(hostname): %v", err)
+	}
+
+	tests := []struct {
+		configured string
+		want       string
+		wantErr    bool
+	}{
+		{configured: "ws-7f9c-2", want: "ws-7f9c-2"},
+		{configured: "  pod_1  ", want: "pod_1"},
+		{configured: "ws.eu-west.1", want: "ws-eu-west-1"},
+		{configured: "a/b*c>d e", want: "a-b-c-d-e"},
+		{configured: "", want: wantHostname},
+		{configured: "...", wantErr: true},
+		{configured: "* >", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.configured, func(t *testing.T) {
+			got, err := ResolveInstanceID(tt.configured)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveInstanceID(%q) error = %v, wantErr %v", tt.configured, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveInstanceID(%q) = %q, want %q", tt.configured, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumerName(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: ConsumerModeInstance, want: "ws-server-pod-1"},
+		{mode: ConsumerModeShared, want: "ws-server"}, // Every instance binds the same durable
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) {
+				config.JSConsumerName = "ws-server"
+				config.JSConsumerMode = tt.mode
+			})
+			s.instanceID = "pod-1"
+
+			if got := s.consumerName(); got != tt.want {
+				t.Errorf("consumerName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Pull-based JetStream consumption
+//
+// Problem: the push subscription NAK'd every message it wasn't allowed to
+// process (NATS rate limit, CPU brake). A NAK means "redeliver now", so under
+// overload JetStream pushed the same messages again and again - a redelivery
+// loop burning CPU exactly when there was none to spare.
+//
+// Pull consumer: the server asks for messages when it can handle them.
+// Each round fetches
+//
+//	batch = min(JS_FETCH_BATCH_SIZE, free worker queue slots, NATS rate limiter tokens)
+//
+// and waits up to JS_FETCH_MAX_WAIT for them. Backpressure is simply not
+// fetching:
+//   - CPU above WS_CPU_PAUSE_THRESHOLD → no fetch until it drops (brake engaged)
+//   - Rate limiter empty → sleep until the next token
+//   - Worker queue full → short sleep, workers drain it
+//
+// Unfetched messages wait in the stream (bounded by its max age/msgs/bytes).
+// Every stream source has its own consumer and fetch loop; the worker pool and
+// the NATS rate limit are shared, so sources compete for the same budget.
+// ws_nats_messages_dropped_total now only counts real drops: messages fetched
+// but not queued for broadcast (NAK'd with backoff, see jetstream_redelivery.go).
+
+const (
+	// fetchIdleBackoff is the pause while the worker queue is full or the CPU brake is engaged
+	fetchIdleBackoff = 50 * time.Millisecond
+
+	// fetchErrorBackoff is the pause after a failed fetch (NATS reconnecting, ...)
+	fetchErrorBackoff = time.Second
+)
+
+// Fetch throttle reasons (metrics label)
+const (
+	fetchThrottleCPU         = "cpu"
+	fetchThrottleRateLimit   = "rate_limit"
+	fetchThrottleWorkerQueue = "worker_queue"
+)
+
+// jetStreamFetcher holds the counters of the fetch loop
+type jetStreamFetcher struct {
+	received    int64
+	dropped     int64
+	ackFailures int64
+}
+
+// subscribeSource creates the source's pull subscription
+// Per-instance consumer: reset ours in "new" mode
+func (s *Server) subscribeSource(source *StreamSource) error {
+	stream := source.Config.Name
+	s.prepareConsumer(stream)
+
+	// Interest mode starts with the idle filter (see jetstream_interest.go)
+	sub, err := s.pullSubscribe(source, s.initialSubjects(source))
+	if err != nil {
+		RecordJetStreamError(ErrorSeverityCritical)
+		s.auditLogger.Critical("JetStreamSubscriptionFailed", "Failed to subscribe to JetStream", map[string]any{
+			"stream":   stream,
+			"subjects": source.Config.Subjects,
+			"consumer": s.consumerName(),
+			"error":    err.Error(),
+		})
+		return fmt.Errorf("failed to subscribe to jetstream stream %s: %w", stream, err)
+	}
+	source.sub.Store(sub)
+	s.logger.Printf("✅ Subscribed to JetStream: %s %v (pull consumer %s, %s mode, batch %d)",
+		stream, source.Config.Subjects, s.consumerName(), s.config.JSConsumerMode, s.config.JSFetchBatchSize)
+	return nil
+}
+
+// pullSubscribe binds to this instance's consumer on the source's stream,
+// creating it with the given filter if it doesn't exist
+func (s *Server) pullSubscribe(source *StreamSource, subjects []string) (*nats.Subscription, error) {
+	// Single-subject streams keep it as the consumer filter (consumers created
+	// by earlier versions are bound with exactly that filter); multi-subject
+	// streams consume unfiltered - the whole stream
+	subject := ""
+	if len(subjects) == 1 {
+		subject = subjects[0]
+	}
+	opts := append(s.consumerSubOpts(), nats.BindStream(source.Config.Name))
+	return s.natsJS.PullSubscribe(subject, s.consumerName(), opts...)
+}
+
+// consumerDeleted reports whether this instance's consumer on the source's
+// stream no longer exists (false if that can't be told right now)
+func (s *Server) consumerDeleted(source *StreamSource) bool {
+	_, err := s.natsJS.ConsumerInfo(source.Config.Name, s.consumerName())
+	return errors.Is(err, nats.ErrConsumerNotFound)
+}
+
+// resubscribeSource recreates the source's consumer after it was deleted while
+// in use (nil if that failed - the fetch loop retries)
+// The new consumer starts at the tail of the stream: the old one's position is gone
+func (s *Server) resubscribeSource(source *StreamSource) *nats.Subscription {
+	subjects := source.Config.Subjects
+	if s.interest != nil {
+		// The filter belongs to runInterestFilter: start idle and let it
+		// apply the current interest again
+		subjects = source.idleSubjects()
+	}
+
+	sub, err := s.pullSubscribe(source, subjects)
+	if err != nil {
+		RecordJetStreamError(ErrorSeverityWarning)
+		s.structLogger.Warn().
+			Err(err).
+			Str("stream", source.Config.Name).
+			Str("consumer", s.consumerName()).
+			Msg("Failed to recreate deleted consumer - retrying")
+		return nil
+	}
+	source.sub.Store(sub)
+	if s.interest != nil {
+		source.filterLost.Store(true)
+		s.interest.notify()
+	}
+
+	IncrementConsumersRecreated(source.Name)
+	s.auditLogger.Warning("JetStreamConsumerRecreated", "Consumer was deleted while in use - recreated it at the tail of the stream", map[string]any{
+		"stream":   source.Config.Name,
+		"consumer": s.consumerName(),
+	})
+	return sub
+}
+
+// consumeJetStream runs a source's fetch loop until shutdown
+func (s *Server) consumeJetStream(source *StreamSource) {
+	defer s.wg.Done()
+
+	sub := source.sub.Load()
+
+	f := &jetStreamFetcher{}
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		if sub == nil {
+			if sub = s.resubscribeSource(source); sub == nil {
+				s.sleepCtx(fetchErrorBackoff)
+				continue
+			}
+		}
+
+		batch, wait, reason := s.fetchBudget()
+		s.setNATSPaused(reason == fetchThrottleCPU)
+		if batch == 0 {
+			IncrementNATSFetchThrottled(reason)
+			s.sleepCtx(wait)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(s.ctx, s.config.JSFetchMaxWait)
+		msgs, err := sub.Fetch(batch, nats.Context(ctx))
+		cancel()
+		if err != nil && len(msgs) == 0 {
+			switch {
+			case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+				continue // No messages within JS_FETCH_MAX_WAIT - idle stream
+			case errors.Is(err, nats.ErrConnectionClosed), s.ctx.Err() != nil:
+				return
+			case errors.Is(err, nats.ErrConsumerDeleted), errors.Is(err, nats.ErrConsumerNotFound), errors.Is(err, nats.ErrNoResponders):
+				// Deleted under us (server-side InactiveThreshold after a long
+				// CPU brake, an operator) - fetching from it would fail forever
+				if s.consumerDeleted(source) {
+					// Drops the old subscription; the consumer it would delete is gone already
+					_ = sub.Unsubscribe()
+					sub = nil
+					continue
+				}
+			}
+			RecordJetStreamError(ErrorSeverityWarning)
+			s.structLogger.Warn().
+				Err(err).
+				Str("stream", source.Config.Name).
+				Str("consumer", s.consumerName()).
+				Msg("JetStream fetch failed - retrying")
+			s.sleepCtx(fetchErrorBackoff)
+			continue
+		}
+
+		ObserveNATSFetchBatch(len(msgs))
+		for _, msg := range msgs {
+			s.handleJetStreamMessage(f, source, msg)
+		}
+	}
+}
+
+// fetchBudget decides how many messages the next fetch may request
+// batch == 0: don't fetch, sleep wait (reason says why)
+func (s *Server) fetchBudget() (batch int, wait time.Duration, reason string) {
+	if s.resourceGuard.ShouldPauseNATS() {
+		return 0, fetchIdleBackoff, fetchThrottleCPU
+	}
+
+	batch = min(s.config.JSFetchBatchSize, s.workerPool.FreeCapacity())
+	if batch == 0 {
+		return 0, fetchIdleBackoff, fetchThrottleWorkerQueue
+	}
+
+	// Tokens are taken for the whole batch up front - a short fetch wastes the
+	// rest, which errs on the side of less load
+	batch, wait = s.resourceGuard.ReserveNATSMessages(batch)
+	if batch == 0 {
+		return 0, wait, fetchThrottleRateLimit
+	}
+	return batch, 0, ""
+}
+
+// handleJetStreamMessage sequences one fetched message and queues its broadcast
+func (s *Server) handleJetStreamMessage(f *jetStreamFetcher, source *StreamSource, msg *nats.Msg) {
+	count := atomic.AddInt64(&f.received, 1)
+	if count%100 == 0 {
+		s.logger.Printf("📬 Received %d messages from JetStream (%s)", count, source.Name)
+	}
+
+	// Track NATS message in Prometheus
+	IncrementNATSMessages()
+
+	// No mapping → no channel anyone can subscribe to. Ack it: redelivering
+	// won't make a mapping appear, and it would pin interest-retention streams
+	channel := source.ChannelFor(msg.Subject)
+	if channel == "" {
+		IncrementNATSUnmapped(source.Name)
+		s.structLogger.Debug().
+			Str("source", source.Name).
+			Str("subject", msg.Subject).
+			Msg("No channel mapping for NATS subject - message skipped")
+		_ = msg.Ack()
+		return
+	}
+
+	// Stream sequence rides along in the envelope: clients use it for deep
+	// replay (see deep_replay.go) and, chained per channel, for per-channel
+	// gap detection. The chain is built HERE because the fetch loop runs in
+	// stream order - the worker pool below does not
+	var streamSeq, prevChannelSeq uint64
+	prevUnknown := false
+	if meta, err := msg.Metadata(); err == nil {
+		var known bool
+		streamSeq = meta.Sequence.Stream
+		prevChannelSeq, known = s.channelSequencer.Next(channel, streamSeq)
+		prevUnknown = !known
+	}
+
+	// Submit to worker pool - the batch was sized to the free queue slots, so
+	// this only fails if something else filled the queue in the meantime
+	queued := s.workerPool.Submit(func() {
+		// CRITICAL: Pass the mapped channel to broadcast for subscription filtering
+		// Subject "odin.token.BTC.trade" → channel "BTC.trade"
+		// This reduces broadcast fanout from O(all_clients) to O(subscribed_clients)
+		// Performance gain: 10-20x CPU reduction with subscription filtering
+		s.broadcast(source, channel, msg.Data, streamSeq, prevChannelSeq, prevUnknown)
+
+		// Acknowledge message after successful broadcast
+		if err := msg.Ack(); err != nil {
+			RecordJetStreamError(ErrorSeverityWarning)
+			ackFails := atomic.AddInt64(&f.ackFailures, 1)
+
+			// Log every ack failure at debug level, summary every 100 at warn level
+			s.structLogger.Debug().
+				Err(err).
+				Int64("total_ack_failures", ackFails).
+				Str("subject", msg.Subject).
+				Msg("Failed to ACK NATS message")
+
+			if ackFails%100 == 0 {
+				s.structLogger.Warn().
+					Int64("ack_failures", ackFails).
+					Err(err).
+					Msg("High NATS ACK failure rate - check NATS connection health")
+			}
+		}
+	})
+	if queued {
+		return
+	}
+
+	// Real drop: fetched but not broadcast - redeliver later, not immediately
+	IncrementNATSDropped()
+	s.nakWithBackoff(msg, nakReasonWorkerQueue)
+	if dropped := atomic.AddInt64(&f.dropped, 1); dropped%100 == 0 {
+		s.structLogger.Warn().
+			Int64("dropped_count", dropped).
+			Int("worker_queue_size", s.config.WorkerQueueSize).
+			Msg("Worker queue full - fetched NATS messages NAK'd with backoff")
+	}
+}
+
+// setNATSPaused records CPU brake transitions (logged once per change)
+func (s *Server) setNATSPaused(paused bool) {
+	var value int32
+	if paused {
+		value = 1
+	}
+	if atomic.SwapInt32(&s.natsPaused, value) == value {
+		return
+	}
+
+	SetNATSConsumptionPaused(paused)
+	if paused {
+		s.structLogger.Warn().
+			Float64("cpu_threshold", s.config.CPUPauseThreshold).
+			Msg("CPU emergency brake - pausing NATS consumption")
+	} else {
+		s.structLogger.Info().Msg("CPU back below pause threshold - resuming NATS consumption")
+	}
+}
+
+// sleepCtx sleeps for d or until shutdown
+func (s *Server) sleepCtx(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetchBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		cpu        float64
+		queued     int // Tasks already waiting in the 64 slot worker queue
+		ratePerSec int // MaxNATSMessagesPerSec (burst is twice that)
+		spent      int // Rate limiter tokens taken before the call
+		wantBatch  int // 0 = don't fetch
+		wantReason string
+	}{
+		{name: "idle", ratePerSec: 1000, wantBatch: 16},
+		{name: "queue nearly full", queued: 60, ratePerSec: 1000, wantBatch: 4},
+		{name: "queue full", queued: 64, ratePerSec: 1000, wantReason: fetchThrottleWorkerQueue},
+		{name: "CPU brake", cpu: 95, ratePerSec: 1000, wantReason: fetchThrottleCPU},
+		{name: "CPU brake wins over a full queue", cpu: 95, queued: 64, ratePerSec: 1000, wantReason: fetchThrottleCPU},
+		{name: "rate limiter caps the batch", ratePerSec: 5, wantBatch: 10},
+		{name: "rate limiter empty", ratePerSec: 5, spent: 10, wantReason: fetchThrottleRateLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) {
+				config.JSFetchBatchSize = 16
+				config.MaxNATSMessagesPerSec = tt.ratePerSec
+			})
+			s.resourceGuard.currentCPU.Store(tt.cpu)
+			for i := 0; i < tt.queued; i++ {
+				s.workerPool.Submit(func() {})
+			}
+			if tt.spent > 0 {
+				s.resourceGuard.ReserveNATSMessages(tt.spent)
+			}
+
+			batch, wait, reason := s.fetchBudget()
+			if batch != tt.wantBatch || reason != tt.wantReason {
+				t.Fatalf("fetchBudget() = %d %q, want %d %q", batch, reason, tt.wantBatch, tt.wantReason)
+			}
+			if (batch == 0) != (wait > 0) {
+				t.Errorf("wait = %s with batch %d, want a wait exactly when nothing is fetched", wait, batch)
+			}
+		})
+	}
+}
+
+func TestReserveNATSMessages(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.MaxNATSMessagesPerSec = 5 })
+	guard := s.resourceGuard
+
+	if n, _ := guard.ReserveNATSMessages(4); n != 4 {
+		t.Fatalf("first reservation = %d, want 4", n)
+	}
+	// Only 6 of the burst of 10 left: a larger request gets what's there
+	if n, _ := guard.ReserveNATSMessages(8); n != 6 {
+		t.Fatalf("second reservation = %d, want the remaining 6", n)
+	}
+
+	n, wait := guard.ReserveNATSMessages(8)
+	if n != 0 || wait <= 0 || wait > 200*time.Millisecond {
+		t.Fatalf("empty bucket = %d, wait %s, want 0 and at most one token interval (200ms)", n, wait)
+	}
+	// Asking for the wait must not take the next token
+	time.Sleep(wait + 10*time.Millisecond)
+	if n, _ := guard.ReserveNATSMessages(8); n != 1 {
+		t.Errorf("reservation after the wait = %d, want 1", n)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Interest-based consumption (JS_INTEREST_SUBSCRIPTIONS)
+//
+// Problem: every instance fetches the whole feed of every source, even when
+// its clients only watch a handful of symbols - broadcast() then throws most
+// messages away because SubscriptionIndex.Get finds no subscriber.
+//
+// Solution: the instance's consumers only deliver what someone here listens
+// to. Their filter subjects follow the SubscriptionIndex:
+//
+//	clients subscribe BTC.trade, ETH.*  →  filter odin.token.BTC.trade, odin.token.ETH.*
+//	last ETH.* subscriber leaves        →  (after JS_INTEREST_LINGER) odin.token.BTC.trade
+//	nobody subscribed                   →  odin.token._ws_no_interest (matches nothing)
+//
+// Narrowing is debounced: the index signals when a channel or pattern gains
+// its first or loses its last subscriber; the filter is recomputed once the
+// burst has settled (JS_INTEREST_DEBOUNCE), and channels stay in the filter
+// for JS_INTEREST_LINGER after their last subscriber left - a client that
+// reconnects or flips between symbols doesn't cause consumer updates.
+//
+// Widening is not: a subscribe that adds a channel outside the filter updates
+// the consumers before its subscription_ack (widenInterest). Waiting for the
+// debounce would lose whatever was published in between - the consumer skips
+// messages its filter didn't match, they are never delivered later.
+//
+// Filters are updated in place (UpdateConsumer, NATS server 2.10+ for several
+// filter subjects); the consumer keeps its position at the tail of the stream,
+// so newly added channels start with their next message. Too many subjects or
+// overlapping patterns fall back to the full feed (see filterSubjects).
+//
+// Requires per-instance consumers starting at the tail (JS_CONSUMER_MODE=instance,
+// JS_CONSUMER_START=new) - a shared consumer can't follow one instance's clients.
+//
+// Last-value cache: it only learns channels somebody listens to, so a first
+// subscriber's snapshot is loaded from the stream on a cache miss (literal
+// channels, limits retention - see loadStreamSnapshots).
+
+// maxInterestFilterSubjects caps a consumer's filter list (more → full feed)
+const maxInterestFilterSubjects = 256
+
+// interestIdleToken replaces the wildcards of a stream subject to build a
+// filter no publisher uses
+const interestIdleToken = "_ws_no_interest"
+
+// interestRetryBackoff is the pause before retrying a failed filter update
+const interestRetryBackoff = 5 * time.Second
+
+// Stream lookups of missing snapshots (see loadStreamSnapshots)
+const (
+	lvcStreamLookupDeadline    = 2 * time.Second  // Whole batch of one subscribe request
+	lvcStreamLookupsPerRequest = 16               // GetLastMsg calls per subscribe request
+	lvcStreamLookupsInFlight   = 32               // Concurrent batches server-wide
+	lvcStreamMissTTL           = 30 * time.Second // No snapshot in the stream → don't ask again for this long
+	lvcStreamMaxMisses         = 10000            // Negative cache size cap
+)
+
+// interestFilter tracks channel interest and the filters applied per source
+// mu serializes applyInterest: runInterestFilter and subscribing readPumps
+// (widenInterest) both run it
+type interestFilter struct {
+	wake     chan struct{}            // Buffered(1): subscription index changed
+	mu       sync.Mutex               // Protects lastSeen, applied and consumer filter updates
+	lastSeen map[string]time.Time     // Channel/pattern → last time it had subscribers
+	applied  map[*StreamSource]string // Source → applied filter (filterKey)
+
+	// Snapshot lookups, touched by the lookup goroutines
+	lookups chan struct{}        // Semaphore: batches in flight
+	missMu  sync.Mutex           // Protects misses
+	misses  map[string]time.Time // Channel → when the stream had no usable snapshot
+}
+
+func newInterestFilter() *interestFilter {
+	return &interestFilter{
+		wake:     make(chan struct{}, 1),
+		lastSeen: make(map[string]time.Time),
+		applied:  make(map[*StreamSource]string),
+		lookups:  make(chan struct{}, lvcStreamLookupsInFlight),
+		misses:   make(map[string]time.Time),
+	}
+}
+
+// notify is the SubscriptionIndex interest listener (never blocks)
+func (f *interestFilter) notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default: // Update already pending
+	}
+}
+
+// filterKey identifies a filter subject list (order-independent)
+func filterKey(subjects []string) string {
+	sorted := append([]string(nil), subjects...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+// idleSubjects is the filter of a source nobody is interested in: a subject
+// inside the stream that no publisher uses, so the consumer keeps moving with
+// the tail without delivering anything (an empty filter would mean "everything")
+// Streams with only literal subjects have no such subject - they stay unfiltered
+func (src *StreamSource) idleSubjects() []string {
+	for _, subject := range src.Config.Subjects {
+		if !IsChannelPattern(subject) {
+			continue
+		}
+		tokens := strings.Split(subject, tokenSeparator)
+		for i, token := range tokens {
+			if token == singleTokenWildcard || token == fullWildcard {
+				tokens[i] = interestIdleToken
+			}
+		}
+		return []string{strings.Join(tokens, tokenSeparator)}
+	}
+	return src.Config.Subjects
+}
+
+// initialSubjects returns the filter a source's consumer is created with
+func (s *Server) initialSubjects(source *StreamSource) []string {
+	if s.interest == nil {
+		return source.Config.Subjects
+	}
+	subjects := source.idleSubjects() // No clients connected yet
+	s.interest.applied[source] = filterKey(subjects)
+	SetNATSInterestFilterSubjects(source.Name, len(subjects))
+	return subjects
+}
+
+// runInterestFilter applies subscription changes to the consumer filters until shutdown
+func (s *Server) runInterestFilter() {
+	defer s.wg.Done()
+
+	f := s.interest
+	linger := time.NewTimer(time.Hour)
+	linger.Stop()
+	defer linger.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-f.wake:
+			// Debounce: let a burst of (un)subscribes settle into one update
+			s.sleepCtx(s.config.JSInterestDebounce)
+			select {
+			case <-f.wake:
+			default:
+			}
+		case <-linger.C:
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		// Wake up again when the next lingering channel expires (or to retry)
+		f.mu.Lock()
+		next := s.applyInterest(time.Now())
+		f.mu.Unlock()
+		if next > 0 {
+			if !linger.Stop() {
+				select {
+				case <-linger.C:
+				default:
+				}
+			}
+			linger.Reset(next)
+		}
+	}
+}
+
+// widenInterest adds newly subscribed channels the consumer filters don't
+// cover yet, before the subscription_ack goes out (see the header comment)
+// Channels already in the filter (or lingering in it) cost a map lookup
+func (s *Server) widenInterest(channels []string) {
+	if s.interest == nil || len(channels) == 0 {
+		return
+	}
+
+	f := s.interest
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, channel := range channels {
+		if _, ok := f.lastSeen[channel]; !ok {
+			// Failed updates are retried by runInterestFilter, which the
+			// subscription index woke up for the same channels
+			s.applyInterest(time.Now())
+			return
+		}
+	}
+}
+
+// applyInterest recomputes every source's filter and updates consumers whose
+// filter changed
+// Returns when it needs to run again (0 = only on the next subscription change)
+// Caller holds interest.mu
+func (s *Server) applyInterest(now time.Time) time.Duration {
+	f := s.interest
+
+	active := make(map[string]bool)
+	for _, channel := range s.subscriptionIndex.Channels() {
+		active[channel] = true
+		f.lastSeen[channel] = now
+	}
+
+	// Subscribed now, or lingering after the last subscriber left
+	var next time.Duration
+	wanted := make([]string, 0, len(f.lastSeen))
+	for channel, seen := range f.lastSeen {
+		if active[channel] {
+			wanted = append(wanted, channel)
+			continue
+		}
+		remaining := s.config.JSInterestLinger - now.Sub(seen)
+		if remaining <= 0 {
+			delete(f.lastSeen, channel)
+			continue
+		}
+		wanted = append(wanted, channel)
+		if next == 0 || remaining < next {
+			next = remaining
+		}
+	}
+	SetNATSInterest(len(wanted))
+
+	for _, source := range s.sources {
+		subjects := source.filterSubjects(wanted, maxInterestFilterSubjects)
+		if len(subjects) == 0 {
+			subjects = source.idleSubjects()
+		}
+		if source.filterLost.Swap(false) {
+			delete(f.applied, source) // Consumer recreated with the idle filter
+		}
+		key := filterKey(subjects)
+		if f.applied[source] == key {
+			continue
+		}
+
+		if err := s.updateConsumerFilter(source, subjects); err != nil {
+			IncrementNATSInterestUpdates("error")
+			RecordJetStreamError(ErrorSeverityWarning)
+			s.structLogger.Warn().
+				Err(err).
+				Str("stream", source.Config.Name).
+				Str("consumer", s.consumerName()).
+				Int("filter_subjects", len(subjects)).
+				Msg("Failed to update consumer filter - retrying")
+			if next == 0 || interestRetryBackoff < next {
+				next = interestRetryBackoff
+			}
+			continue
+		}
+
+		f.applied[source] = key
+		IncrementNATSInterestUpdates("success")
+		SetNATSInterestFilterSubjects(source.Name, len(subjects))
+		s.structLogger.Debug().
+			Str("stream", source.Config.Name).
+			Strs("filter_subjects", subjects).
+			Int("channels", len(wanted)).
+			Msg("Consumer filter updated to local interest")
+	}
+	return next
+}
+
+// updateConsumerFilter replaces the filter subjects of this instance's consumer
+func (s *Server) updateConsumerFilter(source *StreamSource, subjects []string) error {
+	info, err := s.natsJS.ConsumerInfo(source.Config.Name, s.consumerName())
+	if err != nil {
+		return err
+	}
+
+	cfg := info.Config
+	if len(subjects) == 1 {
+		cfg.FilterSubject, cfg.FilterSubjects = subjects[0], nil
+	} else {
+		cfg.FilterSubject, cfg.FilterSubjects = "", subjects
+	}
+	_, err = s.natsJS.UpdateConsumer(source.Config.Name, &cfg)
+	return err
+}
+
+// recentMiss reports whether the stream had no snapshot for a channel within lvcStreamMissTTL
+func (f *interestFilter) recentMiss(channel string, now time.Time) bool {
+	f.missMu.Lock()
+	defer f.missMu.Unlock()
+
+	at, ok := f.misses[channel]
+	if !ok {
+		return false
+	}
+	if now.Sub(at) > lvcStreamMissTTL {
+		delete(f.misses, channel)
+		return false
+	}
+	return true
+}
+
+// addMiss remembers that the stream had no snapshot for a channel
+// Size is capped: expired misses are swept first, then the cache starts over
+func (f *interestFilter) addMiss(channel string, now time.Time) {
+	f.missMu.Lock()
+	defer f.missMu.Unlock()
+
+	if len(f.misses) >= lvcStreamMaxMisses {
+		for ch, at := range f.misses {
+			if now.Sub(at) > lvcStreamMissTTL {
+				delete(f.misses, ch)
+			}
+		}
+		if len(f.misses) >= lvcStreamMaxMisses {
+			f.misses = make(map[string]time.Time)
+		}
+	}
+	f.misses[channel] = now
+}
+
+// loadStreamSnapshots looks up channels missing from the last-value cache in
+// their source streams and queues the snapshots it finds (interest mode only)
+//
+// Without it the first subscriber of a quiet channel would get no snapshot:
+// the cache only sees messages of channels somebody already listens to.
+// Needs limits retention - interest retention drops messages no consumer wants.
+//
+// Runs in the background - a subscribe with many quiet channels must not stall
+// the connection's readPump. Bounded per subscribe request: one deadline for
+// the whole batch (lvcStreamLookupDeadline), at most lvcStreamLookupsPerRequest
+// stream lookups; channels the stream had nothing for are skipped for
+// lvcStreamMissTTL. The snapshots follow the subscription_ack a little later,
+// possibly after the first live message (clients drop older channel_seqs).
+func (s *Server) loadStreamSnapshots(c *Client, channels []string) {
+	if s.interest == nil || s.natsJS == nil || s.lastValues == nil {
+		return
+	}
+
+	now := time.Now()
+	var lookup []string
+	for _, channel := range channels {
+		if IsChannelPattern(channel) || s.interest.recentMiss(channel, now) {
+			continue
+		}
+		lookup = append(lookup, channel)
+	}
+	if len(lookup) == 0 {
+		return
+	}
+
+	select {
+	case s.interest.lookups <- struct{}{}:
+	default:
+		return // Too many lookups in flight - live updates follow anyway
+	}
+
+	// Connection-scoped: tracked per client so releaseClient can wait for it
+	// before the Client goes back to the pool (see startDeepReplay)
+	stop := c.stop
+	s.wg.Add(1)
+	c.snapshotWG.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer c.snapshotWG.Done()
+		defer func() { <-s.interest.lookups }()
+
+		ctx, cancel := context.WithTimeout(s.ctx, lvcStreamLookupDeadline)
+		defer cancel()
+
+		// A disconnect aborts the lookup in flight
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		budget := lvcStreamLookupsPerRequest
+		sent := 0
+		for _, channel := range lookup {
+			if budget <= 0 || ctx.Err() != nil {
+				break // Remaining channels get no snapshot (not cached as misses)
+			}
+			entry := s.lastValueFromStream(ctx, channel, &budget)
+			if entry == nil || replayStopped(stop) {
+				continue
+			}
+			if s.queueSnapshot(c, entry) {
+				sent++
+			}
+		}
+		IncrementSnapshotsSent(sent)
+	}()
+}
+
+// lastValueFromStream loads a literal channel's latest message from its source
+// stream into the last-value cache (nil if none)
+// budget counts down the stream lookups left for the request
+func (s *Server) lastValueFromStream(ctx context.Context, channel string, budget *int) *lvcEntry {
+	for _, source := range s.sources {
+		subjects, _ := source.SubjectsFor(channel)
+		for _, subject := range subjects {
+			if IsChannelPattern(subject) {
+				continue // Mapping drops a subject token - no single last message
+			}
+			if *budget <= 0 {
+				return nil // Out of lookups - not known to be missing
+			}
+			*budget--
+
+			msg, err := s.natsJS.GetLastMsg(source.Config.Name, subject, nats.Context(ctx))
+			if errors.Is(err, nats.ErrMsgNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil // Lookup failed (deadline, disconnect) - try again next time
+			}
+			if time.Since(msg.Time) > s.lastValues.retentionFor(channel) {
+				s.interest.addMiss(channel, time.Now())
+				return nil // Too old to show (WS_LVC_RETENTION)
+			}
+
+			// A live message broadcast since is newer - the cache keeps it
+			s.lastValues.SetFromStream(channel, msg.Data, msg.Time.UnixMilli(), msg.Sequence, msg.Time)
+			return s.lastValues.Get(channel)
+		}
+	}
+
+	s.interest.addMiss(channel, time.Now())
+	return nil
+}
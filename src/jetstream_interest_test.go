@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newInterestTestServer builds a server in interest mode (NewServer only
+// enables it with a NATS connection)
+// Without sources applyInterest only tracks interest - there is no consumer to update
+func newInterestTestServer(t *testing.T, linger time.Duration) *Server {
+	t.Helper()
+	s := newTestServer(t, func(config *ServerConfig) { config.JSInterestLinger = linger })
+	s.interest = newInterestFilter()
+	s.sources = nil
+	return s
+}
+
+// interestChannels returns the channels in the filter, sorted
+func interestChannels(f *interestFilter) []string {
+	var channels []string
+	for channel := range f.lastSeen {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func TestApplyInterestLinger(t *testing.T) {
+	s := newInterestTestServer(t, 30*time.Second)
+	c := newTestClient(s, 1)
+	s.subscriptionIndex.AddMultiple([]string{"BTC.trade", "ETH.*"}, c)
+	start := time.Now()
+
+	if next := s.applyInterest(start); next != 0 {
+		t.Errorf("applyInterest() = %s with nothing lingering, want 0", next)
+	}
+
+	s.subscriptionIndex.Remove("ETH.*", c)
+
+	tests := []struct {
+		name     string
+		at       time.Duration // After start
+		want     []string
+		wantNext time.Duration
+	}{
+		{name: "left channel lingers", at: 10 * time.Second, want: []string{"BTC.trade", "ETH.*"}, wantNext: 20 * time.Second},
+		{name: "still lingering", at: 29 * time.Second, want: []string{"BTC.trade", "ETH.*"}, wantNext: time.Second},
+		{name: "linger expired", at: 31 * time.Second, want: []string{"BTC.trade"}},
+	}
+
+	for _, tt := range tests {
+		next := s.applyInterest(start.Add(tt.at))
+		if got := interestChannels(s.interest); !reflect.DeepEqual(got, tt.want) || next != tt.wantNext {
+			t.Errorf("%s: interest = %q, next run in %s, want %q in %s", tt.name, got, next, tt.want, tt.wantNext)
+		}
+	}
+}
+
+func TestApplyInterestWithoutLinger(t *testing.T) {
+	s := newInterestTestServer(t, 0)
+	c := newTestClient(s, 1)
+	s.subscriptionIndex.Add("BTC.trade", c)
+	s.applyInterest(time.Now())
+
+	s.subscriptionIndex.RemoveClient(c)
+	if next := s.applyInterest(time.Now()); next != 0 || len(s.interest.lastSeen) != 0 {
+		t.Errorf("interest = %q, next run in %s, want none right after the last subscriber left", interestChannels(s.interest), next)
+	}
+}
+
+func TestWidenInterest(t *testing.T) {
+	s := newInterestTestServer(t, time.Minute)
+	c := newTestClient(s, 1)
+	s.subscriptionIndex.Add("BTC.trade", c)
+	s.applyInterest(time.Now())
+	seen := s.interest.lastSeen["BTC.trade"]
+
+	// Already in the filter: nothing recomputed
+	s.widenInterest([]string{"BTC.trade"})
+	if got := s.interest.lastSeen["BTC.trade"]; !got.Equal(seen) {
+		t.Error("widenInterest recomputed the filter for a channel it already covers")
+	}
+
+	// New channel: in the filter before widenInterest returns, no debounce
+	s.subscriptionIndex.Add("ETH.trade", c)
+	s.widenInterest([]string{"ETH.trade"})
+	if got, want := interestChannels(s.interest), []string{"BTC.trade", "ETH.trade"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("interest after widenInterest = %q, want %q", got, want)
+	}
+}
+
+func TestApplyInterestKeepsAppliedFilter(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.interest = newInterestFilter()
+	source := s.primarySource()
+
+	// The consumer is created with the idle filter; no interest keeps it, so
+	// no consumer update (there is no NATS connection to send one)
+	subjects := s.initialSubjects(source)
+	if want := []string{"odin.token._ws_no_interest"}; !reflect.DeepEqual(subjects, want) {
+		t.Fatalf("initialSubjects() = %q, want %q", subjects, want)
+	}
+	if next := s.applyInterest(time.Now()); next != 0 {
+		t.Errorf("applyInterest() = %s, want 0", next)
+	}
+	if got := s.interest.applied[source]; got != filterKey(subjects) {
+		t.Errorf("applied filter = %q, want the idle filter", got)
+	}
+}
+
+func TestIdleSubjects(t *testing.T) {
+	tests := []struct {
+		subjects []string
+		want     []string
+	}{
+		{subjects: []string{"odin.token.>"}, want: []string{"odin.token._ws_no_interest"}},
+		{subjects: []string{"odin.*.statistics"}, want: []string{"odin._ws_no_interest.statistics"}},
+		{subjects: []string{"odin.market.statistics", "odin.trades.>"}, want: []string{"odin.trades._ws_no_interest"}},
+		{subjects: []string{"odin.market.statistics"}, want: []string{"odin.market.statistics"}}, // Nothing to filter with
+	}
+
+	for _, tt := range tests {
+		source := &StreamSource{}
+		source.Config.Subjects = tt.subjects
+		if got := source.idleSubjects(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("idleSubjects(%q) = %q, want %q", tt.subjects, got, tt.want)
+		}
+	}
+}
+
+func TestFilterKeyIgnoresOrder(t *testing.T) {
+	a := []string{"odin.token.BTC.trade", "odin.token.ETH.*"}
+	b := []string{"odin.token.ETH.*", "odin.token.BTC.trade"}
+	if filterKey(a) != filterKey(b) {
+		t.Errorf("filterKey(%q) != filterKey(%q)", a, b)
+	}
+	if a[0] != "odin.token.BTC.trade" {
+		t.Error("filterKey reordered its argument")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Redelivery policy and dead letters
+//
+// A message that can't be processed is redelivered, but not forever:
+//   - NAKs are delayed with exponential backoff on the delivery count:
+//     JS_NAK_BACKOFF_BASE × 2^(deliveries-1), capped at JS_NAK_BACKOFF_MAX
+//     (1s, 2s, 4s, ... with the defaults) - never an immediate redelivery loop
+//   - The consumer stops after JS_CONSUMER_MAX_DELIVER deliveries (NAKs, and
+//     AckWait timeouts of messages whose broadcast panicked or stalled)
+//
+// Dead letters: when a message hits MaxDeliver the NATS server publishes a
+// max-deliveries advisory for this instance's consumer (one per stream source):
+//
+//	$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.<stream>.<consumer>
+//
+// The handler loads the original message from the stream and republishes it to
+// JS_DEAD_LETTER_SUBJECT with the context in headers, so poisoned messages can
+// be inspected (and replayed by hand) instead of disappearing:
+//
+//	Ws-Dead-Letter-Subject:    odin.token.BTC.trade
+//	Ws-Dead-Letter-Stream:     ODIN_TOKENS
+//	Ws-Dead-Letter-Sequence:   123456
+//	Ws-Dead-Letter-Consumer:   ws-server-pod-a
+//	Ws-Dead-Letter-Deliveries: 5
+//
+// The dead letter subject is captured by JS_DEAD_LETTER_STREAM (created on
+// startup, file storage, kept for a week). Messages already removed from the
+// source stream are recorded in the audit log only.
+
+// NAK reasons (metrics label)
+const nakReasonWorkerQueue = "worker_queue"
+
+// advisoryMaxDeliveriesPrefix is the subject prefix of JetStream max-deliveries advisories
+const advisoryMaxDeliveriesPrefix = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES."
+
+// Dead letter stream limits - inspection only, never read by the server
+const (
+	deadLetterMaxAge  = 7 * 24 * time.Hour
+	deadLetterMaxMsgs = 100000
+)
+
+// Dead letter headers
+const (
+	headerDeadLetterSubject    = "Ws-Dead-Letter-Subject"
+	headerDeadLetterStream     = "Ws-Dead-Letter-Stream"
+	headerDeadLetterSequence   = "Ws-Dead-Letter-Sequence"
+	headerDeadLetterConsumer   = "Ws-Dead-Letter-Consumer"
+	headerDeadLetterDeliveries = "Ws-Dead-Letter-Deliveries"
+)
+
+// Dead letter results (metrics label)
+const (
+	deadLetterRepublished = "republished"
+	deadLetterMissing     = "missing" // Gone from the source stream (MaxAge/limits)
+	deadLetterFailed      = "failed"
+)
+
+// maxDeliveriesAdvisory is the payload of a max-deliveries advisory
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// nakDelay returns the redelivery delay for a message delivered n times so far
+func (s *Server) nakDelay(deliveries uint64) time.Duration {
+	delay := s.config.JSNakBackoffBase
+	for i := uint64(1); i < deliveries && delay < s.config.JSNakBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, s.config.JSNakBackoffMax)
+}
+
+// nakWithBackoff NAKs a message with the backoff delay for its delivery count
+func (s *Server) nakWithBackoff(msg *nats.Msg, reason string) {
+	var deliveries uint64 = 1
+	if meta, err := msg.Metadata(); err == nil {
+		deliveries = meta.NumDelivered
+	}
+
+	IncrementNATSNaks(reason)
+	if err := msg.NakWithDelay(s.nakDelay(deliveries)); err != nil {
+		RecordJetStreamError(ErrorSeverityWarning)
+		s.structLogger.Debug().
+			Err(err).
+			Str("subject", msg.Subject).
+			Str("reason", reason).
+			Msg("Failed to NAK NATS message")
+	}
+}
+
+// startDeadLetterHandler creates the dead letter stream and subscribes to the
+// max-deliveries advisories of this instance's consumer on every source stream
+// No-op with an empty JS_DEAD_LETTER_SUBJECT
+func (s *Server) startDeadLetterHandler() error {
+	if s.config.JSDeadLetterSubject == "" {
+		return nil
+	}
+
+	if s.config.JSDeadLetterStream != "" {
+		if _, err := s.natsJS.StreamInfo(s.config.JSDeadLetterStream); errors.Is(err, nats.ErrStreamNotFound) {
+			_, err = s.natsJS.AddStream(&nats.StreamConfig{
+				Name:      s.config.JSDeadLetterStream,
+				Subjects:  []string{s.config.JSDeadLetterSubject},
+				Retention: nats.LimitsPolicy,
+				Storage:   nats.FileStorage, // Survives NATS restarts - these are for humans
+				MaxAge:    deadLetterMaxAge,
+				MaxMsgs:   deadLetterMaxMsgs,
+				Discard:   nats.DiscardOld,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create dead letter stream: %w", err)
+			}
+			s.logger.Printf("📦 Created dead letter stream: %s (%s)", s.config.JSDeadLetterStream, s.config.JSDeadLetterSubject)
+		} else if err != nil {
+			return fmt.Errorf("failed to check dead letter stream: %w", err)
+		}
+	}
+
+	// Queue group: in shared mode every instance sees the same advisories, one republishes
+	for _, source := range s.sources {
+		subject := advisoryMaxDeliveriesPrefix + source.Config.Name + "." + s.consumerName()
+		sub, err := s.natsConn.QueueSubscribe(subject, s.consumerName(), s.handleMaxDeliveriesAdvisory)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to max-deliveries advisories: %w", err)
+		}
+		s.deadLetterSubs = append(s.deadLetterSubs, sub)
+		s.logger.Printf("✅ Dead letters: %s → %s (max deliver %d)", subject, s.config.JSDeadLetterSubject, s.config.JSConsumerMaxDeliver)
+	}
+	return nil
+}
+
+// stopDeadLetterHandler unsubscribes from the advisories on shutdown - another
+// instance of the queue group takes over the republishing
+func (s *Server) stopDeadLetterHandler() {
+	for _, sub := range s.deadLetterSubs {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			s.structLogger.Debug().
+				Err(err).
+				Str("subject", sub.Subject).
+				Msg("Failed to unsubscribe from max-deliveries advisories")
+		}
+	}
+	s.deadLetterSubs = nil
+}
+
+// handleMaxDeliveriesAdvisory republishes a message that exhausted MaxDeliver
+func (s *Server) handleMaxDeliveriesAdvisory(advisoryMsg *nats.Msg) {
+	var advisory maxDeliveriesAdvisory
+	if err := json.Unmarshal(advisoryMsg.Data, &advisory); err != nil {
+		RecordJetStreamError(ErrorSeverityWarning)
+		s.structLogger.Warn().Err(err).Msg("Malformed max-deliveries advisory")
+		return
+	}
+
+	details := map[string]any{
+		"stream":     advisory.Stream,
+		"consumer":   advisory.Consumer,
+		"streamSeq":  advisory.StreamSeq,
+		"deliveries": advisory.Deliveries,
+	}
+
+	original, err := s.natsJS.GetMsg(advisory.Stream, advisory.StreamSeq)
+	if err != nil {
+		IncrementDeadLetters(deadLetterMissing)
+		details["error"] = err.Error()
+		s.auditLogger.Warning("DeadLetterMissing", "Message exceeded max deliveries but is no longer in the stream", details)
+		return
+	}
+	details["subject"] = original.Subject
+
+	dead := nats.NewMsg(s.config.JSDeadLetterSubject)
+	dead.Data = original.Data
+	for key, values := range original.Header {
+		dead.Header[key] = values
+	}
+	dead.Header.Set(headerDeadLetterSubject, original.Subject)
+	dead.Header.Set(headerDeadLetterStream, advisory.Stream)
+	dead.Header.Set(headerDeadLetterSequence, strconv.FormatUint(advisory.StreamSeq, 10))
+	dead.Header.Set(headerDeadLetterConsumer, advisory.Consumer)
+	dead.Header.Set(headerDeadLetterDeliveries, strconv.FormatUint(advisory.Deliveries, 10))
+
+	// Through JetStream when a stream captures the subject (stored = acked)
+	if s.config.JSDeadLetterStream != "" {
+		_, err = s.natsJS.PublishMsg(dead)
+	} else {
+		err = s.natsConn.PublishMsg(dead)
+	}
+	if err != nil {
+		IncrementDeadLetters(deadLetterFailed)
+		RecordJetStreamError(ErrorSeverityCritical)
+		details["error"] = err.Error()
+		s.auditLogger.Error("DeadLetterFailed", "Failed to republish message to the dead letter subject", details)
+		return
+	}
+
+	IncrementDeadLetters(deadLetterRepublished)
+	details["deadLetterSubject"] = s.config.JSDeadLetterSubject
+	s.auditLogger.Warning("DeadLetter", "Message exceeded max deliveries and was dead-lettered", details)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNakDelay(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) {
+		config.JSNakBackoffBase = time.Second
+		config.JSNakBackoffMax = 10 * time.Second
+	})
+
+	tests := []struct {
+		deliveries uint64
+		want       time.Duration
+	}{
+		{deliveries: 0, want: time.Second}, // No metadata
+		{deliveries: 1, want: time.Second},
+		{deliveries: 2, want: 2 * time.Second},
+		{deliveries: 3, want: 4 * time.Second},
+		{deliveries: 4, want: 8 * time.Second},
+		{deliveries: 5, want: 10 * time.Second},
+		{deliveries: 64, want: 10 * time.Second},
+		{deliveries: 1 << 40, want: 10 * time.Second}, // Must not overflow
+	}
+
+	for _, tt := range tests {
+		if got := s.nakDelay(tt.deliveries); got != tt.want {
+			t.Errorf("nakDelay(%d) = %s, want %s", tt.deliveries, got, tt.want)
+		}
+	}
+}
+
+func TestMaxDeliveriesAdvisoryDecodes(t *testing.T) {
+	// As published by nats-server on $JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.<stream>.<consumer>
+	payload := `{
+		"type": "io.nats.jetstream.advisory.v1.max_deliver",
+		"id": "kYtYtSvHTZhT2eHWS1TUS3",
+		"timestamp": "2024-01-01T00:00:00Z",
+		"stream": "ODIN_TOKENS",
+		"consumer": "ws-server-pod-a",
+		"stream_seq": 123456,
+		"deliveries": 5
+	}`
+
+	var advisory maxDeliveriesAdvisory
+	if err := json.Unmarshal([]byte(payload), &advisory); err != nil {
+		t.Fatalf("decode advisory: %v", err)
+	}
+	want := maxDeliveriesAdvisory{Stream: "ODIN_TOKENS", Consumer: "ws-server-pod-a", StreamSeq: 123456, Deliveries: 5}
+	if advisory != want {
+		t.Errorf("advisory = %+v, want %+v", advisory, want)
+	}
+}
+
+func TestWorkerQueueOverflowIsDropped(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.WorkerQueueSize = 1 })
+	s.workerPool.Submit(func() {})
+	f := &jetStreamFetcher{}
+	source := s.primarySource()
+
+	// Unbound message: the NAK itself fails, the drop must still be counted
+	s.handleJetStreamMessage(f, source, nats.NewMsg("odin.token.BTC.trade"))
+
+	if f.dropped != 1 {
+		t.Errorf("dropped = %d, want 1", f.dropped)
+	}
+	if free := s.workerPool.FreeCapacity(); free != 0 {
+		t.Errorf("worker queue has %d free slots, want the overflowing message not queued", free)
+	}
+}
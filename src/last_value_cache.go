@@ -0,0 +1,311 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Last-value cache (snapshot-on-subscribe)
+//
+// Problem: after "subscribe" a client gets a subscription_ack and then nothing
+// until the next NATS message on that channel. For low-frequency channels
+// (metadata, analytics) that can be minutes of an empty UI.
+//
+// Solution: broadcast() stores the latest message per channel; subscribe sends
+// each newly subscribed channel's cached message right after the ack:
+//
+//	Client → {"type":"subscribe","data":{"channels":["BTC.metadata"]}}
+//	Server → {"type":"subscription_ack",...}
+//	Server → {"seq":0,"ts":...,"type":"token:metadata","priority":2,"snapshot":true,"channel":"BTC.metadata","channel_seq":97120,"data":{...}}
+//
+// Snapshots carry seq 0 (like deep replay, they are outside the per-connection
+// sequence). A live message can overtake a snapshot on a busy channel - clients
+// drop a snapshot whose channel_seq is older than one they already have.
+//
+// Retention is per event type (WS_LVC_RETENTION): a 10-minute-old price is
+// misleading, a 10-minute-old token description is fine. Memory is capped
+// (WS_LVC_MAX_BYTES); least recently updated channels are evicted first.
+
+// lvcEntryOverhead approximates the per-entry bookkeeping (struct, map slot, list element)
+const lvcEntryOverhead = 128
+
+// lvcEntry is the cached latest message for one channel
+type lvcEntry struct {
+	channel   string
+	data      []byte // Raw NATS payload (envelope is built per codec at snapshot time)
+	timestamp int64  // Server timestamp of the original broadcast (Unix ms)
+	streamSeq uint64 // JetStream stream sequence (0 = not from JetStream)
+	storedAt  time.Time
+	element   *list.Element // Position in the eviction list
+}
+
+func (e *lvcEntry) size() int64 {
+	return int64(len(e.data)+len(e.channel)) + lvcEntryOverhead
+}
+
+// LastValueCache holds the latest message per channel
+// Thread-safe: Set is called from concurrent broadcast workers, Get/Match from readPumps
+type LastValueCache struct {
+	retention        map[string]time.Duration // Event type → max age (0 = not cached)
+	defaultRetention time.Duration            // Event types not listed in retention
+	maxBytes         int64
+
+	mu      sync.Mutex
+	entries map[string]*lvcEntry
+	order   *list.List // Front = least recently updated (evicted first)
+	bytes   int64
+}
+
+// NewLastValueCache creates a cache
+//
+// Parameters:
+//
+//	retentionSpec    - "event=duration,..." per event type, e.g. "trade=30s,metadata=24h,balances=0"
+//	defaultRetention - max age for event types not in retentionSpec (0 = not cached)
+//	maxBytes         - memory cap across all channels
+func NewLastValueCache(retentionSpec string, defaultRetention time.Duration, maxBytes int64) (*LastValueCache, error) {
+	retention, err := ParseLVCRetention(retentionSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LastValueCache{
+		retention:        retention,
+		defaultRetention: defaultRetention,
+		maxBytes:         maxBytes,
+		entries:          make(map[string]*lvcEntry),
+		order:            list.New(),
+	}, nil
+}
+
+// ParseLVCRetention parses a WS_LVC_RETENTION specification
+// Format: "event=duration,event=duration" (duration 0 disables caching for that event type)
+func ParseLVCRetention(spec string) (map[string]time.Duration, error) {
+	retention := make(map[string]time.Duration)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eventType, durationStr, found := strings.Cut(entry, "=")
+		eventType = strings.TrimSpace(eventType)
+		if !found || eventType == "" {
+			return nil, fmt.Errorf("invalid retention %q: expected event=duration", entry)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid retention %q: bad duration", entry)
+		}
+		retention[eventType] = duration
+	}
+	return retention, nil
+}
+
+// retentionFor returns the max age for a channel's event type ("BTC.trade" → trade)
+func (lvc *LastValueCache) retentionFor(channel string) time.Duration {
+	if idx := strings.LastIndex(channel, tokenSeparator); idx >= 0 {
+		if retention, ok := lvc.retention[channel[idx+1:]]; ok {
+			return retention
+		}
+	}
+	return lvc.defaultRetention
+}
+
+// Set stores the latest message for a channel
+//
+// Broadcast workers run concurrently, so two messages on one channel can
+// arrive out of order - an older stream sequence never replaces a newer one
+func (lvc *LastValueCache) Set(channel string, data []byte, timestamp int64, streamSeq uint64) {
+	lvc.store(channel, data, timestamp, streamSeq, time.Now(), false)
+}
+
+// SetFromStream stores a message loaded from its JetStream stream
+// (see lastValueFromStream) unless the cache already holds that message or a
+// newer one - the lookup runs off the hot path, a live message broadcast in the
+// meantime must win. Retention counts from publishedAt, not from the lookup.
+// Returns false if the message was not stored.
+func (lvc *LastValueCache) SetFromStream(channel string, data []byte, timestamp int64, streamSeq uint64, publishedAt time.Time) bool {
+	return lvc.store(channel, data, timestamp, streamSeq, publishedAt, true)
+}
+
+// store inserts an entry; keepExisting refuses to replace an entry unless it
+// has an older (known) stream sequence
+func (lvc *LastValueCache) store(channel string, data []byte, timestamp int64, streamSeq uint64, storedAt time.Time, keepExisting bool) bool {
+	if lvc == nil || lvc.retentionFor(channel) == 0 {
+		return false
+	}
+
+	entry := &lvcEntry{
+		channel:   channel,
+		data:      data,
+		timestamp: timestamp,
+		streamSeq: streamSeq,
+		storedAt:  storedAt,
+	}
+	if entry.size() > lvc.maxBytes {
+		return false // Single message larger than the whole cache
+	}
+
+	lvc.mu.Lock()
+	defer lvc.mu.Unlock()
+
+	if existing, ok := lvc.entries[channel]; ok {
+		if streamSeq != 0 && streamSeq < existing.streamSeq {
+			return false // Stale (overtaken by a newer message)
+		}
+		if keepExisting && (existing.streamSeq == 0 || existing.streamSeq >= streamSeq) {
+			return false // Live message at least as new as the stream's
+		}
+		lvc.removeLocked(existing)
+	}
+
+	entry.element = lvc.order.PushBack(entry)
+	lvc.entries[channel] = entry
+	lvc.bytes += entry.size()
+
+	// Memory cap: evict least recently updated channels
+	for lvc.bytes > lvc.maxBytes {
+		oldest := lvc.order.Front().Value.(*lvcEntry)
+		lvc.removeLocked(oldest)
+		IncrementLVCEvictions("memory")
+	}
+
+	UpdateLVCSize(len(lvc.entries), lvc.bytes)
+	return true
+}
+
+// Get returns the cached message for a channel, or nil if none (or expired)
+func (lvc *LastValueCache) Get(channel string) *lvcEntry {
+	if lvc == nil {
+		return nil
+	}
+
+	lvc.mu.Lock()
+	defer lvc.mu.Unlock()
+
+	entry, ok := lvc.entries[channel]
+	if !ok {
+		return nil
+	}
+	if lvc.expiredLocked(entry, time.Now()) {
+		return nil
+	}
+	return entry
+}
+
+// Match returns the cached messages for every channel matching a pattern ("BTC.*")
+func (lvc *LastValueCache) Match(pattern string) []*lvcEntry {
+	if lvc == nil {
+		return nil
+	}
+
+	lvc.mu.Lock()
+	defer lvc.mu.Unlock()
+
+	now := time.Now()
+	var matches []*lvcEntry
+	for channel, entry := range lvc.entries {
+		if !MatchChannelPattern(pattern, channel) {
+			continue
+		}
+		if lvc.expiredLocked(entry, now) {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	return matches
+}
+
+// expiredLocked drops an entry older than its event type's retention
+// Expiry is lazy (checked on read) - quiet channels don't need a sweeper,
+// the memory cap bounds what expired entries can hold
+func (lvc *LastValueCache) expiredLocked(entry *lvcEntry, now time.Time) bool {
+	if now.Sub(entry.storedAt) <= lvc.retentionFor(entry.channel) {
+		return false
+	}
+	lvc.removeLocked(entry)
+	IncrementLVCEvictions("expired")
+	UpdateLVCSize(len(lvc.entries), lvc.bytes)
+	return true
+}
+
+func (lvc *LastValueCache) removeLocked(entry *lvcEntry) {
+	lvc.order.Remove(entry.element)
+	delete(lvc.entries, entry.channel)
+	lvc.bytes -= entry.size()
+}
+
+// sendSnapshots queues the cached message of each newly subscribed channel
+// (patterns expand to every cached channel they match)
+// Channels missing from the cache are looked up in the stream in the
+// background (interest mode, see loadStreamSnapshots)
+// Returns the number of snapshots queued
+func (s *Server) sendSnapshots(c *Client, channels []string) int {
+	if s.lastValues == nil {
+		return 0
+	}
+
+	seen := make(map[string]bool)
+	var missing []string
+	sent := 0
+	for _, channel := range channels {
+		var entries []*lvcEntry
+		if IsChannelPattern(channel) {
+			entries = s.lastValues.Match(channel)
+		} else if entry := s.lastValues.Get(channel); entry != nil {
+			entries = []*lvcEntry{entry}
+		} else if !seen[channel] {
+			seen[channel] = true
+			missing = append(missing, channel) // Interest mode: never consumed here before
+		}
+
+		for _, entry := range entries {
+			if seen[entry.channel] {
+				continue // "BTC.*" and "*.trade" both match BTC.trade
+			}
+			seen[entry.channel] = true
+
+			if s.queueSnapshot(c, entry) {
+				sent++
+			}
+		}
+	}
+
+	IncrementSnapshotsSent(sent)
+	s.loadStreamSnapshots(c, missing)
+	return sent
+}
+
+// queueSnapshot queues one cached message in the client's codec
+// Returns false if it couldn't be serialized or the client buffer is full
+func (s *Server) queueSnapshot(c *Client, entry *lvcEntry) bool {
+	route := s.messageRouter.Resolve(entry.channel)
+	prepared, err := c.codec.PrepareEnvelope(entry.data, EnvelopeHeader{
+		Timestamp:  entry.timestamp,
+		Type:       route.Type,
+		Priority:   route.Priority,
+		StreamSeq:  entry.streamSeq,
+		Channel:    entry.channel,
+		ChannelSeq: entry.streamSeq,
+		Snapshot:   true,
+	})
+	if err != nil {
+		RecordSerializationError(ErrorSeverityWarning)
+		return false
+	}
+
+	// seq 0: outside the per-connection sequence (not replayable)
+	select {
+	case c.send <- prepared.Frame(0):
+		return true
+	default:
+		// Client buffer full - skip, live updates follow anyway
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestLVC(t *testing.T, retention string, maxBytes int64) *LastValueCache {
+	t.Helper()
+	lvc, err := NewLastValueCache(retention, time.Minute, maxBytes)
+	if err != nil {
+		t.Fatalf("NewLastValueCache: %v", err)
+	}
+	return lvc
+}
+
+func TestLastValueCacheKeepsNewest(t *testing.T) {
+	lvc := newTestLVC(t, "", 1<<20)
+
+	// One channel, in order: live broadcasts race each other, stream lookups
+	// race live broadcasts
+	tests := []struct {
+		name       string
+		fromStream bool
+		streamSeq  uint64
+		wantStored bool
+		wantSeq    uint64
+	}{
+		{name: "first live message", streamSeq: 10, wantStored: true, wantSeq: 10},
+		{name: "older live message is stale", streamSeq: 5, wantSeq: 10},
+		{name: "stream lookup of the cached message", fromStream: true, streamSeq: 10, wantSeq: 10},
+		{name: "stream lookup of an older message", fromStream: true, streamSeq: 7, wantSeq: 10},
+		{name: "stream lookup of a newer message", fromStream: true, streamSeq: 12, wantStored: true, wantSeq: 12},
+		{name: "live message with the same seq", streamSeq: 12, wantStored: true, wantSeq: 12},
+		{name: "live message outside JetStream", streamSeq: 0, wantStored: true, wantSeq: 0},
+		{name: "stream lookup never replaces an unsequenced message", fromStream: true, streamSeq: 20, wantSeq: 0},
+		{name: "live message after an unsequenced one", streamSeq: 15, wantStored: true, wantSeq: 15},
+	}
+
+	for _, tt := range tests {
+		data := []byte(tt.name)
+		var stored bool
+		if tt.fromStream {
+			stored = lvc.SetFromStream("BTC.trade", data, 1, tt.streamSeq, time.Now())
+		} else {
+			stored = lvc.store("BTC.trade", data, 1, tt.streamSeq, time.Now(), false)
+		}
+		if stored != tt.wantStored {
+			t.Errorf("%s: stored = %v, want %v", tt.name, stored, tt.wantStored)
+		}
+		if entry := lvc.Get("BTC.trade"); entry == nil || entry.streamSeq != tt.wantSeq {
+			t.Errorf("%s: cached entry = %+v, want stream seq %d", tt.name, entry, tt.wantSeq)
+		}
+	}
+}
+
+func TestLastValueCacheRetention(t *testing.T) {
+	lvc := newTestLVC(t, "trade=30s, balances=0", 1<<20)
+
+	lvc.Set("user-1.balances", []byte("{}"), 1, 1)
+	if lvc.Get("user-1.balances") != nil {
+		t.Error("balances cached although its retention is 0")
+	}
+
+	// Retention counts from when the message was published
+	old := time.Now().Add(-45 * time.Second)
+	if !lvc.SetFromStream("BTC.trade", []byte("{}"), 1, 1, old) {
+		t.Fatal("SetFromStream refused an empty slot")
+	}
+	if lvc.Get("BTC.trade") != nil {
+		t.Error("45s old trade returned with 30s retention")
+	}
+	if !lvc.SetFromStream("BTC.metadata", []byte("{}"), 1, 2, old) {
+		t.Fatal("SetFromStream refused an empty slot")
+	}
+	if lvc.Get("BTC.metadata") == nil {
+		t.Error("45s old metadata dropped with the 1m default retention")
+	}
+}
+
+func TestLastValueCacheEvictsLeastRecentlyUpdated(t *testing.T) {
+	entrySize := (&lvcEntry{channel: "AAA.trade", data: []byte("{}")}).size()
+	lvc := newTestLVC(t, "", 2*entrySize)
+
+	lvc.Set("AAA.trade", []byte("{}"), 1, 1)
+	lvc.Set("BBB.trade", []byte("{}"), 1, 2)
+	lvc.Set("AAA.trade", []byte("{}"), 1, 3) // Now the most recently updated
+	lvc.Set("CCC.trade", []byte("{}"), 1, 4)
+
+	if lvc.Get("BBB.trade") != nil {
+		t.Error("BBB.trade survived although it was updated least recently")
+	}
+	if lvc.Get("AAA.trade") == nil || lvc.Get("CCC.trade") == nil {
+		t.Error("recently updated channels evicted")
+	}
+	if lvc.bytes != 2*entrySize {
+		t.Errorf("bytes = %d, want %d", lvc.bytes, 2*entrySize)
+	}
+
+	if lvc.store("DDD.trade", make([]byte, 3*entrySize), 1, 5, time.Now(), false) {
+		t.Error("stored a message larger than the whole cache")
+	}
+}
+
+func TestLastValueCacheMatch(t *testing.T) {
+	lvc := newTestLVC(t, "", 1<<20)
+	for i, channel := range []string{"BTC.trade", "BTC.metadata", "ETH.trade"} {
+		lvc.Set(channel, []byte("{}"), 1, uint64(i+1))
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "BTC.*", want: []string{"BTC.metadata", "BTC.trade"}},
+		{pattern: "*.trade", want: []string{"BTC.trade", "ETH.trade"}},
+		{pattern: ">", want: []string{"BTC.metadata", "BTC.trade", "ETH.trade"}},
+		{pattern: "SOL.*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			var got []string
+			for _, entry := range lvc.Match(tt.pattern) {
+				got = append(got, entry.channel)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLVCRetention(t *testing.T) {
+	got, err := ParseLVCRetention(" trade=30s,, metadata = 24h ,balances=0")
+	if err != nil {
+		t.Fatalf("ParseLVCRetention: %v", err)
+	}
+	want := map[string]time.Duration{"trade": 30 * time.Second, "metadata": 24 * time.Hour, "balances": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLVCRetention = %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"trade", "=30s", "trade=soon", "trade=-1s"} {
+		if _, err := ParseLVCRetention(spec); err == nil {
+			t.Errorf("ParseLVCRetention(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestSendSnapshotsOncePerChannel(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.LVCEnabled = true })
+	s.lastValues.Set("BTC.trade", []byte(`{"price":1}`), 1700000000000, 41)
+	s.lastValues.Set("BTC.metadata", []byte(`{"name":"Bitcoin"}`), 1700000000000, 42)
+	c := newTestClient(s, 8)
+
+	// BTC.trade is named three times: directly and through two patterns
+	if sent := s.sendSnapshots(c, []string{"BTC.trade", "BTC.*", "*.trade", "ETH.trade"}); sent != 2 {
+		t.Fatalf("sendSnapshots() = %d, want 2", sent)
+	}
+
+	channels := make(map[string]bool)
+	for _, frame := range receiveFrames(t, c, 2, time.Second) {
+		msg := controlMessage(t, frame)
+		if msg["seq"] != 0.0 || msg["snapshot"] != true {
+			t.Errorf("snapshot frame %s: want seq 0 and snapshot true", frame)
+		}
+		channels[msg["channel"].(string)] = true
+	}
+	if !channels["BTC.trade"] || !channels["BTC.metadata"] {
+		t.Errorf("snapshots for %v, want BTC.trade and BTC.metadata", channels)
+	}
+}
@@ -70,6 +70,111 @@ func main() {
 		JSConsumerAckWait: cfg.JSConsumerAckWait,
 		JSStreamName:      cfg.JSStreamName,
 		JSConsumerName:    cfg.JSConsumerName,
+		JSSourcesFile:     cfg.JSSourcesFile,
+
+		// Interest-based consumption
+		JSInterestSubscriptions: cfg.JSInterestSubscriptions,
+		JSInterestDebounce:      cfg.JSInterestDebounce,
+		JSInterestLinger:        cfg.JSInterestLinger,
+
+		// JetStream consumer mode
+		JSConsumerMode:              cfg.JSConsumerMode,
+		JSConsumerStart:             cfg.JSConsumerStart,
+		JSInstanceID:                cfg.JSInstanceID,
+		JSConsumerInactiveThreshold: cfg.JSConsumerInactiveThreshold,
+
+		// Pull consumption
+		JSFetchBatchSize: cfg.JSFetchBatchSize,
+		JSFetchMaxWait:   cfg.JSFetchMaxWait,
+
+		// Redelivery and dead letters
+		JSConsumerMaxDeliver: cfg.JSConsumerMaxDeliver,
+		JSNakBackoffBase:     cfg.JSNakBackoffBase,
+		JSNakBackoffMax:      cfg.JSNakBackoffMax,
+		JSDeadLetterSubject:  cfg.JSDeadLetterSubject,
+		JSDeadLetterStream:   cfg.JSDeadLetterStream,
+
+		// Deep replay from JetStream
+		DeepReplayEnabled:       cfg.DeepReplayEnabled,
+		DeepReplayMaxMessages:   cfg.DeepReplayMaxMessages,
+		DeepReplayTimeout:       cfg.DeepReplayTimeout,
+		DeepReplayMaxConcurrent: cfg.DeepReplayMaxConcurrent,
+
+		// Message routing
+		MessageRoutes:        cfg.MessageRoutes,
+		MessageRouteFallback: cfg.MessageRouteFallback,
+
+		// Last-value cache
+		LVCEnabled:          cfg.LVCEnabled,
+		LVCMaxBytes:         cfg.LVCMaxBytes,
+		LVCDefaultRetention: cfg.LVCDefaultRetention,
+		LVCRetention:        cfg.LVCRetention,
+
+		// Authentication
+		AuthMode:       AuthMode(cfg.AuthMode),
+		JWTHS256Secret: cfg.JWTHS256Secret,
+		JWTJWKSFile:    cfg.JWTJWKSFile,
+		JWTIssuer:      cfg.JWTIssuer,
+		JWTAudience:    cfg.JWTAudience,
+		JWTLeeway:      cfg.JWTLeeway,
+		AuthTimeout:    cfg.AuthTimeout,
+
+		// Connection lifetime
+		MaxConnectionLifetime: cfg.MaxConnectionLifetime,
+		AuthExpiryWarning:     cfg.AuthExpiryWarning,
+
+		// Handshake hardening
+		AllowedOrigins:   cfg.AllowedOrigins,
+		MaxMessageSize:   cfg.MaxMessageSize,
+		HandshakeTimeout: cfg.HandshakeTimeout,
+
+		// Per-IP connection limits
+		MaxConnectionsPerIP:  cfg.MaxConnectionsPerIP,
+		ConnectionRatePerIP:  cfg.ConnectionRatePerIP,
+		ConnectionBurstPerIP: cfg.ConnectionBurstPerIP,
+		AcceptRate:           cfg.AcceptRate,
+		AcceptBurst:          cfg.AcceptBurst,
+		TrustedProxies:       cfg.TrustedProxies,
+		ProxyProtocol:        cfg.ProxyProtocol,
+
+		// Channel authorization
+		AuthzPolicyFile: cfg.AuthzPolicyFile,
+
+		// Subscription quotas and channel validation
+		MaxSubscriptionsPerClient: cfg.MaxSubscriptionsPerClient,
+		MaxChannelsPerRequest:     cfg.MaxChannelsPerRequest,
+		ChannelEventTypes:         cfg.ChannelEventTypes,
+
+		// Admin API
+		AdminAddr:         cfg.AdminAddr,
+		AdminToken:        cfg.AdminToken,
+		AdminTLSCertFile:  cfg.AdminTLSCertFile,
+		AdminTLSKeyFile:   cfg.AdminTLSKeyFile,
+		AdminClientCAFile: cfg.AdminClientCAFile,
+
+		// Ban list
+		BanListFile: cfg.BanListFile,
+
+		// Per-client message rate limits
+		RateLimitBurst:                cfg.RateLimitBurst,
+		RateLimitRate:                 cfg.RateLimitRate,
+		RateLimitCosts:                cfg.RateLimitCosts,
+		RateLimitReplayCostPerMessage: cfg.RateLimitReplayCostPerMessage,
+		RateLimitRoles:                cfg.RateLimitRoles,
+		RateLimitViolationWindow:      cfg.RateLimitViolationWindow,
+		RateLimitMuteAfter:            cfg.RateLimitMuteAfter,
+		RateLimitMuteDuration:         cfg.RateLimitMuteDuration,
+		RateLimitCloseAfter:           cfg.RateLimitCloseAfter,
+
+		// Resumable sessions
+		SessionGracePeriod: cfg.SessionGracePeriod,
+
+		// permessage-deflate
+		CompressionEnabled:               cfg.CompressionEnabled,
+		CompressionLevel:                 cfg.CompressionLevel,
+		CompressionServerContextTakeover: cfg.CompressionServerContextTakeover,
+		CompressionClientContextTakeover: cfg.CompressionClientContextTakeover,
+		CompressionMinSize:               cfg.CompressionMinSize,
 
 		// Monitoring intervals
 		MetricsInterval: cfg.MetricsInterval,
@@ -89,6 +194,20 @@ func main() {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
 
+	// SIGHUP reloads the ban list (kill -HUP <pid>)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			disconnected, err := server.ReloadBanList("sighup")
+			if err != nil {
+				logger.Printf("⚠️  Ban list reload failed: %v", err)
+				continue
+			}
+			logger.Printf("🔄 Ban list reloaded (%d client(s) disconnected)", len(disconnected))
+		}
+	}()
+
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
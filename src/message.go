@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -24,6 +28,20 @@ const (
 	PRIORITY_NORMAL                          // Drop if client slow (prevents head-of-line blocking)
 )
 
+// String returns the config/log name of the priority ("critical", "high", "normal")
+func (p MessagePriority) String() string {
+	switch p {
+	case PRIORITY_CRITICAL:
+		return "critical"
+	case PRIORITY_HIGH:
+		return "high"
+	case PRIORITY_NORMAL:
+		return "normal"
+	default:
+		return "unknown"
+	}
+}
+
 // MessageEnvelope wraps all WebSocket messages with delivery metadata
 // This implements the industry-standard message envelope pattern used by:
 // - Financial markets (FIX protocol)
@@ -59,8 +77,10 @@ type MessageEnvelope struct {
 	Timestamp int64 `json:"ts"`
 
 	// Message type for client-side routing
+	// Resolved per channel by MessageRouter (see message_routing.go)
 	// Examples:
-	//   "price:update"     - Stock/crypto price changed
+	//   "token:trade"      - Trade executed (price, volume)
+	//   "token:social"     - Comment/reaction on a token
 	//   "order:fill"       - User's order executed
 	//   "account:balance"  - Account balance changed
 	//   "system:error"     - Server error notification
@@ -68,7 +88,7 @@ type MessageEnvelope struct {
 	//
 	// Client code pattern:
 	//   switch (message.type) {
-	//     case "price:update": updatePriceDisplay(message.data)
+	//     case "token:trade": updatePriceDisplay(message.data)
 	//     case "order:fill": showNotification(message.data)
 	//   }
 	Type string `json:"type"`
@@ -81,11 +101,43 @@ type MessageEnvelope struct {
 	// - NORMAL: Never block, drop message if client buffer full
 	Priority MessagePriority `json:"priority,omitempty"`
 
+	// true: cached last value sent on subscribe (see LastValueCache), not a live update
+	// Snapshots have seq 0 - they are outside the per-connection sequence
+	Snapshot bool `json:"snapshot,omitempty"`
+
+	// JetStream stream sequence of the source message (ODIN_TOKENS)
+	// Same for every subscriber - unlike Seq, which is per connection
+	// Clients keep the last one they saw to request deep replay from the stream
+	// after a long outage: {"type": "replay", "data": {"stream_seq": 98231}}
+	// Omitted when the message didn't come from JetStream
+	StreamSeq uint64 `json:"stream_seq,omitempty"`
+
+	// Source channel ("BTC.trade") - lets clients track sequences per channel
+	// (pattern subscribers receive many channels on one connection)
+	Channel string `json:"channel,omitempty"`
+
+	// Channel-level sequence: the JetStream stream sequence of this message
+	// Identical on every server instance and across reconnects, so
+	// (channel, channel_seq) is a global dedupe key
+	ChannelSeq uint64 `json:"channel_seq,omitempty"`
+
+	// channel_seq of the previous message on the same channel, as consumed by
+	// THIS server instance (see ChannelSequencer) - omitted (0) when the chain
+	// starts here: first message on the channel this instance has seen
+	// Per-channel gap detection:
+	//   if (prev_channel_seq && prev_channel_seq != last[channel]) { missed messages on channel }
+	PrevChannelSeq uint64 `json:"prev_channel_seq,omitempty"`
+
+	// true: out-of-order redelivery - the previous message on the channel is
+	// unknown (prev_channel_seq omitted). Not a chain start: skip the gap check,
+	// don't move last[channel], and dedupe by (channel, channel_seq)
+	PrevChannelSeqUnknown bool `json:"prev_channel_seq_unknown,omitempty"`
+
 	// Actual message payload (varies by type)
 	// Stored as json.RawMessage to avoid double-encoding
 	// Server doesn't need to parse NATS messages, just wrap and forward
 	//
-	// Example for "price:update":
+	// Example for "token:trade":
 	//   {"tokenId": "BTC", "price": 45000.50, "volume24h": 1234567}
 	//
 	// Example for "order:fill":
@@ -126,14 +178,117 @@ func (s *SequenceGenerator) Next() int64 {
 	return atomic.AddInt64(&s.counter, 1)
 }
 
+// ChannelSequencer links each channel's messages into a chain for per-channel
+// gap detection (see MessageEnvelope.PrevChannelSeq)
+//
+// Channel sequences are JetStream stream sequences, so they are the same on
+// every server instance - but sparse (other channels' messages fall in
+// between). The chain makes gaps visible anyway:
+//
+//	BTC.trade: channel_seq 981, prev 950 → client last saw 950, nothing missed
+//	BTC.trade: channel_seq 1017, prev 990 → client last saw 981, missed 990
+//
+// The chain is PER INSTANCE: prev is the last message on the channel that
+// this instance consumed. Instances only consume channels with local
+// subscribers (see jetstream_interest.go) and start with an empty chain after
+// a restart, so prev for the same channel_seq can differ between instances.
+// Clients must treat the first message on a channel after (re)connecting as
+// the start of a new chain - channel_seq stays the global dedupe key
+//
+// Must be fed in stream order: call it from the NATS callback (serial), not
+// from the worker pool (concurrent)
+//
+// Memory: bounded - channels idle for idleTTL are swept, and at most
+// maxChannels are tracked (per-user channels make the key space unbounded);
+// an untracked channel just starts a new chain on every message
+type ChannelSequencer struct {
+	mu          sync.Mutex
+	last        map[string]channelLink // channel → last stream sequence seen
+	idleTTL     time.Duration
+	maxChannels int
+	lastSweep   time.Time
+}
+
+// channelLink is the newest message this instance consumed on a channel
+type channelLink struct {
+	streamSeq uint64
+	seenAt    time.Time
+}
+
+// Channel chain bounds (see ChannelSequencer)
+const (
+	channelSequencerIdleTTL     = 10 * time.Minute
+	channelSequencerMaxChannels = 100_000
+)
+
+// NewChannelSequencer creates an empty sequencer
+// idleTTL: channels without a message for this long are forgotten (their
+// next message starts a new chain); maxChannels caps the tracked channels
+func NewChannelSequencer(idleTTL time.Duration, maxChannels int) *ChannelSequencer {
+	return &ChannelSequencer{
+		last:        make(map[string]channelLink),
+		idleTTL:     idleTTL,
+		maxChannels: maxChannels,
+	}
+}
+
+// Next records streamSeq as the channel's latest message and returns the
+// previous one (0 = chain starts here: first message on this channel this
+// instance has seen, or the channel was idle past the TTL)
+//
+// known = false for out-of-order redeliveries (streamSeq <= last): the link
+// is unknown, and moving it backwards would break the chain for the next
+// in-order message, so the chain is left untouched
+func (cs *ChannelSequencer) Next(channel string, streamSeq uint64) (prev uint64, known bool) {
+	return cs.next(channel, streamSeq, time.Now())
+}
+
+func (cs *ChannelSequencer) next(channel string, streamSeq uint64, now time.Time) (uint64, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.sweepLocked(now)
+
+	link, exists := cs.last[channel]
+	if exists && now.Sub(link.seenAt) > cs.idleTTL {
+		exists = false // Not swept yet, but already forgotten
+		link = channelLink{}
+	}
+	if exists && streamSeq <= link.streamSeq {
+		return 0, false
+	}
+	if exists || len(cs.last) < cs.maxChannels {
+		cs.last[channel] = channelLink{streamSeq: streamSeq, seenAt: now}
+	}
+	return link.streamSeq, true
+}
+
+// sweepLocked drops idle channels - at most once per idleTTL, or sooner when
+// the map is full
+func (cs *ChannelSequencer) sweepLocked(now time.Time) {
+	if now.Sub(cs.lastSweep) < cs.idleTTL && len(cs.last) < cs.maxChannels {
+		return
+	}
+	if now.Sub(cs.lastSweep) < time.Second {
+		return // Full of active channels - don't rescan on every message
+	}
+	cs.lastSweep = now
+
+	for channel, link := range cs.last {
+		if now.Sub(link.seenAt) > cs.idleTTL {
+			delete(cs.last, channel)
+		}
+	}
+}
+
 // WrapMessage creates an envelope for raw NATS/internal messages
 // This is called in broadcast() before sending to clients
 //
 // Parameters:
 //
 //	data     - Raw message payload from NATS (JSON bytes)
-//	msgType  - Message type for client routing ("price:update", etc.)
-//	priority - Delivery priority (CRITICAL, HIGH, NORMAL)
+//	msgType  - Message type for client routing ("token:trade", etc. - from MessageRouter)
+//	priority - Delivery priority (CRITICAL, HIGH, NORMAL - from MessageRouter)
 //	seqGen   - Per-client sequence generator
 //
 // Returns:
@@ -142,7 +297,8 @@ func (s *SequenceGenerator) Next() int64 {
 //
 // Example usage:
 //
-//	envelope, _ := WrapMessage(natsData, "price:update", PRIORITY_HIGH, client.seqGen)
+//	route := router.Resolve("BTC.trade")
+//	envelope, _ := WrapMessage(natsData, route.Type, route.Priority, client.seqGen)
 //	jsonBytes, _ := envelope.Serialize()
 //	client.send <- jsonBytes
 func WrapMessage(data []byte, msgType string, priority MessagePriority, seqGen *SequenceGenerator) (*MessageEnvelope, error) {
@@ -160,7 +316,7 @@ func WrapMessage(data []byte, msgType string, priority MessagePriority, seqGen *
 //
 // Returns JSON in format:
 //
-//	{"seq":1,"ts":1234567890,"type":"price:update","data":{...}}
+//	{"seq":1,"ts":1234567890,"type":"token:trade","data":{...}}
 //
 // Error handling:
 // - Should never fail in production (envelope fields are always valid JSON)
@@ -168,3 +324,101 @@ func WrapMessage(data []byte, msgType string, priority MessagePriority, seqGen *
 func (m *MessageEnvelope) Serialize() ([]byte, error) {
 	return json.Marshal(m)
 }
+
+// seqFramePrefix is the JSON prefix every serialized envelope starts with
+// Seq is the first field of MessageEnvelope, so json.Marshal always emits it first
+var seqFramePrefix = []byte(`{"seq":`)
+
+// PreparedMessage is a broadcast payload serialized ONCE and shared by every subscriber
+//
+// Problem it solves:
+// - Old fan-out: WrapMessage + Serialize per subscriber, plus another Marshal in ReplayBuffer.Add
+// - 5,000 subscribers = 10,000 json.Marshal calls per NATS message
+//
+// Approach (seq splicing):
+// - Only "seq" differs between subscribers, and it is the FIRST envelope field
+// - Marshal once with seq=0, keep everything after the seq value as an immutable tail
+// - Per subscriber: prefix + encode(seq) + tail (one allocation + memcpy, no reflection)
+//
+// JSON wire format is byte-identical to MessageEnvelope.Serialize():
+//
+//	prefix = `{"seq":`
+//	tail   = `,"ts":1234567890,"type":"token:trade","priority":1,"stream_seq":981,"channel":"BTC.trade",...,"data":{...}}`
+//	Frame(42) = `{"seq":42,"ts":1234567890,"type":"token:trade","priority":1,"stream_seq":981,"channel":"BTC.trade",...,"data":{...}}`
+//
+// Binary codecs (msgpack) use a fixed-width seq instead of decimal digits (fixedSeqLen)
+//
+// Thread-safe: immutable after PrepareMessage returns
+type PreparedMessage struct {
+	EnvelopeHeader        // Shared envelope fields for this broadcast
+	prefix         []byte // Encoded envelope up to the seq value (shared, never mutated)
+	tail           []byte // Encoded envelope after the seq value (shared, never mutated)
+	fixedSeqLen    bool   // true: seq as 8 big-endian bytes (msgpack), false: decimal (JSON)
+}
+
+// EnvelopeHeader is every envelope field except seq and data
+// Identical for all subscribers of one broadcast
+type EnvelopeHeader struct {
+	Timestamp      int64           // Server timestamp (Unix ms) - publish time for deep replay
+	Type           string          // Envelope type
+	Priority       MessagePriority // Delivery priority
+	Snapshot       bool            // Cached last value (snapshot-on-subscribe)
+	StreamSeq      uint64          // JetStream stream sequence (0 = not from JetStream)
+	Channel        string          // Source channel ("BTC.trade")
+	ChannelSeq     uint64          // Channel-level sequence (0 = not from JetStream)
+	PrevChannelSeq uint64          // Previous channel_seq on this channel (0 = chain starts here)
+	PrevUnknown    bool            // Redelivery: previous channel_seq unknown
+}
+
+// PrepareMessage serializes the immutable part of a JSON envelope once per broadcast
+//
+// Returns error if data is not valid JSON (same failure mode as Serialize)
+func PrepareMessage(data []byte, header EnvelopeHeader) (*PreparedMessage, error) {
+	template := MessageEnvelope{
+		Seq:                   0,
+		Timestamp:             header.Timestamp,
+		Type:                  header.Type,
+		Priority:              header.Priority,
+		Snapshot:              header.Snapshot,
+		StreamSeq:             header.StreamSeq,
+		Channel:               header.Channel,
+		ChannelSeq:            header.ChannelSeq,
+		PrevChannelSeq:        header.PrevChannelSeq,
+		PrevChannelSeqUnknown: header.PrevUnknown,
+		Data:                  json.RawMessage(data),
+	}
+
+	encoded, err := template.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip `{"seq":0` - everything after it is identical for all subscribers
+	placeholder := append(append([]byte{}, seqFramePrefix...), '0')
+	if !bytes.HasPrefix(encoded, placeholder) {
+		// Would mean MessageEnvelope field order changed - refuse rather than emit bad frames
+		return nil, fmt.Errorf("unexpected envelope encoding prefix: %.20q", encoded)
+	}
+
+	return &PreparedMessage{
+		EnvelopeHeader: header,
+		prefix:         seqFramePrefix,
+		tail:           encoded[len(placeholder):],
+	}, nil
+}
+
+// Frame returns the complete serialized envelope for one subscriber's sequence number
+// The returned slice is freshly allocated and owned by the caller
+//
+// Performance: one allocation + two copies (~50ns for 500 byte payload)
+// vs json.Marshal (~1-2µs with reflection and RawMessage compaction)
+func (p *PreparedMessage) Frame(seq int64) []byte {
+	frame := make([]byte, 0, len(p.prefix)+20+len(p.tail))
+	frame = append(frame, p.prefix...)
+	if p.fixedSeqLen {
+		frame = appendFixedInt64(frame, seq)
+	} else {
+		frame = strconv.AppendInt(frame, seq, 10)
+	}
+	return append(frame, p.tail...)
+}
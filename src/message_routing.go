@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MessageRoute describes how messages on a channel are presented and delivered
+//
+// Type is the envelope "type" field clients switch on ("token:trade", "token:social")
+// Priority selects the delivery policy in broadcast() (see MessagePriority)
+type MessageRoute struct {
+	Pattern  string          // Channel pattern this route applies to ("*.trade", "BTC.*")
+	Type     string          // Envelope type sent to clients
+	Priority MessagePriority // Delivery priority
+}
+
+// defaultMessageRoutes covers the 8 event types documented in stream_sources.go
+//
+// Priority rationale:
+// - balances:  CRITICAL - user's own funds, must be delivered or client disconnected
+// - trade:     HIGH     - prices drive trading decisions, stale price = bad trade
+// - liquidity: HIGH     - affects execution price
+// - creation:  HIGH     - token launches are time-sensitive (first buyers)
+// - metadata, social, favorites, analytics: NORMAL - nice-to-have, safe to drop for slow clients
+var defaultMessageRoutes = map[string]MessageRoute{
+	"trade":     {Pattern: "*.trade", Type: "token:trade", Priority: PRIORITY_HIGH},
+	"liquidity": {Pattern: "*.liquidity", Type: "token:liquidity", Priority: PRIORITY_HIGH},
+	"metadata":  {Pattern: "*.metadata", Type: "token:metadata", Priority: PRIORITY_NORMAL},
+	"social":    {Pattern: "*.social", Type: "token:social", Priority: PRIORITY_NORMAL},
+	"favorites": {Pattern: "*.favorites", Type: "token:favorites", Priority: PRIORITY_NORMAL},
+	"creation":  {Pattern: "*.creation", Type: "token:creation", Priority: PRIORITY_HIGH},
+	"analytics": {Pattern: "*.analytics", Type: "token:analytics", Priority: PRIORITY_NORMAL},
+	"balances":  {Pattern: "*.balances", Type: "token:balances", Priority: PRIORITY_CRITICAL},
+}
+
+// MessageRouter resolves a channel ("BTC.trade") to its envelope type and priority
+//
+// Resolution order:
+//  1. Configured routes (WS_MESSAGE_ROUTES), first match wins - allows overrides
+//     such as "BTC.trade=token:trade/critical" for a single hot symbol
+//  2. Built-in route for the channel's event type (defaultMessageRoutes)
+//  3. Fallback route (WS_MESSAGE_ROUTE_FALLBACK) for unknown event types
+//
+// Immutable after construction - safe for concurrent use from broadcast workers
+type MessageRouter struct {
+	overrides   []MessageRoute          // Configured routes (scanned in order, usually a handful)
+	eventRoutes map[string]MessageRoute // Event type → built-in route (O(1) hot path)
+	fallback    MessageRoute
+}
+
+// NewMessageRouter builds a router from configuration strings
+//
+// Parameters:
+//
+//	routesSpec   - Comma-separated "pattern=type/priority" entries (may be empty)
+//	fallbackSpec - "type/priority" used when nothing else matches
+//
+// Pattern may be a bare event type ("social" ≡ "*.social") or a channel pattern
+// ("BTC.*", "*.trade"). Priority is one of critical, high, normal (default: high).
+//
+// Example:
+//
+//	NewMessageRouter("balances=account:balance/critical,BTC.*=token:btc/high", "token:update/normal")
+func NewMessageRouter(routesSpec, fallbackSpec string) (*MessageRouter, error) {
+	overrides, err := ParseMessageRoutes(routesSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	fallbackType, fallbackPriority, err := parseRouteTarget(fallbackSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback route %q: %w", fallbackSpec, err)
+	}
+
+	return &MessageRouter{
+		overrides:   overrides,
+		eventRoutes: defaultMessageRoutes,
+		fallback:    MessageRoute{Pattern: fullWildcard, Type: fallbackType, Priority: fallbackPriority},
+	}, nil
+}
+
+// Resolve returns the route for a channel
+// Never fails: unknown event types get the fallback route
+func (r *MessageRouter) Resolve(channel string) MessageRoute {
+	for _, route := range r.overrides {
+		if MatchChannelPattern(route.Pattern, channel) {
+			return route
+		}
+	}
+
+	if idx := strings.LastIndex(channel, tokenSeparator); idx >= 0 {
+		if route, ok := r.eventRoutes[channel[idx+1:]]; ok {
+			return route
+		}
+	}
+
+	return r.fallback
+}
+
+// ParseMessageRoutes parses a WS_MESSAGE_ROUTES specification
+// Format: "pattern=type/priority,pattern=type/priority"
+func ParseMessageRoutes(spec string) ([]MessageRoute, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var routes []MessageRoute
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, target, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid route %q: expected pattern=type/priority", entry)
+		}
+		pattern = strings.TrimSpace(pattern)
+
+		// Bare event type: "social" → "*.social"
+		if !strings.Contains(pattern, tokenSeparator) && !IsChannelPattern(pattern) {
+			pattern = singleTokenWildcard + tokenSeparator + pattern
+		}
+		if err := ValidateChannelPattern(pattern); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+		}
+
+		msgType, priority, err := parseRouteTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+		}
+
+		routes = append(routes, MessageRoute{Pattern: pattern, Type: msgType, Priority: priority})
+	}
+	return routes, nil
+}
+
+// parseRouteTarget parses "type/priority" (priority optional, default high)
+func parseRouteTarget(target string) (string, MessagePriority, error) {
+	msgType, priorityStr, hasPriority := strings.Cut(strings.TrimSpace(target), "/")
+	msgType = strings.TrimSpace(msgType)
+	if msgType == "" {
+		return "", 0, fmt.Errorf("empty message type")
+	}
+
+	priority := PRIORITY_HIGH
+	if hasPriority {
+		var err error
+		priority, err = ParseMessagePriority(priorityStr)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return msgType, priority, nil
+}
+
+// ParseMessagePriority converts a config string to MessagePriority
+func ParseMessagePriority(s string) (MessagePriority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return PRIORITY_CRITICAL, nil
+	case "high":
+		return PRIORITY_HIGH, nil
+	case "normal":
+		return PRIORITY_NORMAL, nil
+	default:
+		return 0, fmt.Errorf("unknown priority %q (expected critical, high or normal)", s)
+	}
+}
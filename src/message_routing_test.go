@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageRouterResolve(t *testing.T) {
+	router, err := NewMessageRouter("BTC.trade=token:btc-trade/critical, social=token:chatter, ETH.*=token:eth/normal", "token:update/normal")
+	if err != nil {
+		t.Fatalf("NewMessageRouter: %v", err)
+	}
+
+	tests := []struct {
+		channel      string
+		wantType     string
+		wantPriority MessagePriority
+	}{
+		{channel: "BTC.trade", wantType: "token:btc-trade", wantPriority: PRIORITY_CRITICAL},
+		{channel: "SOL.trade", wantType: "token:trade", wantPriority: PRIORITY_HIGH},
+		{channel: "SOL.balances", wantType: "token:balances", wantPriority: PRIORITY_CRITICAL},
+		{channel: "SOL.analytics", wantType: "token:analytics", wantPriority: PRIORITY_NORMAL},
+		// Bare event type override, priority defaults to high
+		{channel: "SOL.social", wantType: "token:chatter", wantPriority: PRIORITY_HIGH},
+		// Overrides win over the built-in routes, first match first
+		{channel: "ETH.trade", wantType: "token:eth", wantPriority: PRIORITY_NORMAL},
+		{channel: "ETH.social", wantType: "token:chatter", wantPriority: PRIORITY_HIGH},
+		{channel: "SOL.unknown", wantType: "token:update", wantPriority: PRIORITY_NORMAL},
+		{channel: "nodots", wantType: "token:update", wantPriority: PRIORITY_NORMAL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			route := router.Resolve(tt.channel)
+			if route.Type != tt.wantType || route.Priority != tt.wantPriority {
+				t.Errorf("Resolve(%q) = %s/%v, want %s/%v", tt.channel, route.Type, route.Priority, tt.wantType, tt.wantPriority)
+			}
+		})
+	}
+}
+
+func TestParseMessageRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []MessageRoute
+		wantErr bool
+	}{
+		{name: "empty", spec: "  "},
+		{
+			name: "bare event type and pattern",
+			spec: " trade = token:px / Normal ,, BTC.>=token:btc",
+			want: []MessageRoute{
+				{Pattern: "*.trade", Type: "token:px", Priority: PRIORITY_NORMAL},
+				{Pattern: "BTC.>", Type: "token:btc", Priority: PRIORITY_HIGH},
+			},
+		},
+		{name: "wildcard alone", spec: "*=token:any/critical", want: []MessageRoute{{Pattern: "*", Type: "token:any", Priority: PRIORITY_CRITICAL}}},
+		{name: "missing target", spec: "trade", wantErr: true},
+		{name: "empty type", spec: "trade=/high", wantErr: true},
+		{name: "unknown priority", spec: "trade=token:trade/urgent", wantErr: true},
+		{name: "invalid pattern", spec: "BTC..trade=token:trade", wantErr: true},
+		{name: "misplaced full wildcard", spec: "BTC.>.trade=token:trade", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessageRoutes(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseMessageRoutes(%q) = %+v, want error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMessageRoutes(%q): %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMessageRoutes(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageRouterRejectsBadFallback(t *testing.T) {
+	for _, fallback := range []string{"", "/normal", "token:update/low"} {
+		if _, err := NewMessageRouter("", fallback); err == nil {
+			t.Errorf("NewMessageRouter(fallback %q) succeeded, want error", fallback)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// envelopeFor builds the envelope Frame(seq) must be equivalent to
+func envelopeFor(seq int64, data []byte, header EnvelopeHeader) *MessageEnvelope {
+	return &MessageEnvelope{
+		Seq:                   seq,
+		Timestamp:             header.Timestamp,
+		Type:                  header.Type,
+		Priority:              header.Priority,
+		Snapshot:              header.Snapshot,
+		StreamSeq:             header.StreamSeq,
+		Channel:               header.Channel,
+		ChannelSeq:            header.ChannelSeq,
+		PrevChannelSeq:        header.PrevChannelSeq,
+		PrevChannelSeqUnknown: header.PrevUnknown,
+		Data:                  json.RawMessage(data),
+	}
+}
+
+// decodeMsgpackFrame decodes one msgpack envelope into the values JSON decoding
+// would produce, so it can be compared with a marshalled MessageEnvelope
+func decodeMsgpackFrame(t *testing.T, frame []byte) any {
+	t.Helper()
+
+	r := bytes.NewReader(frame)
+	var v any
+	if err := msgpack.NewDecoder(r).Decode(&v); err != nil {
+		t.Fatalf("decode msgpack frame: %v", err)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("msgpack frame has %d trailing bytes", r.Len())
+	}
+
+	asJSON, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("re-encode msgpack frame as JSON: %v", err)
+	}
+	var out any
+	if err := json.Unmarshal(asJSON, &out); err != nil {
+		t.Fatalf("decode JSON: %v", err)
+	}
+	return out
+}
+
+func TestPreparedMessageFrameMatchesEnvelope(t *testing.T) {
+	headers := []struct {
+		name   string
+		header EnvelopeHeader
+	}{
+		{
+			name:   "minimal",
+			header: EnvelopeHeader{Timestamp: 1700000000000, Type: "token:trade"},
+		},
+		{
+			name: "all fields",
+			header: EnvelopeHeader{
+				Timestamp:      1700000000123,
+				Type:           "token:trade",
+				Priority:       PRIORITY_NORMAL,
+				Snapshot:       true,
+				StreamSeq:      98231,
+				Channel:        "BTC.trade",
+				ChannelSeq:     98231,
+				PrevChannelSeq: 98230,
+			},
+		},
+		{
+			name: "redelivery",
+			header: EnvelopeHeader{
+				Timestamp:   1700000000456,
+				Type:        "token:trade",
+				StreamSeq:   98100,
+				Channel:     "BTC.trade",
+				ChannelSeq:  98100,
+				PrevUnknown: true,
+			},
+		},
+	}
+	data := []byte(`{"tokenId": "BTC", "price": 45000.5, "volume": 1234567, "tags": ["a", "b"], "meta": {"ok": true, "note": null}}`)
+
+	// Digit-count boundaries: the JSON tail must not depend on the seq length
+	seqs := []int64{0, 1, 9, 10, 99, 100, 999, 1000, 1 << 40}
+
+	for _, codec := range []WireCodec{jsonCodec{}, msgpackCodec{}} {
+		for _, h := range headers {
+			prepared, err := codec.PrepareEnvelope(data, h.header)
+			if err != nil {
+				t.Fatalf("%s PrepareEnvelope(%s): %v", codec.Subprotocol(), h.name, err)
+			}
+
+			for _, seq := range seqs {
+				t.Run(fmt.Sprintf("%s/%s/seq=%d", codec.Subprotocol(), h.name, seq), func(t *testing.T) {
+					want, err := envelopeFor(seq, data, h.header).Serialize()
+					if err != nil {
+						t.Fatalf("Serialize: %v", err)
+					}
+					frame := prepared.Frame(seq)
+
+					if _, ok := codec.(jsonCodec); ok {
+						if !bytes.Equal(frame, want) {
+							t.Errorf("Frame(%d) =\n%s\nwant\n%s", seq, frame, want)
+						}
+						return
+					}
+
+					var wantValue any
+					if err := json.Unmarshal(want, &wantValue); err != nil {
+						t.Fatalf("decode envelope: %v", err)
+					}
+					if got := decodeMsgpackFrame(t, frame); !reflect.DeepEqual(got, wantValue) {
+						t.Errorf("Frame(%d) decodes to %v, want %v", seq, got, wantValue)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestChannelSequencerNext(t *testing.T) {
+	cs := NewChannelSequencer(time.Minute, 3)
+	start := time.Unix(1700000000, 0)
+
+	type link struct {
+		prev  uint64
+		known bool
+	}
+	tests := []struct {
+		name      string
+		channel   string
+		streamSeq uint64
+		after     time.Duration // Since start
+		want      link
+	}{
+		{name: "first message starts the chain", channel: "BTC.trade", streamSeq: 950, want: link{0, true}},
+		{name: "next message links back", channel: "BTC.trade", streamSeq: 981, after: time.Second, want: link{950, true}},
+		{name: "other channels have their own chain", channel: "ETH.trade", streamSeq: 982, after: time.Second, want: link{0, true}},
+		{name: "redelivery is unknown", channel: "BTC.trade", streamSeq: 960, after: 2 * time.Second, want: link{0, false}},
+		{name: "duplicate is unknown", channel: "BTC.trade", streamSeq: 981, after: 2 * time.Second, want: link{0, false}},
+		{name: "redelivery leaves the chain untouched", channel: "BTC.trade", streamSeq: 1017, after: 3 * time.Second, want: link{981, true}},
+		{name: "third channel fills the map", channel: "SOL.trade", streamSeq: 1018, after: 3 * time.Second, want: link{0, true}},
+		{name: "past the cap a channel is not tracked", channel: "user-1.balances", streamSeq: 1019, after: 3 * time.Second, want: link{0, true}},
+		{name: "untracked channel keeps starting new chains", channel: "user-1.balances", streamSeq: 1020, after: 3 * time.Second, want: link{0, true}},
+		{name: "idle channel is forgotten", channel: "ETH.trade", streamSeq: 2000, after: 2 * time.Minute, want: link{0, true}},
+		{name: "sweep freed room for new channels", channel: "user-1.balances", streamSeq: 2001, after: 2 * time.Minute, want: link{0, true}},
+		{name: "swept channel chains again", channel: "user-1.balances", streamSeq: 2002, after: 2 * time.Minute, want: link{2001, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, known := cs.next(tt.channel, tt.streamSeq, start.Add(tt.after))
+			if got := (link{prev, known}); got != tt.want {
+				t.Errorf("Next(%q, %d) = %+v, want %+v", tt.channel, tt.streamSeq, got, tt.want)
+			}
+		})
+	}
+
+	// Only the channels touched after the sweep remain
+	if got := len(cs.last); got != 2 {
+		t.Errorf("tracked channels = %d, want 2", got)
+	}
+}
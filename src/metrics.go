@@ -34,6 +34,11 @@ var (
 		Help: "Total number of failed connection attempts",
 	})
 
+	connectionsByProtocol = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_connections_by_protocol_total",
+		Help: "Total number of WebSocket connections by negotiated wire subprotocol",
+	}, []string{"protocol"})
+
 	// Message metrics
 	messagesSent = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ws_messages_sent_total",
@@ -55,6 +60,60 @@ var (
 		Help: "Total number of bytes received from clients",
 	})
 
+	// Resumable session metrics
+	sessionsDetached = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_sessions_detached",
+		Help: "Current number of disconnected sessions waiting to be resumed",
+	})
+
+	sessionsResumed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_sessions_resumed_total",
+		Help: "Total number of sessions resumed after reconnect",
+	})
+
+	sessionsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_sessions_expired_total",
+		Help: "Total number of detached sessions dropped after the grace period",
+	})
+
+	sessionResumeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_session_resume_failures_total",
+		Help: "Total number of failed resume attempts by reason",
+	}, []string{"reason"})
+
+	// permessage-deflate metrics
+	// Ratio = ws_compression_compressed_bytes_total / ws_compression_uncompressed_bytes_total
+	compressionUncompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_compression_uncompressed_bytes_total",
+		Help: "Total payload bytes before deflate (outbound) or after inflate (inbound)",
+	}, []string{"direction"})
+
+	compressionCompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_compression_compressed_bytes_total",
+		Help: "Total compressed payload bytes on the wire",
+	}, []string{"direction"})
+
+	compressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ws_compression_ratio",
+		Help:    "Per-frame compressed/uncompressed size ratio (lower is better)",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"direction"})
+
+	compressionSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_compression_seconds_total",
+		Help: "Total time spent compressing/decompressing frames (CPU cost)",
+	}, []string{"direction"})
+
+	compressionSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_compression_skipped_total",
+		Help: "Total outbound frames sent uncompressed because they were below the minimum size",
+	})
+
+	compressionNegotiated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_compression_negotiated_total",
+		Help: "Total connections that negotiated permessage-deflate",
+	})
+
 	// Reliability metrics
 	slowClientsDisconnected = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ws_slow_clients_disconnected_total",
@@ -66,11 +125,118 @@ var (
 		Help: "Total number of rate limited messages",
 	})
 
+	adminDisconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_admin_disconnects_total",
+		Help: "Total number of connections closed through the admin API",
+	})
+
+	banRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_ban_rejections_total",
+		Help: "Total number of connections rejected by the ban list, by rule (ip, user, user_agent)",
+	}, []string{"rule"})
+
+	banDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_ban_disconnects_total",
+		Help: "Total number of connected clients closed after a ban list reload, by rule",
+	}, []string{"rule"})
+
+	banListReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_ban_list_reloads_total",
+		Help: "Total number of ban list reloads, by result (success, error)",
+	}, []string{"result"})
+
+	rateLimitPenalties = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_rate_limit_penalties_total",
+		Help: "Total number of rate limit penalties applied to clients, by action (warning, mute, close)",
+	}, []string{"action"})
+
+	messagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_messages_dropped_total",
+		Help: "Total number of messages not queued for a client because its send buffer was full, by priority",
+	}, []string{"priority"})
+
 	replayRequests = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ws_replay_requests_total",
 		Help: "Total number of replay requests served",
 	})
 
+	deepReplayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_deep_replay_requests_total",
+		Help: "Total number of replay requests served from JetStream, by result",
+	}, []string{"result"})
+
+	deepReplayMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_deep_replay_messages_total",
+		Help: "Total number of historical messages sent by deep replay",
+	})
+
+	// Last-value cache metrics
+	lvcEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_lvc_entries",
+		Help: "Number of channels held in the last-value cache",
+	})
+
+	lvcBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_lvc_bytes",
+		Help: "Approximate memory used by the last-value cache",
+	})
+
+	lvcEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_lvc_evictions_total",
+		Help: "Total number of last-value cache entries evicted, by reason (memory, expired)",
+	}, []string{"reason"})
+
+	snapshotsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_snapshots_sent_total",
+		Help: "Total number of last-value snapshots sent on subscribe",
+	})
+
+	// Authentication metrics
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_auth_attempts_total",
+		Help: "Total number of authentication attempts, by result (success, invalid, expired, missing, timeout)",
+	}, []string{"result"})
+
+	subscriptionDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_subscription_denials_total",
+		Help: "Total number of channels denied by the channel policy on subscribe, by reason",
+	}, []string{"reason"})
+
+	subscriptionRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_subscription_rejections_total",
+		Help: "Total number of rejected subscribe channels/requests, by reason (invalid_channel, unknown_event_type, subscription_limit, too_many_channels)",
+	}, []string{"reason"})
+
+	reauthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_reauth_attempts_total",
+		Help: "Total number of in-band token refreshes, by result (success, invalid, expired, user_mismatch)",
+	}, []string{"result"})
+
+	connectionsExpired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_connections_expired_total",
+		Help: "Total number of connections closed by the expiry watchdog, by reason (token_expired, max_lifetime)",
+	}, []string{"reason"})
+
+	subscriptionsRevoked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_subscriptions_revoked_total",
+		Help: "Total number of subscriptions dropped after a client's claims changed",
+	})
+
+	protocolViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_protocol_violations_total",
+		Help: "Total number of handshake/message limit violations, by reason (origin_not_allowed, handshake_timeout, message_too_large, invalid_utf8)",
+	}, []string{"reason"})
+
+	connectionLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_connection_limit_rejections_total",
+		Help: "Total number of connections rejected by per-IP or global accept limits, by reason (ip_connections, ip_rate, accept_rate)",
+	}, []string{"reason"})
+
+	trackedIPs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_connection_limiter_tracked_ips",
+		Help: "Number of client IPs tracked by the per-IP connection limiter",
+	})
+
 	droppedBroadcasts = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ws_dropped_broadcasts_total",
 		Help: "Total number of broadcast tasks dropped when worker pool queue full",
@@ -110,9 +276,60 @@ var (
 
 	natsMessagesDropped = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ws_nats_messages_dropped_total",
-		Help: "Total number of NATS messages dropped due to backpressure",
+		Help: "Total number of fetched NATS messages that could not be queued for broadcast (NAK'd for delayed redelivery)",
 	})
 
+	natsUnmapped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_nats_unmapped_messages_total",
+		Help: "Total number of JetStream messages skipped because no subject mapping of their stream source matched, by source",
+	}, []string{"source"})
+
+	natsInterestChannels = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_nats_interest_channels",
+		Help: "Channels and patterns the JetStream consumer filters currently cover (interest mode, including lingering ones)",
+	})
+
+	natsInterestFilterSubjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ws_nats_interest_filter_subjects",
+		Help: "Filter subjects applied to the JetStream consumer of each stream source (interest mode)",
+	}, []string{"source"})
+
+	natsInterestUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_nats_interest_updates_total",
+		Help: "Total number of JetStream consumer filter updates in interest mode, by result (success, error)",
+	}, []string{"result"})
+
+	natsFetchBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ws_nats_fetch_batch_size",
+		Help:    "Number of messages returned per JetStream fetch",
+		Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+	})
+
+	natsFetchThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_nats_fetch_throttled_total",
+		Help: "Total number of JetStream fetch rounds skipped for backpressure, by reason (cpu, rate_limit, worker_queue)",
+	}, []string{"reason"})
+
+	natsNaks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_nats_naks_total",
+		Help: "Total number of JetStream messages NAK'd with backoff for redelivery, by reason",
+	}, []string{"reason"})
+
+	deadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_dead_letters_total",
+		Help: "Total number of messages that exceeded max deliveries, by result (republished, missing, failed)",
+	}, []string{"result"})
+
+	natsConsumptionPaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_nats_consumption_paused",
+		Help: "1 while the CPU emergency brake stops JetStream fetches",
+	})
+
+	consumersRecreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_jetstream_consumers_recreated_total",
+		Help: "Total number of times this instance recreated a consumer that was deleted while in use, by source",
+	}, []string{"source"})
+
 	// Dynamic capacity metrics
 	capacityMaxConnections = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ws_capacity_max_connections",
@@ -147,15 +364,47 @@ func init() {
 	prometheus.MustRegister(connectionsActive)
 	prometheus.MustRegister(connectionsMax)
 	prometheus.MustRegister(connectionsFailed)
+	prometheus.MustRegister(connectionsByProtocol)
 
 	prometheus.MustRegister(messagesSent)
 	prometheus.MustRegister(messagesReceived)
 	prometheus.MustRegister(bytesSent)
 	prometheus.MustRegister(bytesReceived)
+	prometheus.MustRegister(sessionsDetached)
+	prometheus.MustRegister(sessionsResumed)
+	prometheus.MustRegister(sessionsExpired)
+	prometheus.MustRegister(sessionResumeFailures)
+	prometheus.MustRegister(compressionUncompressedBytes)
+	prometheus.MustRegister(compressionCompressedBytes)
+	prometheus.MustRegister(compressionRatio)
+	prometheus.MustRegister(compressionSeconds)
+	prometheus.MustRegister(compressionSkipped)
+	prometheus.MustRegister(compressionNegotiated)
 
 	prometheus.MustRegister(slowClientsDisconnected)
 	prometheus.MustRegister(rateLimitedMessages)
+	prometheus.MustRegister(rateLimitPenalties)
+	prometheus.MustRegister(adminDisconnects)
+	prometheus.MustRegister(banRejections)
+	prometheus.MustRegister(banDisconnects)
+	prometheus.MustRegister(banListReloads)
+	prometheus.MustRegister(messagesDropped)
 	prometheus.MustRegister(replayRequests)
+	prometheus.MustRegister(deepReplayRequests)
+	prometheus.MustRegister(deepReplayMessages)
+	prometheus.MustRegister(lvcEntries)
+	prometheus.MustRegister(lvcBytes)
+	prometheus.MustRegister(lvcEvictions)
+	prometheus.MustRegister(snapshotsSent)
+	prometheus.MustRegister(authAttempts)
+	prometheus.MustRegister(subscriptionDenials)
+	prometheus.MustRegister(subscriptionRejections)
+	prometheus.MustRegister(reauthAttempts)
+	prometheus.MustRegister(connectionsExpired)
+	prometheus.MustRegister(subscriptionsRevoked)
+	prometheus.MustRegister(protocolViolations)
+	prometheus.MustRegister(connectionLimitRejections)
+	prometheus.MustRegister(trackedIPs)
 	prometheus.MustRegister(droppedBroadcasts)
 
 	prometheus.MustRegister(memoryUsageBytes)
@@ -166,6 +415,16 @@ func init() {
 	prometheus.MustRegister(natsConnected)
 	prometheus.MustRegister(natsMessagesReceived)
 	prometheus.MustRegister(natsMessagesDropped)
+	prometheus.MustRegister(natsUnmapped)
+	prometheus.MustRegister(natsInterestChannels)
+	prometheus.MustRegister(natsInterestFilterSubjects)
+	prometheus.MustRegister(natsInterestUpdates)
+	prometheus.MustRegister(natsFetchBatchSize)
+	prometheus.MustRegister(natsFetchThrottled)
+	prometheus.MustRegister(natsConsumptionPaused)
+	prometheus.MustRegister(natsNaks)
+	prometheus.MustRegister(deadLetters)
+	prometheus.MustRegister(consumersRecreated)
 
 	prometheus.MustRegister(capacityMaxConnections)
 	prometheus.MustRegister(capacityCPUThreshold)
@@ -267,6 +526,11 @@ func UpdateConnectionMetrics(server *Server) {
 	connectionsActive.Set(float64(atomic.LoadInt64(&server.stats.CurrentConnections)))
 }
 
+// IncrementConnectionsByProtocol records the wire subprotocol negotiated by a new connection
+func IncrementConnectionsByProtocol(protocol string) {
+	connectionsByProtocol.WithLabelValues(protocol).Inc()
+}
+
 // UpdateMessageMetrics updates message-related metrics
 func UpdateMessageMetrics(sent, received int64) {
 	if sent > 0 {
@@ -287,6 +551,47 @@ func UpdateBytesMetrics(sent, received int64) {
 	}
 }
 
+// UpdateDetachedSessions sets the number of sessions waiting to be resumed
+func UpdateDetachedSessions(count int) {
+	sessionsDetached.Set(float64(count))
+}
+
+// IncrementSessionsResumed counts a successful session resume
+func IncrementSessionsResumed() {
+	sessionsResumed.Inc()
+}
+
+// IncrementSessionsExpired counts a detached session dropped after the grace period
+func IncrementSessionsExpired() {
+	sessionsExpired.Inc()
+}
+
+// IncrementSessionResumeFailures counts a failed resume attempt
+// reason: "not_found", "invalid_token", "codec_mismatch"
+func IncrementSessionResumeFailures(reason string) {
+	sessionResumeFailures.WithLabelValues(reason).Inc()
+}
+
+// RecordCompression records one deflate (outbound) or inflate (inbound) operation
+func RecordCompression(direction string, uncompressed, compressed int, elapsed time.Duration) {
+	compressionUncompressedBytes.WithLabelValues(direction).Add(float64(uncompressed))
+	compressionCompressedBytes.WithLabelValues(direction).Add(float64(compressed))
+	compressionSeconds.WithLabelValues(direction).Add(elapsed.Seconds())
+	if uncompressed > 0 {
+		compressionRatio.WithLabelValues(direction).Observe(float64(compressed) / float64(uncompressed))
+	}
+}
+
+// IncrementCompressionSkipped counts an outbound frame sent uncompressed (below min size)
+func IncrementCompressionSkipped() {
+	compressionSkipped.Inc()
+}
+
+// IncrementCompressionNegotiated counts a connection that negotiated permessage-deflate
+func IncrementCompressionNegotiated() {
+	compressionNegotiated.Inc()
+}
+
 // IncrementSlowClientDisconnects increments slow client disconnect counter
 func IncrementSlowClientDisconnects() {
 	slowClientsDisconnected.Inc()
@@ -297,11 +602,109 @@ func IncrementRateLimitedMessages() {
 	rateLimitedMessages.Inc()
 }
 
+// IncrementAdminDisconnects counts connections closed through the admin API
+func IncrementAdminDisconnects(count int) {
+	adminDisconnects.Add(float64(count))
+}
+
+// IncrementBanRejections counts a connection refused by the ban list
+func IncrementBanRejections(rule string) {
+	banRejections.WithLabelValues(rule).Inc()
+}
+
+// IncrementBanDisconnects counts a connected client closed by a ban list reload
+func IncrementBanDisconnects(rule string) {
+	banDisconnects.WithLabelValues(rule).Inc()
+}
+
+// IncrementBanListReloads records a ban list reload attempt
+func IncrementBanListReloads(result string) {
+	banListReloads.WithLabelValues(result).Inc()
+}
+
+// IncrementRateLimitPenalties records an escalation step for a rate limited client
+func IncrementRateLimitPenalties(action string) {
+	rateLimitPenalties.WithLabelValues(action).Inc()
+}
+
+// IncrementDroppedMessages records a message dropped for a slow client
+func IncrementDroppedMessages(priority MessagePriority) {
+	messagesDropped.WithLabelValues(priority.String()).Inc()
+}
+
 // IncrementReplayRequests increments replay request counter
 func IncrementReplayRequests() {
 	replayRequests.Inc()
 }
 
+// RecordDeepReplay records a finished deep replay
+// result: "complete", "truncated", "aged_out", "busy", "failed"
+func RecordDeepReplay(result string, messages int) {
+	deepReplayRequests.WithLabelValues(result).Inc()
+	deepReplayMessages.Add(float64(messages))
+}
+
+// UpdateLVCSize records the last-value cache size
+func UpdateLVCSize(entries int, bytes int64) {
+	lvcEntries.Set(float64(entries))
+	lvcBytes.Set(float64(bytes))
+}
+
+// IncrementLVCEvictions records a last-value cache eviction
+func IncrementLVCEvictions(reason string) {
+	lvcEvictions.WithLabelValues(reason).Inc()
+}
+
+// IncrementSnapshotsSent records snapshots queued after a subscribe
+func IncrementSnapshotsSent(count int) {
+	snapshotsSent.Add(float64(count))
+}
+
+// IncrementAuthAttempts records an authentication attempt
+func IncrementAuthAttempts(result string) {
+	authAttempts.WithLabelValues(result).Inc()
+}
+
+// IncrementSubscriptionDenials increments the channel policy denial counter
+func IncrementSubscriptionDenials(reason string) {
+	subscriptionDenials.WithLabelValues(reason).Inc()
+}
+
+// IncrementSubscriptionRejections increments the subscribe validation/quota rejection counter
+func IncrementSubscriptionRejections(reason string) {
+	subscriptionRejections.WithLabelValues(reason).Inc()
+}
+
+// IncrementReauthAttempts records an in-band token refresh
+func IncrementReauthAttempts(result string) {
+	reauthAttempts.WithLabelValues(result).Inc()
+}
+
+// IncrementConnectionsExpired records a watchdog close
+func IncrementConnectionsExpired(reason string) {
+	connectionsExpired.WithLabelValues(reason).Inc()
+}
+
+// IncrementSubscriptionsRevoked adds subscriptions dropped by re-authorization
+func IncrementSubscriptionsRevoked(count int) {
+	subscriptionsRevoked.Add(float64(count))
+}
+
+// IncrementProtocolViolations records a handshake or message limit violation
+func IncrementProtocolViolations(reason string) {
+	protocolViolations.WithLabelValues(reason).Inc()
+}
+
+// IncrementConnectionLimitRejections records a per-IP/global accept rejection
+func IncrementConnectionLimitRejections(reason string) {
+	connectionLimitRejections.WithLabelValues(reason).Inc()
+}
+
+// UpdateTrackedIPs sets the number of IPs held by the connection limiter
+func UpdateTrackedIPs(count int) {
+	trackedIPs.Set(float64(count))
+}
+
 // IncrementNATSMessages increments NATS message counter
 func IncrementNATSMessages() {
 	natsMessagesReceived.Inc()
@@ -312,6 +715,60 @@ func IncrementNATSDropped() {
 	natsMessagesDropped.Inc()
 }
 
+// IncrementNATSUnmapped counts a message no subject mapping matched
+func IncrementNATSUnmapped(source string) {
+	natsUnmapped.WithLabelValues(source).Inc()
+}
+
+// SetNATSInterest records the channels the consumer filters cover
+func SetNATSInterest(channels int) {
+	natsInterestChannels.Set(float64(channels))
+}
+
+// SetNATSInterestFilterSubjects records a source's applied filter size
+func SetNATSInterestFilterSubjects(source string, subjects int) {
+	natsInterestFilterSubjects.WithLabelValues(source).Set(float64(subjects))
+}
+
+// IncrementNATSInterestUpdates counts a consumer filter update
+func IncrementNATSInterestUpdates(result string) {
+	natsInterestUpdates.WithLabelValues(result).Inc()
+}
+
+// ObserveNATSFetchBatch records the size of one JetStream fetch
+func ObserveNATSFetchBatch(size int) {
+	natsFetchBatchSize.Observe(float64(size))
+}
+
+// IncrementNATSFetchThrottled counts a fetch round skipped for backpressure
+func IncrementNATSFetchThrottled(reason string) {
+	natsFetchThrottled.WithLabelValues(reason).Inc()
+}
+
+// IncrementNATSNaks counts a NAK with backoff
+func IncrementNATSNaks(reason string) {
+	natsNaks.WithLabelValues(reason).Inc()
+}
+
+// IncrementDeadLetters records a max-deliveries advisory outcome
+func IncrementDeadLetters(result string) {
+	deadLetters.WithLabelValues(result).Inc()
+}
+
+// SetNATSConsumptionPaused records whether the CPU brake is engaged
+func SetNATSConsumptionPaused(paused bool) {
+	if paused {
+		natsConsumptionPaused.Set(1)
+	} else {
+		natsConsumptionPaused.Set(0)
+	}
+}
+
+// IncrementConsumersRecreated counts a source's consumer recreated after it was deleted
+func IncrementConsumersRecreated(source string) {
+	consumersRecreated.WithLabelValues(source).Inc()
+}
+
 // UpdateCapacityMetrics updates dynamic capacity metrics
 func UpdateCapacityMetrics(maxConnections int, cpuThreshold float64) {
 	capacityMaxConnections.Set(float64(maxConnections))
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gobwas/ws"
 )
 
 // TokenBucket implements rate limiting using the token bucket algorithm
@@ -193,14 +201,62 @@ func (tb *TokenBucket) TryConsume(tokens float64) bool {
 	return false // Rate limited
 }
 
+// TimeUntil returns how long until the bucket holds N tokens (0 if it already does)
+// Used for Retry-After on rejected requests
+func (tb *TokenBucket) TimeUntil(tokens float64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	current := tb.tokens + time.Since(tb.lastRefill).Seconds()*tb.refillRate
+	if current >= tokens || tb.refillRate <= 0 {
+		return 0
+	}
+	return time.Duration((tokens - current) / tb.refillRate * float64(time.Second))
+}
+
+// RateLimit is a token bucket configuration (burst capacity, sustained rate)
+type RateLimit struct {
+	Burst float64 // Tokens the bucket holds
+	Rate  float64 // Tokens added per second
+}
+
+// RateLimitConfig configures the per-client message rate limiter
+type RateLimitConfig struct {
+	Burst                float64       // Default bucket capacity (default: 100)
+	Rate                 float64       // Default tokens per second (default: 10)
+	Costs                string        // Per message type cost: "type=cost,..." (unlisted types: 1)
+	ReplayCostPerMessage float64       // Extra replay cost per requested message (default: 0.1)
+	RoleLimits           string        // Per role bucket: "role=burst/rate,..." (from the JWT "roles" claim)
+	ViolationWindow      time.Duration // Window violations are counted in (default: 60s)
+	MuteAfter            int           // Violations in the window before the client is muted (0 = never)
+	MuteDuration         time.Duration // How long a muted client's messages are dropped (default: 10s)
+	CloseAfter           int           // Violations in the window before close 1008 (0 = never)
+}
+
+// Rate limit outcomes (Check result, ws_rate_limit_penalties_total "action" label)
+//
+// Escalation for one offender within the violation window - the user, or the
+// IP for anonymous clients, so reconnecting doesn't start over (see offenderKey):
+//
+//	violations < MuteAfter  → warning (message dropped, RATE_LIMIT_EXCEEDED error)
+//	violations ≥ MuteAfter  → mute (every message dropped for MuteDuration)
+//	violations ≥ CloseAfter → close (1008 Policy Violation, not resumable)
+const (
+	rateActionAllow = ""
+	rateActionWarn  = "warning"
+	rateActionMute  = "mute"
+	rateActionMuted = "muted" // Dropped silently while a mute is active (not a new violation)
+	rateActionClose = "close"
+)
+
 // RateLimiter manages per-client rate limits
 // Each client gets their own token bucket
-// Memory usage: ~100 bytes per client × 10,000 clients = 1MB (negligible)
+// Memory usage: ~200 bytes per client × 10,000 clients = 2MB (negligible)
 //
 // Design pattern: sync.Map for concurrent access
 // Why sync.Map vs map[int64]*TokenBucket with mutex:
 //   - Optimized for case where entries change infrequently (client connects/disconnects)
-//   - Read-heavy workload (many CheckLimit calls, few LoadOrStore)
+//   - Read-heavy workload (many Check calls, few LoadOrStore)
 //   - Better performance under high concurrency
 //
 // Alternative considered: Single global bucket
@@ -208,57 +264,446 @@ func (tb *TokenBucket) TryConsume(tokens float64) bool {
 //	Pros: Simple, low memory
 //	Cons: One abusive client affects all users (unfair)
 //	Decision: Per-client is industry standard
+//
+// Messages are weighted by what they cost the server, not counted:
+//
+//	heartbeat          0.2 tokens (nothing to do)
+//	subscribe          2 tokens   (index updates + snapshots)
+//	replay from..to    2 + 0.1 × messages requested (a 100-message replay = 12 tokens)
+//
+// Clients with a role listed in WS_RATE_LIMIT_ROLES (e.g. market makers) get
+// that role's bucket instead of the default; with several, the most generous.
 type RateLimiter struct {
-	// Map of clientID → TokenBucket
-	// Automatically cleans up on disconnect (RemoveClient called from readPump defer)
-	clients sync.Map // map[int64]*TokenBucket
+	defaultLimit         RateLimit
+	roleLimits           map[string]RateLimit
+	costs                map[string]float64
+	replayCostPerMessage float64
+
+	violationWindow time.Duration
+	muteAfter       int
+	muteDuration    time.Duration
+	closeAfter      int
+
+	// Map of clientID → clientRateState
+	// Automatically cleans up on disconnect (RemoveClient called from releaseClient)
+	clients sync.Map // map[int64]*clientRateState
+
+	// Map of offenderKey → offenderState, independent of connections
+	// Only offenders have an entry; idle ones are swept (see sweepOffenders)
+	offenders sync.Map // map[string]*offenderState
+	lastSweep int64    // Unix nanos of the last sweep (atomic)
+}
+
+// clientRateState is one connection's token bucket
+type clientRateState struct {
+	mu     sync.Mutex
+	bucket *TokenBucket
+	limit  RateLimit // Limit the bucket was created with (changes on reauth with new roles)
+}
+
+// offenderState is the violation history of one user or IP (see offenderKey)
+type offenderState struct {
+	mu         sync.Mutex
+	violations []time.Time // Violations within the window, oldest first
+	mutedUntil time.Time
+	removed    bool // Swept - callers holding it must load a fresh one
+}
+
+// offenderKey identifies whose violations these are: the user for
+// authenticated clients, the client IP for anonymous ones
+// Not the connection - a reconnect must not reset the escalation
+func offenderKey(identity *ClientIdentity, clientIP string) string {
+	if identity != nil {
+		return "user:" + identity.UserID
+	}
+	return "ip:" + clientIP
 }
 
 // NewRateLimiter creates a rate limiter for managing client limits
-func NewRateLimiter() *RateLimiter {
-	return &RateLimiter{}
+func NewRateLimiter(config RateLimitConfig) (*RateLimiter, error) {
+	costs, err := ParseMessageCosts(config.Costs)
+	if err != nil {
+		return nil, err
+	}
+	roleLimits, err := ParseRoleRateLimits(config.RoleLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimiter{
+		defaultLimit:         RateLimit{Burst: config.Burst, Rate: config.Rate},
+		roleLimits:           roleLimits,
+		costs:                costs,
+		replayCostPerMessage: config.ReplayCostPerMessage,
+		violationWindow:      config.ViolationWindow,
+		muteAfter:            config.MuteAfter,
+		muteDuration:         config.MuteDuration,
+		closeAfter:           config.CloseAfter,
+	}, nil
+}
+
+// ParseMessageCosts parses a WS_RATE_LIMIT_COSTS specification
+// Format: "type=cost,type=cost" (cost ≥ 0, 0 = free)
+func ParseMessageCosts(spec string) (map[string]float64, error) {
+	costs := make(map[string]float64)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		msgType, costStr, found := strings.Cut(entry, "=")
+		msgType = strings.TrimSpace(msgType)
+		if !found || msgType == "" {
+			return nil, fmt.Errorf("invalid message cost %q: expected type=cost", entry)
+		}
+
+		cost, err := parseFinite(costStr)
+		if err != nil || cost < 0 {
+			return nil, fmt.Errorf("invalid message cost %q: bad cost", entry)
+		}
+		costs[msgType] = cost
+	}
+	return costs, nil
 }
 
-// CheckLimit verifies if client can perform action
-// Returns true if allowed, false if rate limited
-//
-// For trading platform, we enforce different limits per action type:
-// - WebSocket messages (incoming): 100 burst, 10/sec sustained
-// - Order submissions: 20 burst, 5/sec sustained
-// - Market data subscriptions: 100 burst, 5/sec sustained
-// - Account queries: 50 burst, 10/sec sustained
-//
-// Current implementation: Single limit for all actions (simplicity)
-// Production evolution: Multiple buckets per client (by action type)
-//
-// Rate limit values chosen based on:
-//
-//	100 burst: Active trader during volatility enters ~50 orders/minute
-//	           100 burst handles 2 minutes of rapid entry
-//	10/sec sustained: Professional trader averages ~600 orders/hour
-//	                  = 10/min sustained average
-//	                  Peak: 10/sec for short bursts
-//
-// Comparison to industry:
-//   - Coinbase: 10 orders/second
-//   - Binance: 10 orders/second per symbol, 100/sec total
-//   - Interactive Brokers: 50 orders/second
-//   - Our limit: Competitive for retail, may need increase for institutional
-func (rl *RateLimiter) CheckLimit(clientID int64) bool {
-	// Get existing bucket or create new one
-	// LoadOrStore is atomic: Only one goroutine creates bucket for new client
-	bucket, _ := rl.clients.LoadOrStore(clientID, NewTokenBucket(100, 10))
-
-	return bucket.(*TokenBucket).TryConsume(1)
+// parseFinite parses a number, rejecting NaN and ±Inf (strconv accepts them)
+func parseFinite(value string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", value)
+	}
+	return f, nil
 }
 
-// RemoveClient cleans up rate limit state on disconnect
+// ParseRoleRateLimits parses a WS_RATE_LIMIT_ROLES specification
+// Format: "role=burst/rate,role=burst/rate" (both > 0)
+func ParseRoleRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, limitStr, found := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		burstStr, rateStr, hasRate := strings.Cut(limitStr, "/")
+		if !found || role == "" || !hasRate {
+			return nil, fmt.Errorf("invalid role rate limit %q: expected role=burst/rate", entry)
+		}
+
+		burst, burstErr := parseFinite(burstStr)
+		rate, rateErr := parseFinite(rateStr)
+		if burstErr != nil || rateErr != nil || burst <= 0 || rate <= 0 {
+			return nil, fmt.Errorf("invalid role rate limit %q: burst and rate must be > 0", entry)
+		}
+		limits[role] = RateLimit{Burst: burst, Rate: rate}
+	}
+	return limits, nil
+}
+
+// LimitFor returns the bucket configuration for a client identity
+// Anonymous clients and clients without a listed role get the default
+func (rl *RateLimiter) LimitFor(identity *ClientIdentity) RateLimit {
+	limit := rl.defaultLimit
+	found := false
+
+	if identity != nil {
+		for _, role := range identity.Roles {
+			roleLimit, ok := rl.roleLimits[role]
+			if !ok {
+				continue
+			}
+			// Role overrides replace the default (they may be stricter) -
+			// among several matching roles the most generous wins
+			if !found || roleLimit.Rate > limit.Rate ||
+				(roleLimit.Rate == limit.Rate && roleLimit.Burst > limit.Burst) {
+				limit = roleLimit
+				found = true
+			}
+		}
+	}
+	return limit
+}
+
+// Cost returns the base cost of a message type (1 for unlisted types)
+func (rl *RateLimiter) Cost(msgType string) float64 {
+	if cost, ok := rl.costs[msgType]; ok {
+		return cost
+	}
+	return 1
+}
+
+// Check charges a message of the given cost to a client
+// Returns rateActionAllow if the message may be processed; any other action
+// means it must be dropped (and, for rateActionClose, the client disconnected)
+//
+// The bucket is per connection; violations and mutes are per offender (user,
+// or IP when anonymous) and outlive the connection for the violation window
+//
+// Called from readPump only, but state is locked: Violations reads it from
+// other goroutines (admin API, metrics)
+func (rl *RateLimiter) Check(clientID int64, identity *ClientIdentity, clientIP string, cost float64) string {
+	value, _ := rl.clients.LoadOrStore(clientID, &clientRateState{})
+	state := value.(*clientRateState)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	// New client, or reauth changed the roles → bucket for the current limit
+	limit := rl.LimitFor(identity)
+	if state.bucket == nil || state.limit != limit {
+		state.bucket = NewTokenBucket(limit.Burst, limit.Rate)
+		state.limit = limit
+	}
+
+	now := time.Now()
+	key := offenderKey(identity, clientIP)
+	if rl.muted(key, now) {
+		return rateActionMuted
+	}
+
+	// A message costing more than the whole bucket drains it instead of
+	// being rejected forever (e.g. a maximum-range replay with a small bucket)
+	if state.bucket.TryConsume(min(cost, limit.Burst)) {
+		return rateActionAllow
+	}
+
+	rl.sweepOffenders(now)
+	return rl.recordViolation(key, now)
+}
+
+// muted reports whether an offender's mute is active
+func (rl *RateLimiter) muted(key string, now time.Time) bool {
+	value, ok := rl.offenders.Load(key)
+	if !ok {
+		return false
+	}
+	offender := value.(*offenderState)
+
+	offender.mu.Lock()
+	defer offender.mu.Unlock()
+	return now.Before(offender.mutedUntil)
+}
+
+// recordViolation counts a violation within the sliding window and returns
+// the resulting action
+func (rl *RateLimiter) recordViolation(key string, now time.Time) string {
+	for {
+		value, _ := rl.offenders.LoadOrStore(key, &offenderState{})
+		offender := value.(*offenderState)
+
+		offender.mu.Lock()
+		if offender.removed {
+			offender.mu.Unlock()
+			continue // Swept concurrently - the next LoadOrStore creates a fresh one
+		}
+
+		offender.violations = append(offender.pruneLocked(now.Add(-rl.violationWindow)), now)
+		violations := len(offender.violations)
+
+		action := rateActionWarn
+		switch {
+		case rl.closeAfter > 0 && violations >= rl.closeAfter:
+			action = rateActionClose
+		case rl.muteAfter > 0 && violations >= rl.muteAfter:
+			offender.mutedUntil = now.Add(rl.muteDuration)
+			action = rateActionMute
+		}
+		offender.mu.Unlock()
+		return action
+	}
+}
+
+// pruneLocked drops violations older than cutoff (caller holds mu)
+func (o *offenderState) pruneLocked(cutoff time.Time) []time.Time {
+	kept := o.violations[:0]
+	for _, at := range o.violations {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+// sweepOffenders drops offenders with no violation in the window and no
+// active mute - at most once per violation window, on the violation path
+func (rl *RateLimiter) sweepOffenders(now time.Time) {
+	last := atomic.LoadInt64(&rl.lastSweep)
+	if now.UnixNano()-last < int64(rl.violationWindow) || !atomic.CompareAndSwapInt64(&rl.lastSweep, last, now.UnixNano()) {
+		return
+	}
+
+	cutoff := now.Add(-rl.violationWindow)
+	rl.offenders.Range(func(key, value any) bool {
+		offender := value.(*offenderState)
+
+		offender.mu.Lock()
+		offender.violations = offender.pruneLocked(cutoff)
+		if len(offender.violations) == 0 && !now.Before(offender.mutedUntil) {
+			offender.removed = true
+			rl.offenders.Delete(key)
+		}
+		offender.mu.Unlock()
+		return true
+	})
+}
+
+// Violations returns how many rate limit violations a user (or anonymous IP)
+// has within the window
+func (rl *RateLimiter) Violations(identity *ClientIdentity, clientIP string) int {
+	value, ok := rl.offenders.Load(offenderKey(identity, clientIP))
+	if !ok {
+		return 0
+	}
+	offender := value.(*offenderState)
+
+	offender.mu.Lock()
+	defer offender.mu.Unlock()
+
+	cutoff := time.Now().Add(-rl.violationWindow)
+	count := 0
+	for _, at := range offender.violations {
+		if at.After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// RemoveClient cleans up a connection's bucket on disconnect
+// (violation history is per offender and expires on its own)
 // Important for memory management:
 //
 //	Without cleanup: 10,000 clients connect/disconnect per day = 10,000 buckets leak
 //	With cleanup: Memory usage stays constant
 //
-// Called from readPump() defer (guaranteed to run on disconnect)
+// Called from releaseClient (guaranteed to run when a client is released)
 func (rl *RateLimiter) RemoveClient(clientID int64) {
 	rl.clients.Delete(clientID)
 }
+
+// messageCost returns what a client message costs in rate limit tokens
+// Replays are weighted by how many messages they may send back
+func (s *Server) messageCost(c *Client, msgType string, data json.RawMessage) float64 {
+	cost := s.rateLimiter.Cost(msgType)
+	if msgType != "replay" || s.rateLimiter.replayCostPerMessage == 0 {
+		return cost
+	}
+
+	var replayReq struct {
+		From      int64  `json:"from"`
+		To        int64  `json:"to"`
+		Since     int64  `json:"since"`
+		StreamSeq uint64 `json:"stream_seq"`
+		StartTime int64  `json:"start_time"`
+	}
+	_ = json.Unmarshal(data, &replayReq) // Malformed → base cost (the handler rejects it)
+
+	// Upper bound of what the request can send: the buffer, plus the stream
+	// when deep replay is on
+	maxMessages := int64(replayBufferSize)
+	if s.deepReplayAvailable() {
+		maxMessages += int64(s.config.DeepReplayMaxMessages)
+	}
+
+	var messages int64
+	switch {
+	case replayReq.StreamSeq > 0 || replayReq.StartTime > 0:
+		messages = int64(s.config.DeepReplayMaxMessages)
+	case replayReq.Since > 0:
+		messages = atomic.LoadInt64(&c.seqGen.counter) - replayReq.Since
+	default:
+		messages = replayReq.To - replayReq.From + 1
+	}
+	messages = max(0, min(messages, maxMessages))
+
+	return cost + float64(messages)*s.rateLimiter.replayCostPerMessage
+}
+
+// checkRateLimit charges an incoming message to the client and applies penalties
+// Returns false if the message must be dropped
+//
+// Rate limiting prevents a client from flooding the server
+// Important for:
+// 1. DoS prevention (malicious client can't overwhelm server)
+// 2. Bug protection (client code bug causing infinite loop)
+// 3. Fair resource sharing (one client can't starve others)
+//
+// A single violation only drops the message (might be a temporary spike, give
+// them a chance); repeat offenders are muted, then disconnected.
+func (s *Server) checkRateLimit(c *Client, msgType string, data json.RawMessage) bool {
+	action := s.rateLimiter.Check(c.id, c.identity, c.clientIP, s.messageCost(c, msgType, data))
+	if action == rateActionAllow {
+		return true
+	}
+
+	// Increment rate limit counter for monitoring
+	atomic.AddInt64(&s.stats.RateLimitedMessages, 1)
+	IncrementRateLimitedMessages()
+	if action == rateActionMuted {
+		return false // Already told when the mute started
+	}
+	IncrementRateLimitPenalties(action)
+
+	limit := s.rateLimiter.LimitFor(c.identity)
+	violations := s.rateLimiter.Violations(c.identity, c.clientIP)
+	userID := sessionUserID(c.identity)
+
+	switch action {
+	case rateActionWarn:
+		s.logger.Printf("⚠️  Client %d rate limited (%.0f burst, %.0f/sec sustained)", c.id, limit.Burst, limit.Rate)
+
+		// Audit log rate limiting event
+		s.auditLogger.Warning("ClientRateLimited", "Client exceeded rate limit", map[string]any{
+			"clientID":    c.id,
+			"userID":      userID,
+			"messageType": msgType,
+			"limit":       fmt.Sprintf("%.0f burst, %.0f/sec sustained", limit.Burst, limit.Rate),
+			"violations":  violations,
+		})
+
+		// Send error message to client
+		// This helps client-side debugging (they know why messages are being dropped)
+		// Best effort send (don't block if client slow)
+		s.sendControlMessage(c, map[string]any{
+			"type":       "error",
+			"code":       "RATE_LIMIT_EXCEEDED",
+			"message":    fmt.Sprintf("Too many messages, please slow down (limit: %.0f/sec)", limit.Rate),
+			"violations": violations,
+		})
+
+	case rateActionMute:
+		s.logger.Printf("🔇 Client %d muted for %s (%d rate limit violations)", c.id, s.config.RateLimitMuteDuration, violations)
+		s.auditLogger.Warning("ClientMuted", "Client muted after repeated rate limit violations", map[string]any{
+			"clientID":   c.id,
+			"userID":     userID,
+			"violations": violations,
+			"duration":   s.config.RateLimitMuteDuration.String(),
+		})
+		s.sendControlMessage(c, map[string]any{
+			"type":        "error",
+			"code":        "RATE_LIMIT_MUTED",
+			"message":     "Too many rate limit violations, messages are ignored until muted_until",
+			"muted_until": time.Now().Add(s.config.RateLimitMuteDuration).UnixMilli(),
+			"violations":  violations,
+		})
+
+	case rateActionClose:
+		s.logger.Printf("🚫 Client %d disconnected (%d rate limit violations)", c.id, violations)
+		s.auditLogger.Warning("ClientRateLimitDisconnected", "Client disconnected for persistent rate limit violations", map[string]any{
+			"clientID":   c.id,
+			"userID":     userID,
+			"remoteAddr": c.clientIP,
+			"violations": violations,
+		})
+		s.closeClient(c, ws.StatusPolicyViolation, "Rate limit exceeded")
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMessageCosts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{spec: "", want: map[string]float64{}},
+		{spec: "heartbeat=0.2,subscribe=2,replay=2", want: map[string]float64{"heartbeat": 0.2, "subscribe": 2, "replay": 2}},
+		{spec: " heartbeat = 0 , ,subscribe=1e1 ", want: map[string]float64{"heartbeat": 0, "subscribe": 10}},
+		{spec: "subscribe=1,subscribe=3", want: map[string]float64{"subscribe": 3}},
+		{spec: "heartbeat", wantErr: true},
+		{spec: "=2", wantErr: true},
+		{spec: "heartbeat=", wantErr: true},
+		{spec: "heartbeat=cheap", wantErr: true},
+		{spec: "heartbeat=-1", wantErr: true},
+		{spec: "heartbeat=NaN", wantErr: true},
+		{spec: "heartbeat=Inf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseMessageCosts(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseMessageCosts(%q) = %v, want error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMessageCosts(%q): %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMessageCosts(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoleRateLimits(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]RateLimit
+		wantErr bool
+	}{
+		{spec: "", want: map[string]RateLimit{}},
+		{
+			spec: "market_maker=1000/100,internal=500/50",
+			want: map[string]RateLimit{"market_maker": {Burst: 1000, Rate: 100}, "internal": {Burst: 500, Rate: 50}},
+		},
+		{spec: " vip = 20 / 2.5 ,", want: map[string]RateLimit{"vip": {Burst: 20, Rate: 2.5}}},
+		{spec: "vip=20", wantErr: true},
+		{spec: "vip", wantErr: true},
+		{spec: "=20/2", wantErr: true},
+		{spec: "vip=0/2", wantErr: true},
+		{spec: "vip=20/0", wantErr: true},
+		{spec: "vip=-1/2", wantErr: true},
+		{spec: "vip=20/fast", wantErr: true},
+		{spec: "vip=20/2/1", wantErr: true},
+		{spec: "vip=NaN/2", wantErr: true},
+		{spec: "vip=20/Inf", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseRoleRateLimits(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseRoleRateLimits(%q) = %v, want error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRoleRateLimits(%q): %v", tt.spec, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRoleRateLimits(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterEscalationSurvivesReconnect(t *testing.T) {
+	rl, err := NewRateLimiter(RateLimitConfig{
+		Burst:           1,
+		Rate:            0.001, // No refill within the test
+		ViolationWindow: time.Minute,
+		MuteAfter:       0,
+		CloseAfter:      3,
+	})
+	if err != nil {
+		t.Fatalf("NewRateLimiter: %v", err)
+	}
+
+	user := &ClientIdentity{UserID: "user-1"}
+	tests := []struct {
+		name     string
+		clientID int64
+		identity *ClientIdentity
+		clientIP string
+		want     []string // Actions of consecutive messages on a fresh connection
+	}{
+		{name: "first connection", clientID: 1, identity: user, clientIP: "203.0.113.1", want: []string{rateActionAllow, rateActionWarn}},
+		{name: "reconnect keeps violations", clientID: 2, identity: user, clientIP: "203.0.113.2", want: []string{rateActionAllow, rateActionWarn, rateActionClose}},
+		{name: "other user unaffected", clientID: 3, identity: &ClientIdentity{UserID: "user-2"}, clientIP: "203.0.113.1", want: []string{rateActionAllow, rateActionWarn}},
+		{name: "anonymous by IP", clientID: 4, clientIP: "198.51.100.1", want: []string{rateActionAllow, rateActionWarn}},
+		{name: "anonymous reconnect from same IP", clientID: 5, clientIP: "198.51.100.1", want: []string{rateActionAllow, rateActionWarn}},
+		{name: "anonymous from another IP", clientID: 6, clientIP: "198.51.100.2", want: []string{rateActionAllow, rateActionWarn}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for range tt.want {
+				got = append(got, rl.Check(tt.clientID, tt.identity, tt.clientIP, 1))
+			}
+			rl.RemoveClient(tt.clientID)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("actions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := rl.Violations(user, ""); got != 3 {
+		t.Errorf("Violations(user-1) = %d, want 3", got)
+	}
+	if got := rl.Violations(nil, "198.51.100.1"); got != 2 {
+		t.Errorf("Violations(198.51.100.1) = %d, want 2", got)
+	}
+}
@@ -34,8 +34,10 @@ import (
 // For initial deployment: 1000 messages is safe with 512MB limit
 // because we limit connections to ~7K (memory aware)
 type ReplayEntry struct {
-	seq int64
-	buf *[]byte
+	seq       int64
+	streamSeq uint64 // Primary stream sequence (0 if unknown or from another source) - bridges to deep replay
+	buf       *[]byte
+	pooled    bool // true if buf came from BufferPool (must be returned on eviction)
 }
 
 type ReplayBuffer struct {
@@ -43,6 +45,14 @@ type ReplayBuffer struct {
 	maxSize int
 	pool    *BufferPool
 	mu      sync.RWMutex
+
+	// paused is set while the client's session is detached (see SessionManager)
+	// Frames keep accumulating but AddFrame tells broadcast() not to send them
+	paused bool
+
+	// Stream sequence of the newest evicted entry (0 = nothing evicted yet)
+	// Deep replay uses it to tell whether an exhausted range is still in JetStream
+	evictedStreamSeq uint64
 }
 
 // NewReplayBuffer creates a buffer with specified capacity
@@ -113,14 +123,7 @@ func (rb *ReplayBuffer) Add(envelope *MessageEnvelope) {
 		return
 	}
 
-	// Evict oldest if full - RETURN buffer to pool
-	if len(rb.entries) >= rb.maxSize {
-		oldest := rb.entries[0]
-		if rb.pool != nil && oldest.buf != nil {
-			rb.pool.Put(oldest.buf) // Return to pool for reuse
-		}
-		rb.entries = rb.entries[1:]
-	}
+	rb.evictOldestLocked()
 
 	// GET buffer from pool and store serialized envelope
 	var stored *[]byte
@@ -135,7 +138,96 @@ func (rb *ReplayBuffer) Add(envelope *MessageEnvelope) {
 		stored = &copyBuf
 	}
 
-	rb.entries = append(rb.entries, ReplayEntry{seq: envelope.Seq, buf: stored})
+	rb.entries = append(rb.entries, ReplayEntry{seq: envelope.Seq, buf: stored, pooled: rb.pool != nil})
+}
+
+// AddFrame stores an already-serialized envelope (from PreparedMessage.Frame)
+// Called from broadcast() on the hot path instead of Add()
+//
+// Zero-copy: the frame is immutable once built, so the same backing array
+// is shared by the send queue and the replay buffer. Saves one json.Marshal
+// and one copy per subscriber per message compared to Add().
+//
+// Frame buffers are NOT pooled (ownership is shared with writePump)
+//
+// streamSeq is the primary stream sequence (0 for frames of other sources)
+//
+// Returns false if the buffer is paused (session detached) - the frame is
+// stored for replay on resume but must NOT be queued for sending
+func (rb *ReplayBuffer) AddFrame(seq int64, streamSeq uint64, frame []byte) bool {
+	if rb == nil || frame == nil {
+		return false
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.evictOldestLocked()
+	rb.entries = append(rb.entries, ReplayEntry{seq: seq, streamSeq: streamSeq, buf: &frame})
+	return !rb.paused
+}
+
+// Pause stops live delivery while a session is detached
+// broadcast() keeps adding frames (so the gap can be replayed on resume)
+// but AddFrame returns false so nothing is queued on the dead connection
+func (rb *ReplayBuffer) Pause() {
+	rb.mu.Lock()
+	rb.paused = true
+	rb.mu.Unlock()
+}
+
+// Resume hands every frame after sinceSeq to deliver, then re-enables live
+// delivery - atomically, under the write lock
+//
+// Why atomic: a broadcast racing with the resume either lands before the
+// lock (included in the replay) or after it (sent live, AFTER the replayed
+// frames). Frames are never lost, duplicated or reordered.
+//
+// deliver receives:
+//   - frames: buffered frames after sinceSeq, oldest first
+//   - missed: frames after sinceSeq already evicted from the buffer
+//     (client must resync those from a snapshot)
+//
+// deliver runs under the lock and must not block
+func (rb *ReplayBuffer) Resume(sinceSeq int64, deliver func(frames [][]byte, missed int64)) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	var missed int64
+	if len(rb.entries) > 0 {
+		if gap := rb.entries[0].seq - sinceSeq - 1; gap > 0 {
+			missed = gap
+		}
+	}
+
+	frames := make([][]byte, 0, len(rb.entries))
+	for _, entry := range rb.entries {
+		if entry.seq > sinceSeq && entry.buf != nil {
+			frames = append(frames, *entry.buf)
+		}
+	}
+
+	deliver(frames, missed)
+	rb.paused = false
+}
+
+// evictOldestLocked drops the oldest entry if the buffer is full
+// Pooled buffers are RETURNED to the pool; shared frames are left to the GC
+// Caller must hold write lock
+func (rb *ReplayBuffer) evictOldestLocked() {
+	if len(rb.entries) < rb.maxSize {
+		return
+	}
+
+	oldest := rb.entries[0]
+	if oldest.streamSeq != 0 {
+		rb.evictedStreamSeq = oldest.streamSeq
+	}
+	if oldest.pooled && rb.pool != nil && oldest.buf != nil {
+		rb.pool.Put(oldest.buf) // Return to pool for reuse
+	}
+	rb.entries[0] = ReplayEntry{}
+	rb.entries = rb.entries[1:]
 }
 
 // GetRange returns messages with sequence numbers from fromSeq to toSeq (inclusive)
@@ -243,6 +335,64 @@ func (rb *ReplayBuffer) GetSince(sinceSeq int64) []*MessageEnvelope {
 	return result
 }
 
+// ReplayCoverage describes what the in-memory buffer can still serve
+type ReplayCoverage struct {
+	OldestSeq        int64  // Oldest buffered client seq (0 = buffer empty)
+	OldestStreamSeq  uint64 // Its primary stream sequence (0 = unknown or another source)
+	EvictedStreamSeq uint64 // Primary stream sequence of the newest evicted entry (0 = none evicted)
+}
+
+// Coverage reports the oldest buffered entry and the eviction watermark
+// Used by the replay handler to decide whether a request needs deep replay
+func (rb *ReplayBuffer) Coverage() ReplayCoverage {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	coverage := ReplayCoverage{EvictedStreamSeq: rb.evictedStreamSeq}
+	if len(rb.entries) > 0 {
+		coverage.OldestSeq = rb.entries[0].seq
+		coverage.OldestStreamSeq = rb.entries[0].streamSeq
+	}
+	return coverage
+}
+
+// GetRangeFrames returns the stored frames for fromSeq..toSeq (inclusive)
+// without deserializing them
+//
+// Used by the replay handler: frames were stored in the client's negotiated
+// wire codec (JSON or msgpack), so they can be sent back byte-for-byte.
+// The returned slices are shared with the buffer - callers must not modify them
+func (rb *ReplayBuffer) GetRangeFrames(fromSeq, toSeq int64) [][]byte {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	result := make([][]byte, 0)
+	for _, entry := range rb.entries {
+		if entry.seq > toSeq {
+			break
+		}
+		if entry.seq >= fromSeq && entry.buf != nil {
+			result = append(result, *entry.buf)
+		}
+	}
+	return result
+}
+
+// GetSinceFrames returns the stored frames after sinceSeq without deserializing them
+// See GetRangeFrames for ownership rules
+func (rb *ReplayBuffer) GetSinceFrames(sinceSeq int64) [][]byte {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	result := make([][]byte, 0)
+	for _, entry := range rb.entries {
+		if entry.seq > sinceSeq && entry.buf != nil {
+			result = append(result, *entry.buf)
+		}
+	}
+	return result
+}
+
 // Clear empties the buffer completely
 // Used on client disconnect to free memory
 //
@@ -262,11 +412,17 @@ func (rb *ReplayBuffer) Clear() {
 
 	if rb.pool != nil {
 		for _, entry := range rb.entries {
-			if entry.buf != nil {
+			if entry.pooled && entry.buf != nil {
 				rb.pool.Put(entry.buf)
 			}
 		}
 	}
 
+	// Drop references so shared frames can be garbage collected
+	for i := range rb.entries {
+		rb.entries[i] = ReplayEntry{}
+	}
 	rb.entries = rb.entries[:0]
+	rb.paused = false
+	rb.evictedStreamSeq = 0
 }
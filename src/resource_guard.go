@@ -214,37 +214,35 @@ func (rg *ResourceGuard) ShouldAcceptConnection() (accept bool, reason string) {
 // ShouldPauseNATS checks if NATS consumption should be paused
 //
 // This provides backpressure when CPU is critically high.
-// Messages are NAK'd and will be redelivered by JetStream.
+// The fetch loop stops pulling - messages wait in the stream.
 func (rg *ResourceGuard) ShouldPauseNATS() bool {
 	currentCPU := rg.currentCPU.Load().(float64)
 	return currentCPU > rg.config.CPUPauseThreshold
 }
 
-// AllowNATSMessage checks if a NATS message should be processed (rate limiting)
+// ReserveNATSMessages takes up to limit tokens from the NATS rate limiter
 //
-// This prevents NATS from flooding the server with more work than it can handle.
+// This prevents NATS from flooding the server with more work than it can handle:
+// the fetch loop only pulls as many messages as the limiter allows right now.
 //
 // Returns:
-//   - allow: true if message should be processed
-//   - waitDuration: how long caller should wait before retrying (if blocked)
-func (rg *ResourceGuard) AllowNATSMessage(ctx context.Context) (allow bool, waitDuration time.Duration) {
-	// Non-blocking check
-	reservation := rg.natsLimiter.Reserve()
-	if !reservation.OK() {
-		// Rate limit exceeded
-		rg.logger.Warn().Msg("NATS rate limit exceeded")
-		return false, 0
+//   - n: tokens taken (0..limit) - the batch size to fetch
+//   - wait: with n == 0, how long until the next token is available
+func (rg *ResourceGuard) ReserveNATSMessages(limit int) (n int, wait time.Duration) {
+	now := time.Now()
+	n = min(limit, int(rg.natsLimiter.TokensAt(now)))
+	if n >= 1 && rg.natsLimiter.AllowN(now, n) {
+		return n, 0
 	}
 
-	delay := reservation.Delay()
-	if delay == 0 {
-		// Allowed immediately
-		return true, 0
+	// Empty bucket: find out when one token is available, without taking it
+	reservation := rg.natsLimiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return 0, time.Second // Limit of 0 - poll slowly
 	}
-
-	// Would need to wait
-	reservation.Cancel() // Don't consume token
-	return false, delay
+	wait = reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return 0, max(wait, time.Millisecond)
 }
 
 // AllowBroadcast checks if a broadcast should be processed (rate limiting)
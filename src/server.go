@@ -3,12 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
@@ -35,6 +37,22 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	// = 27 seconds with new pongWait
 	pingPeriod = (pongWait * 9) / 10
+
+	// Per-priority delivery policy (see MessagePriority)
+	// HIGH: block up to 100ms for a full send buffer, then count a strike
+	// CRITICAL: block up to 1s, then disconnect the client
+	highPriorityBlockTimeout     = 100 * time.Millisecond
+	criticalPriorityBlockTimeout = 1 * time.Second
+
+	// Consecutive HIGH-priority send failures before a client is disconnected
+	maxSlowClientStrikes = 3
+
+	// HIGH/CRITICAL frames waiting in one client's slow path (see slowPathQueue)
+	// A client this far behind its full send buffer misses the rest right away
+	maxSlowPathFrames = 256
+
+	// Messages kept per client for gap recovery (older ones come from deep replay)
+	replayBufferSize = 100
 )
 
 type ServerConfig struct {
@@ -64,7 +82,112 @@ type ServerConfig struct {
 	JSStreamMaxBytes  int64         // Max bytes in stream (default: 50MB)
 	JSConsumerAckWait time.Duration // Ack wait timeout (default: 30s)
 	JSStreamName      string        // Stream name (default: "ODIN_TOKENS")
-	JSConsumerName    string        // Consumer name (default: "ws-server")
+	JSConsumerName    string        // Consumer name (default: "ws-server"), prefix in instance mode
+	JSSourcesFile     string        // Stream sources JSON (empty = one token source from JS_STREAM_*, see stream_sources.go)
+
+	// Interest-based consumption (see jetstream_interest.go)
+	JSInterestSubscriptions bool          // Consumer filters follow local subscriptions (default: false)
+	JSInterestDebounce      time.Duration // Coalescing window for subscription changes (default: 250ms)
+	JSInterestLinger        time.Duration // Keep a channel's filter this long after its last subscriber left (default: 30s)
+
+	// JetStream consumer mode (see jetstream_consumer.go)
+	JSConsumerMode              string        // instance (full feed per instance) or shared (default: instance)
+	JSConsumerStart             string        // new (tail of the stream) or resume (after last ack) (default: new)
+	JSInstanceID                string        // Per-instance consumer suffix (default: hostname)
+	JSConsumerInactiveThreshold time.Duration // Delete consumers of instances gone this long (default: 5m)
+
+	// Pull consumption (see jetstream_fetch.go)
+	JSFetchBatchSize int           // Max messages per fetch (default: 100)
+	JSFetchMaxWait   time.Duration // How long one fetch waits for messages (default: 500ms)
+
+	// Redelivery and dead letters (see jetstream_redelivery.go)
+	JSConsumerMaxDeliver int           // Deliveries per message before it is dead-lettered (default: 5)
+	JSNakBackoffBase     time.Duration // First NAK delay, doubled per delivery (default: 1s)
+	JSNakBackoffMax      time.Duration // NAK delay cap (default: 30s)
+	JSDeadLetterSubject  string        // Subject for messages that exhausted MaxDeliver (empty = disabled)
+	JSDeadLetterStream   string        // Stream capturing JSDeadLetterSubject, created on startup (empty = none)
+
+	// Deep replay (replay requests beyond the in-memory buffer, served from the stream)
+	DeepReplayEnabled       bool          // Serve exhausted replays from JetStream (default: false)
+	DeepReplayMaxMessages   int           // Max historical messages per request (default: 1000)
+	DeepReplayTimeout       time.Duration // Max time one deep replay may take (default: 5s)
+	DeepReplayMaxConcurrent int           // Max deep replays running at once, server-wide (default: 4)
+
+	// Message routing (channel → envelope type + priority)
+	MessageRoutes        string // Route overrides: "pattern=type/priority,..." (default: none)
+	MessageRouteFallback string // Route for unknown event types: "type/priority" (default: "token:update/normal")
+
+	// Last-value cache (latest message per channel, sent as a snapshot on subscribe)
+	LVCEnabled          bool          // Cache and send snapshots (default: true)
+	LVCMaxBytes         int64         // Memory cap across all channels (default: 16MB)
+	LVCDefaultRetention time.Duration // Max snapshot age for unlisted event types (default: 10m, 0 = not cached)
+	LVCRetention        string        // Per event type max age: "event=duration,..." (0 = not cached)
+
+	// Authentication (JWT)
+	AuthMode       AuthMode      // off, optional, required (default: off)
+	JWTHS256Secret string        // HS256 shared secret (default: none)
+	JWTJWKSFile    string        // Local JWKS file with RS256 keys (default: none)
+	JWTIssuer      string        // Required "iss" claim (default: not checked)
+	JWTAudience    string        // Required "aud" claim (default: not checked)
+	JWTLeeway      time.Duration // Clock skew tolerance (default: 30s)
+	AuthTimeout    time.Duration // Deadline for first-message auth (default: 5s)
+
+	// Connection lifetime (see connection_lifetime.go)
+	MaxConnectionLifetime time.Duration // Connections are closed after this long (0 = unlimited)
+	AuthExpiryWarning     time.Duration // session_expiring is sent this long before the deadline
+
+	// Handshake hardening (see handshake_guard.go)
+	AllowedOrigins   string        // Comma-separated Origin allowlist (empty = any origin)
+	MaxMessageSize   int64         // Max inbound message size, on the wire and inflated
+	HandshakeTimeout time.Duration // Max time to send the complete upgrade request
+
+	// Per-IP connection limits (see connection_limiter.go)
+	MaxConnectionsPerIP  int     // Concurrent connections per client IP (0 = unlimited)
+	ConnectionRatePerIP  float64 // New connections per second per IP (0 = unlimited)
+	ConnectionBurstPerIP int     // Per-IP token bucket capacity
+	AcceptRate           float64 // New connections per second server-wide (0 = unlimited)
+	AcceptBurst          int     // Global accept burst
+	TrustedProxies       string  // CIDRs whose PROXY header / Forwarded / X-Forwarded-For is believed
+	ProxyProtocol        string  // PROXY protocol on the listener: off, optional, required
+
+	// Channel authorization
+	AuthzPolicyFile string // JSON channel policy (empty = every channel allowed)
+
+	// Subscription quotas and channel validation (see channel_validation.go)
+	MaxSubscriptionsPerClient int    // Channels + patterns per client (default: 500, 0 = unlimited)
+	MaxChannelsPerRequest     int    // Channels per subscribe/unsubscribe (default: 100, 0 = unlimited)
+	ChannelEventTypes         string // Known event types: "trade,liquidity,..." (default: the 8 built-in types)
+
+	// Admin API (see admin_api.go)
+	AdminAddr         string // Admin listener address (empty = disabled)
+	AdminToken        string // Bearer token required on every admin request (empty = none)
+	AdminTLSCertFile  string // Serve the admin API over TLS with this certificate
+	AdminTLSKeyFile   string // Private key for AdminTLSCertFile
+	AdminClientCAFile string // Require client certificates signed by this CA (mTLS)
+
+	// Ban list (see ban_list.go)
+	BanListFile string // JSON ban list of IPs/CIDRs, users and user agents (empty = disabled)
+
+	// Per-client message rate limits (see rate_limiter.go)
+	RateLimitBurst                float64       // Default bucket capacity (default: 100)
+	RateLimitRate                 float64       // Default tokens per second (default: 10)
+	RateLimitCosts                string        // Per message type cost: "type=cost,..." (unlisted: 1)
+	RateLimitReplayCostPerMessage float64       // Extra replay cost per requested message (default: 0.1)
+	RateLimitRoles                string        // Per role bucket: "role=burst/rate,..." (default: none)
+	RateLimitViolationWindow      time.Duration // Window violations are counted in (default: 60s)
+	RateLimitMuteAfter            int           // Violations before a temporary mute (default: 3, 0 = never)
+	RateLimitMuteDuration         time.Duration // Mute length (default: 10s)
+	RateLimitCloseAfter           int           // Violations before close 1008 (default: 10, 0 = never)
+
+	// Resumable sessions
+	SessionGracePeriod time.Duration // How long a disconnected session can be resumed (default: 30s, 0 = disabled)
+
+	// permessage-deflate (RFC 7692)
+	CompressionEnabled               bool // Negotiate permessage-deflate (default: false)
+	CompressionLevel                 int  // flate level 1-9 (default: 1 = BestSpeed)
+	CompressionServerContextTakeover bool // Keep server compressor window across messages (default: false)
+	CompressionClientContextTakeover bool // Allow client to keep its window across messages (default: false)
+	CompressionMinSize               int  // Frames below this size are sent uncompressed (default: 256)
 
 	// Monitoring intervals
 	MetricsInterval time.Duration // Metrics collection interval (default: 15s)
@@ -75,20 +198,37 @@ type ServerConfig struct {
 }
 
 type Server struct {
-	config          ServerConfig
-	logger          *log.Logger    // Old logger (for backwards compat)
-	structLogger    zerolog.Logger // New structured logger for Loki
-	listener        net.Listener
-	natsConn        *nats.Conn
-	natsJS          nats.JetStreamContext
-	natsSubcription *nats.Subscription
-	natsPaused      int32 // Atomic flag: 1 = paused, 0 = active
+	config       ServerConfig
+	logger       *log.Logger    // Old logger (for backwards compat)
+	structLogger zerolog.Logger // New structured logger for Loki
+	listener     net.Listener
+	natsConn     *nats.Conn
+	natsJS       nats.JetStreamContext
+	natsPaused   int32 // Atomic flag: 1 = paused, 0 = active
+
+	// Consumed streams and their subject → channel mappings (see stream_sources.go)
+	sources []*StreamSource
+
+	// Consumer filters following local subscriptions, nil = full feed (see jetstream_interest.go)
+	interest *interestFilter
+
+	// Per-instance consumer suffix (JS_INSTANCE_ID / hostname, see jetstream_consumer.go)
+	instanceID string
+
+	// Max-deliveries advisory subscriptions, one per source (see jetstream_redelivery.go)
+	deadLetterSubs []*nats.Subscription
+
+	// Deep replay (see deep_replay.go)
+	// streamJS: new JetStream API (ordered consumers), nil when deep replay is disabled
+	// deepReplaySem: caps concurrent deep replays server-wide
+	streamJS      jetstream.JetStream
+	deepReplaySem chan struct{}
 
 	// Connection management
 	connections       *ConnectionPool
 	clients           sync.Map // map[*Client]bool
 	clientCount       int64
-	connectionsSem    chan struct{} // Semaphore for max connections
+	connectionsSem    chan struct{}      // Semaphore for max connections
 	subscriptionIndex *SubscriptionIndex // Fast lookup: channel → subscribers (93% CPU savings!)
 
 	// Performance optimization
@@ -98,6 +238,43 @@ type Server struct {
 	// Rate limiting
 	rateLimiter *RateLimiter
 
+	// Message routing (envelope type + priority per channel)
+	messageRouter *MessageRouter
+
+	// Per-channel sequence chain (channel_seq / prev_channel_seq in the envelope)
+	channelSequencer *ChannelSequencer
+
+	// Latest message per channel for snapshot-on-subscribe (nil = disabled)
+	lastValues *LastValueCache
+
+	// JWT validation for the handshake and first-message auth
+	auth *Authenticator
+
+	// Per-channel subscribe authorization (allows everything without a policy file)
+	channelAuthorizer ChannelAuthorizer
+
+	// Subscribe channel grammar + event-type registry (see channel_validation.go)
+	channelValidator *ChannelValidator
+
+	// Origin header allowlist for upgrades (nil = every origin allowed)
+	allowedOrigins *OriginAllowlist
+
+	// Client IP resolution (X-Forwarded-For from trusted proxies) and per-IP limits
+	trustedProxies *TrustedProxies
+	connLimiter    *ConnectionLimiter
+
+	// permessage-deflate settings (negotiated per connection)
+	compression CompressionConfig
+
+	// Resumable sessions (detached clients waiting for reconnect)
+	sessions *SessionManager
+
+	// Admin API on its own listener (nil = disabled, see admin_api.go)
+	adminServer *http.Server
+
+	// Active ban list (nil = nothing banned), swapped atomically on reload
+	banList atomic.Pointer[BanList]
+
 	// Monitoring
 	auditLogger      *AuditLogger
 	metricsCollector *MetricsCollector
@@ -142,6 +319,12 @@ func NewServer(config ServerConfig, logger *log.Logger) (*Server, error) {
 
 	bufferPool := NewBufferPool(config.BufferSize)
 
+	messageRouter, err := NewMessageRouter(config.MessageRoutes, config.MessageRouteFallback)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid message routing config: %w", err)
+	}
+
 	s := &Server{
 		config:            config,
 		logger:            logger,       // Old logger (backwards compat)
@@ -153,12 +336,106 @@ func NewServer(config ServerConfig, logger *log.Logger) (*Server, error) {
 		connectionsSem:    make(chan struct{}, config.MaxConnections),
 		subscriptionIndex: NewSubscriptionIndex(), // Fast channel → subscribers lookup
 		workerPool:        NewWorkerPool(config.WorkerCount, config.WorkerQueueSize, structLogger),
-		rateLimiter:       NewRateLimiter(),
+		messageRouter:     messageRouter,
+		channelSequencer:  NewChannelSequencer(channelSequencerIdleTTL, channelSequencerMaxChannels),
+		compression: CompressionConfig{
+			Enabled:               config.CompressionEnabled,
+			Level:                 config.CompressionLevel,
+			ServerContextTakeover: config.CompressionServerContextTakeover,
+			ClientContextTakeover: config.CompressionClientContextTakeover,
+			MinSize:               config.CompressionMinSize,
+		},
 		stats: &Stats{
 			StartTime: time.Now(),
 		},
 	}
 
+	if config.LVCEnabled {
+		s.lastValues, err = NewLastValueCache(config.LVCRetention, config.LVCDefaultRetention, config.LVCMaxBytes)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("invalid last-value cache config: %w", err)
+		}
+	}
+
+	s.auth, err = NewAuthenticator(AuthConfig{
+		Mode:        config.AuthMode,
+		HS256Secret: config.JWTHS256Secret,
+		JWKSFile:    config.JWTJWKSFile,
+		Issuer:      config.JWTIssuer,
+		Audience:    config.JWTAudience,
+		Leeway:      config.JWTLeeway,
+		Timeout:     config.AuthTimeout,
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid auth config: %w", err)
+	}
+
+	s.rateLimiter, err = NewRateLimiter(RateLimitConfig{
+		Burst:                config.RateLimitBurst,
+		Rate:                 config.RateLimitRate,
+		Costs:                config.RateLimitCosts,
+		ReplayCostPerMessage: config.RateLimitReplayCostPerMessage,
+		RoleLimits:           config.RateLimitRoles,
+		ViolationWindow:      config.RateLimitViolationWindow,
+		MuteAfter:            config.RateLimitMuteAfter,
+		MuteDuration:         config.RateLimitMuteDuration,
+		CloseAfter:           config.RateLimitCloseAfter,
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid rate limit config: %w", err)
+	}
+
+	s.channelAuthorizer, err = NewChannelAuthorizer(config.AuthzPolicyFile)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid channel policy: %w", err)
+	}
+
+	s.channelValidator, err = NewChannelValidator(config.ChannelEventTypes)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid channel event types: %w", err)
+	}
+
+	s.sources, err = configuredStreamSources(config)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid stream sources: %w", err)
+	}
+	if config.JSInterestSubscriptions && config.NATSUrl != "" {
+		s.interest = newInterestFilter()
+		s.subscriptionIndex.SetInterestListener(s.interest.notify)
+	}
+
+	if config.BanListFile != "" {
+		banList, err := LoadBanList(config.BanListFile)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("invalid ban list: %w", err)
+		}
+		s.banList.Store(banList)
+	}
+
+	s.allowedOrigins, err = ParseOriginAllowlist(config.AllowedOrigins)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid allowed origins: %w", err)
+	}
+
+	s.trustedProxies, err = ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	s.connLimiter = NewConnectionLimiter(config.MaxConnectionsPerIP, config.ConnectionRatePerIP,
+		config.ConnectionBurstPerIP, config.AcceptRate, config.AcceptBurst)
+
+	// Detached sessions are capped at MaxConnections (each costs as much as a live client)
+	s.sessions = NewSessionManager(config.SessionGracePeriod, config.MaxConnections, s.releaseClient)
+
 	// Initialize monitoring
 	s.auditLogger = NewAuditLogger(INFO)          // Log INFO and above
 	s.auditLogger.SetAlerter(NewConsoleAlerter()) // Use console alerter for now
@@ -177,66 +454,70 @@ func NewServer(config ServerConfig, logger *log.Logger) (*Server, error) {
 		Msg("Server initialized with ResourceGuard")
 
 	if config.NATSUrl != "" {
-		nc, err := nats.Connect(config.NATSUrl, nats.MaxReconnects(5), nats.ReconnectWait(2*time.Second))
-		if err != nil {
-			s.auditLogger.Critical("NATSConnectionFailed", "Failed to connect to NATS", map[string]any{
-				"url":   config.NATSUrl,
-				"error": err.Error(),
-			})
-			return nil, fmt.Errorf("failed to connect to nats: %w", err)
+		if err := s.connectNATS(); err != nil {
+			// Nothing started yet - release the connection and the context
+			if s.natsConn != nil {
+				s.natsConn.Close()
+			}
+			cancel()
+			return nil, err
 		}
-		s.natsConn = nc
-		logger.Printf("Connected to NATS at %s", config.NATSUrl)
+	}
+
+	return s, nil
+}
 
-		s.auditLogger.Info("NATSConnected", "Connected to NATS successfully", map[string]any{
-			"url": config.NATSUrl,
+// connectNATS connects to NATS, initializes JetStream and creates the source streams
+// On error the caller closes s.natsConn (set as soon as the connection is up)
+func (s *Server) connectNATS() error {
+	nc, err := nats.Connect(s.config.NATSUrl, nats.MaxReconnects(5), nats.ReconnectWait(2*time.Second))
+	if err != nil {
+		s.auditLogger.Critical("NATSConnectionFailed", "Failed to connect to NATS", map[string]any{
+			"url":   s.config.NATSUrl,
+			"error": err.Error(),
 		})
+		return fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	s.natsConn = nc
+	s.logger.Printf("Connected to NATS at %s", s.config.NATSUrl)
 
-		// Initialize JetStream
-		js, err := nc.JetStream()
+	if s.config.JSConsumerMode != ConsumerModeShared {
+		s.instanceID, err = ResolveInstanceID(s.config.JSInstanceID)
 		if err != nil {
-			RecordJetStreamError(ErrorSeverityFatal)
-			s.auditLogger.Critical("JetStreamInitFailed", "Failed to initialize JetStream", map[string]any{
-				"error": err.Error(),
-			})
-			logger.Printf("❌ FATAL: JetStream init failed: %v", err)
-			return nil, fmt.Errorf("failed to initialize jetstream: %w", err)
+			return err
 		}
-		s.natsJS = js
+	}
+
+	s.auditLogger.Info("NATSConnected", "Connected to NATS successfully", map[string]any{
+		"url": s.config.NATSUrl,
+	})
+
+	// Initialize JetStream
+	js, err := nc.JetStream()
+	if err != nil {
+		RecordJetStreamError(ErrorSeverityFatal)
+		s.auditLogger.Critical("JetStreamInitFailed", "Failed to initialize JetStream", map[string]any{
+			"error": err.Error(),
+		})
+		s.logger.Printf("❌ FATAL: JetStream init failed: %v", err)
+		return fmt.Errorf("failed to initialize jetstream: %w", err)
+	}
+	s.natsJS = js
+
+	// Create the streams of all sources (existing ones are left as they are)
+	if err := s.ensureStreams(); err != nil {
+		return err
+	}
 
-		// Create or update stream for token updates
-		streamName := config.JSStreamName
-		streamInfo, err := js.StreamInfo(streamName)
+	if s.config.DeepReplayEnabled {
+		streamJS, err := jetstream.New(nc)
 		if err != nil {
-			// Stream doesn't exist, create it
-			logger.Printf("📦 Creating JetStream stream: %s", streamName)
-			_, err = js.AddStream(&nats.StreamConfig{
-				Name:      streamName,
-				Subjects:  []string{"odin.token.>"},
-				Retention: nats.InterestPolicy,     // Delete after all subscribers ack
-				MaxAge:    config.JSStreamMaxAge,   // Configured max age
-				Storage:   nats.MemoryStorage,      // In-memory for speed
-				Replicas:  1,                       // Single replica for now
-				Discard:   nats.DiscardOld,         // Drop oldest when full
-				MaxMsgs:   config.JSStreamMaxMsgs,  // Configured max messages
-				MaxBytes:  config.JSStreamMaxBytes, // Configured max bytes
-			})
-			if err != nil {
-				RecordJetStreamError(ErrorSeverityFatal)
-				s.auditLogger.Critical("StreamCreationFailed", "Failed to create JetStream stream", map[string]any{
-					"stream": streamName,
-					"error":  err.Error(),
-				})
-				logger.Printf("❌ FATAL: Stream creation failed: %v", err)
-				return nil, fmt.Errorf("failed to create stream: %w", err)
-			}
-			logger.Printf("✅ JetStream stream created: %s", streamName)
-		} else {
-			logger.Printf("✅ JetStream stream exists: %s (messages: %d)", streamName, streamInfo.State.Msgs)
+			return fmt.Errorf("failed to initialize jetstream for deep replay: %w", err)
 		}
+		s.streamJS = streamJS
+		s.deepReplaySem = make(chan struct{}, s.config.DeepReplayMaxConcurrent)
 	}
-
-	return s, nil
+	return nil
 }
 
 func (s *Server) Start() error {
@@ -246,6 +527,9 @@ func (s *Server) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
+
+	// PROXY protocol v1/v2 from trusted TCP load balancers (real client address)
+	listener = wrapProxyProtocol(listener, s.config.ProxyProtocol, s.trustedProxies, s.config.HandshakeTimeout)
 	s.listener = listener
 
 	s.logger.Printf("Server listening on %s", s.config.Addr)
@@ -253,106 +537,29 @@ func (s *Server) Start() error {
 	s.workerPool.Start(s.ctx)
 
 	if s.natsConn != nil && s.natsJS != nil {
-		msgCount := int64(0)
-		droppedCount := int64(0)
-		ackFailCount := int64(0)
-
-		// Subscribe to JetStream with manual ack + rate limiting
-		// CRITICAL: Rate limiting prevents goroutine explosion and CPU overload
-		sub, err := s.natsJS.Subscribe("odin.token.>", func(msg *nats.Msg) {
-			count := atomic.AddInt64(&msgCount, 1)
-			if count%100 == 0 {
-				s.logger.Printf("📬 Received %d messages from JetStream", count)
-			}
-
-			// STEP 1: Rate limiting (CRITICAL - prevents overload)
-			// Check if we're allowed to process this message based on configured rate limit
-			allow, waitDuration := s.resourceGuard.AllowNATSMessage(s.ctx)
-			if !allow {
-				// Rate limit exceeded - NAK for redelivery
-				if err := msg.Nak(); err != nil {
-					RecordJetStreamError(ErrorSeverityWarning)
-					s.structLogger.Error().
-						Err(err).
-						Str("reason", "rate_limit_nak_failed").
-						Msg("Failed to NAK message during rate limiting")
-				}
-				dropped := atomic.AddInt64(&droppedCount, 1)
-				IncrementNATSDropped()
-				if dropped%100 == 0 {
-					s.structLogger.Warn().
-						Int64("dropped_count", dropped).
-						Dur("would_wait", waitDuration).
-						Int("rate_limit", s.config.MaxNATSMessagesPerSec).
-						Msg("NATS rate limit exceeded - NAK'ing messages for redelivery")
-				}
-				return
+		// One pull consumer and fetch loop per source (see jetstream_fetch.go)
+		for _, source := range s.sources {
+			if err := s.subscribeSource(source); err != nil {
+				return err
 			}
+		}
 
-			// STEP 2: CPU emergency brake
-			if s.resourceGuard.ShouldPauseNATS() {
-				// CPU critically high - NAK message for redelivery
-				if err := msg.Nak(); err != nil {
-					RecordJetStreamError(ErrorSeverityWarning)
-					s.structLogger.Error().
-						Err(err).
-						Str("reason", "cpu_brake_nak_failed").
-						Msg("Failed to NAK message during CPU emergency brake")
-				}
-				dropped := atomic.AddInt64(&droppedCount, 1)
-				IncrementNATSDropped()
-				if dropped%100 == 0 {
-					s.structLogger.Warn().
-						Int64("dropped_count", dropped).
-						Float64("cpu_threshold", s.config.CPUPauseThreshold).
-						Msg("CPU emergency brake - pausing NATS consumption")
-				}
-				return
-			}
+		// Messages that exhaust MaxDeliver → dead letter subject
+		if err := s.startDeadLetterHandler(); err != nil {
+			RecordJetStreamError(ErrorSeverityCritical)
+			return err
+		}
 
-			// Track NATS message in Prometheus
-			IncrementNATSMessages()
-
-			// STEP 3: Submit to worker pool (can still drop if queue full)
-			s.workerPool.Submit(func() {
-				// CRITICAL: Pass NATS subject to broadcast for subscription filtering
-				// Subject format: "odin.token.BTC" → extracts "BTC" for filtering
-				// This reduces broadcast fanout from O(all_clients) to O(subscribed_clients)
-				// Performance gain: 10-20x CPU reduction with subscription filtering
-				s.broadcast(msg.Subject, msg.Data)
-
-				// Acknowledge message after successful broadcast
-				if err := msg.Ack(); err != nil {
-					RecordJetStreamError(ErrorSeverityWarning)
-					ackFails := atomic.AddInt64(&ackFailCount, 1)
-
-					// Log every ack failure at debug level, summary every 100 at warn level
-					s.structLogger.Debug().
-						Err(err).
-						Int64("total_ack_failures", ackFails).
-						Str("subject", msg.Subject).
-						Msg("Failed to ACK NATS message")
-
-					if ackFails%100 == 0 {
-						s.structLogger.Warn().
-							Int64("ack_failures", ackFails).
-							Err(err).
-							Msg("High NATS ACK failure rate - check NATS connection health")
-					}
-				}
-			})
-		}, nats.Durable(s.config.JSConsumerName), nats.ManualAck(), nats.AckWait(s.config.JSConsumerAckWait))
+		for _, source := range s.sources {
+			s.wg.Add(1)
+			go s.consumeJetStream(source)
+		}
 
-		if err != nil {
-			RecordJetStreamError(ErrorSeverityCritical)
-			s.auditLogger.Critical("JetStreamSubscriptionFailed", "Failed to subscribe to JetStream", map[string]any{
-				"subject": "odin.token.>",
-				"error":   err.Error(),
-			})
-			return fmt.Errorf("failed to subscribe to jetstream: %w", err)
+		// Narrow the consumer filters to what local clients subscribe to
+		if s.interest != nil {
+			s.wg.Add(1)
+			go s.runInterestFilter()
 		}
-		s.natsSubcription = sub
-		s.logger.Printf("✅ Subscribed to JetStream: odin.token.> (durable, manual ack)")
 
 		// Monitor NATS connection status
 		s.wg.Add(1)
@@ -364,12 +571,25 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/metrics", handleMetrics) // Prometheus metrics endpoint
 
+	// Slowloris protection: the whole upgrade request must arrive within
+	// HandshakeTimeout; the tracker counts connections dropped for it
+	handshakes := &handshakeTracker{
+		timeout: s.config.HandshakeTimeout,
+		onTimeout: func(remoteAddr string, elapsed time.Duration) {
+			s.recordViolation(violationHandshakeTimeout, remoteAddr, 0, map[string]any{
+				"elapsed": elapsed.String(),
+			})
+		},
+	}
+
 	server := &http.Server{
-		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		IdleTimeout:    120 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+		Handler:           mux,
+		ReadHeaderTimeout: s.config.HandshakeTimeout,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ConnState:         handshakes.ConnState,
 	}
 
 	s.wg.Add(1)
@@ -394,6 +614,14 @@ func (s *Server) Start() error {
 	// Start ResourceGuard monitoring (static limits with safety checks)
 	s.resourceGuard.StartMonitoring(s.ctx, s.config.MetricsInterval)
 
+	// Forget per-IP limiter state of IPs that went away
+	s.connLimiter.StartPruning(s.ctx, time.Minute)
+
+	// Operator API (list clients, subscribers, force-disconnect)
+	if err := s.startAdminAPI(); err != nil {
+		return err
+	}
+
 	s.auditLogger.Info("ServerStarted", "WebSocket server started successfully", map[string]any{
 		"addr":           s.config.Addr,
 		"maxConnections": s.config.MaxConnections,
@@ -488,22 +716,30 @@ func (s *Server) monitorNATS() {
 
 			// Check subscription health every 30 seconds
 			if time.Since(lastSubCheck) >= 30*time.Second {
-				if s.natsSubcription != nil {
+				for _, source := range s.sources {
+					sub := source.sub.Load()
+					if sub == nil {
+						continue
+					}
+
 					// Check if subscription is still valid
-					if !s.natsSubcription.IsValid() {
+					if !sub.IsValid() {
 						s.auditLogger.Critical("NATSSubscriptionInvalid", "NATS subscription is invalid", map[string]any{
-							"subject": "odin.token.>",
+							"stream":   source.Config.Name,
+							"subjects": source.Config.Subjects,
 						})
 						s.structLogger.Error().
-							Str("subject", "odin.token.>").
+							Str("stream", source.Config.Name).
+							Strs("subjects", source.Config.Subjects).
 							Msg("NATS subscription is invalid - clients will not receive updates")
 					}
 
 					// Get pending message count for monitoring
-					pending, _, err := s.natsSubcription.Pending()
+					pending, _, err := sub.Pending()
 					if err == nil && pending > 1000 {
 						// High pending count indicates subscription isn't keeping up
 						s.structLogger.Warn().
+							Str("stream", source.Config.Name).
 							Int("pending_messages", pending).
 							Msg("High NATS pending message count - subscription may be falling behind")
 					}
@@ -583,6 +819,49 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Real client address (PROXY protocol / trusted forwarding headers)
+	// Used for every log, audit event and per-IP limit from here on
+	clientIP := s.trustedProxies.ClientIP(r)
+
+	// Banned IPs / User-Agents (HTTP 403) - before any other work
+	if !s.checkBannedConnection(w, r, clientIP) {
+		connectionsFailed.Inc()
+		return
+	}
+
+	// Cross-site WebSocket hijacking protection (HTTP 403)
+	if !s.checkOrigin(w, r, clientIP) {
+		connectionsFailed.Inc()
+		return
+	}
+
+	// Per-IP and global connection limits (HTTP 429/503 with Retry-After)
+	// Checked before JWT validation and the upgrade - rejecting must stay cheap
+	if !s.admitConnection(w, clientIP) {
+		connectionsFailed.Inc()
+		return
+	}
+	releaseIP := true // Until the connection is handed to readPump
+	defer func() {
+		if releaseIP {
+			s.connLimiter.Release(clientIP)
+		}
+	}()
+
+	// Authenticate BEFORE taking a connection slot or upgrading:
+	// a bad header/query token costs an HTTP 401, not a WebSocket
+	identity, ok := s.authenticateHandshake(w, r, clientIP)
+	if !ok {
+		connectionsFailed.Inc()
+		return
+	}
+
+	// Banned users (HTTP 403) - only known once the handshake token is validated
+	if !s.checkBannedUser(w, r, clientIP, identity) {
+		connectionsFailed.Inc()
+		return
+	}
+
 	// Try to acquire connection slot (non-blocking with timeout)
 	select {
 	case s.connectionsSem <- struct{}{}:
@@ -598,22 +877,75 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	// Negotiate wire encoding via Sec-WebSocket-Protocol
+	// Supported: odin.json.v1 (text), odin.msgpack.v1 (binary)
+	// Clients that send no (or no supported) subprotocol get JSON
+	upgrader := ws.HTTPUpgrader{
+		Protocol: isSupportedSubprotocol,
+	}
+
+	// Negotiate permessage-deflate via Sec-WebSocket-Extensions (if enabled)
+	// Negotiator is stateful, so one per handshake
+	var deflate *deflateNegotiator
+	if s.compression.Enabled {
+		deflate = &deflateNegotiator{config: s.compression}
+		upgrader.Negotiate = deflate.Negotiate
+	}
+
+	conn, _, hs, err := upgrader.Upgrade(r, w)
 	if err != nil {
 		<-s.connectionsSem // Release slot
 		s.auditLogger.Error("WebSocketUpgradeFailed", "Failed to upgrade HTTP connection to WebSocket", map[string]any{
 			"error":      err.Error(),
-			"remoteAddr": r.RemoteAddr,
+			"remoteAddr": clientIP,
 		})
 		connectionsFailed.Inc()
 		s.logger.Printf("Failed to upgrade connection: %v", err)
 		return
 	}
 
-	client := s.connections.Get()
+	codec := negotiateCodec(hs.Protocol)
+
+	// Resume a detached session if the client presented a resume token
+	// Any failure falls back to a fresh session (the client learns via "resumed": false)
+	client := s.resumeSession(r, codec, identity, clientIP)
+	resumed := client != nil
+	if !resumed {
+		client = s.connections.Get()
+		client.id = atomic.AddInt64(&s.clientCount, 1)
+		client.codec = codec
+	}
+
+	// last_seq = last sequence the client processed (0 = replay everything buffered)
+	lastSeq, _ := strconv.ParseInt(r.URL.Query().Get("last_seq"), 10, 64)
+
+	// First-message auth: the session's owner is only known once the auth
+	// message arrives - nothing is announced or replayed until then
+	if resumed && s.resumeAwaitsAuth(identity) {
+		client.pendingResume = &pendingResume{owner: client.identity, lastSeq: lastSeq}
+	}
+	client.setIdentity(identity)
+	client.clientIP = clientIP
+	client.userAgent = r.UserAgent()
+	releaseIP = false // readPump releases it on disconnect
+
 	client.conn = conn
 	client.server = s
-	client.id = atomic.AddInt64(&s.clientCount, 1)
+	IncrementConnectionsByProtocol(client.codec.Subprotocol())
+
+	client.deflate = nil
+	if deflate != nil && deflate.accepted {
+		client.deflate = newDeflateSession(s.compression, deflate.params)
+		IncrementCompressionNegotiated()
+	}
+
+	// Fresh pump lifecycle for this connection
+	// (a resumed Client's previous pumps have fully exited - see readPump)
+	client.closeOnce = sync.Once{}
+	client.stop = make(chan struct{})
+	client.writerDone = make(chan struct{})
+	client.slowPath = newSlowPathQueue()
+	client.connectedAt = time.Now()
 
 	s.clients.Store(client, true)
 	atomic.AddInt64(&s.stats.TotalConnections, 1)
@@ -622,6 +954,31 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Update Prometheus metrics
 	UpdateConnectionMetrics(s)
 
+	// Required auth without a handshake token: the first message must be "auth"
+	if identity == nil && s.auth.Required() {
+		s.awaitFirstMessageAuth(client, clientIP)
+	}
+
+	// Token exp / max connection lifetime watchdog
+	s.scheduleExpiry(client)
+
+	// Frames queued for the old connection were never written - drop them,
+	// they are all in the replay buffer
+	if resumed {
+		for drained := false; !drained; {
+			select {
+			case <-client.send:
+			default:
+				drained = true
+			}
+		}
+	}
+
+	// Announce the session (queued before any replayed or live frame)
+	if s.sessions.Enabled() && client.pendingResume == nil {
+		s.announceSession(client, lastSeq, resumed)
+	}
+
 	go s.writePump(client)
 	go s.readPump(client)
 }
@@ -635,25 +992,51 @@ func (s *Server) readPump(c *Client) {
 				c.conn.Close()
 			}
 		})
+		close(c.stop) // Stop writePump (don't wait for its next failed write/ping)
+
 		s.clients.Delete(c)
 		atomic.AddInt64(&s.stats.CurrentConnections, -1)
-
-		// Remove client from subscription index (cleanup to prevent memory leak)
-		s.subscriptionIndex.RemoveClient(c)
-
-		s.connections.Put(c)
 		<-s.connectionsSem // Release connection slot
+		s.connLimiter.Release(c.clientIP)
+
+		// Keep the session for the grace period if the client may come back
+		// Not for server-initiated disconnects (slow client, shutdown)
+		if s.sessions.Enabled() && atomic.LoadInt32(&c.disconnecting) == 0 && atomic.LoadInt32(&s.shuttingDown) == 0 {
+			// Pause BEFORE detaching: from here broadcast() only buffers frames
+			c.replayBuffer.Pause()
+			<-c.writerDone // writePump must be gone before the Client can be re-attached
+
+			c.conn = nil
+			c.deflate = nil
+
+			// Resumed but never authenticated: the session stays its owner's
+			if c.pendingResume != nil {
+				c.setIdentity(c.pendingResume.owner)
+				c.pendingResume = nil
+			}
 
-		// Clean up rate limiter state
-		// Important for memory management - prevents leak
-		s.rateLimiter.RemoveClient(c.id)
+			if s.sessions.Detach(c) {
+				s.structLogger.Debug().
+					Int64("client_id", c.id).
+					Str("session_id", c.sessionID).
+					Dur("grace_period", s.config.SessionGracePeriod).
+					Msg("Session detached, awaiting resume")
+				return
+			}
+		}
+
+		s.releaseClient(c)
 	}()
 
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
 	for {
-		msg, op, err := wsutil.ReadClientData(c.conn)
+		// Inflates permessage-deflate frames; wireSize is the on-the-wire payload size
+		msg, op, wireSize, err := c.readMessage(s.config.MaxMessageSize)
 		if err != nil {
+			// Oversized / invalid UTF-8 messages get a specific close code
+			s.closeOnReadViolation(c, err)
+
 			// Log disconnection reason for visibility
 			s.structLogger.Debug().
 				Int64("client_id", c.id).
@@ -666,56 +1049,14 @@ func (s *Server) readPump(c *Client) {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
 		atomic.AddInt64(&s.stats.MessagesReceived, 1)
-		atomic.AddInt64(&s.stats.BytesReceived, int64(len(msg)))
+		atomic.AddInt64(&s.stats.BytesReceived, int64(wireSize))
 		UpdateMessageMetrics(0, 1)
-		UpdateBytesMetrics(0, int64(len(msg)))
-
-		if op == ws.OpText {
-			// Rate limiting check - prevent client from flooding server
-			// Important for:
-			// 1. DoS prevention (malicious client can't overwhelm server)
-			// 2. Bug protection (client code bug causing infinite loop)
-			// 3. Fair resource sharing (one client can't starve others)
-			//
-			// Rate limit: 100 burst, 10/sec sustained
-			// This allows legitimate bursts (rapid order entry) while preventing abuse
-			if !s.rateLimiter.CheckLimit(c.id) {
-				s.logger.Printf("⚠️  Client %d rate limited (exceeded 100 burst or 10/sec sustained)", c.id)
-
-				// Audit log rate limiting event
-				s.auditLogger.Warning("ClientRateLimited", "Client exceeded rate limit", map[string]any{
-					"clientID": c.id,
-					"limit":    "100 burst, 10/sec sustained",
-				})
-
-				// Send error message to client
-				// This helps client-side debugging (they know why messages are being dropped)
-				errorMsg := map[string]any{
-					"type":    "error",
-					"code":    "RATE_LIMIT_EXCEEDED",
-					"message": "Too many messages, please slow down (limit: 10/sec)",
-				}
-
-				if data, err := json.Marshal(errorMsg); err == nil {
-					// Best effort send (don't block if client slow)
-					select {
-					case c.send <- data:
-					default:
-						// Client buffer full, they won't see error anyway
-					}
-				}
-
-				// Increment rate limit counter for monitoring
-				atomic.AddInt64(&s.stats.RateLimitedMessages, 1)
-				IncrementRateLimitedMessages()
-
-				// Drop the message but don't disconnect
-				// Rationale: Might be temporary spike, give them a chance
-				// If persistent, they'll see error messages and fix their code
-				continue
-			}
+		UpdateBytesMetrics(0, int64(wireSize))
 
+		if op == ws.OpText || op == ws.OpBinary {
+			// Text (JSON) or binary (msgpack) - decoded per negotiated codec in handleClientMessage
 			// Process client message (replay requests, heartbeats, etc.)
+			// Rate limited there: the cost depends on the message type (see checkRateLimit)
 			s.handleClientMessage(c, msg)
 
 		} else if op == ws.OpPing {
@@ -727,6 +1068,11 @@ func (s *Server) readPump(c *Client) {
 }
 
 func (s *Server) writePump(c *Client) {
+	// Capture this connection's lifecycle channels (replaced if the session is resumed)
+	stop := c.stop
+	done := c.writerDone
+	defer close(done)
+
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
@@ -741,6 +1087,10 @@ func (s *Server) writePump(c *Client) {
 
 	for {
 		select {
+		case <-stop:
+			// readPump exited - connection is gone
+			return
+
 		case message, ok := <-c.send:
 			if !ok {
 				// The server closed the channel.
@@ -749,7 +1099,9 @@ func (s *Server) writePump(c *Client) {
 					Str("reason", "send_channel_closed").
 					Msg("Client send channel closed, disconnecting")
 				if c.conn != nil {
+					c.writeMu.Lock()
 					wsutil.WriteServerMessage(c.conn, ws.OpClose, []byte{})
+					c.writeMu.Unlock()
 				}
 				return
 			}
@@ -762,8 +1114,14 @@ func (s *Server) writePump(c *Client) {
 				return
 			}
 
+			// writeMu: server-initiated close frames come from other goroutines
+			// (see writeCloseFrame)
+			c.writeMu.Lock()
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			err := wsutil.WriteServerMessage(c.conn, ws.OpText, message)
+			// Text frames for JSON, binary frames for msgpack (negotiated at upgrade)
+			// Deflated if permessage-deflate was negotiated and message >= min size
+			wireSize, err := c.writeMessage(c.codec.OpCode(), message)
+			c.writeMu.Unlock()
 			if err != nil {
 				s.structLogger.Debug().
 					Int64("client_id", c.id).
@@ -774,9 +1132,9 @@ func (s *Server) writePump(c *Client) {
 				return
 			}
 			atomic.AddInt64(&s.stats.MessagesSent, 1)
-			atomic.AddInt64(&s.stats.BytesSent, int64(len(message)))
+			atomic.AddInt64(&s.stats.BytesSent, int64(wireSize))
 			UpdateMessageMetrics(1, 0)
-			UpdateBytesMetrics(int64(len(message)), 0)
+			UpdateBytesMetrics(int64(wireSize), 0)
 
 		case <-ticker.C:
 			if c.conn == nil {
@@ -786,8 +1144,11 @@ func (s *Server) writePump(c *Client) {
 					Msg("Client connection is nil during ping")
 				return
 			}
+			c.writeMu.Lock()
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := wsutil.WriteServerMessage(c.conn, ws.OpPing, nil); err != nil {
+			err := wsutil.WriteServerMessage(c.conn, ws.OpPing, nil)
+			c.writeMu.Unlock()
+			if err != nil {
 				s.structLogger.Debug().
 					Int64("client_id", c.id).
 					Err(err).
@@ -799,53 +1160,18 @@ func (s *Server) writePump(c *Client) {
 	}
 }
 
-// extractChannel extracts the hierarchical channel identifier from a NATS subject
-// NATS subject format: "odin.token.BTC.trade" → extracts "BTC.trade"
-//
-// Subject structure:
-// - Part 0: Namespace ("odin")
-// - Part 1: Type ("token")
-// - Part 2: Symbol ("BTC", "ETH", "SOL")
-// - Part 3: Event Type (REQUIRED) ("trade", "liquidity", "metadata", "social", "favorites", "creation", "analytics", "balances")
-//
-// Event Types (8 channels per symbol):
-// 1. "trade"      - Real-time trading (price, volume) - User-initiated, high-frequency
-// 2. "liquidity"  - Liquidity operations (add/remove) - User-initiated
-// 3. "metadata"   - Token metadata updates - Manual, infrequent
-// 4. "social"     - Comments, reactions - User-initiated
-// 5. "favorites"  - User bookmarks - User-initiated
-// 6. "creation"   - Token launches - User-initiated
-// 7. "analytics"  - Background metrics (holder counts, TVL) - Scheduler-driven, low-frequency
-// 8. "balances"   - Wallet balance changes - User-initiated
-//
-// Returns: "BTC.trade" for "odin.token.BTC.trade" or empty string if invalid format
-//
-// Performance Impact:
-// - Clients subscribe to specific event types: "BTC.trade" instead of all BTC events
-// - 8x reduction in unnecessary messages per subscribed symbol
-// - Example: Trading client subscribes to ["BTC.trade", "ETH.trade"] only
-//
-// Future Enhancement (Phase 2):
-// - Move price deltas from scheduler to real-time trade events (<100ms latency)
-// - See: /Volumes/Dev/Codev/Toniq/ws_poc/docs/production/ODIN_API_IMPROVEMENTS.md
-func extractChannel(subject string) string {
-	parts := strings.Split(subject, ".")
-	if len(parts) >= 4 {
-		// Hierarchical format: "odin.token.BTC.trade" → "BTC.trade"
-		return parts[2] + "." + parts[3]
-	}
-	// Invalid format - event type required
-	return ""
-}
-
 // broadcast sends a message to subscribed clients with reliability guarantees
 // This is the critical path for message delivery in a trading platform
 //
 // Changes from basic WebSocket broadcast:
-// 1. Wraps raw NATS messages in MessageEnvelope with sequence numbers
+// 1. Wraps raw NATS messages in MessageEnvelope with sequence numbers (serialized once, seq spliced per client)
 // 2. Stores in replay buffer BEFORE sending (ensures can replay if send fails)
 // 3. Detects slow clients and disconnects them (prevents head-of-line blocking)
-// 4. Never drops messages silently (either deliver or disconnect client)
+// 4. Priority-aware delivery when a client's send buffer is full:
+//   - NORMAL:   dropped immediately, counted in ws_messages_dropped_total
+//   - HIGH:     retried for up to 100ms after the fast path, 3 strikes → disconnect
+//   - CRITICAL: retried for up to 1s after the fast path, then disconnect (never silently dropped)
+//
 // 5. HIERARCHICAL SUBSCRIPTION FILTERING: Only sends to clients subscribed to specific event types (8x reduction per symbol)
 //
 // Industry standard approach:
@@ -866,16 +1192,21 @@ func extractChannel(subject string) string {
 // - With symbol filtering: 12 × 8 × 500 = 48,000 writes/sec (CPU 80%+)
 // - With hierarchical filtering: 12 × 500 = 6,000 writes/sec (CPU <30%)
 // - Result: 160x reduction vs no filtering, 8x reduction vs symbol-only filtering
-func (s *Server) broadcast(subject string, message []byte) {
-	// Extract hierarchical channel from NATS subject for subscription filtering
-	// Example: "odin.token.BTC.trade" → "BTC.trade"
-	channel := extractChannel(subject)
-
-	// Edge case: If channel is empty (malformed NATS subject), skip broadcast entirely
-	// Invalid subjects indicate misconfigured publisher - should never happen in production
-	if channel == "" {
-		return
-	}
+//
+// channel: mapped from the NATS subject by the message's stream source
+// (see stream_sources.go), e.g. "odin.token.BTC.trade" → "BTC.trade"
+//
+// source: the stream source the message was consumed from
+//
+// streamSeq/prevChannelSeq: JetStream stream sequence of this message and of
+// the previous message on the same channel (0 = chain starts here)
+// prevUnknown: redelivery - the previous message is unknown (see ChannelSequencer)
+func (s *Server) broadcast(source *StreamSource, channel string, message []byte, streamSeq, prevChannelSeq uint64, prevUnknown bool) {
+
+	// Cache as the channel's last value BEFORE the subscriber check - quiet
+	// channels nobody watches yet are exactly the ones a new subscriber needs
+	timestamp := time.Now().UnixMilli()
+	s.lastValues.Set(channel, message, timestamp, streamSeq)
 
 	// SUBSCRIPTION INDEX OPTIMIZATION: Directly lookup subscribers for this channel
 	// Instead of iterating ALL clients and filtering, only iterate subscribed clients!
@@ -894,44 +1225,81 @@ func (s *Server) broadcast(subject string, message []byte) {
 		return // No subscribers for this channel
 	}
 
+	// Resolve envelope type and priority once per broadcast (same for every subscriber)
+	// Example: "BTC.trade" → token:trade/HIGH, "BTC.social" → token:social/NORMAL
+	route := s.messageRouter.Resolve(channel)
+
+	// Serialize ONCE per wire codec for all subscribers - only seq differs per client
+	// 5,000 subscribers: 1 json.Marshal instead of 10,000
+	// JSON is prepared eagerly (validates payload, most clients use it);
+	// other codecs are prepared on first subscriber that negotiated them
+	prepared := newPreparedSet(message, EnvelopeHeader{
+		Timestamp:      timestamp,
+		Type:           route.Type,
+		Priority:       route.Priority,
+		StreamSeq:      streamSeq,
+		Channel:        channel,
+		ChannelSeq:     streamSeq, // Channel sequences are stream sequences (see ChannelSequencer)
+		PrevChannelSeq: prevChannelSeq,
+		PrevUnknown:    prevUnknown,
+	})
+	if _, err := prepared.get(jsonWireCodec); err != nil {
+		RecordSerializationError(ErrorSeverityWarning)
+		s.logger.Printf("❌ Failed to serialize message for channel %s: %v", channel, err)
+		return
+	}
+
+	// Deep replay reads the primary stream only: stream sequences of other
+	// sources live in another sequence space and must not bound a handoff
+	replayStreamSeq := streamSeq
+	if source != s.primarySource() {
+		replayStreamSeq = 0
+	}
+
 	// Track broadcast metrics for debug logging
 	totalCount := len(subscribers)
 	successCount := 0
 
 	// Iterate ONLY subscribed clients (not all clients!)
 	for _, client := range subscribers {
-		// Wrap raw NATS message in envelope with sequence number
-		// Message type: "price:update" (could be parsed from NATS subject)
-		// Priority: HIGH (trading data is critical but not life-or-death)
-		envelope, err := WrapMessage(message, "price:update", PRIORITY_HIGH, client.seqGen)
+		// Shared frame in this client's negotiated encoding (JSON or msgpack)
+		frame, err := prepared.get(client.codec)
 		if err != nil {
-			RecordBroadcastError(ErrorSeverityWarning)
-			s.logger.Printf("❌ Failed to wrap message for client %d: %v", client.id, err)
+			RecordSerializationError(ErrorSeverityWarning)
+			s.logger.Printf("❌ Failed to encode message for client %d (%s): %v", client.id, client.codec.Subprotocol(), err)
 			continue // Skip to next client
 		}
 
+		// Splice this client's sequence number into the shared frame
+		// (no per-client json.Marshal - see PreparedMessage)
+		seq := client.seqGen.Next()
+		data := frame.Frame(seq)
+
 		// Add to replay buffer BEFORE sending
 		// Critical: If send fails, client can request replay
 		// If we added AFTER send, failed sends wouldn't be replayable
-		client.replayBuffer.Add(envelope)
+		// The frame is stored as-is (shared with send queue, not re-marshaled)
+		if !client.replayBuffer.AddFrame(seq, replayStreamSeq, data) {
+			// Session detached (client reconnecting) - frame is replayed on resume
+			continue
+		}
 
-		// Serialize envelope to JSON for WebSocket transmission
-		data, err := envelope.Serialize()
-		if err != nil {
-			RecordSerializationError(ErrorSeverityWarning)
-			s.logger.Printf("❌ Failed to serialize message for client %d: %v", client.id, err)
-			continue // Skip to next client
+		// Frames already waiting in the client's slow path go first - sending
+		// this one directly would overtake them (seq order is per client)
+		slowPath := client.slowPath
+		if slowPath.waiting() {
+			s.enqueueSlowPath(client, slowPath, data, route.Priority)
+			continue
 		}
 
 		// Attempt to send - COMPLETELY NON-BLOCKING
 		// Critical fix: Do not use time.After() which blocks the entire broadcast
-		// Instead, immediately detect full buffers and mark client as slow
+		// Instead, immediately detect full buffers and apply the priority policy
 		select {
 		case client.send <- data:
 			// Success - message queued for writePump to send
 			// Reset failure counter (client is healthy)
-			atomic.StoreInt32(&client.sendAttempts, 0)
-			client.lastMessageSentAt = time.Now()
+			client.markSent()
 			successCount++
 
 		default:
@@ -941,57 +1309,9 @@ func (s *Server) broadcast(subject string, message []byte) {
 			// 2. Client device overloaded (CPU pegged, memory swapping)
 			// 3. Client code has bug (not reading messages)
 			//
-			// CRITICAL: We do NOT block here. We increment failure counter
-			// and let the cleanup goroutine handle disconnection.
-			// This keeps broadcast fast (~1-2ms) regardless of slow clients.
-			attempts := atomic.AddInt32(&client.sendAttempts, 1)
-
-			// Log warning on first failure (avoid spam)
-			// Use atomic CompareAndSwap to avoid race condition
-			if attempts == 1 && atomic.CompareAndSwapInt32(&client.slowClientWarned, 0, 1) {
-				s.logger.Printf("⚠️  Client %d is slow (send buffer full)", client.id)
-			}
-
-			// Disconnect after 3 consecutive failures (industry standard)
-			// Why 3? Balance between:
-			// - Too low (1-2): False positives from brief network hiccups
-			// - Too high (5+): Slow client wastes resources too long
-			if attempts >= 3 {
-				s.logger.Printf("❌ Disconnecting client %d (too slow: %d consecutive send failures)", client.id, attempts)
-
-				// Audit log slow client disconnection
-				s.auditLogger.Warning("SlowClientDisconnected", "Client disconnected for being too slow", map[string]any{
-					"clientID":         client.id,
-					"consecutiveFails": attempts,
-				})
-
-				// Send WebSocket close frame with reason code
-				// Close code 1008 = Policy Violation (client too slow)
-				// This helps client-side debugging (clear error message)
-				// Standard close codes:
-				//   1000 = Normal closure
-				//   1001 = Going away (server restart)
-				//   1008 = Policy violation (rate limit, too slow)
-				//   1011 = Internal error
-				// CRITICAL FIX: Capture conn pointer locally to prevent TOCTOU race condition
-				// Race scenario: readPump/writePump may set client.conn = nil between our
-				// nil check and usage, causing panic. Local variable is safe even if
-				// client.conn becomes nil after we capture it.
-				conn := client.conn
-				if conn != nil {
-					closeMsg := ws.NewCloseFrameBody(ws.StatusPolicyViolation, "Client too slow to process messages")
-					ws.WriteFrame(conn, ws.NewCloseFrame(closeMsg))
-					conn.Close()
-				}
-
-				// Increment slow client counter for monitoring
-				// If this counter is high (>1% of connections), indicates:
-				// - Network infrastructure issues
-				// - Client app performance issues
-				// - Need to optimize message size/frequency
-				atomic.AddInt64(&s.stats.SlowClientsDisconnected, 1)
-				IncrementSlowClientDisconnects()
-			}
+			// NORMAL is dropped, HIGH/CRITICAL wait in the client's slow path -
+			// this worker moves on, so one slow client never delays the rest
+			s.enqueueSlowPath(client, slowPath, data, route.Priority)
 		}
 	}
 
@@ -1001,6 +1321,8 @@ func (s *Server) broadcast(subject string, message []byte) {
 		successRate := float64(successCount) / float64(totalCount) * 100
 		s.structLogger.Debug().
 			Str("channel", channel).
+			Str("message_type", route.Type).
+			Str("priority", route.Priority.String()).
 			Int("subscribers", totalCount).
 			Int("sent_successfully", successCount).
 			Float64("success_rate_pct", successRate).
@@ -1008,6 +1330,475 @@ func (s *Server) broadcast(subject string, message []byte) {
 	}
 }
 
+// slowPathQueue holds one connection's HIGH/CRITICAL frames that didn't fit
+// in its send buffer, in seq order
+//
+// One drainer goroutine per queue moves them into send, each frame waiting at
+// most until its own deadline (enqueue time + policy timeout), so:
+//   - the broadcast worker never blocks on a slow client
+//   - frames reach send in the order broadcast produced them: while the queue
+//     is non-empty, later frames are queued behind it instead of sent directly
+//
+// Allocated per connection (see handleConnection): a drainer left over from
+// a previous connection only ever sees its own queue and stop channel
+type slowPathQueue struct {
+	mu      sync.Mutex
+	frames  []slowPathFrame
+	running bool  // Drainer goroutine active
+	pending int32 // 1 while frames are queued or being delivered (atomic, fast-path check)
+}
+
+// slowPathFrame is one frame waiting for room in a client's send buffer
+type slowPathFrame struct {
+	data     []byte
+	priority MessagePriority
+	deadline time.Time
+}
+
+func newSlowPathQueue() *slowPathQueue {
+	return &slowPathQueue{}
+}
+
+// waiting reports whether frames are queued or being delivered (lock-free)
+func (q *slowPathQueue) waiting() bool {
+	return atomic.LoadInt32(&q.pending) == 1
+}
+
+// enqueueSlowPath applies the priority policy to a frame that can't be sent
+// directly (send buffer full, or earlier frames still in the slow path)
+//
+// Policy (see MessagePriority):
+// - NORMAL:   dropped (stays in the replay buffer, client sees a seq gap)
+// - HIGH:     waits up to 100ms, then counts a strike (disconnect after 3 consecutive)
+// - CRITICAL: waits up to 1s, then disconnects - never silently dropped
+//
+// A client more than maxSlowPathFrames behind misses the frame right away
+func (s *Server) enqueueSlowPath(c *Client, q *slowPathQueue, data []byte, priority MessagePriority) {
+	if priority == PRIORITY_NORMAL {
+		s.recordDroppedMessage(c, priority)
+		return
+	}
+
+	timeout := highPriorityBlockTimeout
+	if priority == PRIORITY_CRITICAL {
+		timeout = criticalPriorityBlockTimeout
+	}
+
+	q.mu.Lock()
+	if len(q.frames) >= maxSlowPathFrames {
+		q.mu.Unlock()
+		s.missSlowPath(c, priority)
+		return
+	}
+	q.frames = append(q.frames, slowPathFrame{data: data, priority: priority, deadline: time.Now().Add(timeout)})
+	atomic.StoreInt32(&q.pending, 1)
+	start := !q.running
+	q.running = true
+	q.mu.Unlock()
+
+	if start {
+		s.wg.Add(1)
+		go s.drainSlowPath(c, q, c.stop)
+	}
+}
+
+// drainSlowPath moves a client's slow-path frames into its send buffer in
+// order, until the queue is empty or the connection stops
+//
+// A frame that misses its deadline is handled by missSlowPath and the next
+// one is tried. A connection that stops meanwhile drops the rest (the frames
+// are in its replay buffer). deliveryMu is held while waiting so
+// releaseClient can't pool the Client under us - a late frame must never
+// reach its next owner.
+func (s *Server) drainSlowPath(c *Client, q *slowPathQueue, stop <-chan struct{}) {
+	defer s.wg.Done()
+
+	for {
+		q.mu.Lock()
+		if len(q.frames) == 0 {
+			q.running = false
+			atomic.StoreInt32(&q.pending, 0)
+			q.mu.Unlock()
+			return
+		}
+		frame := q.frames[0]
+		q.frames[0] = slowPathFrame{}
+		q.frames = q.frames[1:]
+		q.mu.Unlock()
+
+		if !s.deliverSlowPathFrame(c, stop, frame) {
+			q.mu.Lock()
+			q.frames = nil
+			q.running = false
+			atomic.StoreInt32(&q.pending, 0)
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// deliverSlowPathFrame waits for room in c.send until the frame's deadline
+// Returns false if the connection stopped or the server is shutting down
+func (s *Server) deliverSlowPathFrame(c *Client, stop <-chan struct{}, frame slowPathFrame) bool {
+	c.deliveryMu.RLock()
+	defer c.deliveryMu.RUnlock()
+
+	if replayStopped(stop) {
+		return false
+	}
+
+	timer := time.NewTimer(time.Until(frame.deadline))
+	defer timer.Stop()
+
+	select {
+	case c.send <- frame.data:
+		c.markSent()
+		return true
+
+	case <-stop:
+		return false // Disconnected while waiting
+
+	case <-s.ctx.Done():
+		return false
+
+	case <-timer.C:
+		s.missSlowPath(c, frame.priority)
+		return true
+	}
+}
+
+// missSlowPath applies the policy for a HIGH/CRITICAL message that couldn't
+// be queued before its deadline (or found the client's slow path full)
+func (s *Server) missSlowPath(c *Client, priority MessagePriority) {
+	if priority == PRIORITY_CRITICAL {
+		// Can't deliver within 1s - disconnect rather than silently drop
+		// Message remains in replay buffer for recovery after reconnect
+		s.recordDroppedMessage(c, priority)
+		s.disconnectSlowClient(c, "critical message undeliverable within timeout")
+		return
+	}
+
+	s.recordDroppedMessage(c, priority)
+
+	// HIGH: strike counting (industry standard)
+	attempts := atomic.AddInt32(&c.sendAttempts, 1)
+
+	// Log warning on first failure (avoid spam)
+	// Use atomic CompareAndSwap to avoid race condition
+	if attempts == 1 && atomic.CompareAndSwapInt32(&c.slowClientWarned, 0, 1) {
+		s.logger.Printf("⚠️  Client %d is slow (send buffer full)", c.id)
+	}
+
+	// Disconnect after 3 consecutive failures (industry standard)
+	// Why 3? Balance between:
+	// - Too low (1-2): False positives from brief network hiccups
+	// - Too high (5+): Slow client wastes resources too long
+	if attempts >= maxSlowClientStrikes {
+		s.disconnectSlowClient(c, fmt.Sprintf("%d consecutive send failures", attempts))
+	}
+}
+
+// recordDroppedMessage counts a message that was not queued for a client
+// The message is still in the client's replay buffer (gap is recoverable)
+func (s *Server) recordDroppedMessage(c *Client, priority MessagePriority) {
+	IncrementDroppedMessages(priority)
+	s.structLogger.Debug().
+		Int64("client_id", c.id).
+		Str("priority", priority.String()).
+		Msg("Message dropped - client send buffer full")
+}
+
+// disconnectSlowClient closes a client that cannot keep up with delivery
+// Safe to call repeatedly: only the first call per connection takes effect
+func (s *Server) disconnectSlowClient(c *Client, reason string) {
+	if !atomic.CompareAndSwapInt32(&c.disconnecting, 0, 1) {
+		return // Already being disconnected
+	}
+
+	s.logger.Printf("❌ Disconnecting client %d (too slow: %s)", c.id, reason)
+
+	// Audit log slow client disconnection
+	s.auditLogger.Warning("SlowClientDisconnected", "Client disconnected for being too slow", map[string]any{
+		"clientID":         c.id,
+		"consecutiveFails": atomic.LoadInt32(&c.sendAttempts),
+		"reason":           reason,
+	})
+
+	// Send WebSocket close frame with reason code
+	// Close code 1008 = Policy Violation (client too slow)
+	// This helps client-side debugging (clear error message)
+	// Standard close codes:
+	//   1000 = Normal closure
+	//   1001 = Going away (server restart)
+	//   1008 = Policy violation (rate limit, too slow)
+	//   1011 = Internal error
+	// CRITICAL FIX: Capture conn pointer locally to prevent TOCTOU race condition
+	// Race scenario: readPump/writePump may set client.conn = nil between our
+	// nil check and usage, causing panic. Local variable is safe even if
+	// client.conn becomes nil after we capture it.
+	// Written in the background - a broadcast worker must not wait for the
+	// slow client's writePump (see writeCloseFrame)
+	conn := c.conn
+	if conn != nil {
+		go c.writeCloseFrame(conn, ws.StatusPolicyViolation, "Client too slow to process messages")
+	}
+
+	// Increment slow client counter for monitoring
+	// If this counter is high (>1% of connections), indicates:
+	// - Network infrastructure issues
+	// - Client app performance issues
+	// - Need to optimize message size/frequency
+	atomic.AddInt64(&s.stats.SlowClientsDisconnected, 1)
+	IncrementSlowClientDisconnects()
+}
+
+// closeClient closes a connection with a WebSocket close code and reason
+// Server-initiated (like disconnectSlowClient): the session is not kept for resume
+// Doesn't block: the close frame is written in the background (see writeCloseFrame)
+func (s *Server) closeClient(c *Client, code ws.StatusCode, reason string) {
+	if conn := s.beginClose(c); conn != nil {
+		go c.writeCloseFrame(conn, code, reason)
+	}
+}
+
+// beginClose marks a client as being disconnected by the server
+// Returns the connection to close, nil if another close won (or no connection)
+func (s *Server) beginClose(c *Client) net.Conn {
+	if !atomic.CompareAndSwapInt32(&c.disconnecting, 0, 1) {
+		return nil // Already being disconnected
+	}
+
+	// Capture conn locally (see disconnectSlowClient for the TOCTOU race)
+	return c.conn
+}
+
+// writeCloseFrame writes a close frame, then closes the connection
+//
+// Close frames come from other goroutines than writePump (expiry watchdog,
+// broadcast workers, admin API, ban reload): writeMu keeps them from landing
+// in the middle of a message writePump is writing - interleaved bytes corrupt
+// the stream, and a half-written deflated message the client's inflate state.
+// Waits at most writeWait for the write in progress.
+func (c *Client) writeCloseFrame(conn net.Conn, code ws.StatusCode, reason string) {
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	ws.WriteFrame(conn, ws.NewCloseFrame(ws.NewCloseFrameBody(code, reason)))
+	c.writeMu.Unlock()
+	conn.Close()
+}
+
+// releaseClient frees everything a client holds once it can no longer be resumed
+// Called on disconnect (sessions disabled / not resumable) or when a detached
+// session expires
+func (s *Server) releaseClient(c *Client) {
+	// Remove client from subscription index (cleanup to prevent memory leak)
+	s.subscriptionIndex.RemoveClient(c)
+
+	// Clean up rate limiter state
+	// Important for memory management - prevents leak
+	s.rateLimiter.RemoveClient(c.id)
+
+	// A deep replay still running would keep queueing into c.send - its
+	// connection's stop is closed, so it returns within one fetch round
+	c.deepReplayWG.Wait()
+
+	// Same for a stream snapshot lookup (aborted by the closed stop)
+	c.snapshotWG.Wait()
+
+	// And for slow-path HIGH/CRITICAL frames still waiting for room in
+	// c.send (see drainSlowPath) - they hold deliveryMu and see the closed
+	// stop; once the lock is ours none is in flight and new ones skip the Client
+	c.deliveryMu.Lock()
+	c.deliveryMu.Unlock()
+
+	s.connections.Put(c)
+}
+
+// pendingResume is a session resumed before first-message auth
+// Its owner is checked when the auth message arrives (see finishPendingResume)
+type pendingResume struct {
+	owner   *ClientIdentity // Identity the session was created for
+	lastSeq int64           // ?last_seq= of the resume request
+}
+
+// resumeSession looks up the detached session named by ?resume_token=
+// Returns nil if there is no token or the session can't be resumed
+// A session only resumes for the same user it was created for (or anonymous → anonymous)
+// Under first-message auth that check is deferred to the auth message
+func (s *Server) resumeSession(r *http.Request, codec WireCodec, identity *ClientIdentity, clientIP string) *Client {
+	token := r.URL.Query().Get("resume_token")
+	if token == "" || !s.sessions.Enabled() {
+		return nil
+	}
+
+	c, offline, err := s.sessions.Resume(token)
+	if err != nil {
+		reason := "not_found"
+		if errors.Is(err, ErrSessionTokenInvalid) {
+			reason = "invalid_token"
+		}
+		IncrementSessionResumeFailures(reason)
+		s.structLogger.Debug().
+			Err(err).
+			Str("remote_addr", clientIP).
+			Msg("Session resume failed, starting new session")
+		return nil
+	}
+
+	// Replay buffer holds frames in the ORIGINAL encoding - can't switch codecs mid-session
+	if c.codec != codec {
+		IncrementSessionResumeFailures("codec_mismatch")
+		s.structLogger.Debug().
+			Str("session_id", c.sessionID).
+			Str("session_protocol", c.codec.Subprotocol()).
+			Str("requested_protocol", codec.Subprotocol()).
+			Msg("Session resume rejected (subprotocol changed), starting new session")
+		s.releaseClient(c)
+		return nil
+	}
+
+	// A leaked resume token must not hand one user's session to another
+	if !s.resumeAwaitsAuth(identity) && sessionUserID(c.identity) != sessionUserID(identity) {
+		IncrementSessionResumeFailures("identity_mismatch")
+		s.auditLogger.Warning("SessionResumeRejected", "Resume token presented by a different user", map[string]any{
+			"sessionID":  c.sessionID,
+			"remoteAddr": clientIP,
+		})
+		s.releaseClient(c)
+		return nil
+	}
+
+	// Reset slow client detection for the new connection
+	atomic.StoreInt64(&c.lastMessageSentAt, time.Now().UnixNano())
+	atomic.StoreInt32(&c.sendAttempts, 0)
+	atomic.StoreInt32(&c.slowClientWarned, 0)
+
+	IncrementSessionsResumed()
+	s.structLogger.Info().
+		Int64("client_id", c.id).
+		Str("session_id", c.sessionID).
+		Dur("offline", offline).
+		Int("subscriptions", c.subscriptions.Count()).
+		Msg("Session resumed")
+
+	return c
+}
+
+// resumeAwaitsAuth reports whether a connection's user is only known after
+// its first message (required auth, no handshake token)
+func (s *Server) resumeAwaitsAuth(identity *ClientIdentity) bool {
+	return identity == nil && s.auth.Required()
+}
+
+// finishPendingResume announces and replays a session resumed before
+// first-message auth, once the auth message identified the connection
+// Called from readPump (handleAuthMessage); a different user is disconnected -
+// the resume token was consumed, so a reconnect gets a fresh session
+// Returns false if the connection was closed
+func (s *Server) finishPendingResume(c *Client) bool {
+	pending := c.pendingResume
+	c.pendingResume = nil
+
+	if sessionUserID(pending.owner) != sessionUserID(c.identity) {
+		IncrementSessionResumeFailures("identity_mismatch")
+		s.auditLogger.Warning("SessionResumeRejected", "Resume token presented by a different user", map[string]any{
+			"sessionID":  c.sessionID,
+			"remoteAddr": c.clientIP,
+			"userID":     sessionUserID(c.identity),
+		})
+		s.closeClient(c, ws.StatusPolicyViolation, "Session belongs to another user")
+		return false
+	}
+
+	s.announceSession(c, pending.lastSeq, true)
+	return true
+}
+
+// sessionUserID returns the user a session belongs to ("" = anonymous)
+func sessionUserID(identity *ClientIdentity) string {
+	if identity == nil {
+		return ""
+	}
+	return identity.UserID
+}
+
+// announceSession sends the session message (with a freshly rotated resume
+// token) and, for a resumed session, replays everything after lastSeq
+//
+// Runs before the pumps start (or, for a pending resume, from readPump after
+// auth) - broadcast() can't queue anything for the session until
+// replayBuffer.Resume returns, so the gap always precedes live frames
+func (s *Server) announceSession(c *Client, lastSeq int64, resumed bool) {
+	// A resumed session's subscriptions were authorized under the old token -
+	// drop the ones the new claims no longer allow before anything is replayed
+	if resumed {
+		s.reauthorizeSubscriptions(c)
+	}
+
+	token, err := s.sessions.Issue(c)
+	if err != nil {
+		s.logger.Printf("❌ Failed to issue session token for client %d: %v", c.id, err)
+		return
+	}
+
+	msg := map[string]any{
+		"type":         "session",
+		"session_id":   c.sessionID,
+		"resume_token": token,
+		"resumed":      resumed,
+	}
+
+	if !resumed {
+		s.sendControlMessage(c, msg)
+		return
+	}
+
+	// Session message, then the gap, then live frames (see ReplayBuffer.Resume)
+	c.replayBuffer.Resume(lastSeq, func(frames [][]byte, missed int64) {
+		msg["last_seq"] = lastSeq
+		msg["replayed"] = len(frames)
+		msg["missed"] = missed // > 0: evicted while offline, client must resync
+		s.sendControlMessage(c, msg)
+
+		// Send buffer (512) was just drained and is larger than the replay buffer (100)
+		sent := 0
+		for _, frame := range frames {
+			select {
+			case c.send <- frame:
+				sent++
+			default:
+			}
+		}
+
+		s.structLogger.Debug().
+			Int64("client_id", c.id).
+			Str("session_id", c.sessionID).
+			Int64("last_seq", lastSeq).
+			Int("replayed", sent).
+			Int64("missed", missed).
+			Msg("Session gap replayed")
+	})
+}
+
+// sendControlMessage encodes a non-envelope message (ack, error, pong) in the
+// client's negotiated wire codec and queues it without blocking
+// Returns false if encoding failed or the client's send buffer is full
+func (s *Server) sendControlMessage(c *Client, msg map[string]any) bool {
+	data, err := c.codec.Marshal(msg)
+	if err != nil {
+		RecordSerializationError(ErrorSeverityWarning)
+		s.logger.Printf("❌ Failed to encode control message for client %d: %v", c.id, err)
+		return false
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
 // handleClientMessage processes incoming WebSocket messages from clients
 // Trading clients send various message types:
 // 1. "replay" - Request missed messages (gap recovery after network issue)
@@ -1015,7 +1806,7 @@ func (s *Server) broadcast(subject string, message []byte) {
 // 3. "subscribe" - Subscribe to specific symbols (future enhancement)
 // 4. "unsubscribe" - Unsubscribe from symbols (future enhancement)
 //
-// Message format (JSON):
+// Message format (JSON, or the same structure in MessagePack for odin.msgpack.v1 clients):
 //
 //	{
 //	  "type": "replay",
@@ -1034,12 +1825,43 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 		Data json.RawMessage `json:"data"`
 	}
 
+	// Binary codecs (msgpack) are transcoded to JSON so a single parser handles every encoding
+	data, err := c.codec.DecodeRequest(data)
+	if err != nil {
+		s.checkRateLimit(c, "", nil) // Garbage costs too (1 token)
+		s.logger.Printf("⚠️  Client %d sent undecodable %s message: %v", c.id, c.codec.Subprotocol(), err)
+		return
+	}
+
 	if err := json.Unmarshal(data, &req); err != nil {
+		s.checkRateLimit(c, "", nil)
 		s.logger.Printf("⚠️  Client %d sent invalid JSON: %v", c.id, err)
 		return
 	}
 
+	// Rate limiting - charged by message type before any work is done
+	if !s.checkRateLimit(c, req.Type, req.Data) {
+		return
+	}
+
+	// Awaiting first-message auth: nothing else is accepted
+	if atomic.LoadInt32(&c.authPending) == 1 && req.Type != "auth" {
+		s.rejectAuth(c.clientIP, "message", ErrAuthTokenMissing)
+		s.closeClient(c, CloseCodeUnauthorized, "Authentication required")
+		return
+	}
+
 	switch req.Type {
+	case "auth":
+		// First-message authentication (see auth.go)
+		// Message format: {"type": "auth", "data": {"token": "<jwt>"}}
+		s.handleAuthMessage(c, req.Data)
+
+	case "reauth":
+		// In-band token refresh (see connection_lifetime.go)
+		// Message format: {"type": "reauth", "data": {"token": "<new jwt>"}}
+		s.handleReauthMessage(c, req.Data)
+
 	case "replay":
 		// Client detected gap in sequence numbers, requesting missed messages
 		// Example scenario:
@@ -1052,10 +1874,17 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 		// - Replay: 10-50ms (send buffered messages)
 		//
 		// Especially important for mobile clients with flaky networks
+		//
+		// Longer outages outrun the 100-frame buffer - those are served from
+		// JetStream instead (see deep_replay.go):
+		//   {"type": "replay", "data": {"stream_seq": 98231}}         - from a stream sequence
+		//   {"type": "replay", "data": {"start_time": 1718000000000}} - from a time (Unix ms)
 		var replayReq struct {
-			From  int64 `json:"from"`  // Start sequence (inclusive)
-			To    int64 `json:"to"`    // End sequence (inclusive)
-			Since int64 `json:"since"` // Alternative: "give me everything after X"
+			From      int64  `json:"from"`       // Start sequence (inclusive)
+			To        int64  `json:"to"`         // End sequence (inclusive)
+			Since     int64  `json:"since"`      // Alternative: "give me everything after X"
+			StreamSeq uint64 `json:"stream_seq"` // Deep replay: first JetStream stream sequence
+			StartTime int64  `json:"start_time"` // Deep replay: first message time (Unix ms)
 		}
 
 		if err := json.Unmarshal(req.Data, &replayReq); err != nil {
@@ -1063,16 +1892,67 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 			return
 		}
 
+		// Explicit deep replay - the client tracked stream_seq (or time) itself
+		if replayReq.StreamSeq > 0 || replayReq.StartTime > 0 {
+			if !s.deepReplayAvailable() {
+				s.sendControlMessage(c, map[string]any{
+					"type":    "error",
+					"code":    "DEEP_REPLAY_UNAVAILABLE",
+					"message": "Replay from stream is disabled on this server",
+				})
+				return
+			}
+
+			s.logger.Printf("📬 Client %d requesting deep replay: stream_seq %d, start_time %d", c.id, replayReq.StreamSeq, replayReq.StartTime)
+
+			deepReq := deepReplayRequest{startSeq: replayReq.StreamSeq, exact: true}
+			if replayReq.StreamSeq == 0 {
+				deepReq.startTime = time.UnixMilli(replayReq.StartTime)
+			}
+			s.startDeepReplay(c, deepReq)
+
+			atomic.AddInt64(&s.stats.MessageReplayRequests, 1)
+			IncrementReplayRequests()
+			return
+		}
+
 		s.logger.Printf("📬 Client %d requesting replay: seq %d to %d", c.id, replayReq.From, replayReq.To)
 
 		// Get messages from replay buffer
-		var messages []*MessageEnvelope
+		// Frames are stored already encoded in the client's wire codec,
+		// so they are sent as-is (no decode/re-encode round trip)
+		var messages [][]byte
 		if replayReq.Since > 0 {
 			// "Give me everything after seq X" (used during reconnection)
-			messages = c.replayBuffer.GetSince(replayReq.Since)
+			messages = c.replayBuffer.GetSinceFrames(replayReq.Since)
 		} else {
 			// "Give me seq X to Y" (used for gap filling)
-			messages = c.replayBuffer.GetRange(replayReq.From, replayReq.To)
+			messages = c.replayBuffer.GetRangeFrames(replayReq.From, replayReq.To)
+		}
+
+		// Requested start is older than the buffer: frames were evicted, so the
+		// missing head comes from JetStream, followed by the buffered frames.
+		// The client seq → stream seq mapping of evicted frames is gone, so the
+		// stream is read up to the oldest buffered frame (exact: false - the
+		// client dedupes by stream_seq)
+		startSeq := replayReq.From
+		if replayReq.Since > 0 {
+			startSeq = replayReq.Since + 1
+		}
+		coverage := c.replayBuffer.Coverage()
+		if s.deepReplayAvailable() && coverage.EvictedStreamSeq != 0 &&
+			coverage.OldestStreamSeq > 1 && startSeq < coverage.OldestSeq {
+			s.logger.Printf("📬 Replay buffer exhausted for client %d (oldest seq %d), replaying from stream", c.id, coverage.OldestSeq)
+
+			s.startDeepReplay(c, deepReplayRequest{
+				endSeq: coverage.OldestStreamSeq - 1,
+				tail:   messages,
+				exact:  false,
+			})
+
+			atomic.AddInt64(&s.stats.MessageReplayRequests, 1)
+			IncrementReplayRequests()
+			return
 		}
 
 		s.logger.Printf("📬 Replaying %d messages to client %d", len(messages), c.id)
@@ -1081,13 +1961,7 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 		// Note: These already have sequence numbers (from when originally sent)
 		sentCount := 0
 	replayLoop:
-		for i, msg := range messages {
-			data, err := msg.Serialize()
-			if err != nil {
-				s.logger.Printf("❌ Failed to serialize replay message %d: %v", i, err)
-				continue
-			}
-
+		for _, data := range messages {
 			// Send with 3 second timeout (increased from 1s for better recovery)
 			// Replay is critical for gap recovery, so we're more patient
 			select {
@@ -1105,19 +1979,13 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 
 				// Notify client that replay was incomplete
 				// Client can request another replay or reconnect
-				errorMsg := map[string]any{
+				// Best effort send (don't wait if client buffer full)
+				s.sendControlMessage(c, map[string]any{
 					"type":    "replay_incomplete",
 					"sent":    sentCount,
 					"total":   len(messages),
 					"message": fmt.Sprintf("Replay incomplete: sent %d of %d messages", sentCount, len(messages)),
-				}
-				if errorData, err := json.Marshal(errorMsg); err == nil {
-					// Best effort send (don't wait if client buffer full)
-					select {
-					case c.send <- errorData:
-					default:
-					}
-				}
+				})
 
 				break replayLoop
 			}
@@ -1145,20 +2013,12 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 		// So they send application-level heartbeats
 		//
 		// We respond with current server time (helps detect clock skew)
-		pong := map[string]any{
+		// If can't send pong, client buffer is full
+		// Don't worry about it, regular heartbeat will fail eventually
+		s.sendControlMessage(c, map[string]any{
 			"type": "pong",
 			"ts":   time.Now().UnixMilli(),
-		}
-
-		if data, err := json.Marshal(pong); err == nil {
-			select {
-			case c.send <- data:
-				// Sent
-			default:
-				// If can't send pong, client buffer is full
-				// Don't worry about it, regular heartbeat will fail eventually
-			}
-		}
+		})
 
 	case "subscribe":
 		// Client subscribing to hierarchical channels (symbol.eventType)
@@ -1183,8 +2043,13 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 		// - Without filtering: 12 × 8 events × 10K = 960K writes/sec (CPU overload)
 		// - With hierarchical: 12 × avg 2K subscribers = 24K writes/sec (CPU <30%)
 		// - Result: 40x reduction in broadcast overhead
+		//
+		// Wildcard patterns (NATS-style) are also accepted:
+		// - "BTC.*"   - Every event type for BTC (replaces 8 separate channels)
+		// - "*.trade" - Trade events for every symbol (market-wide ticker)
+		// - "BTC.>"   - BTC and anything nested below it
 		var subReq struct {
-			Channels []string `json:"channels"` // List of hierarchical channels (e.g., "BTC.trade")
+			Channels []string `json:"channels"` // List of hierarchical channels or patterns (e.g., "BTC.trade", "*.trade")
 		}
 
 		if err := json.Unmarshal(req.Data, &subReq); err != nil {
@@ -1192,33 +2057,67 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 			return
 		}
 
+		// Bounded request size - thousands of channels in one message are rejected whole
+		if !s.checkChannelsPerRequest(c, "subscribe", subReq.Channels) {
+			return
+		}
+
+		// Reject malformed channels/patterns ("BTC..trade", "BTC.>.trade", "BT*.trade")
+		// and unknown event types before they reach the index (see channel_validation.go)
+		channels, rejected := s.validateChannels(subReq.Channels)
+
+		// Per-channel authorization: denied channels are reported, not subscribed
+		channels, denied := s.authorizeChannels(c, channels)
+
+		// Per-client quota: channels past WS_MAX_SUBSCRIPTIONS_PER_CLIENT are rejected
+		channels, overQuota := s.applySubscriptionQuota(c, channels)
+		rejected = append(rejected, overQuota...)
+
+		// Only newly subscribed channels get a snapshot (re-subscribing is a no-op)
+		added := make([]string, 0, len(channels))
+		for _, channel := range channels {
+			if !c.subscriptions.Has(channel) {
+				added = append(added, channel)
+			}
+		}
+
 		// Add subscriptions to client's local set
-		c.subscriptions.AddMultiple(subReq.Channels)
+		c.subscriptions.AddMultiple(channels)
 
 		// Add to global subscription index for fast broadcast targeting
-		s.subscriptionIndex.AddMultiple(subReq.Channels, c)
+		s.subscriptionIndex.AddMultiple(channels, c)
 
-		s.logger.Printf("✅ Client %d subscribed to %d channels: %v", c.id, len(subReq.Channels), subReq.Channels)
+		// Interest mode: the consumers must deliver the new channels before
+		// the ack promises them (see jetstream_interest.go)
+		s.widenInterest(added)
+
+		s.logger.Printf("✅ Client %d subscribed to %d channels: %v", c.id, len(channels), channels)
 
 		// Send acknowledgment to client
+		// count includes patterns; patterns reports how many of them are wildcards
 		ack := map[string]any{
 			"type":       "subscription_ack",
-			"subscribed": subReq.Channels,
+			"subscribed": channels,
 			"count":      c.subscriptions.Count(),
+			"patterns":   c.subscriptions.PatternCount(),
 		}
-
-		if data, err := json.Marshal(ack); err == nil {
-			select {
-			case c.send <- data:
-				// Ack sent successfully
-			default:
-				// Client buffer full - skip ack (not critical)
-			}
+		if len(denied) > 0 {
+			ack["denied"] = denied
 		}
 
+		// Client buffer full - skip ack (not critical)
+		s.sendControlMessage(c, ack)
+
+		// Invalid / over-quota channels are reported only here (after the ack)
+		s.sendSubscriptionRejected(c, rejected)
+
+		// Last value of each new channel right after the ack (see LastValueCache)
+		s.sendSnapshots(c, added)
+
 	case "unsubscribe":
 		// Client unsubscribing from channels
-		// Message format: {"type": "unsubscribe", "data": {"channels": ["BTC"]}}
+		// Message format: {"type": "unsubscribe", "data": {"channels": ["BTC.trade", "ETH.*"]}}
+		// Patterns are removed by exact string: unsubscribing "BTC.*" leaves "BTC.trade" in place
 		var unsubReq struct {
 			Channels []string `json:"channels"` // List of channels to unsubscribe from
 		}
@@ -1228,6 +2127,10 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 			return
 		}
 
+		if !s.checkChannelsPerRequest(c, "unsubscribe", unsubReq.Channels) {
+			return
+		}
+
 		// Remove subscriptions from client's local set
 		c.subscriptions.RemoveMultiple(unsubReq.Channels)
 
@@ -1241,16 +2144,11 @@ func (s *Server) handleClientMessage(c *Client, data []byte) {
 			"type":         "unsubscription_ack",
 			"unsubscribed": unsubReq.Channels,
 			"count":        c.subscriptions.Count(),
+			"patterns":     c.subscriptions.PatternCount(),
 		}
 
-		if data, err := json.Marshal(ack); err == nil {
-			select {
-			case c.send <- data:
-				// Ack sent successfully
-			default:
-				// Client buffer full - skip ack (not critical)
-			}
-		}
+		// Client buffer full - skip ack (not critical)
+		s.sendControlMessage(c, ack)
 
 	default:
 		// Unknown message type
@@ -1452,8 +2350,15 @@ func (s *Server) Shutdown() error {
 		s.listener.Close()
 	}
 
+	// Admin API goes too - nothing to manage during the drain
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
+
 	// Stop receiving new messages from NATS
 	if s.natsConn != nil {
+		s.stopDeadLetterHandler()
+		s.releaseConsumer()
 		s.logger.Println("Closing NATS connection (no new messages)")
 		s.natsConn.Close()
 	}
@@ -1501,6 +2406,9 @@ forceClose:
 	})
 
 cleanup:
+	// Drop sessions waiting to be resumed (nothing to resume into)
+	s.sessions.CloseAll()
+
 	// Cancel context to stop all goroutines
 	s.cancel()
 
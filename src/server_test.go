@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer builds a Server without NATS or listeners
+// configure adjusts the defaults before NewServer runs (nil = defaults)
+func newTestServer(t *testing.T, configure func(*ServerConfig)) *Server {
+	t.Helper()
+
+	config := ServerConfig{
+		MaxConnections:           64,
+		BufferSize:               4096,
+		WorkerCount:              1,
+		WorkerQueueSize:          64,
+		MaxNATSMessagesPerSec:    1000,
+		MaxBroadcastsPerSec:      1000,
+		MaxGoroutines:            1000,
+		CPURejectThreshold:       75,
+		CPUPauseThreshold:        80,
+		JSStreamName:             "ODIN_TOKENS",
+		MessageRouteFallback:     "token:update/normal",
+		LVCMaxBytes:              1 << 20,
+		LVCDefaultRetention:      10 * time.Minute,
+		AuthMode:                 AuthModeOff,
+		AuthTimeout:              5 * time.Second,
+		ChannelEventTypes:        "trade,liquidity,metadata,social,favorites,creation,analytics,balances",
+		RateLimitBurst:           100,
+		RateLimitRate:            10,
+		RateLimitViolationWindow: time.Minute,
+		LogLevel:                 LogLevelError,
+		LogFormat:                LogFormatJSON,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	s, err := NewServer(config, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() {
+		s.cancel()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// newTestClient returns an attached Client with a send buffer of sendSize
+// frames and no network connection
+func newTestClient(s *Server, sendSize int) *Client {
+	c := s.connections.Get()
+	c.id = atomic.AddInt64(&s.clientCount, 1)
+	c.server = s
+	c.send = make(chan []byte, sendSize)
+	c.stop = make(chan struct{})
+	c.writerDone = make(chan struct{})
+	c.slowPath = newSlowPathQueue()
+	return c
+}
+
+// receiveFrames reads n frames from c.send, failing the test after timeout
+func receiveFrames(t *testing.T, c *Client, n int, timeout time.Duration) []string {
+	t.Helper()
+
+	var frames []string
+	deadline := time.After(timeout)
+	for len(frames) < n {
+		select {
+		case frame := <-c.send:
+			frames = append(frames, string(frame))
+		case <-deadline:
+			t.Fatalf("received %q, want %d frames", frames, n)
+		}
+	}
+	return frames
+}
+
+// eventually polls cond until it holds or timeout passes
+func eventually(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowPathKeepsSeqOrder(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 2)
+
+	c.send <- []byte("1")
+	c.send <- []byte("2")
+
+	// Buffer full: HIGH waits in the slow path, NORMAL behind it is dropped,
+	// and later frames queue behind the waiting one instead of overtaking it
+	s.enqueueSlowPath(c, c.slowPath, []byte("3"), PRIORITY_HIGH)
+	if !c.slowPath.waiting() {
+		t.Fatal("slow path not waiting after a HIGH frame was queued")
+	}
+	s.enqueueSlowPath(c, c.slowPath, []byte("4"), PRIORITY_NORMAL)
+	s.enqueueSlowPath(c, c.slowPath, []byte("5"), PRIORITY_CRITICAL)
+	s.enqueueSlowPath(c, c.slowPath, []byte("6"), PRIORITY_HIGH)
+
+	got := receiveFrames(t, c, 5, time.Second)
+	if want := []string{"1", "2", "3", "5", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("frames = %q, want %q", got, want)
+	}
+
+	eventually(t, time.Second, func() bool { return !c.slowPath.waiting() }, "slow path still waiting after it was drained")
+	if atomic.LoadInt32(&c.disconnecting) != 0 {
+		t.Error("client disconnected although every frame was delivered in time")
+	}
+}
+
+func TestSlowPathMissedDeadlines(t *testing.T) {
+	tests := []struct {
+		name             string
+		priority         MessagePriority
+		frames           int
+		wantDisconnected bool
+	}{
+		{name: "HIGH below the strike limit", priority: PRIORITY_HIGH, frames: maxSlowClientStrikes - 1},
+		{name: "HIGH strikes out", priority: PRIORITY_HIGH, frames: maxSlowClientStrikes, wantDisconnected: true},
+		{name: "CRITICAL disconnects on the first miss", priority: PRIORITY_CRITICAL, frames: 1, wantDisconnected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			c := newTestClient(s, 1)
+			c.send <- []byte("full")
+
+			for i := 0; i < tt.frames; i++ {
+				s.enqueueSlowPath(c, c.slowPath, []byte("x"), tt.priority)
+			}
+			eventually(t, 3*time.Second, func() bool { return !c.slowPath.waiting() }, "slow path never gave up on a full client")
+
+			if got := atomic.LoadInt32(&c.disconnecting) == 1; got != tt.wantDisconnected {
+				t.Errorf("disconnected = %v, want %v", got, tt.wantDisconnected)
+			}
+			if len(c.send) != 1 {
+				t.Errorf("send holds %d frames, want only the one that filled it", len(c.send))
+			}
+		})
+	}
+}
+
+func TestSlowPathFullDisconnectsCritical(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 1)
+	c.send <- []byte("full")
+
+	for i := 0; i < maxSlowPathFrames; i++ {
+		s.enqueueSlowPath(c, c.slowPath, []byte("x"), PRIORITY_CRITICAL)
+	}
+	if atomic.LoadInt32(&c.disconnecting) != 0 {
+		t.Fatal("disconnected before the slow path was full")
+	}
+
+	// One frame past the cap misses right away - no 1s wait
+	s.enqueueSlowPath(c, c.slowPath, []byte("x"), PRIORITY_CRITICAL)
+	if atomic.LoadInt32(&c.disconnecting) != 1 {
+		t.Error("CRITICAL frame past a full slow path didn't disconnect the client")
+	}
+
+	close(c.stop)
+	eventually(t, time.Second, func() bool { return !c.slowPath.waiting() }, "slow path kept waiting after the connection stopped")
+}
+
+func TestSlowPathStopsWithConnection(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := newTestClient(s, 1)
+	c.send <- []byte("full")
+
+	s.enqueueSlowPath(c, c.slowPath, []byte("a"), PRIORITY_CRITICAL)
+	s.enqueueSlowPath(c, c.slowPath, []byte("b"), PRIORITY_CRITICAL)
+	close(c.stop)
+
+	eventually(t, 500*time.Millisecond, func() bool { return !c.slowPath.waiting() }, "slow path kept waiting after the connection stopped")
+	if atomic.LoadInt32(&c.disconnecting) != 0 {
+		t.Error("a stopped connection was counted as a slow client")
+	}
+	if len(c.send) != 1 {
+		t.Errorf("send holds %d frames after stop, want 1", len(c.send))
+	}
+
+	// releaseClient's barrier: no drainer holds deliveryMu any more
+	c.deliveryMu.Lock()
+	c.deliveryMu.Unlock()
+}
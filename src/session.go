@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable sessions
+//
+// Problem: a network blip (mobile handoff, wifi → 4G, laptop sleep) drops the
+// WebSocket. Without sessions the client reconnects to a blank Client:
+// seq restarts at 1, subscriptions are gone, and everything published while
+// it was offline is lost.
+//
+// Solution: on disconnect the Client is DETACHED instead of destroyed.
+// For the grace period it stays in the subscription index; broadcast() keeps
+// assigning seqs and appending frames to its replay buffer (paused - nothing
+// is sent). A reconnect presenting the resume token re-attaches the SAME
+// Client to the new connection and replays the gap automatically.
+//
+// Protocol:
+//
+//	1. Connect:   ws://host/ws
+//	   Server →   {"type":"session","session_id":"9f2c…","resume_token":"9f2c….Xk3…","resumed":false}
+//	2. Reconnect: ws://host/ws?resume_token=9f2c….Xk3…&last_seq=1042
+//	   Server →   {"type":"session",…,"resumed":true,"last_seq":1042,"replayed":17,"missed":0}
+//	   Server →   seq 1043 … 1059 (replayed), then live messages
+//
+// The resume token is rotated on every (re)connect - a token is single-use.
+// "missed" > 0 means frames were evicted from the replay buffer while the
+// client was away; the client should resync those channels from a snapshot.
+//
+// Memory: a detached session costs the same as a live client (replay buffer
+// ~50KB + subscriptions). Detached sessions are capped at MaxConnections.
+
+var (
+	// ErrSessionNotFound is returned when the session expired or never existed
+	ErrSessionNotFound = errors.New("session not found or expired")
+
+	// ErrSessionTokenInvalid is returned when the token is malformed or its secret doesn't match
+	ErrSessionTokenInvalid = errors.New("invalid resume token")
+)
+
+// sessionTokenSeparator splits "<session_id>.<secret>"
+const sessionTokenSeparator = "."
+
+// detachedSession is a Client waiting to be resumed
+type detachedSession struct {
+	client     *Client
+	timer      *time.Timer
+	detachedAt time.Time
+}
+
+// SessionManager tracks detached sessions and expires them after the grace period
+// Thread-safe: detach/resume/expiry race on the same session; whoever takes
+// the lock first wins (a resumed session is never expired and vice versa)
+type SessionManager struct {
+	gracePeriod time.Duration
+	maxDetached int
+	onExpire    func(*Client) // Final cleanup (subscription index, pool, rate limiter)
+
+	mu       sync.Mutex
+	detached map[string]*detachedSession // session ID → detached session
+}
+
+// NewSessionManager creates a session manager
+// gracePeriod 0 disables resumable sessions
+func NewSessionManager(gracePeriod time.Duration, maxDetached int, onExpire func(*Client)) *SessionManager {
+	return &SessionManager{
+		gracePeriod: gracePeriod,
+		maxDetached: maxDetached,
+		onExpire:    onExpire,
+		detached:    make(map[string]*detachedSession),
+	}
+}
+
+// Enabled reports whether sessions survive disconnects
+func (sm *SessionManager) Enabled() bool {
+	return sm.gracePeriod > 0
+}
+
+// Issue assigns a session ID (first connect only) and a fresh resume secret
+// Returns the resume token to hand to the client
+func (sm *SessionManager) Issue(c *Client) (string, error) {
+	if c.sessionID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		c.sessionID = hex.EncodeToString(id)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	c.resumeSecret = base64.RawURLEncoding.EncodeToString(secret)
+
+	return c.sessionID + sessionTokenSeparator + c.resumeSecret, nil
+}
+
+// Detach parks a disconnected client for the grace period
+// Returns false if the client can't be kept (sessions disabled, no session
+// issued, or too many detached sessions) - caller must clean it up normally
+func (sm *SessionManager) Detach(c *Client) bool {
+	if !sm.Enabled() || c.sessionID == "" {
+		return false
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if len(sm.detached) >= sm.maxDetached {
+		return false
+	}
+
+	sessionID := c.sessionID
+	session := &detachedSession{
+		client:     c,
+		detachedAt: time.Now(),
+	}
+	session.timer = time.AfterFunc(sm.gracePeriod, func() {
+		sm.expire(sessionID, session)
+	})
+	sm.detached[sessionID] = session
+
+	UpdateDetachedSessions(len(sm.detached))
+	return true
+}
+
+// Resume takes a detached session by resume token
+// On success the session is removed from the manager (the token is single-use)
+func (sm *SessionManager) Resume(token string) (*Client, time.Duration, error) {
+	sessionID, secret, ok := strings.Cut(token, sessionTokenSeparator)
+	if !ok || sessionID == "" || secret == "" {
+		return nil, 0, ErrSessionTokenInvalid
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.detached[sessionID]
+	if !exists {
+		return nil, 0, ErrSessionNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(session.client.resumeSecret)) != 1 {
+		return nil, 0, ErrSessionTokenInvalid
+	}
+
+	session.timer.Stop() // If it already fired, expire() will find the entry gone
+	delete(sm.detached, sessionID)
+	UpdateDetachedSessions(len(sm.detached))
+
+	return session.client, time.Since(session.detachedAt), nil
+}
+
+// expire drops a session whose grace period elapsed without a resume
+func (sm *SessionManager) expire(sessionID string, session *detachedSession) {
+	sm.mu.Lock()
+	current, exists := sm.detached[sessionID]
+	if !exists || current != session {
+		sm.mu.Unlock()
+		return // Resumed (or replaced) before the timer fired
+	}
+	delete(sm.detached, sessionID)
+	UpdateDetachedSessions(len(sm.detached))
+	sm.mu.Unlock()
+
+	IncrementSessionsExpired()
+	sm.onExpire(session.client)
+}
+
+// DetachedCount returns the number of sessions waiting to be resumed
+func (sm *SessionManager) DetachedCount() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return len(sm.detached)
+}
+
+// CloseAll expires every detached session immediately (graceful shutdown)
+func (sm *SessionManager) CloseAll() {
+	sm.mu.Lock()
+	sessions := make([]*detachedSession, 0, len(sm.detached))
+	for sessionID, session := range sm.detached {
+		session.timer.Stop()
+		sessions = append(sessions, session)
+		delete(sm.detached, sessionID)
+	}
+	UpdateDetachedSessions(0)
+	sm.mu.Unlock()
+
+	for _, session := range sessions {
+		sm.onExpire(session.client)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerResume(t *testing.T) {
+	sm := NewSessionManager(time.Minute, 4, func(*Client) { t.Error("session expired during the test") })
+	defer sm.CloseAll()
+
+	c := &Client{}
+	token, err := sm.Issue(c)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !sm.Detach(c) {
+		t.Fatal("Detach refused a client with a session")
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "malformed", token: "no-separator", wantErr: ErrSessionTokenInvalid},
+		{name: "empty secret", token: c.sessionID + ".", wantErr: ErrSessionTokenInvalid},
+		{name: "wrong secret", token: c.sessionID + ".guessed", wantErr: ErrSessionTokenInvalid},
+		{name: "unknown session", token: "0123.secret", wantErr: ErrSessionNotFound},
+		{name: "valid token"},
+		{name: "token is single-use", token: token, wantErr: ErrSessionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presented := tt.token
+			if presented == "" {
+				presented = token
+			}
+			got, _, err := sm.Resume(presented)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Resume() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != c {
+				t.Errorf("Resume() = %p, %v, want the detached client", got, err)
+			}
+		})
+	}
+
+	// Reissuing keeps the session ID and rotates the secret
+	sessionID := c.sessionID
+	next, err := sm.Issue(c)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if c.sessionID != sessionID || next == token {
+		t.Errorf("Issue() = %q (session %s), want a new token for session %s", next, c.sessionID, sessionID)
+	}
+}
+
+func TestSessionManagerDetachLimits(t *testing.T) {
+	if (NewSessionManager(0, 4, nil)).Detach(&Client{sessionID: "a"}) {
+		t.Error("Detach succeeded with sessions disabled")
+	}
+
+	expired := make(chan *Client, 2)
+	sm := NewSessionManager(20*time.Millisecond, 1, func(c *Client) { expired <- c })
+
+	if sm.Detach(&Client{}) {
+		t.Error("Detach succeeded without an issued session")
+	}
+	first, second := &Client{sessionID: "first"}, &Client{sessionID: "second"}
+	if !sm.Detach(first) {
+		t.Fatal("Detach refused the first session")
+	}
+	if sm.Detach(second) {
+		t.Error("Detach went past maxDetached")
+	}
+
+	select {
+	case c := <-expired:
+		if c != first {
+			t.Errorf("expired %s, want first", c.sessionID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("detached session never expired")
+	}
+	if n := sm.DetachedCount(); n != 0 {
+		t.Errorf("DetachedCount() = %d after expiry, want 0", n)
+	}
+}
+
+// detachTestSession parks a client owned by userID ("" = anonymous) and
+// returns its resume token
+func detachTestSession(t *testing.T, s *Server, userID string) (*Client, string) {
+	t.Helper()
+
+	c := newTestClient(s, 16)
+	if userID != "" {
+		c.identity = &ClientIdentity{UserID: userID}
+	}
+	token, err := s.sessions.Issue(c)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !s.sessions.Detach(c) {
+		t.Fatal("Detach refused the session")
+	}
+	return c, token
+}
+
+func TestResumeSessionChecksOwner(t *testing.T) {
+	tests := []struct {
+		name       string
+		owner      string
+		presenter  *ClientIdentity
+		codec      WireCodec
+		wantResume bool
+	}{
+		{name: "same user", owner: "user-1", presenter: &ClientIdentity{UserID: "user-1"}, codec: jsonWireCodec, wantResume: true},
+		{name: "anonymous session", presenter: nil, codec: jsonWireCodec, wantResume: true},
+		{name: "other user", owner: "user-1", presenter: &ClientIdentity{UserID: "user-2"}, codec: jsonWireCodec},
+		{name: "anonymous presenter", owner: "user-1", presenter: nil, codec: jsonWireCodec},
+		{name: "user takes an anonymous session", presenter: &ClientIdentity{UserID: "user-2"}, codec: jsonWireCodec},
+		{name: "codec changed", owner: "user-1", presenter: &ClientIdentity{UserID: "user-1"}, codec: msgpackWireCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) { config.SessionGracePeriod = time.Minute })
+			c, token := detachTestSession(t, s, tt.owner)
+
+			r := httptest.NewRequest("GET", "/ws?resume_token="+url.QueryEscape(token), nil)
+			got := s.resumeSession(r, tt.codec, tt.presenter, "192.0.2.1")
+
+			if tt.wantResume {
+				if got != c {
+					t.Fatalf("resumeSession() = %p, want the detached client", got)
+				}
+				return
+			}
+			if got != nil {
+				t.Fatal("resumeSession() handed the session over")
+			}
+			// The token was consumed: a second try can't resume either
+			if s.sessions.DetachedCount() != 0 {
+				t.Error("rejected session is still detached")
+			}
+		})
+	}
+}
+
+func TestFinishPendingResumeChecksOwner(t *testing.T) {
+	tests := []struct {
+		name       string
+		authedAs   string
+		wantResume bool
+	}{
+		{name: "owner", authedAs: "user-1", wantResume: true},
+		{name: "other user", authedAs: "user-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(config *ServerConfig) { config.SessionGracePeriod = time.Minute })
+			c := newTestClient(s, 16)
+			c.pendingResume = &pendingResume{owner: &ClientIdentity{UserID: "user-1"}}
+			c.setIdentity(&ClientIdentity{UserID: tt.authedAs})
+
+			if got := s.finishPendingResume(c); got != tt.wantResume {
+				t.Fatalf("finishPendingResume() = %v, want %v", got, tt.wantResume)
+			}
+			if c.pendingResume != nil {
+				t.Error("pending resume not cleared")
+			}
+			if disconnected := atomic.LoadInt32(&c.disconnecting) == 1; disconnected == tt.wantResume {
+				t.Errorf("disconnected = %v, want %v", disconnected, !tt.wantResume)
+			}
+
+			if tt.wantResume {
+				var msg map[string]any
+				if err := json.Unmarshal(<-c.send, &msg); err != nil || msg["type"] != "session" || msg["resumed"] != true {
+					t.Errorf("first frame = %v (%v), want the resumed session message", msg, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAnnounceResumedSessionReauthorizesBeforeReplay(t *testing.T) {
+	s := newTestServer(t, func(config *ServerConfig) { config.SessionGracePeriod = time.Minute })
+	policy, err := NewPolicyAuthorizer(ChannelPolicy{Rules: []ChannelPolicyRule{
+		{Channels: []string{"{user}.balances"}, Authenticated: true},
+		{Channels: []string{"*.trade"}, Roles: []string{"trader"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer: %v", err)
+	}
+	s.channelAuthorizer = policy
+
+	// Subscribed as a trader, then resumed with a token without the role
+	c := newTestClient(s, 16)
+	channels := []string{"BTC.trade", "user-1.balances"}
+	c.subscriptions.AddMultiple(channels)
+	s.subscriptionIndex.AddMultiple(channels, c)
+	c.replayBuffer.AddFrame(1, 0, []byte(`"frame 1"`))
+	c.replayBuffer.AddFrame(2, 0, []byte(`"frame 2"`))
+	c.replayBuffer.Pause()
+	c.setIdentity(&ClientIdentity{UserID: "user-1"})
+
+	s.announceSession(c, 1, true)
+
+	var types []string
+	for _, frame := range receiveFrames(t, c, 3, time.Second) {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(frame), &msg); err == nil {
+			types = append(types, msg["type"].(string))
+			continue
+		}
+		types = append(types, frame) // Replayed frame
+	}
+	if want := []string{"subscriptions_revoked", "session", `"frame 2"`}; !reflect.DeepEqual(types, want) {
+		t.Errorf("frames = %q, want %q", types, want)
+	}
+
+	if c.subscriptions.Has("BTC.trade") || len(s.subscriptionIndex.Get("BTC.trade")) != 0 {
+		t.Error("BTC.trade still subscribed after the role was dropped")
+	}
+	if !c.subscriptions.Has("user-1.balances") {
+		t.Error("user-1.balances revoked although the new token still allows it")
+	}
+}
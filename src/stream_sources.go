@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Stream sources: which JetStream streams the server consumes and how their
+// subjects become client channels
+//
+// Without JS_SOURCES_FILE there is one source, built from JS_STREAM_*:
+//
+//	stream ODIN_TOKENS, subjects odin.token.>, odin.token.{symbol}.{event} → {symbol}.{event}
+//
+// JS_SOURCES_FILE declares several (each stream gets its own pull consumer and
+// fetch loop, the rate limit and worker pool are shared):
+//
+//	{
+//	  "sources": [
+//	    {"name": "tokens", "stream": "ODIN_TOKENS", "subjects": ["odin.token.>"],
+//	     "storage": "memory", "retention": "limits", "max_age": "30s", "max_msgs": 100000,
+//	     "mappings": [
+//	       {"subject": "odin.token.batch.update", "channel": "batch.update"},
+//	       {"subject": "odin.token.{symbol}.{event}", "channel": "{symbol}.{event}"}]},
+//	    {"name": "market", "stream": "ODIN_MARKET",
+//	     "subjects": ["odin.market.>", "odin.trades.>"],
+//	     "storage": "file", "retention": "limits", "max_age": "10m",
+//	     "mappings": [
+//	       {"subject": "odin.market.statistics", "channel": "market.statistics"},
+//	       {"subject": "odin.trades.user.{user}", "channel": "{user}.trades"}]}
+//	  ]
+//	}
+//
+// Mappings: "{name}" placeholders match exactly one subject token and may be
+// used in the channel; the first matching mapping wins; subjects no mapping
+// matches are acked and counted (ws_nats_unmapped_messages_total). Channels
+// must follow the {SYMBOL}.{EVENT_TYPE} grammar (see channel_validation.go) -
+// add new event types ("statistics", "trades") to WS_CHANNEL_EVENT_TYPES.
+//
+// The first source is the primary one: deep replay and the stream_seq clients
+// replay from refer to it. Each channel should come from exactly one source -
+// stream sequences of different streams aren't comparable.
+//
+// Event types of the default token source (8 channels per symbol):
+//  1. "trade"      - Real-time trading (price, volume) - User-initiated, high-frequency
+//  2. "liquidity"  - Liquidity operations (add/remove) - User-initiated
+//  3. "metadata"   - Token metadata updates - Manual, infrequent
+//  4. "social"     - Comments, reactions - User-initiated
+//  5. "favorites"  - User bookmarks - User-initiated
+//  6. "creation"   - Token launches - User-initiated
+//  7. "analytics"  - Background metrics (holder counts, TVL) - Scheduler-driven, low-frequency
+//  8. "balances"   - Wallet balance changes - User-initiated
+//
+// Clients subscribe to specific event types ("BTC.trade" instead of all BTC
+// events) - an 8x reduction in unnecessary messages per subscribed symbol.
+
+// defaultTokenSubject is the subject filter of the default token source
+const defaultTokenSubject = "odin.token.>"
+
+// StreamSourcesFile is the JSON structure of JS_SOURCES_FILE
+type StreamSourcesFile struct {
+	Sources []StreamSourceSpec `json:"sources"`
+}
+
+// StreamSourceSpec declares one source
+type StreamSourceSpec struct {
+	Name      string               `json:"name"`      // Label for logs and metrics (default: stream name)
+	Stream    string               `json:"stream"`    // JetStream stream (created if missing)
+	Subjects  []string             `json:"subjects"`  // Stream subjects
+	Storage   string               `json:"storage"`   // memory (default) or file
+	Retention string               `json:"retention"` // limits (default) or interest
+	MaxAge    string               `json:"max_age"`   // Go duration, empty = unlimited
+	MaxMsgs   int64                `json:"max_msgs"`  // 0 = unlimited
+	MaxBytes  int64                `json:"max_bytes"` // 0 = unlimited
+	Mappings  []SubjectMappingSpec `json:"mappings"`
+}
+
+// SubjectMappingSpec maps subjects to a channel
+type SubjectMappingSpec struct {
+	Subject string `json:"subject"` // "odin.trades.user.{user}"
+	Channel string `json:"channel"` // "{user}.trades"
+}
+
+// StreamSource is a validated source
+type StreamSource struct {
+	Name     string
+	Config   nats.StreamConfig // Used to create the stream if it doesn't exist
+	mappings []subjectMapping
+
+	// Pull subscription, set by Start and replaced when the consumer is
+	// recreated (see jetstream_fetch.go)
+	sub atomic.Pointer[nats.Subscription]
+
+	// Consumer recreated with the idle filter - the interest filter must be
+	// applied again (see jetstream_interest.go)
+	filterLost atomic.Bool
+}
+
+// subjectMapping is a parsed SubjectMappingSpec
+// Tokens wrapped in {} are placeholders
+type subjectMapping struct {
+	subject []string
+	channel []string
+}
+
+// LoadStreamSources reads and validates a stream sources file
+func LoadStreamSources(path string) ([]*StreamSource, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream sources: %w", err)
+	}
+
+	var file StreamSourcesFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse stream sources %s: %w", path, err)
+	}
+	return NewStreamSources(file.Sources)
+}
+
+// configuredStreamSources returns the sources of JS_SOURCES_FILE or, without
+// one, the default token source built from the JS_STREAM_* settings
+func configuredStreamSources(config ServerConfig) ([]*StreamSource, error) {
+	if config.JSSourcesFile == "" {
+		return []*StreamSource{defaultStreamSource(config)}, nil
+	}
+	return LoadStreamSources(config.JSSourcesFile)
+}
+
+// defaultStreamSource is the single token source used without JS_SOURCES_FILE
+func defaultStreamSource(config ServerConfig) *StreamSource {
+	// Deep replay (opt-in) reads history back from the stream, so messages must
+	// outlive the ack: limits retention keeps them until MaxAge/MaxMsgs/MaxBytes
+	retention := nats.InterestPolicy // Delete after all subscribers ack
+	if config.DeepReplayEnabled {
+		retention = nats.LimitsPolicy
+	}
+
+	return &StreamSource{
+		Name: config.JSStreamName,
+		Config: nats.StreamConfig{
+			Name:      config.JSStreamName,
+			Subjects:  []string{defaultTokenSubject},
+			Retention: retention,               // Limits (deep replay) or interest
+			MaxAge:    config.JSStreamMaxAge,   // Configured max age
+			Storage:   nats.MemoryStorage,      // In-memory for speed
+			Replicas:  1,                       // Single replica for now
+			Discard:   nats.DiscardOld,         // Drop oldest when full
+			MaxMsgs:   config.JSStreamMaxMsgs,  // Configured max messages
+			MaxBytes:  config.JSStreamMaxBytes, // Configured max bytes
+		},
+		mappings: []subjectMapping{{
+			subject: []string{"odin", "token", "{symbol}", "{event}"},
+			channel: []string{"{symbol}", "{event}"},
+		}},
+	}
+}
+
+// NewStreamSources validates source declarations
+func NewStreamSources(specs []StreamSourceSpec) ([]*StreamSource, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("stream sources: at least one source required")
+	}
+
+	sources := make([]*StreamSource, 0, len(specs))
+	streams := make(map[string]bool)
+	var allSubjects []string
+
+	for i, spec := range specs {
+		source, err := newStreamSource(spec)
+		if err != nil {
+			return nil, fmt.Errorf("stream source %d (%s): %w", i, spec.Stream, err)
+		}
+		if streams[source.Config.Name] {
+			return nil, fmt.Errorf("stream source %d: stream %s declared twice", i, source.Config.Name)
+		}
+		streams[source.Config.Name] = true
+
+		// NATS rejects streams with overlapping subjects
+		for _, subject := range source.Config.Subjects {
+			for _, other := range allSubjects {
+				if ChannelPatternsOverlap(subject, other) {
+					return nil, fmt.Errorf("stream source %d (%s): subject %q overlaps %q", i, spec.Stream, subject, other)
+				}
+			}
+		}
+		allSubjects = append(allSubjects, source.Config.Subjects...)
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// newStreamSource validates one source declaration
+func newStreamSource(spec StreamSourceSpec) (*StreamSource, error) {
+	if spec.Stream == "" || strings.ContainsAny(spec.Stream, ".*> \t") {
+		return nil, fmt.Errorf("invalid stream name %q", spec.Stream)
+	}
+	if len(spec.Subjects) == 0 {
+		return nil, fmt.Errorf("no subjects")
+	}
+	for _, subject := range spec.Subjects {
+		if err := ValidateChannelPattern(subject); err != nil {
+			return nil, fmt.Errorf("invalid subject %q: %w", subject, err)
+		}
+	}
+	if len(spec.Mappings) == 0 {
+		return nil, fmt.Errorf("no mappings")
+	}
+
+	config := nats.StreamConfig{
+		Name:     spec.Stream,
+		Subjects: spec.Subjects,
+		Replicas: 1,
+		Discard:  nats.DiscardOld,
+		MaxMsgs:  spec.MaxMsgs,
+		MaxBytes: spec.MaxBytes,
+	}
+	switch spec.Storage {
+	case "", "memory":
+		config.Storage = nats.MemoryStorage
+	case "file":
+		config.Storage = nats.FileStorage
+	default:
+		return nil, fmt.Errorf("storage must be memory or file, got %q", spec.Storage)
+	}
+	switch spec.Retention {
+	case "", "limits":
+		config.Retention = nats.LimitsPolicy
+	case "interest":
+		config.Retention = nats.InterestPolicy
+	default:
+		return nil, fmt.Errorf("retention must be limits or interest, got %q", spec.Retention)
+	}
+	if spec.MaxAge != "" {
+		maxAge, err := time.ParseDuration(spec.MaxAge)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid max_age %q", spec.MaxAge)
+		}
+		config.MaxAge = maxAge
+	}
+
+	source := &StreamSource{Name: spec.Name, Config: config}
+	if source.Name == "" {
+		source.Name = spec.Stream
+	}
+	for _, mappingSpec := range spec.Mappings {
+		mapping, err := parseSubjectMapping(mappingSpec)
+		if err != nil {
+			return nil, err
+		}
+		// A mapping no stream subject can ever match is a typo
+		if !source.covers(mappingSpec.Subject) {
+			return nil, fmt.Errorf("mapping subject %q is outside the stream subjects", mappingSpec.Subject)
+		}
+		source.mappings = append(source.mappings, mapping)
+	}
+	return source, nil
+}
+
+// parseSubjectMapping validates a mapping: placeholders are whole tokens, every
+// channel placeholder is bound by the subject, the channel has two tokens
+func parseSubjectMapping(spec SubjectMappingSpec) (subjectMapping, error) {
+	mapping := subjectMapping{
+		subject: strings.Split(spec.Subject, tokenSeparator),
+		channel: strings.Split(spec.Channel, tokenSeparator),
+	}
+
+	bound := make(map[string]bool)
+	for _, token := range mapping.subject {
+		if isPlaceholder(token) {
+			if bound[token] {
+				return subjectMapping{}, fmt.Errorf("mapping %q: placeholder %s used twice", spec.Subject, token)
+			}
+			bound[token] = true
+		} else if !isChannelToken(token) {
+			return subjectMapping{}, fmt.Errorf("mapping %q: invalid subject token %q", spec.Subject, token)
+		}
+	}
+
+	if len(mapping.channel) != 2 {
+		return subjectMapping{}, fmt.Errorf("mapping %q: channel %q must be {SYMBOL}.{EVENT_TYPE}", spec.Subject, spec.Channel)
+	}
+	for _, token := range mapping.channel {
+		if isPlaceholder(token) {
+			if !bound[token] {
+				return subjectMapping{}, fmt.Errorf("mapping %q: channel placeholder %s not in subject", spec.Subject, token)
+			}
+		} else if !isSymbolToken(token) {
+			return subjectMapping{}, fmt.Errorf("mapping %q: invalid channel token %q", spec.Subject, token)
+		}
+	}
+	return mapping, nil
+}
+
+// isPlaceholder reports whether a mapping token is a "{name}" placeholder
+func isPlaceholder(token string) bool {
+	return len(token) > 2 && strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}")
+}
+
+// covers reports whether a mapping subject (placeholders = wildcards) can match
+// one of the source's stream subjects
+func (src *StreamSource) covers(mappingSubject string) bool {
+	tokens := strings.Split(mappingSubject, tokenSeparator)
+	for i, token := range tokens {
+		if isPlaceholder(token) {
+			tokens[i] = singleTokenWildcard
+		}
+	}
+	pattern := strings.Join(tokens, tokenSeparator)
+
+	for _, subject := range src.Config.Subjects {
+		if ChannelPatternsOverlap(subject, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelFor maps a message subject to its channel ("" = no mapping matches)
+//
+//	"odin.token.BTC.trade" → "BTC.trade"
+//	"odin.trades.user.user-42" → "user-42.trades"
+func (src *StreamSource) ChannelFor(subject string) string {
+	tokens := strings.Split(subject, tokenSeparator)
+	for _, mapping := range src.mappings {
+		if channel, ok := mapping.channelFor(tokens); ok {
+			return channel
+		}
+	}
+	return ""
+}
+
+// channelFor applies the mapping to a subject's tokens
+func (m subjectMapping) channelFor(tokens []string) (string, bool) {
+	if len(tokens) != len(m.subject) {
+		return "", false
+	}
+
+	values := make(map[string]string, 2)
+	for i, token := range m.subject {
+		switch {
+		case isPlaceholder(token):
+			if !isSymbolToken(tokens[i]) {
+				return "", false // Would produce a channel nobody can subscribe to
+			}
+			values[token] = tokens[i]
+		case token != tokens[i]:
+			return "", false
+		}
+	}
+	return m.render(m.channel, values), true
+}
+
+// SubjectsFor maps a channel or channel pattern back to stream subjects
+// (deep replay consumer filters). ok is false if the channel can't be mapped
+// precisely ("BTC.>", placeholders mapped through literals) - callers fall back
+// to the stream subjects.
+//
+//	"BTC.trade" → ["odin.token.BTC.trade"]
+//	"*.trade"   → ["odin.token.*.trade"]
+//	"ETH.batch" → [] (no mapping produces it)
+func (src *StreamSource) SubjectsFor(channel string) (subjects []string, ok bool) {
+	if strings.Contains(channel, fullWildcard) {
+		return nil, false
+	}
+
+	tokens := strings.Split(channel, tokenSeparator)
+	for _, mapping := range src.mappings {
+		if len(tokens) != len(mapping.channel) {
+			continue
+		}
+
+		values := make(map[string]string, 2)
+		matched := true
+		for i, token := range mapping.channel {
+			switch {
+			case isPlaceholder(token):
+				values[token] = tokens[i]
+			case tokens[i] != token && tokens[i] != singleTokenWildcard:
+				matched = false
+			}
+		}
+		// Several mappings can produce the same subject ("batch.update" via the
+		// literal and the placeholder mapping) - JetStream rejects duplicate filters
+		if subject := mapping.render(mapping.subject, values); matched && !slices.Contains(subjects, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects, true
+}
+
+// filterSubjects converts channels and patterns to consumer filter subjects of
+// the source's stream (mapped back through its subject mappings)
+// Used by deep replay and interest-based consumption (jetstream_interest.go)
+//
+// JetStream rejects overlapping filters, so literals already covered by a
+// pattern are dropped ("BTC.trade" under "BTC.*"). If subjects still overlap
+// each other ("BTC.*" and "*.trade"), a channel can't be mapped back precisely
+// ("BTC.>") - or there are more than limit - the whole stream is read and
+// filtered client-side. Empty: no channel maps to this source.
+func (src *StreamSource) filterSubjects(subscriptions []string, limit int) []string {
+	var patterns, literals []string
+	for _, sub := range subscriptions {
+		if IsChannelPattern(sub) {
+			patterns = append(patterns, sub)
+		} else {
+			literals = append(literals, sub)
+		}
+	}
+
+	channels := append([]string(nil), patterns...)
+	for _, literal := range literals {
+		covered := false
+		for _, pattern := range patterns {
+			if MatchChannelPattern(pattern, literal) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			channels = append(channels, literal)
+		}
+	}
+
+	var subjects []string
+	for _, channel := range channels {
+		mapped, ok := src.SubjectsFor(channel)
+		if !ok {
+			return src.Config.Subjects
+		}
+		subjects = append(subjects, mapped...)
+	}
+
+	if len(subjects) > limit {
+		return src.Config.Subjects
+	}
+	for i := range subjects {
+		for j := i + 1; j < len(subjects); j++ {
+			if ChannelPatternsOverlap(subjects[i], subjects[j]) {
+				return src.Config.Subjects
+			}
+		}
+	}
+	return subjects
+}
+
+// render fills a template's placeholders (unbound ones become "*")
+func (m subjectMapping) render(template []string, values map[string]string) string {
+	out := make([]string, len(template))
+	for i, token := range template {
+		if !isPlaceholder(token) {
+			out[i] = token
+		} else if value, ok := values[token]; ok {
+			out[i] = value
+		} else {
+			out[i] = singleTokenWildcard
+		}
+	}
+	return strings.Join(out, tokenSeparator)
+}
+
+// primarySource is the source deep replay reads from
+func (s *Server) primarySource() *StreamSource {
+	return s.sources[0]
+}
+
+// ensureStreams creates missing streams (existing ones are left as they are)
+func (s *Server) ensureStreams() error {
+	for _, source := range s.sources {
+		streamName := source.Config.Name
+		streamInfo, err := s.natsJS.StreamInfo(streamName)
+		if err != nil {
+			if err := s.checkDeepReplayRetention(source, source.Config.Retention); err != nil {
+				return err
+			}
+
+			// Stream doesn't exist, create it
+			s.logger.Printf("📦 Creating JetStream stream: %s %v", streamName, source.Config.Subjects)
+			config := source.Config
+			if _, err = s.natsJS.AddStream(&config); err != nil {
+				RecordJetStreamError(ErrorSeverityFatal)
+				s.auditLogger.Critical("StreamCreationFailed", "Failed to create JetStream stream", map[string]any{
+					"stream": streamName,
+					"error":  err.Error(),
+				})
+				s.logger.Printf("❌ FATAL: Stream creation failed: %v", err)
+				return fmt.Errorf("failed to create stream %s: %w", streamName, err)
+			}
+			s.logger.Printf("✅ JetStream stream created: %s", streamName)
+			continue
+		}
+
+		s.logger.Printf("✅ JetStream stream exists: %s (messages: %d)", streamName, streamInfo.State.Msgs)
+
+		// Retention can't be changed on an existing stream
+		if err := s.checkDeepReplayRetention(source, streamInfo.Config.Retention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDeepReplayRetention refuses to start deep replay on an interest stream
+// Interest retention deletes messages once acked, so deep replay would
+// silently find (almost) no history - the operator has to pick one:
+// recreate the stream with limits retention, or disable WS_DEEP_REPLAY_ENABLED
+func (s *Server) checkDeepReplayRetention(source *StreamSource, retention nats.RetentionPolicy) error {
+	if !s.config.DeepReplayEnabled || source != s.primarySource() || retention != nats.InterestPolicy {
+		return nil
+	}
+
+	s.auditLogger.Critical("DeepReplayRetentionMismatch", "Deep replay requires a limits-retention stream", map[string]any{
+		"stream": source.Config.Name,
+	})
+	return fmt.Errorf("WS_DEEP_REPLAY_ENABLED requires limits retention, but stream %s uses interest retention "+
+		"(recreate it with limits retention or disable deep replay)", source.Config.Name)
+}
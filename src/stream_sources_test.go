@@ -0,0 +1,233 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// testSourcesFile is the JS_SOURCES_FILE example from stream_sources.go
+const testSourcesFile = `{
+  "sources": [
+    {"name": "tokens", "stream": "ODIN_TOKENS", "subjects": ["odin.token.>"],
+     "storage": "memory", "retention": "limits", "max_age": "30s", "max_msgs": 100000,
+     "mappings": [
+       {"subject": "odin.token.batch.update", "channel": "batch.update"},
+       {"subject": "odin.token.{symbol}.{event}", "channel": "{symbol}.{event}"}]},
+    {"name": "market", "stream": "ODIN_MARKET",
+     "subjects": ["odin.market.>", "odin.trades.>"],
+     "storage": "file", "retention": "limits", "max_age": "10m",
+     "mappings": [
+       {"subject": "odin.market.statistics", "channel": "market.statistics"},
+       {"subject": "odin.trades.user.{user}", "channel": "{user}.trades"}]}
+  ]
+}`
+
+// loadTestSources loads testSourcesFile through a temporary file
+func loadTestSources(t *testing.T) []*StreamSource {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sources.json")
+	if err := os.WriteFile(path, []byte(testSourcesFile), 0o600); err != nil {
+		t.Fatalf("write sources file: %v", err)
+	}
+	sources, err := LoadStreamSources(path)
+	if err != nil {
+		t.Fatalf("LoadStreamSources: %v", err)
+	}
+	return sources
+}
+
+func TestLoadStreamSources(t *testing.T) {
+	sources := loadTestSources(t)
+	if len(sources) != 2 {
+		t.Fatalf("loaded %d sources, want 2", len(sources))
+	}
+
+	tokens, market := sources[0], sources[1]
+	if tokens.Name != "tokens" || tokens.Config.Name != "ODIN_TOKENS" || tokens.Config.Storage != nats.MemoryStorage ||
+		tokens.Config.MaxAge != 30*time.Second || tokens.Config.MaxMsgs != 100000 {
+		t.Errorf("tokens source = %s %+v", tokens.Name, tokens.Config)
+	}
+	if market.Config.Storage != nats.FileStorage || market.Config.Retention != nats.LimitsPolicy ||
+		!reflect.DeepEqual(market.Config.Subjects, []string{"odin.market.>", "odin.trades.>"}) {
+		t.Errorf("market source = %+v", market.Config)
+	}
+}
+
+func TestStreamSourceChannelFor(t *testing.T) {
+	sources := loadTestSources(t)
+
+	tests := []struct {
+		source  int
+		subject string
+		want    string
+	}{
+		{source: 0, subject: "odin.token.BTC.trade", want: "BTC.trade"},
+		{source: 0, subject: "odin.token.batch.update", want: "batch.update"}, // First mapping wins
+		{source: 0, subject: "odin.token.BTC.trade.v2"},
+		{source: 0, subject: "odin.token.BTC"},
+		{source: 1, subject: "odin.market.statistics", want: "market.statistics"},
+		{source: 1, subject: "odin.trades.user.user-42", want: "user-42.trades"},
+		{source: 1, subject: "odin.market.other"},
+		{source: 1, subject: "odin.token.BTC.trade"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if got := sources[tt.source].ChannelFor(tt.subject); got != tt.want {
+				t.Errorf("ChannelFor(%q) = %q, want %q", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamSourceSubjectsFor(t *testing.T) {
+	sources := loadTestSources(t)
+
+	tests := []struct {
+		source  int
+		channel string
+		want    []string
+		wantOK  bool
+	}{
+		{source: 0, channel: "BTC.trade", want: []string{"odin.token.BTC.trade"}, wantOK: true},
+		{source: 0, channel: "*.trade", want: []string{"odin.token.*.trade"}, wantOK: true},
+		{source: 0, channel: "batch.update", want: []string{"odin.token.batch.update"}, wantOK: true}, // Two mappings, one subject
+		{source: 0, channel: "BTC.>"},
+		{source: 1, channel: "user-42.trades", want: []string{"odin.trades.user.user-42"}, wantOK: true},
+		{source: 1, channel: "*.statistics", want: []string{"odin.market.statistics"}, wantOK: true},
+		{source: 1, channel: "BTC.trade", wantOK: true}, // Mapped by no mapping of this source
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			got, ok := sources[tt.source].SubjectsFor(tt.channel)
+			if !reflect.DeepEqual(got, tt.want) || ok != tt.wantOK {
+				t.Errorf("SubjectsFor(%q) = %q %v, want %q %v", tt.channel, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestStreamSourceFilterSubjects(t *testing.T) {
+	tokens := loadTestSources(t)[0]
+	whole := tokens.Config.Subjects
+
+	tests := []struct {
+		name          string
+		subscriptions []string
+		limit         int
+		want          []string
+	}{
+		{name: "literals", subscriptions: []string{"BTC.trade", "ETH.trade"}, limit: 10, want: []string{"odin.token.BTC.trade", "odin.token.ETH.trade"}},
+		{name: "literal under a pattern", subscriptions: []string{"BTC.trade", "BTC.*"}, limit: 10, want: []string{"odin.token.BTC.*"}},
+		{name: "channel two mappings produce", subscriptions: []string{"batch.update"}, limit: 10, want: []string{"odin.token.batch.update"}},
+		{name: "overlapping patterns", subscriptions: []string{"BTC.*", "*.trade"}, limit: 10, want: whole},
+		{name: "unmappable pattern", subscriptions: []string{"BTC.>"}, limit: 10, want: whole},
+		{name: "over the limit", subscriptions: []string{"BTC.trade", "ETH.trade", "SOL.trade"}, limit: 2, want: whole},
+		{name: "nothing", limit: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokens.filterSubjects(tt.subscriptions, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterSubjects(%q) = %q, want %q", tt.subscriptions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStreamSourcesValidates(t *testing.T) {
+	valid := func() StreamSourceSpec {
+		return StreamSourceSpec{
+			Stream:   "ODIN_MARKET",
+			Subjects: []string{"odin.market.>"},
+			Mappings: []SubjectMappingSpec{{Subject: "odin.market.{name}", Channel: "market.{name}"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		specs   func() []StreamSourceSpec
+		wantErr string
+	}{
+		{name: "no sources", specs: func() []StreamSourceSpec { return nil }, wantErr: "at least one source"},
+		{name: "invalid stream name", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Stream = "ODIN.MARKET"
+			return []StreamSourceSpec{spec}
+		}, wantErr: "invalid stream name"},
+		{name: "no subjects", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Subjects = nil
+			return []StreamSourceSpec{spec}
+		}, wantErr: "no subjects"},
+		{name: "unknown storage", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Storage = "disk"
+			return []StreamSourceSpec{spec}
+		}, wantErr: "storage"},
+		{name: "unknown retention", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Retention = "workqueue"
+			return []StreamSourceSpec{spec}
+		}, wantErr: "retention"},
+		{name: "invalid max age", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.MaxAge = "a week"
+			return []StreamSourceSpec{spec}
+		}, wantErr: "max_age"},
+		{name: "no mappings", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Mappings = nil
+			return []StreamSourceSpec{spec}
+		}, wantErr: "no mappings"},
+		{name: "mapping outside the stream", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Mappings = []SubjectMappingSpec{{Subject: "odin.token.{symbol}.{event}", Channel: "{symbol}.{event}"}}
+			return []StreamSourceSpec{spec}
+		}, wantErr: "outside the stream subjects"},
+		{name: "unbound channel placeholder", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Mappings = []SubjectMappingSpec{{Subject: "odin.market.{name}", Channel: "{symbol}.{name}"}}
+			return []StreamSourceSpec{spec}
+		}, wantErr: "not in subject"},
+		{name: "placeholder used twice", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Mappings = []SubjectMappingSpec{{Subject: "odin.market.{name}.{name}", Channel: "market.{name}"}}
+			return []StreamSourceSpec{spec}
+		}, wantErr: "used twice"},
+		{name: "channel with three tokens", specs: func() []StreamSourceSpec {
+			spec := valid()
+			spec.Mappings = []SubjectMappingSpec{{Subject: "odin.market.{name}", Channel: "market.stats.{name}"}}
+			return []StreamSourceSpec{spec}
+		}, wantErr: "{SYMBOL}.{EVENT_TYPE}"},
+		{name: "stream declared twice", specs: func() []StreamSourceSpec {
+			other := valid()
+			other.Subjects = []string{"odin.other.>"}
+			other.Mappings = []SubjectMappingSpec{{Subject: "odin.other.{name}", Channel: "other.{name}"}}
+			return []StreamSourceSpec{valid(), other}
+		}, wantErr: "declared twice"},
+		{name: "overlapping subjects", specs: func() []StreamSourceSpec {
+			other := valid()
+			other.Stream = "ODIN_STATS"
+			other.Subjects = []string{"odin.*.statistics"}
+			other.Mappings = []SubjectMappingSpec{{Subject: "odin.{kind}.statistics", Channel: "{kind}.statistics"}}
+			return []StreamSourceSpec{valid(), other}
+		}, wantErr: "overlaps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStreamSources(tt.specs())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewStreamSources() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
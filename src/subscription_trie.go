@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Channel pattern tokens (NATS-style wildcards)
+//
+// Channels are dot-separated token lists: "BTC.trade" = ["BTC", "trade"]
+// Patterns may contain two wildcard tokens:
+//   - "*" matches exactly one token:   "BTC.*"  → BTC.trade, BTC.social (not BTC.trade.v2)
+//   - ">" matches one or more tokens:  "BTC.>"  → BTC.trade, BTC.trade.v2 (must be last token)
+//
+// Example subscriptions:
+//   - Dashboard for one symbol:    "BTC.*"     (all 8 event types for BTC)
+//   - Market-wide ticker:          "*.trade"   (trade events for every symbol)
+//   - Everything (debug tooling):  ">"
+const (
+	tokenSeparator      = "."
+	singleTokenWildcard = "*"
+	fullWildcard        = ">"
+)
+
+// IsChannelPattern reports whether a channel contains wildcard tokens
+// Literal channels use the exact-match map in SubscriptionIndex (fast path),
+// patterns are stored in the token trie
+func IsChannelPattern(channel string) bool {
+	for _, token := range strings.Split(channel, tokenSeparator) {
+		if token == singleTokenWildcard || token == fullWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateChannelPattern checks a channel or pattern against NATS subject rules
+//
+// Rules:
+//   - Non-empty, no empty tokens ("BTC..trade", ".trade", "BTC." are invalid)
+//   - Wildcards must be whole tokens ("BT*.trade" is invalid)
+//   - ">" may only appear as the last token ("BTC.>.trade" is invalid)
+//   - No whitespace (NATS treats it as a protocol delimiter)
+func ValidateChannelPattern(channel string) error {
+	if channel == "" {
+		return fmt.Errorf("empty channel")
+	}
+	if strings.ContainsAny(channel, " \t\r\n") {
+		return fmt.Errorf("channel %q contains whitespace", channel)
+	}
+
+	tokens := strings.Split(channel, tokenSeparator)
+	for i, token := range tokens {
+		if token == "" {
+			return fmt.Errorf("channel %q contains an empty token", channel)
+		}
+		if token == fullWildcard && i != len(tokens)-1 {
+			return fmt.Errorf("channel %q: '>' must be the last token", channel)
+		}
+		if token != singleTokenWildcard && token != fullWildcard &&
+			strings.ContainsAny(token, singleTokenWildcard+fullWildcard) {
+			return fmt.Errorf("channel %q: wildcards must be whole tokens", channel)
+		}
+	}
+	return nil
+}
+
+// MatchChannelPattern reports whether a literal channel matches a pattern
+// Used where a trie would be overkill (single pattern checks, config tables)
+//
+// Examples:
+//
+//	MatchChannelPattern("BTC.*", "BTC.trade")   → true
+//	MatchChannelPattern("*.trade", "ETH.trade") → true
+//	MatchChannelPattern("BTC.>", "BTC.trade")   → true
+//	MatchChannelPattern("BTC.*", "ETH.trade")   → false
+func MatchChannelPattern(pattern, channel string) bool {
+	patternTokens := strings.Split(pattern, tokenSeparator)
+	channelTokens := strings.Split(channel, tokenSeparator)
+
+	for i, token := range patternTokens {
+		if token == fullWildcard {
+			return len(channelTokens) > i
+		}
+		if i >= len(channelTokens) {
+			return false
+		}
+		if token != singleTokenWildcard && token != channelTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(channelTokens)
+}
+
+// ChannelPatternsOverlap reports whether some channel matches both patterns
+// (literal channels are patterns without wildcards)
+// Needed because JetStream rejects consumers whose filter subjects overlap
+//
+// Examples:
+//
+//	ChannelPatternsOverlap("BTC.*", "*.trade")   → true  (BTC.trade)
+//	ChannelPatternsOverlap("BTC.>", "BTC.trade") → true
+//	ChannelPatternsOverlap("BTC.*", "ETH.*")     → false
+func ChannelPatternsOverlap(a, b string) bool {
+	aTokens := strings.Split(a, tokenSeparator)
+	bTokens := strings.Split(b, tokenSeparator)
+
+	for i := 0; i < len(aTokens) && i < len(bTokens); i++ {
+		if aTokens[i] == fullWildcard || bTokens[i] == fullWildcard {
+			return true
+		}
+		if aTokens[i] != singleTokenWildcard && bTokens[i] != singleTokenWildcard && aTokens[i] != bTokens[i] {
+			return false
+		}
+	}
+
+	// No ">" reached: patterns of different depth can't match the same channel
+	return len(aTokens) == len(bTokens)
+}
+
+// ChannelPatternCovers reports whether every channel matched by inner is also
+// matched by outer (literal channels are patterns without wildcards)
+// Used by channel authorization: granting "*.trade" grants "BTC.trade" but not "BTC.*"
+//
+// Examples:
+//
+//	ChannelPatternCovers("*.trade", "BTC.trade") → true
+//	ChannelPatternCovers("BTC.>", "BTC.*")       → true
+//	ChannelPatternCovers("*.trade", "BTC.*")     → false
+func ChannelPatternCovers(outer, inner string) bool {
+	outerTokens := strings.Split(outer, tokenSeparator)
+	innerTokens := strings.Split(inner, tokenSeparator)
+
+	for i, token := range outerTokens {
+		if token == fullWildcard {
+			return i < len(innerTokens) // ">" needs at least one more token
+		}
+		if i >= len(innerTokens) || innerTokens[i] == fullWildcard {
+			return false
+		}
+		if token == singleTokenWildcard {
+			continue
+		}
+		if innerTokens[i] != token {
+			return false // Literal vs different literal, or literal vs "*"
+		}
+	}
+	return len(outerTokens) == len(innerTokens)
+}
+
+// subscriptionTrie stores pattern subscriptions as a token trie
+//
+// Structure for patterns "BTC.*", "*.trade", "ETH.>":
+//
+//	root
+//	├── "BTC" ── "*"      [clients subscribed to BTC.*]
+//	├── "*"   ── "trade"  [clients subscribed to *.trade]
+//	└── "ETH" ── ">"      [clients subscribed to ETH.>]
+//
+// Wildcard tokens are stored as ordinary children keyed by "*" and ">"
+// Matching walks at most 3 branches per level (literal, "*", ">"), so lookup
+// cost depends on channel depth (2 tokens) rather than number of patterns
+//
+// Not thread-safe: SubscriptionIndex holds its mutex around all trie access
+type subscriptionTrie struct {
+	root *subscriptionTrieNode
+	size int // Number of distinct patterns stored (for metrics/debugging)
+}
+
+type subscriptionTrieNode struct {
+	children map[string]*subscriptionTrieNode
+	clients  []*Client // Clients whose pattern terminates at this node
+}
+
+func newSubscriptionTrie() *subscriptionTrie {
+	return &subscriptionTrie{root: &subscriptionTrieNode{}}
+}
+
+// insert adds client under pattern
+// Returns false if the client was already subscribed to this exact pattern
+func (t *subscriptionTrie) insert(pattern string, client *Client) bool {
+	node := t.root
+	for _, token := range strings.Split(pattern, tokenSeparator) {
+		if node.children == nil {
+			node.children = make(map[string]*subscriptionTrieNode)
+		}
+		child, exists := node.children[token]
+		if !exists {
+			child = &subscriptionTrieNode{}
+			node.children[token] = child
+		}
+		node = child
+	}
+
+	for _, existing := range node.clients {
+		if existing == client {
+			return false
+		}
+	}
+	if len(node.clients) == 0 {
+		t.size++
+	}
+	node.clients = append(node.clients, client)
+	return true
+}
+
+// remove deletes client from pattern and prunes empty branches
+// Returns true if the pattern has no subscribers left
+func (t *subscriptionTrie) remove(pattern string, client *Client) bool {
+	tokens := strings.Split(pattern, tokenSeparator)
+
+	// Record path so empty nodes can be pruned bottom-up
+	path := make([]*subscriptionTrieNode, 0, len(tokens)+1)
+	node := t.root
+	path = append(path, node)
+	for _, token := range tokens {
+		child, exists := node.children[token]
+		if !exists {
+			return false
+		}
+		node = child
+		path = append(path, node)
+	}
+
+	removed := false
+	for i, existing := range node.clients {
+		if existing == client {
+			node.clients[i] = node.clients[len(node.clients)-1]
+			node.clients[len(node.clients)-1] = nil
+			node.clients = node.clients[:len(node.clients)-1]
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		return false
+	}
+	if len(node.clients) > 0 {
+		return false
+	}
+	t.size--
+
+	// Prune: walk back up, deleting nodes with no clients and no children
+	for i := len(tokens); i > 0; i-- {
+		current := path[i]
+		if len(current.clients) > 0 || len(current.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, tokens[i-1])
+	}
+	return true
+}
+
+// removeClient deletes client from every pattern in the trie
+// Returns the patterns that lost their last subscriber
+// O(nodes) - only called on disconnect, patterns are few compared to literals
+func (t *subscriptionTrie) removeClient(client *Client) []string {
+	var emptied []string
+	var walk func(node *subscriptionTrieNode, prefix []string)
+	walk = func(node *subscriptionTrieNode, prefix []string) {
+		for token, child := range node.children {
+			tokens := append(prefix, token)
+			walk(child, tokens)
+
+			for i, existing := range child.clients {
+				if existing == client {
+					child.clients[i] = child.clients[len(child.clients)-1]
+					child.clients[len(child.clients)-1] = nil
+					child.clients = child.clients[:len(child.clients)-1]
+					if len(child.clients) == 0 {
+						t.size--
+						emptied = append(emptied, strings.Join(tokens, tokenSeparator))
+					}
+					break
+				}
+			}
+
+			if len(child.clients) == 0 && len(child.children) == 0 {
+				delete(node.children, token)
+			}
+		}
+	}
+	walk(t.root, make([]string, 0, 4))
+	return emptied
+}
+
+// list appends every pattern with subscribers to dst
+func (t *subscriptionTrie) list(dst []string) []string {
+	var walk func(node *subscriptionTrieNode, prefix string)
+	walk = func(node *subscriptionTrieNode, prefix string) {
+		for token, child := range node.children {
+			pattern := token
+			if prefix != "" {
+				pattern = prefix + tokenSeparator + token
+			}
+			if len(child.clients) > 0 {
+				dst = append(dst, pattern)
+			}
+			walk(child, pattern)
+		}
+	}
+	walk(t.root, "")
+	return dst
+}
+
+// match appends every client whose pattern matches channel to dst
+// May contain duplicates if a client holds several matching patterns
+// (e.g. "BTC.*" and "*.trade" both match "BTC.trade") - caller dedupes
+func (t *subscriptionTrie) match(channel string, dst []*Client) []*Client {
+	if t.size == 0 {
+		return dst
+	}
+	return t.root.match(strings.Split(channel, tokenSeparator), dst)
+}
+
+func (n *subscriptionTrieNode) match(tokens []string, dst []*Client) []*Client {
+	if len(tokens) == 0 {
+		return append(dst, n.clients...)
+	}
+	if n.children == nil {
+		return dst
+	}
+
+	// ">" matches all remaining tokens (at least one remains here)
+	if child, ok := n.children[fullWildcard]; ok {
+		dst = append(dst, child.clients...)
+	}
+	// "*" matches exactly this token
+	if child, ok := n.children[singleTokenWildcard]; ok {
+		dst = child.match(tokens[1:], dst)
+	}
+	// Literal token
+	if child, ok := n.children[tokens[0]]; ok {
+		dst = child.match(tokens[1:], dst)
+	}
+	return dst
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchChannelPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		channel string
+		want    bool
+	}{
+		{pattern: "BTC.trade", channel: "BTC.trade", want: true},
+		{pattern: "BTC.trade", channel: "BTC.social", want: false},
+		{pattern: "BTC.trade", channel: "BTC.trade.v2", want: false},
+		{pattern: "BTC.*", channel: "BTC.trade", want: true},
+		{pattern: "BTC.*", channel: "ETH.trade", want: false},
+		{pattern: "BTC.*", channel: "BTC.trade.v2", want: false},
+		{pattern: "BTC.*", channel: "BTC", want: false},
+		{pattern: "*.trade", channel: "ETH.trade", want: true},
+		{pattern: "*.*", channel: "ETH.trade", want: true},
+		{pattern: "*", channel: "BTC", want: true},
+		{pattern: "*", channel: "BTC.trade", want: false},
+		{pattern: "BTC.>", channel: "BTC.trade", want: true},
+		{pattern: "BTC.>", channel: "BTC.trade.v2", want: true},
+		{pattern: "BTC.>", channel: "BTC", want: false},
+		{pattern: "BTC.>", channel: "ETH.trade", want: false},
+		{pattern: "*.>", channel: "ETH.trade", want: true},
+		{pattern: ">", channel: "BTC", want: true},
+		{pattern: ">", channel: "BTC.trade.v2", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"|"+tt.channel, func(t *testing.T) {
+			if got := MatchChannelPattern(tt.pattern, tt.channel); got != tt.want {
+				t.Errorf("MatchChannelPattern(%q, %q) = %v, want %v", tt.pattern, tt.channel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelPatternsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "BTC.trade", b: "BTC.trade", want: true},
+		{a: "BTC.trade", b: "ETH.trade", want: false},
+		{a: "BTC.*", b: "*.trade", want: true},
+		{a: "BTC.*", b: "ETH.*", want: false},
+		{a: "BTC.*", b: "BTC.trade", want: true},
+		{a: "BTC.*", b: "BTC.trade.v2", want: false},
+		{a: "*.*", b: "*", want: false},
+		{a: "BTC.>", b: "BTC.trade", want: true},
+		{a: "BTC.>", b: "BTC.trade.v2", want: true},
+		{a: "BTC.>", b: "*.trade", want: true},
+		{a: "BTC.>", b: "ETH.>", want: false},
+		{a: "BTC.>", b: "ETH.trade", want: false},
+		{a: ">", b: "ETH.trade", want: true},
+		{a: "*.trade", b: "*.social", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"|"+tt.b, func(t *testing.T) {
+			// Overlap is symmetric
+			if got := ChannelPatternsOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("ChannelPatternsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := ChannelPatternsOverlap(tt.b, tt.a); got != tt.want {
+				t.Errorf("ChannelPatternsOverlap(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelPatternCovers(t *testing.T) {
+	tests := []struct {
+		outer, inner string
+		want         bool
+	}{
+		{outer: "BTC.trade", inner: "BTC.trade", want: true},
+		{outer: "BTC.trade", inner: "BTC.*", want: false},
+		{outer: "*.trade", inner: "BTC.trade", want: true},
+		{outer: "*.trade", inner: "*.trade", want: true},
+		{outer: "*.trade", inner: "BTC.*", want: false},
+		{outer: "*.trade", inner: "*.>", want: false},
+		{outer: "BTC.*", inner: "BTC.trade.v2", want: false},
+		{outer: "BTC.>", inner: "BTC.*", want: true},
+		{outer: "BTC.>", inner: "BTC.trade.v2", want: true},
+		{outer: "BTC.>", inner: "BTC.>", want: true},
+		{outer: "BTC.>", inner: "BTC", want: false},
+		{outer: "BTC.>", inner: "*.trade", want: false},
+		{outer: "BTC.*", inner: "BTC.>", want: false},
+		{outer: "*.>", inner: "BTC.>", want: true},
+		{outer: ">", inner: "BTC", want: true},
+		{outer: ">", inner: "*.>", want: true},
+		{outer: "*", inner: ">", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outer+"|"+tt.inner, func(t *testing.T) {
+			if got := ChannelPatternCovers(tt.outer, tt.inner); got != tt.want {
+				t.Errorf("ChannelPatternCovers(%q, %q) = %v, want %v", tt.outer, tt.inner, got, tt.want)
+			}
+		})
+	}
+}
+
+// clientNames maps matched clients back to the names they were registered under
+func clientNames(clients []*Client, names map[*Client]string) []string {
+	out := make([]string, 0, len(clients))
+	for _, c := range clients {
+		out = append(out, names[c])
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestSubscriptionTrieMatch(t *testing.T) {
+	trie := newSubscriptionTrie()
+	names := make(map[*Client]string)
+	subscribe := func(name string, patterns ...string) {
+		c := &Client{}
+		names[c] = name
+		for _, pattern := range patterns {
+			trie.insert(pattern, c)
+		}
+	}
+	subscribe("dashboard", "BTC.*")
+	subscribe("ticker", "*.trade")
+	subscribe("eth", "ETH.>")
+	subscribe("both", "BTC.*", "*.trade")
+	subscribe("debug", ">")
+	subscribe("nested", "BTC.*.v2")
+
+	tests := []struct {
+		channel string
+		want    []string
+	}{
+		// "both" holds two matching patterns - duplicates are the caller's to remove
+		{channel: "BTC.trade", want: []string{"both", "both", "dashboard", "debug", "ticker"}},
+		{channel: "BTC.social", want: []string{"both", "dashboard", "debug"}},
+		{channel: "ETH.trade", want: []string{"both", "debug", "eth", "ticker"}},
+		{channel: "ETH.trade.v2", want: []string{"debug", "eth"}},
+		{channel: "BTC.trade.v2", want: []string{"debug", "nested"}},
+		{channel: "SOL.social", want: []string{"debug"}},
+		{channel: "BTC", want: []string{"debug"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if got := clientNames(trie.match(tt.channel, nil), names); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match(%q) = %q, want %q", tt.channel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionTrieMatchAgreesWithMatchChannelPattern(t *testing.T) {
+	patterns := []string{"BTC.trade", "BTC.*", "*.trade", "*.*", "BTC.>", "*.>", ">", "*", "BTC.*.v2"}
+	channels := []string{"BTC", "BTC.trade", "BTC.social", "ETH.trade", "BTC.trade.v2", "ETH.social.v2"}
+
+	for _, pattern := range patterns {
+		trie := newSubscriptionTrie()
+		trie.insert(pattern, &Client{})
+		for _, channel := range channels {
+			got := len(trie.match(channel, nil)) == 1
+			if want := MatchChannelPattern(pattern, channel); got != want {
+				t.Errorf("trie %q matches %q = %v, MatchChannelPattern = %v", pattern, channel, got, want)
+			}
+		}
+	}
+}
+
+func TestSubscriptionTrieInsertRemove(t *testing.T) {
+	trie := newSubscriptionTrie()
+	a, b := &Client{}, &Client{}
+
+	if !trie.insert("BTC.*", a) {
+		t.Fatal("insert(BTC.*, a) = false, want true")
+	}
+	if trie.insert("BTC.*", a) {
+		t.Error("duplicate insert(BTC.*, a) = true, want false")
+	}
+	trie.insert("BTC.*", b)
+	trie.insert("BTC.>", a)
+	trie.insert("*.trade", a)
+	if trie.size != 3 {
+		t.Errorf("size = %d, want 3", trie.size)
+	}
+
+	steps := []struct {
+		pattern   string
+		client    *Client
+		wantEmpty bool
+		wantSize  int
+		wantList  []string
+	}{
+		{pattern: "ETH.*", client: a, wantEmpty: false, wantSize: 3, wantList: []string{"*.trade", "BTC.*", "BTC.>"}},
+		{pattern: "BTC", client: a, wantEmpty: false, wantSize: 3, wantList: []string{"*.trade", "BTC.*", "BTC.>"}},
+		{pattern: "*.trade", client: b, wantEmpty: false, wantSize: 3, wantList: []string{"*.trade", "BTC.*", "BTC.>"}},
+		{pattern: "BTC.*", client: a, wantEmpty: false, wantSize: 3, wantList: []string{"*.trade", "BTC.*", "BTC.>"}},
+		{pattern: "BTC.*", client: b, wantEmpty: true, wantSize: 2, wantList: []string{"*.trade", "BTC.>"}},
+		{pattern: "BTC.*", client: b, wantEmpty: false, wantSize: 2, wantList: []string{"*.trade", "BTC.>"}},
+		{pattern: "BTC.>", client: a, wantEmpty: true, wantSize: 1, wantList: []string{"*.trade"}},
+		{pattern: "*.trade", client: a, wantEmpty: true, wantSize: 0, wantList: []string{}},
+	}
+
+	for _, step := range steps {
+		if got := trie.remove(step.pattern, step.client); got != step.wantEmpty {
+			t.Errorf("remove(%q) = %v, want %v", step.pattern, got, step.wantEmpty)
+		}
+		if trie.size != step.wantSize {
+			t.Errorf("after remove(%q): size = %d, want %d", step.pattern, trie.size, step.wantSize)
+		}
+		got := trie.list([]string{})
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, step.wantList) {
+			t.Errorf("after remove(%q): list = %q, want %q", step.pattern, got, step.wantList)
+		}
+	}
+
+	// The shared "BTC" node must be pruned along with its last pattern
+	if len(trie.root.children) != 0 {
+		t.Errorf("root has %d children after removing every pattern, want 0", len(trie.root.children))
+	}
+}
+
+func TestSubscriptionTrieRemovePrunesOnlyEmptyBranches(t *testing.T) {
+	trie := newSubscriptionTrie()
+	a := &Client{}
+	trie.insert("BTC", a)
+	trie.insert("BTC.trade.v2", a)
+
+	// "BTC" still has subscribers: pruning must stop below it
+	trie.remove("BTC.trade.v2", a)
+	btc, ok := trie.root.children["BTC"]
+	if !ok {
+		t.Fatal("BTC node pruned while it still has subscribers")
+	}
+	if len(btc.children) != 0 {
+		t.Errorf("BTC node has %d children, want 0", len(btc.children))
+	}
+
+	trie.remove("BTC", a)
+	if len(trie.root.children) != 0 {
+		t.Errorf("root has %d children, want 0", len(trie.root.children))
+	}
+}
+
+func TestSubscriptionTrieRemoveClient(t *testing.T) {
+	trie := newSubscriptionTrie()
+	a, b := &Client{}, &Client{}
+	trie.insert("BTC.*", a)
+	trie.insert("BTC.*", b)
+	trie.insert("BTC.>", a)
+	trie.insert("*.trade", a)
+	trie.insert("ETH.*.v2", b)
+
+	emptied := trie.removeClient(a)
+	sort.Strings(emptied)
+	if want := []string{"*.trade", "BTC.>"}; !reflect.DeepEqual(emptied, want) {
+		t.Errorf("removeClient(a) emptied %q, want %q", emptied, want)
+	}
+	if trie.size != 2 {
+		t.Errorf("size = %d, want 2", trie.size)
+	}
+	if got := trie.match("BTC.trade", nil); len(got) != 1 || got[0] != b {
+		t.Errorf("match(BTC.trade) after removeClient(a) = %v, want [b]", got)
+	}
+
+	emptied = trie.removeClient(b)
+	sort.Strings(emptied)
+	if want := []string{"BTC.*", "ETH.*.v2"}; !reflect.DeepEqual(emptied, want) {
+		t.Errorf("removeClient(b) emptied %q, want %q", emptied, want)
+	}
+	if trie.size != 0 || len(trie.root.children) != 0 {
+		t.Errorf("trie not empty after removing every client: size %d, %d root children", trie.size, len(trie.root.children))
+	}
+}
@@ -140,8 +140,8 @@ func (wp *WorkerPool) worker() {
 // Submit enqueues a task for asynchronous execution by a worker.
 //
 // Behavior:
-//   - If queue has space: Task is queued and Submit returns immediately
-//   - If queue is full: Task is DROPPED and counter incremented
+//   - If queue has space: Task is queued and Submit returns true immediately
+//   - If queue is full: Task is DROPPED, counter incremented, returns false
 //
 // Task dropping provides backpressure:
 //   - Prevents goroutine explosion when system overloaded
@@ -160,16 +160,24 @@ func (wp *WorkerPool) worker() {
 //	pool.Submit(func() {
 //	    server.broadcast(message)
 //	})
-func (wp *WorkerPool) Submit(task Task) {
+func (wp *WorkerPool) Submit(task Task) bool {
 	select {
 	case wp.taskQueue <- task:
 		// Task queued successfully
+		return true
 	default:
 		// Queue is full - drop task to prevent goroutine explosion
 		atomic.AddInt64(&wp.droppedTasks, 1)
+		return false
 	}
 }
 
+// FreeCapacity returns how many tasks can be queued right now without dropping.
+// The JetStream fetch loop sizes its batches with it (see jetstream_fetch.go).
+func (wp *WorkerPool) FreeCapacity() int {
+	return cap(wp.taskQueue) - len(wp.taskQueue)
+}
+
 // Stop gracefully shuts down the worker pool.
 //
 // Shutdown sequence: